@@ -4,5 +4,6 @@ go 1.16
 
 require (
 	github.com/grafana/grafana-plugin-sdk-go v0.102.0
+	github.com/prometheus/client_golang v1.10.0
 	gopkg.in/ns1/ns1-go.v2 v2.6.3
 )
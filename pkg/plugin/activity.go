@@ -0,0 +1,152 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// queryTypeActivity is the type of the queries of the NS1 account
+	// activity, the changes made to the account, meant for the annotations.
+	queryTypeActivity = "activity"
+	// queryTypeJobChanges is the type of the queries of the changes made to
+	// the Pulsar jobs, out of the account activity.
+	queryTypeJobChanges = "jobChanges"
+	// activityLimit is the most activity entries NS1 returns at once.
+	activityLimit = 1000
+)
+
+var errActivityNotFound = errors.New("NS1 account activity not found")
+
+// Activity is a change made to the NS1 account.
+type Activity struct {
+	Time         time.Time
+	User         string
+	Action       string
+	ResourceType string
+	ResourceID   string
+}
+
+// resource returns the type and ID of the resource changed.
+func (a *Activity) resource() string {
+	if a.ResourceID == "" {
+		return a.ResourceType
+	}
+	return a.ResourceType + " " + a.ResourceID
+}
+
+// GetActivity returns the activity of the account over the time range of the
+// query, oldest first. Only the latest activityLimit entries are returned.
+func (pc *PulsarClient) GetActivity(ctx context.Context, apiKey string, qm *queryModel) (activity []Activity, err error) {
+	ctx, span := startSpan(ctx, "GetActivity")
+	defer func() { span.end(err) }()
+
+	var body []struct {
+		UserName     string `json:"user_name"`
+		Timestamp    int64  `json:"timestamp"`
+		Action       string `json:"action"`
+		ResourceType string `json:"resource_type"`
+		ResourceID   string `json:"resource_id"`
+	}
+	path := fmt.Sprintf("account/activity?start=%d&end=%d&limit=%d", qm.From.Unix(), qm.To.Unix(), activityLimit)
+	if err = pc.getJSON(ctx, apiKey, path, errActivityNotFound, &body); err != nil {
+		return nil, asPermissionError(err, operationReadActivity)
+	}
+
+	activity = make([]Activity, 0, len(body))
+	for _, entry := range body {
+		activity = append(activity, Activity{
+			Time:         time.Unix(entry.Timestamp, 0),
+			User:         entry.UserName,
+			Action:       entry.Action,
+			ResourceType: entry.ResourceType,
+			ResourceID:   entry.ResourceID,
+		})
+	}
+	sort.SliceStable(activity, func(i, j int) bool { return activity[i].Time.Before(activity[j].Time) })
+
+	return activity, nil
+}
+
+// activityFrame returns the frame of the activity, usable as annotations:
+// the text field describes the change.
+func activityFrame(activity []Activity, describe func(a *Activity) string) *data.Frame {
+	frame := data.NewFrame("activity",
+		data.NewField("time", nil, []time.Time{}),
+		data.NewField("user", nil, []string{}),
+		data.NewField("action", nil, []string{}),
+		data.NewField("resource", nil, []string{}),
+		data.NewField("text", nil, []string{}),
+	)
+	for i := range activity {
+		a := &activity[i]
+		text := fmt.Sprintf("%s: %s %s", a.User, a.Action, describe(a))
+		frame.AppendRow(a.Time, a.User, a.Action, a.resource(), text)
+	}
+	return frame
+}
+
+// queryActivity answers the activity queries with a frame of the changes.
+func (p *PulsarDatasource) queryActivity(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	activity, err := p.pulsarAPI().GetActivity(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	return backend.DataResponse{Frames: data.Frames{activityFrame(activity, (*Activity).resource)}}
+}
+
+// queryJobChanges answers the job changes queries with a frame of the changes
+// made to the Pulsar jobs visible to the user, or to the jobs of the app, or
+// to the job, of the query. The changes are picked out of the account
+// activity by the ID of the resource changed.
+func (p *PulsarDatasource) queryJobChanges(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+	if err = checkQueryAllowed(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	jobs := make(map[string]Job)
+	for _, app := range appsResponse.Apps {
+		if qm.AppID != "" && app.AppID != qm.AppID {
+			continue
+		}
+		for _, job := range app.Jobs {
+			if qm.JobID == "" || job.JobID == qm.JobID {
+				jobs[job.JobID] = job
+			}
+		}
+	}
+
+	activity, err := p.pulsarAPI().GetActivity(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	changes := activity[:0]
+	for _, a := range activity {
+		if _, found := jobs[a.ResourceID]; found {
+			changes = append(changes, a)
+		}
+	}
+
+	frame := activityFrame(changes, func(a *Activity) string {
+		return "Pulsar job " + jobs[a.ResourceID].Name
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
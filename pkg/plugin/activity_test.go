@@ -0,0 +1,134 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryActivity(t *testing.T) {
+	var query string
+	forbidden := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forbidden {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "insufficient permissions"}`)
+			return
+		}
+		query = r.URL.RawQuery
+		fmt.Fprint(w, `[
+			{"user_name": "jdoe", "timestamp": 1600000060, "action": "update", "resource_type": "record", "resource_id": "r1"},
+			{"user_name": "asmith", "timestamp": 1600000000, "action": "login", "resource_type": "user"}
+		]`)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := &queryModel{From: time.Unix(1599990000, 0), To: time.Unix(1600010000, 0)}
+	res := p.queryActivity(context.Background(), "key", qm)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if query != "start=1599990000&end=1600010000&limit=1000" {
+		t.Errorf("expected the activity of the time range, got %s", query)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 2 {
+		t.Fatalf("expected 2 rows, got %d", rows)
+	}
+	if frame.Fields[1].At(0) != "asmith" || frame.Fields[3].At(0) != "user" {
+		t.Errorf("expected the oldest activity first, got %v", frame.Fields)
+	}
+	if text := frame.Fields[4].At(1); text != "jdoe: update record r1" {
+		t.Errorf("unexpected annotation text %v", text)
+	}
+
+	forbidden = true
+	res = p.queryActivity(context.Background(), "key", qm)
+	var permErr *permissionError
+	if !errors.As(res.Error, &permErr) || permErr.operation != operationReadActivity {
+		t.Errorf("expected a permission error, got %v", res.Error)
+	}
+}
+
+func TestQueryJobChanges(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", testutil.NewNS1())
+	mux.HandleFunc("/account/activity", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"user_name": "jdoe", "timestamp": 1600000000, "action": "update", "resource_type": "record", "resource_id": "r1"},
+			{"user_name": "jdoe", "timestamp": 1600000060, "action": "update", "resource_type": "pulsar", "resource_id": "job1"},
+			{"user_name": "asmith", "timestamp": 1600000120, "action": "delete", "resource_type": "pulsar", "resource_id": "job2"}
+		]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	res := p.queryJobChanges(context.Background(), "key", &queryModel{})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 2 {
+		t.Fatalf("expected the changes of the Pulsar jobs only, got %d rows", rows)
+	}
+	if text := frame.Fields[4].At(0); text != "jdoe: update Pulsar job Job 1" {
+		t.Errorf("unexpected annotation text %v", text)
+	}
+
+	res = p.queryJobChanges(context.Background(), "key", &queryModel{AppID: "app1", JobID: "job2"})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if rows, _ := res.Frames[0].RowLen(); rows != 1 || res.Frames[0].Fields[1].At(0) != "asmith" {
+		t.Errorf("expected the changes of the job only, got %v", res.Frames[0].Fields)
+	}
+
+	res = p.queryJobChanges(context.Background(), "key", &queryModel{JobID: "job3"})
+	if !errors.Is(res.Error, errAppNotAllowed) {
+		t.Errorf("expected errAppNotAllowed, got %v", res.Error)
+	}
+}
+
+func TestQueryRouteMapChanges(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", testutil.NewNS1())
+	mux.HandleFunc("/pulsar/routemaps", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": "rm1", "name": "Eyeball networks", "appid": "app1"}, {"id": "rm2", "name": "Other", "appid": "app2"}]`)
+	})
+	mux.HandleFunc("/account/activity", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"user_name": "jdoe", "timestamp": 1600000000, "action": "update", "resource_type": "routemap", "resource_id": "rm1"},
+			{"user_name": "jdoe", "timestamp": 1600000060, "action": "update", "resource_type": "routemap", "resource_id": "rm2"},
+			{"user_name": "asmith", "timestamp": 1600000120, "action": "update", "resource_type": "pulsar", "resource_id": "job1"}
+		]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	for _, qm := range []*queryModel{{}, {JobID: "job1"}} {
+		res := p.queryRouteMapChanges(context.Background(), "key", qm)
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		frame := res.Frames[0]
+		if rows, _ := frame.RowLen(); rows != 1 {
+			t.Fatalf("expected the changes of the route maps of the visible apps only, got %d rows", rows)
+		}
+		if text := frame.Fields[4].At(0); text != "jdoe: update Pulsar route map Eyeball networks" {
+			t.Errorf("unexpected annotation text %v", text)
+		}
+	}
+}
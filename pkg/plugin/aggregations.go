@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var errInvalidAggregations = newInvalidQueryError("invalid agg list")
+
+// checkAggregations rejects the unknown and repeated aggregations of the
+// queries with a list of them, and the lists of the queries that don't return
+// the series of the data as is.
+func checkAggregations(qm *queryModel) error {
+	if len(qm.Aggregations) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(qm.Aggregations))
+	for _, agg := range qm.Aggregations {
+		if !isValidAggregation(agg) {
+			return fmt.Errorf("%w: expected aggregations among %v, got %q", errInvalidAggregations, aggregations, agg)
+		}
+		if seen[agg] {
+			return fmt.Errorf("%w: %s is repeated", errInvalidAggregations, agg)
+		}
+		seen[agg] = true
+	}
+	if len(qm.Aggregations) > 1 && (qm.Reduce != "" || qm.Condition != nil || qm.Quantile > 0 || qm.Band != nil ||
+		qm.Forecast != "" || qm.BaselineWeeks > 0 || qm.Envelope || qm.Resample != "" || qm.Cumulative) {
+		return fmt.Errorf("%w: a field per aggregation needs the series as is, without reducer, condition, "+
+			"quantile, band, forecast, baseline, envelope, resampling nor running total", errInvalidAggregations)
+	}
+	return nil
+}
+
+// getAggregations fetches the series of the other aggregations of the query
+// than its first one, over its range, and aligns them on the times of the
+// first one. The points without value are null, all of them when NS1 has no
+// data for the aggregation.
+func (p *PulsarDatasource) getAggregations(ctx context.Context, apiKey string, qm *queryModel, times []time.Time) ([][]*float64, error) {
+	series := make([][]*float64, 0, len(qm.Aggregations)-1)
+	for _, agg := range qm.Aggregations[1:] {
+		aggQuery := *qm
+		aggQuery.Aggregation = agg
+		// The alignment keeps the points at the times of the first series.
+		aggQuery.MaxDataPoints = math.MaxInt64
+		aggTimes, aggValues, err := p.getData(ctx, apiKey, &aggQuery)
+		if errors.Is(err, errNoDataFound) {
+			series = append(series, make([]*float64, len(times)))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, alignSeries(times, aggTimes, aggValues))
+	}
+	return series, nil
+}
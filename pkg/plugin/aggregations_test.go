@@ -0,0 +1,70 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryAggregations(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 5))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": ["p50", "p95", "p99"]}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	fields := res.Frames[0].Fields
+	if len(fields) != 4 || fields[1].Labels["agg"] != "p50" || fields[2].Name != "p95" || fields[3].Labels["agg"] != "p99" {
+		t.Fatalf("expected the time and a field per aggregation, got %d fields", len(fields))
+	}
+	for _, field := range fields[2:] {
+		if field.Len() != fields[0].Len() {
+			t.Errorf("expected the %s points on the time axis, got %d points", field.Name, field.Len())
+		}
+		if value := field.At(0).(*float64); value == nil || *value != 5 {
+			t.Errorf("expected the %s values aligned on the times", field.Name)
+		}
+	}
+	if requests := server.NS1.Requests("/pulsar/query/performance/time"); requests != 3 {
+		t.Errorf("expected a request per aggregation, got %d", requests)
+	}
+
+	// A list of one is the aggregation of the query.
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": ["max"]}`)
+	if res.Error != nil || len(res.Frames[0].Fields) != 2 || res.Frames[0].Fields[1].Labels["agg"] != "max" {
+		t.Errorf("expected the series of the aggregation, got %v", res.Error)
+	}
+
+	for _, json := range []string{
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": ["p50", "p50"]}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": ["p50", "p42"]}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": ["p50", "p99"], "reduce": "mean"}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidAggregations) {
+			t.Errorf("%s: expected errInvalidAggregations, got %v", json, res.Error)
+		}
+	}
+	if res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": [95]}`); !errors.Is(res.Error, errInvalidQuery) {
+		t.Errorf("expected errInvalidQuery, got %v", res.Error)
+	}
+}
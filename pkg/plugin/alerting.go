@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// fromAlertHeader is set by Grafana on the queries of the alert rules.
+	fromAlertHeader = "FromAlert"
+	// valueFieldName is the name of the value field of the Pulsar series, the
+	// same for every query so the alert rules and the transformations don't
+	// depend on the labels.
+	valueFieldName = "value"
+)
+
+// alertRetryDelay is how long the alert queries wait before retrying a
+// transient failure. A variable, so the tests don't wait.
+var alertRetryDelay = time.Second
+
+// isAlertQuery tells whether the request comes from the evaluation of an
+// alert rule.
+func isAlertQuery(headers map[string]string) bool {
+	return headers[fromAlertHeader] == "true"
+}
+
+// retryable tells whether the failure is transient, NS1 being rate limited,
+// unavailable or too slow, so running the query again may succeed.
+func (e *QueryError) retryable() bool {
+	if e.Source != ErrorSourceDownstream {
+		return false
+	}
+	switch e.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// seriesLabels returns the labels of the series of the query, one per
+// dimension, which the alert rules use to tell the series apart.
+func seriesLabels(app App, job Job, qm *queryModel) data.Labels {
+	labels := data.Labels{
+		"appid":  qm.AppID,
+		"jobid":  qm.JobID,
+		"metric": qm.MetricType,
+		"agg":    qm.Aggregation,
+	}
+	if app.Name != "" {
+		labels["app"] = app.Name
+	}
+	if job.Name != "" {
+		labels["job"] = job.Name
+	}
+	if qm.Geo != "" {
+		labels["geo"] = qm.Geo
+	}
+	if qm.ASN != "" {
+		labels["asn"] = qm.ASN
+	}
+	return labels
+}
+
+// retryAlertQuery runs the query of an alert rule once more after a transient
+// failure, as a failed evaluation puts the rule in the error state.
+func (p *PulsarDatasource) retryAlertQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, res backend.DataResponse) backend.DataResponse {
+	if res.Error == nil || !classifyError(res.Error).retryable() {
+		return res
+	}
+
+	loggerFromContext(ctx).Warn("Retrying the alert query", "error", res.Error)
+	select {
+	case <-ctx.Done():
+		return res
+	case <-time.After(alertRetryDelay):
+	}
+	return p.query(withRetry(ctx), pCtx, query)
+}
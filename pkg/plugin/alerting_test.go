@@ -0,0 +1,134 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestAlertQuery(t *testing.T) {
+	alertRetryDelay = 0
+	defer func() { alertRetryDelay = time.Second }()
+
+	now := time.Now().Unix()
+	failures, dataRequests := 0, 0
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			dataRequests++
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `[{"timestamp": %d, "job1": 120}, {"timestamp": %d, "job1": 95}]`, now-60, now)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{
+		settings:     defaultSettings(),
+		pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL)),
+	}
+	req := func(fromAlert bool) *backend.QueryDataRequest {
+		headers := map[string]string{}
+		if fromAlert {
+			headers[fromAlertHeader] = "true"
+		}
+		return &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+			}},
+			Headers: headers,
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "geo": "*"}`),
+				TimeRange: backend.TimeRange{From: time.Unix(now-3600, 0), To: time.Unix(now, 0)},
+			}},
+		}
+	}
+
+	// A transient NS1 failure is retried once for the alert rules.
+	failures = 1
+	resp, err := p.QueryData(context.Background(), req(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil || dataRequests != 2 {
+		t.Fatalf("expected the query to succeed on retry, got %v after %d requests", res.Error, dataRequests)
+	}
+
+	// The rule evaluation expects numeric time series, told apart by their
+	// labels.
+	if len(res.Frames) != 1 {
+		t.Fatalf("expected a frame, got %d", len(res.Frames))
+	}
+	frame := res.Frames[0]
+	if schema := frame.TimeSeriesSchema(); schema.Type != data.TimeSeriesTypeWide || len(schema.ValueIndices) != 1 {
+		t.Fatalf("expected a wide numeric time series, got %+v", schema)
+	}
+	value := frame.Fields[1]
+	if value.Name != valueFieldName || value.Labels["jobid"] != "job1" || value.Labels["job"] != "Job 1" || value.Labels["geo"] != "*" {
+		t.Errorf("unexpected value field %q %v", value.Name, value.Labels)
+	}
+	if value.Config == nil || !strings.HasPrefix(value.Config.DisplayNameFromDS, "App 1 (app1):Job 1 (job1)") {
+		t.Errorf("expected the legend to be kept as the display name, got %+v", value.Config)
+	}
+	if _, err := frame.MarshalArrow(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dashboards don't retry, and the errors tell whether they're worth
+	// retrying.
+	failures, dataRequests = 2, 0
+	resp, err = p.QueryData(context.Background(), req(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryErr, ok := resp.Responses["A"].Error.(*QueryError)
+	if !ok || !queryErr.retryable() || dataRequests != 1 {
+		t.Errorf("expected a retryable error after a single request, got %v after %d requests", resp.Responses["A"].Error, dataRequests)
+	}
+
+	// An alert query failing for good reports the error.
+	failures, dataRequests = 2, 0
+	resp, _ = p.QueryData(context.Background(), req(true))
+	if resp.Responses["A"].Error == nil || dataRequests != 2 {
+		t.Errorf("expected an error after a retry, got %v after %d requests", resp.Responses["A"].Error, dataRequests)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{newAPIError(http.StatusTooManyRequests, "", errJobNotFound), true},
+		{newAPIError(http.StatusServiceUnavailable, "", errJobNotFound), true},
+		{newAPIError(http.StatusNotFound, "", errJobNotFound), false},
+		{errRangeTooLong, false},
+		{errRateBudgetExceeded, false},
+		{errNoDataFound, false},
+	}
+
+	for _, tt := range tests {
+		if retryable := classifyError(tt.err).retryable(); retryable != tt.retryable {
+			t.Errorf("%v: expected retryable %v, got %v", tt.err, tt.retryable, retryable)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// queryTypeAnnotation is the type of the annotation queries, drawing the
+	// availability drops of the jobs on the panels.
+	queryTypeAnnotation = "annotation"
+	// queryTypeOutages is the type of the annotation queries telling the
+	// real outages apart from the drops due to a lack of data.
+	queryTypeOutages = "outages"
+	// insufficiencySpike is how many times more insufficient decisions than
+	// outside of it make a drop due to a lack of data.
+	insufficiencySpike = 2
+)
+
+// The kinds of the availability drops of the outages queries.
+const (
+	dropOutage       = "real outage"
+	dropInsufficient = "insufficient data"
+)
+
+var errInvalidAnnotationQuery = newInvalidQueryError("invalid annotation query, it needs an app and a threshold between 0 and 100")
+
+// availabilityDrop is an interval during which the availability of a job
+// stayed below the threshold.
+type availabilityDrop struct {
+	job    Job
+	start  time.Time
+	end    time.Time
+	lowest float64
+	// ongoing tells the job was still below the threshold at the end of the
+	// range.
+	ongoing bool
+	// kind tells a real outage from a lack of data, when classified.
+	kind string
+}
+
+func (d *availabilityDrop) text(threshold float64) string {
+	duration := d.end.Sub(d.start).Round(time.Second).String()
+	if d.ongoing {
+		duration += ", ongoing"
+	}
+	text := fmt.Sprintf("%s availability below %g%% for %s, lowest %g%%", d.job.Name, threshold, duration, d.lowest)
+	if d.kind != "" {
+		text = d.kind + ": " + text
+	}
+	return text
+}
+
+// tags returns the tags of the annotation of the drop.
+func (d *availabilityDrop) tags() string {
+	tags := "availability," + d.job.JobID
+	switch d.kind {
+	case dropOutage:
+		tags += ",outage"
+	case dropInsufficient:
+		tags += ",insufficient"
+	}
+	return tags
+}
+
+// classify tells whether the drop is a real outage, or due to a lack of data:
+// the insufficient decisions of the job during the drop being at least
+// insufficiencySpike times their mean outside of it.
+func (d *availabilityDrop) classify(times []time.Time, insufficient []float64) {
+	var inside, outside, insideCount, outsideCount float64
+	for i, t := range times {
+		if !t.Before(d.start) && !t.After(d.end) {
+			inside += insufficient[i]
+			insideCount++
+		} else {
+			outside += insufficient[i]
+			outsideCount++
+		}
+	}
+
+	d.kind = dropOutage
+	if insideCount == 0 || inside == 0 {
+		return
+	}
+	if outsideCount == 0 || inside/insideCount >= insufficiencySpike*outside/outsideCount {
+		d.kind = dropInsufficient
+	}
+}
+
+// findDrops returns the intervals of the series below the threshold. A drop
+// ends with the first point back above the threshold, or with the last point
+// of the series.
+func findDrops(job Job, times []time.Time, values []float64, threshold float64) []*availabilityDrop {
+	var (
+		drops []*availabilityDrop
+		drop  *availabilityDrop
+	)
+	for i, t := range times {
+		switch {
+		case values[i] < threshold && drop == nil:
+			drop = &availabilityDrop{job: job, start: t, end: t, lowest: values[i]}
+		case values[i] < threshold:
+			drop.end = t
+			if values[i] < drop.lowest {
+				drop.lowest = values[i]
+			}
+		case drop != nil:
+			drop.end = t
+			drops = append(drops, drop)
+			drop = nil
+		}
+	}
+	if drop != nil {
+		drop.ongoing = true
+		drops = append(drops, drop)
+	}
+	return drops
+}
+
+// GetInsufficientDecisions returns the decisions Pulsar made without enough
+// data for the job of the query, over its range.
+func (pc *PulsarClient) GetInsufficientDecisions(ctx context.Context, apiKey string, qm *queryModel) (times []time.Time, values []float64, err error) {
+	ctx, span := startSpan(ctx, "GetInsufficientDecisions", "job", qm.JobID)
+	defer func() { span.end(err) }()
+
+	query := url.Values{}
+	query.Set("start", fmt.Sprint(qm.From.Unix()))
+	query.Set("end", fmt.Sprint(qm.To.Unix()))
+	query.Set("jobs", qm.JobID)
+	query.Set("area", "GLOBAL")
+	if qm.Geo != "*" {
+		query.Set("area", qm.Geo)
+	}
+	if qm.ASN != "*" {
+		query.Set("asn", qm.ASN)
+	}
+	apiURL, err := url.Parse(pc.getAPIClient(apiKey).Endpoint.String() + "pulsar/query/decisions/insufficient/time?" + query.Encode())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	points, err := pc.fetchData(ctx, apiKey, apiURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, point := range points {
+		times = append(times, time.Unix(int64(point["timestamp"]), 0))
+		values = append(values, point[qm.JobID])
+	}
+	return times, values, nil
+}
+
+// queryAnnotation answers the annotation queries with an annotation per
+// availability drop of the job of the query, or of every job of its app, over
+// the range. The threshold defaults to availabilityThreshold. The outages
+// queries classify the drops, as real outages or as due to a lack of data.
+func (p *PulsarDatasource) queryAnnotation(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if err := p.settings.checkFeatures(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	threshold := qm.Threshold
+	if threshold == 0 {
+		threshold = p.settings.availabilityThreshold()
+	}
+	if qm.AppID == "" || threshold < 0 || threshold > 100 {
+		return backend.DataResponse{Error: errInvalidAnnotationQuery}
+	}
+
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+	if err = checkQueryAllowed(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if err = checkJobType(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	qm.applyDefaults(p.settings)
+	qm.validate()
+	qm.MetricType = metricTypeAvailability
+	if qm.Aggregation == "" {
+		qm.Aggregation = defaultStreamAggregation
+	}
+	// Every point is scanned, keeping the latest ones only as the graphs do
+	// would miss the older drops.
+	qm.MaxDataPoints = math.MaxInt64
+
+	var drops []*availabilityDrop
+	for _, job := range appsResponse.AppsMap[qm.AppID].Jobs {
+		if (qm.JobID != "" && job.JobID != qm.JobID) || (qm.JobType != "" && job.Type != qm.JobType) {
+			continue
+		}
+		jobQuery := *qm
+		jobQuery.JobID = job.JobID
+		times, values, err := p.getData(ctx, apiKey, &jobQuery)
+		if errors.Is(err, errNoDataFound) {
+			continue
+		}
+		if err != nil {
+			return backend.DataResponse{Error: err}
+		}
+		jobDrops := findDrops(job, times, values, threshold)
+		if qm.QueryType == queryTypeOutages && len(jobDrops) > 0 {
+			insufficientTimes, insufficient, err := p.pulsarAPI().GetInsufficientDecisions(ctx, apiKey, &jobQuery)
+			if err != nil {
+				return backend.DataResponse{Error: err}
+			}
+			for _, drop := range jobDrops {
+				drop.classify(insufficientTimes, insufficient)
+			}
+		}
+		drops = append(drops, jobDrops...)
+	}
+	sort.SliceStable(drops, func(i, j int) bool {
+		return drops[i].start.Before(drops[j].start)
+	})
+
+	var (
+		starts  = make([]time.Time, 0, len(drops))
+		ends    = make([]time.Time, 0, len(drops))
+		jobs    = make([]string, 0, len(drops))
+		texts   = make([]string, 0, len(drops))
+		tags    = make([]string, 0, len(drops))
+		lowests = make([]float64, 0, len(drops))
+		kinds   = make([]string, 0, len(drops))
+	)
+	for _, drop := range drops {
+		starts = append(starts, drop.start)
+		ends = append(ends, drop.end)
+		jobs = append(jobs, drop.job.JobID)
+		texts = append(texts, drop.text(threshold))
+		tags = append(tags, drop.tags())
+		lowests = append(lowests, drop.lowest)
+		kinds = append(kinds, drop.kind)
+	}
+
+	frame := data.NewFrame("annotations",
+		data.NewField("time", nil, starts),
+		data.NewField("timeEnd", nil, ends),
+		data.NewField("job", nil, jobs),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+		data.NewField("lowest", nil, lowests),
+	)
+	if qm.QueryType == queryTypeOutages {
+		frame.Fields = append(frame.Fields, data.NewField("kind", nil, kinds))
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
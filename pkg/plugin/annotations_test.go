@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestFindDrops(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	times := make([]time.Time, 7)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Minute)
+	}
+
+	drops := findDrops(Job{JobID: "job1", Name: "Job 1"}, times, []float64{100, 90, 80, 100, 100, 50, 60}, 95)
+	if len(drops) != 2 {
+		t.Fatalf("expected 2 drops, got %d", len(drops))
+	}
+	if !drops[0].start.Equal(times[1]) || !drops[0].end.Equal(times[3]) || drops[0].lowest != 80 || drops[0].ongoing {
+		t.Errorf("unexpected first drop %+v", drops[0])
+	}
+	if text := drops[0].text(95); text != "Job 1 availability below 95% for 2m0s, lowest 80%" {
+		t.Errorf("unexpected text %q", text)
+	}
+	if !drops[1].ongoing || drops[1].lowest != 50 || !strings.HasSuffix(drops[1].text(95), "1m0s, ongoing, lowest 50%") {
+		t.Errorf("expected the last drop to be ongoing, got %+v", drops[1])
+	}
+}
+
+func TestQueryAnnotation(t *testing.T) {
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			if r.URL.Query().Get("jobs") != "job1" {
+				fmt.Fprint(w, `[]`)
+				return
+			}
+			fmt.Fprint(w, `[{"timestamp": 1600000000, "job1": 100}, {"timestamp": 1600000060, "job1": 70},
+				{"timestamp": 1600000120, "job1": 99}]`)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := func() *queryModel {
+		return &queryModel{QueryType: queryTypeAnnotation, AppID: "app1",
+			From: time.Unix(1599999000, 0), To: time.Unix(1600001000, 0)}
+	}
+
+	res := p.queryAnnotation(context.Background(), "key", qm())
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 1 {
+		t.Fatalf("expected an annotation for the drop of job1, got %d", rows)
+	}
+	if frame.Fields[1].At(0).(time.Time).Unix() != 1600000120 || frame.Fields[2].At(0) != "job1" {
+		t.Errorf("expected the drop to end when job1 recovers, got %v", frame.Fields)
+	}
+	if text := frame.Fields[3].At(0).(string); text != "Job 1 availability below 95% for 1m0s, lowest 70%" {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	// Lower thresholds ignore the smaller drops.
+	lower := qm()
+	lower.Threshold = 50
+	if res = p.queryAnnotation(context.Background(), "key", lower); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if rows, _ := res.Frames[0].RowLen(); rows != 0 {
+		t.Errorf("expected no annotation below 50%%, got %d", rows)
+	}
+
+	invalid := qm()
+	invalid.AppID = ""
+	if res = p.queryAnnotation(context.Background(), "key", invalid); !errors.Is(res.Error, errInvalidAnnotationQuery) {
+		t.Errorf("expected errInvalidAnnotationQuery, got %v", res.Error)
+	}
+}
+
+func TestQueryOutages(t *testing.T) {
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/pulsar/query/decisions/insufficient/"):
+			// Few decisions lack data, except during the first drop.
+			fmt.Fprint(w, `[{"timestamp": 1600000000, "job1": 2}, {"timestamp": 1600000060, "job1": 40},
+				{"timestamp": 1600000120, "job1": 2}, {"timestamp": 1600000180, "job1": 2}, {"timestamp": 1600000240, "job1": 3}]`)
+		case strings.HasPrefix(r.URL.Path, "/pulsar/query/"):
+			if r.URL.Query().Get("jobs") != "job1" {
+				fmt.Fprint(w, `[]`)
+				return
+			}
+			fmt.Fprint(w, `[{"timestamp": 1600000000, "job1": 100}, {"timestamp": 1600000060, "job1": 70},
+				{"timestamp": 1600000120, "job1": 99}, {"timestamp": 1600000180, "job1": 60}, {"timestamp": 1600000240, "job1": 99}]`)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := func() *queryModel {
+		return &queryModel{QueryType: queryTypeOutages, AppID: "app1", JobID: "job1",
+			From: time.Unix(1599999000, 0), To: time.Unix(1600001000, 0)}
+	}
+
+	if res := p.queryAnnotation(context.Background(), "key", qm()); !errors.Is(res.Error, errFeatureDisabled) {
+		t.Fatalf("expected errFeatureDisabled, got %v", res.Error)
+	}
+
+	p.settings.EnableDecisions = true
+	res := p.queryAnnotation(context.Background(), "key", qm())
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 2 {
+		t.Fatalf("expected an annotation per drop, got %d", rows)
+	}
+	kinds := frame.Fields[len(frame.Fields)-1]
+	if kinds.Name != "kind" || kinds.At(0) != dropInsufficient || kinds.At(1) != dropOutage {
+		t.Errorf("expected a drop due to a lack of data then a real outage, got %v", kinds)
+	}
+	if text := frame.Fields[3].At(1).(string); !strings.HasPrefix(text, "real outage: Job 1 availability below 95%") {
+		t.Errorf("unexpected text %q", text)
+	}
+	if tags := frame.Fields[4].At(0); tags != "availability,job1,insufficient" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// defaultBandDeviations is the width of the anomaly bands, in standard
+// deviations, when not set.
+const defaultBandDeviations = 2
+
+var errInvalidBand = newInvalidQueryError("invalid anomaly band, expected a window such as 1h and a positive number of deviations")
+
+// anomalyBand is the expected range of a series, the rolling mean of the
+// points of the Window before each point, plus or minus Deviations standard
+// deviations.
+type anomalyBand struct {
+	Window     string  `json:"window"`
+	Deviations float64 `json:"deviations"`
+}
+
+// window returns the parsed Window of the band.
+func (b *anomalyBand) window() (time.Duration, error) {
+	window, err := parseDuration(b.Window)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("%w: invalid window %q", errInvalidBand, b.Window)
+	}
+	return window, nil
+}
+
+// check rejects the invalid windows and deviations, and the bands of the
+// queries that don't return a series of the data.
+func (b *anomalyBand) check(qm *queryModel) error {
+	if _, err := b.window(); err != nil {
+		return err
+	}
+	if b.Deviations < 0 {
+		return fmt.Errorf("%w: %v deviations", errInvalidBand, b.Deviations)
+	}
+	if qm.Reduce != "" || qm.Condition != nil {
+		return fmt.Errorf("%w: the bands need the series, not a reducer nor a condition", errInvalidBand)
+	}
+	return nil
+}
+
+func (b *anomalyBand) deviations() float64 {
+	if b.Deviations == 0 {
+		return defaultBandDeviations
+	}
+	return b.Deviations
+}
+
+// bands returns the lower and upper bounds of the band at every point, out of
+// the points within the window before it, the point included. The bounds are
+// null until the window holds two points.
+func (b *anomalyBand) bands(times []time.Time, values []float64, window time.Duration) (lower, upper []*float64) {
+	lower = make([]*float64, len(values))
+	upper = make([]*float64, len(values))
+	k := b.deviations()
+
+	var (
+		first      int
+		sum, sumSq float64
+	)
+	for i, value := range values {
+		sum += value
+		sumSq += value * value
+		for times[first].Before(times[i].Add(-window)) {
+			sum -= values[first]
+			sumSq -= values[first] * values[first]
+			first++
+		}
+
+		n := float64(i - first + 1)
+		if n < 2 {
+			continue
+		}
+		mean := sum / n
+		// The rounding errors of the running sums may go below zero.
+		stddev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+		low, high := mean-k*stddev, mean+k*stddev
+		lower[i], upper[i] = &low, &high
+	}
+	return lower, upper
+}
+
+// historyQuery returns the query extended by the window before its range, so
+// the band is already computed over a whole window at the start of the range.
+// The max data points grow along, to keep the points of the range.
+func (b *anomalyBand) historyQuery(qm *queryModel, window time.Duration) *queryModel {
+	history := *qm
+	history.From = qm.From.Add(-window)
+	if length := qm.To.Sub(qm.From); length > 0 && qm.MaxDataPoints < math.MaxInt64/2 {
+		extra := float64(qm.MaxDataPoints) * float64(window) / float64(length)
+		history.MaxDataPoints += int64(math.Min(extra, math.MaxInt64/2))
+	}
+	return &history
+}
+
+// trimBefore drops the points before from, along with their bounds.
+func trimBefore(from time.Time, times []time.Time, values []float64, lower, upper []*float64) ([]time.Time, []float64, []*float64, []*float64) {
+	i := 0
+	for i < len(times) && times[i].Before(from) {
+		i++
+	}
+	return times[i:], values[i:], lower[i:], upper[i:]
+}
@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestAnomalyBands(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	times := make([]time.Time, 6)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Minute)
+	}
+	values := []float64{10, 20, 10, 20, 10, 20}
+
+	band := &anomalyBand{Window: "2m", Deviations: 1}
+	lower, upper := band.bands(times, values, 2*time.Minute)
+	if lower[0] != nil || upper[0] != nil {
+		t.Errorf("expected no band for a single point, got %v and %v", *lower[0], *upper[0])
+	}
+	// The window of 2 minutes holds the 3 latest points.
+	if math.Abs(*lower[2]-(40.0/3-math.Sqrt(200.0/9))) > 1e-9 || math.Abs(*upper[3]-(50.0/3+math.Sqrt(200.0/9))) > 1e-9 {
+		t.Errorf("unexpected bands %v and %v", *lower[2], *upper[3])
+	}
+
+	trimmedTimes, trimmedValues, trimmedLower, trimmedUpper := trimBefore(times[4], times, values, lower, upper)
+	if len(trimmedTimes) != 2 || len(trimmedValues) != 2 || len(trimmedLower) != 2 || len(trimmedUpper) != 2 {
+		t.Errorf("expected the 2 latest points, got %d", len(trimmedTimes))
+	}
+}
+
+func TestQueryAnomalyBand(t *testing.T) {
+	ns1 := testutil.NewNS1(testutil.OptionValue("job1", 30))
+	var start string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("start") != "" {
+			start = r.URL.Query().Get("start")
+		}
+		ns1.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 60,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "band": {"window": "30m"}}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if start != "1599998200" {
+		t.Errorf("expected the data of the window before the range, got %s", start)
+	}
+	fields := res.Frames[0].Fields
+	if len(fields) != 4 || fields[2].Name != "lower" || fields[3].Name != "upper" {
+		t.Fatalf("expected the lower and upper fields, got %d fields", len(fields))
+	}
+	if fields[0].Len() != 60 || fields[0].At(0).(time.Time).Before(to.Add(-time.Hour)) {
+		t.Errorf("expected the points of the range only, got %d from %v", fields[0].Len(), fields[0].At(0))
+	}
+	if lower := fields[2].At(0).(*float64); lower == nil || *lower != 30 {
+		t.Errorf("expected a flat band at the start of the range, got %v", lower)
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "reduce": "last", "band": {"window": "30m"}}`); !errors.Is(res.Error, errInvalidBand) {
+		t.Errorf("expected errInvalidBand with a reducer, got %v", res.Error)
+	}
+	if res := query(`{"appid": "app1", "jobid": "job1", "band": {"window": "later"}}`); !errors.Is(res.Error, errInvalidBand) {
+		t.Errorf("expected errInvalidBand with an invalid window, got %v", res.Error)
+	}
+}
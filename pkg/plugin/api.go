@@ -0,0 +1,47 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"time"
+)
+
+// PulsarAPI is the part of the NS1 API the queries and resources are answered
+// from. PulsarClient implements it over HTTP, the tests swap it for fakes
+// answering without any server.
+type PulsarAPI interface {
+	GetApps(ctx context.Context, apiKey string, params ...PulsarAppParameter) (*GetAppsResponse, error)
+	GetJobs(ctx context.Context, apiKey, appID string, params ...PulsarAppParameter) ([]Job, error)
+	GetData(ctx context.Context, apiKey string, qm *queryModel) ([]time.Time, []float64, error)
+	GetInsufficientDecisions(ctx context.Context, apiKey string, qm *queryModel) ([]time.Time, []float64, error)
+	GetPulsarStage(ctx context.Context, apiKey, zone, domain, recordType string) (string, error)
+	GetDecisionAnswers(ctx context.Context, apiKey string, qm *queryModel) ([]map[string]float64, error)
+	GetActivity(ctx context.Context, apiKey string, qm *queryModel) ([]Activity, error)
+	GetRouteMaps(ctx context.Context, apiKey string) ([]RouteMap, error)
+	GetDHCPScopes(ctx context.Context, apiKey string) ([]DHCPScope, error)
+	GetMonitoringStatuses(ctx context.Context, apiKey, jobID string) ([]MonitoringStatus, error)
+	GetMonitoringFailures(ctx context.Context, apiKey string, qm *queryModel) ([]MonitoringFailure, error)
+	GetMonitoringMetrics(ctx context.Context, apiKey string, qm *queryModel) ([]MonitoringSeries, error)
+	GetQPS(ctx context.Context, apiKey string, qm *queryModel) (float64, error)
+	GetReport(ctx context.Context, apiKey string, qm *queryModel, maxWait time.Duration) ([][]string, error)
+	GetShedLoad(ctx context.Context, apiKey string, qm *queryModel) ([]ShedLoad, error)
+	GetNetworks(ctx context.Context, apiKey string) ([]Network, error)
+	GetUsage(ctx context.Context, apiKey string, qm *queryModel) ([]UsageSeries, error)
+	GetZones(ctx context.Context, apiKey string) ([]string, error)
+	GetRecords(ctx context.Context, apiKey, zone string) ([]DNSRecord, error)
+}
+
+var _ PulsarAPI = (*PulsarClient)(nil)
+
+// pulsarAPI returns the API the queries are answered from, the PulsarClient of
+// the datasource unless replaced. The client keeps answering for the caches,
+// the streams and the health checks.
+func (p *PulsarDatasource) pulsarAPI() PulsarAPI {
+	if p.api != nil {
+		return p.api
+	}
+	return p.pulsarClient
+}
@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// stubAPI answers the apps and the data out of memory, the other calls of the
+// API panic.
+type stubAPI struct {
+	PulsarAPI
+	apps   *GetAppsResponse
+	values []float64
+}
+
+func (s *stubAPI) GetApps(context.Context, string, ...PulsarAppParameter) (*GetAppsResponse, error) {
+	return s.apps, nil
+}
+
+func (s *stubAPI) GetData(_ context.Context, _ string, qm *queryModel) ([]time.Time, []float64, error) {
+	times := make([]time.Time, len(s.values))
+	for i := range times {
+		times[i] = qm.From.Add(time.Duration(i) * time.Minute)
+	}
+	return times, s.values, nil
+}
+
+func TestQueryStubAPI(t *testing.T) {
+	job := Job{JobID: "job1", Name: "Job 1"}
+	app := App{AppID: "app1", Name: "App 1", Jobs: []Job{job}}
+	api := &stubAPI{
+		apps: &GetAppsResponse{
+			Apps:    []App{app},
+			AppsMap: map[string]App{"app1": app},
+			JobsMap: map[string]Job{"job1": job},
+		},
+		values: []float64{1, 2, 3},
+	}
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(), api: api}
+
+	to := time.Unix(1600000000, 0)
+	res := p.queryWithKey(context.Background(), "key", backend.DataQuery{
+		RefID:         "A",
+		JSON:          []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`),
+		TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+		MaxDataPoints: 1000,
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if values := res.Frames[0].Fields[1]; values.Len() != 3 || values.At(2) != 3.0 {
+		t.Errorf("expected the values of the stub, got %d points", values.Len())
+	}
+}
@@ -0,0 +1,193 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// SecondaryAPIKey is the key to get the optional secondary NS1 API Key from
+	// the decrypted secure data. It is used when NS1 rejects the primary one.
+	SecondaryAPIKey = "secondaryApiKey"
+	// DataAPIKey is the key to get the optional NS1 API Key used for the data
+	// queries from the decrypted secure data. The catalog operations (apps and
+	// jobs listing) keep using the primary key, so each key can be granted
+	// the least privileges.
+	DataAPIKey = "dataApiKey"
+	// OrgAPIKeyPrefix prefixes the Grafana organization ID in the keys of the
+	// decrypted secure data holding per organization NS1 API keys, e.g.
+	// "orgApiKey.2". They route each organization to its own NS1 account.
+	OrgAPIKeyPrefix = "orgApiKey."
+	// UserAPIKeyPrefix and RoleAPIKeyPrefix prefix the Grafana user login, or
+	// role, in the keys of the decrypted secure data holding per user, or per
+	// role, NS1 API keys, e.g. "userApiKey.jdoe" or "roleApiKey.Viewer". They
+	// let the teams sharing a datasource use their own scoped key.
+	UserAPIKeyPrefix = "userApiKey."
+	RoleAPIKeyPrefix = "roleApiKey."
+)
+
+// apiKeys holds the NS1 API keys configured for the datasource.
+type apiKeys struct {
+	primary   string
+	secondary string
+	// data is the key of the data queries, if different from the catalog
+	// one.
+	data string
+	// route tells which mapped key is in use, if any, e.g. "user/jdoe".
+	route string
+}
+
+// pick returns the key to use given the fallback state.
+func (k *apiKeys) pick(fallback *keyFallback) string {
+	if k.secondary != "" && fallback.isActive() {
+		return k.secondary
+	}
+	return k.primary
+}
+
+type dataAPIKeyContextKey struct{}
+
+// withDataAPIKey sets the key of the data queries run with ctx. An empty key
+// leaves the data queries to the key of the request.
+func withDataAPIKey(ctx context.Context, apiKey string) context.Context {
+	if apiKey == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, dataAPIKeyContextKey{}, apiKey)
+}
+
+// dataAPIKeyFromContext returns the key of the data queries run with ctx, or
+// apiKey when there is no dedicated one.
+func dataAPIKeyFromContext(ctx context.Context, apiKey string) string {
+	if dataKey, ok := ctx.Value(dataAPIKeyContextKey{}).(string); ok {
+		return dataKey
+	}
+	return apiKey
+}
+
+// keyFallback tracks whether NS1 rejected the primary API key (rotation,
+// revocation...), in which case the secondary key is used instead.
+type keyFallback struct {
+	lock   sync.RWMutex
+	active bool
+}
+
+func (f *keyFallback) isActive() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.active
+}
+
+func (f *keyFallback) activate(logger log.Logger) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.active {
+		logger.Warn("NS1 rejected the primary API key, falling back to the secondary one")
+		f.active = true
+	}
+}
+
+// reset goes back to the primary key, once it's known to be valid again.
+func (f *keyFallback) reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.active = false
+}
+
+// getAPIKeysFromContext returns the API keys of the datasource. The primary key
+// is the one mapped to the user of the request if any, or to their role, or
+// to their organization, or else the one in the secure data, or the one from
+// the environment variable or file referenced in the settings, for
+// provisioned deployments.
+func getAPIKeysFromContext(pluginContext backend.PluginContext, settings *Settings) (*apiKeys, error) {
+	if pluginContext.DataSourceInstanceSettings == nil {
+		return nil, errDataSourceInstanceSettingsNil
+	}
+
+	if settings.offline() {
+		return &apiKeys{primary: offlineAPIKey, route: "offline"}, nil
+	}
+
+	secureData := pluginContext.DataSourceInstanceSettings.DecryptedSecureJSONData
+	keys := &apiKeys{secondary: secureData[SecondaryAPIKey], data: secureData[DataAPIKey]}
+
+	// The secondary and data keys back the default key only.
+	if user := pluginContext.User; user != nil {
+		if apiKey, exists := secureData[UserAPIKeyPrefix+user.Login]; exists && user.Login != "" {
+			return &apiKeys{primary: apiKey, route: "user/" + user.Login}, nil
+		}
+		if apiKey, exists := secureData[RoleAPIKeyPrefix+user.Role]; exists && user.Role != "" {
+			return &apiKeys{primary: apiKey, route: "role/" + user.Role}, nil
+		}
+	}
+
+	orgKey := OrgAPIKeyPrefix + strconv.FormatInt(pluginContext.OrgID, 10)
+	if apiKey, exists := secureData[orgKey]; exists {
+		return &apiKeys{primary: apiKey, route: "org"}, nil
+	}
+
+	if apiKey, exists := secureData[APIKey]; exists {
+		keys.primary = apiKey
+		return keys, nil
+	}
+
+	if settings.APIKeyEnv != "" || settings.APIKeyFile != "" {
+		apiKey, err := readExternalAPIKey(settings.APIKeyEnv, settings.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys.primary = apiKey
+		return keys, nil
+	}
+
+	if secureData == nil {
+		return nil, errDecryptedSecureDataNil
+	}
+	return nil, errAPIKeyNotFound
+}
+
+// readExternalAPIKey reads the API key from the environment variable, or else
+// from the file. The file is read every time, so rotated secrets are picked up.
+// Only the environment variables of the plugin, and the files of the directory
+// set by the server admin, can be read.
+func readExternalAPIKey(envName, file string) (string, error) {
+	if envName != "" {
+		if !strings.HasPrefix(envName, apiKeyEnvPrefix) {
+			return "", fmt.Errorf("%w: environment variable %s doesn't start with %s", errAPIKeyNotFound, envName,
+				apiKeyEnvPrefix)
+		}
+		if apiKey := strings.TrimSpace(os.Getenv(envName)); apiKey != "" {
+			return apiKey, nil
+		}
+		if file == "" {
+			return "", fmt.Errorf("%w: environment variable %s is not set", errAPIKeyNotFound, envName)
+		}
+	}
+
+	path, err := pathInServerDir(apiKeyDirEnv, file)
+	if err != nil {
+		return "", fmt.Errorf("%w: can't read the API key file: %v", errAPIKeyNotFound, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: can't read the API key file: %v", errAPIKeyNotFound, err)
+	}
+	apiKey := strings.TrimSpace(string(content))
+	if apiKey == "" {
+		return "", fmt.Errorf("%w: the API key file %s is empty", errAPIKeyNotFound, file)
+	}
+
+	return apiKey, nil
+}
@@ -0,0 +1,161 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+// newKeysDatasource returns a datasource of the fake NS1 API with the API
+// keys, along with the context of its requests.
+func newKeysDatasource(t *testing.T, server *testutil.Server, secureData map[string]string) (*PulsarDatasource, backend.PluginContext) {
+	instanceSettings := backend.DataSourceInstanceSettings{
+		JSONData:                []byte(fmt.Sprintf(`{"endpoint": %q}`, server.URL)),
+		DecryptedSecureJSONData: secureData,
+	}
+	instance, err := NewPulsarDatasource(instanceSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance.(*PulsarDatasource), backend.PluginContext{DataSourceInstanceSettings: &instanceSettings}
+}
+
+func TestSecondaryKeyFallback(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionAPIKey("secondary-key"), testutil.OptionValue("job1", 42))
+	defer server.Close()
+	p, pCtx := newKeysDatasource(t, server, map[string]string{APIKey: "primary-key", SecondaryAPIKey: "secondary-key"})
+	defer p.Dispose()
+
+	to := time.Unix(1600000000, 0)
+	query := backend.DataQuery{
+		RefID:     "A",
+		JSON:      []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50"}`),
+		TimeRange: backend.TimeRange{From: to.Add(-time.Hour), To: to},
+	}
+	if res := p.query(context.Background(), pCtx, query); res.Error != nil {
+		t.Fatalf("expected the query retried with the secondary key, got %v", res.Error)
+	}
+	keys, err := getAPIKeysFromContext(pCtx, p.settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.keyFallback.isActive() || keys.pick(&p.keyFallback) != "secondary-key" {
+		t.Fatal("expected the 401 on the primary key to switch to the secondary one")
+	}
+
+	p.keyFallback.reset()
+	if keys.pick(&p.keyFallback) != "primary-key" {
+		t.Error("expected the reset to go back to the primary key")
+	}
+	if (&apiKeys{primary: "primary-key"}).pick(&p.keyFallback) != "primary-key" {
+		t.Error("expected the primary key without a secondary one")
+	}
+}
+
+func TestDataKeyRejectionKeepsPrimary(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionAPIKey("primary-key"), testutil.OptionValue("job1", 42))
+	defer server.Close()
+	p, pCtx := newKeysDatasource(t, server, map[string]string{APIKey: "primary-key", SecondaryAPIKey: "secondary-key",
+		DataAPIKey: "revoked-key"})
+	defer p.Dispose()
+
+	to := time.Unix(1600000000, 0)
+	res := p.query(context.Background(), pCtx, backend.DataQuery{
+		RefID:     "A",
+		JSON:      []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50"}`),
+		TimeRange: backend.TimeRange{From: to.Add(-time.Hour), To: to},
+	})
+	if !errors.Is(res.Error, errDataKeyRejected) {
+		t.Fatalf("expected errDataKeyRejected, got %v", res.Error)
+	}
+	if p.keyFallback.isActive() {
+		t.Error("expected the rejection of the data key not to switch to the secondary key")
+	}
+}
+
+func TestHealthCheckResetsKeyFallback(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionAPIKey("primary-key"))
+	defer server.Close()
+	p, pCtx := newKeysDatasource(t, server, map[string]string{APIKey: "primary-key", SecondaryAPIKey: "secondary-key"})
+	defer p.Dispose()
+
+	p.keyFallback.activate(p.logger)
+	res, err := p.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pCtx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Fatalf("expected a healthy datasource, got %s", res.Message)
+	}
+	if p.keyFallback.isActive() {
+		t.Error("expected the valid primary key to be used again")
+	}
+}
+
+func TestReadExternalAPIKey(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(dir, "ns1-key"):       "file-key\n",
+		filepath.Join(dir, "empty"):         " \n",
+		filepath.Join(outside, "secret"):    "server-secret",
+		filepath.Join(outside, "other-key"): "other-key",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PULSAR_TEST_API_KEY", "env-key")
+	defer os.Unsetenv("PULSAR_TEST_API_KEY")
+	os.Setenv("TEST_SERVER_SECRET", "server-secret")
+	defer os.Unsetenv("TEST_SERVER_SECRET")
+	os.Setenv(apiKeyDirEnv, dir)
+	defer os.Unsetenv(apiKeyDirEnv)
+
+	tests := []struct {
+		env, file string
+		want      string
+	}{
+		{"PULSAR_TEST_API_KEY", "", "env-key"},
+		{"PULSAR_TEST_MISSING_KEY", filepath.Join(dir, "ns1-key"), "file-key"},
+		{"", "ns1-key", "file-key"},
+		{"PULSAR_TEST_MISSING_KEY", "", ""},
+		{"TEST_SERVER_SECRET", "", ""},
+		{"", "empty", ""},
+		{"", "missing", ""},
+		{"", filepath.Join(outside, "other-key"), ""},
+		{"", "../" + filepath.Base(outside) + "/other-key", ""},
+		{"", "link", ""},
+	}
+	for _, tt := range tests {
+		apiKey, err := readExternalAPIKey(tt.env, tt.file)
+		if tt.want == "" {
+			if !errors.Is(err, errAPIKeyNotFound) {
+				t.Errorf("env %q, file %q: expected errAPIKeyNotFound, got %q, %v", tt.env, tt.file, apiKey, err)
+			}
+			continue
+		}
+		if err != nil || apiKey != tt.want {
+			t.Errorf("env %q, file %q: expected %q, got %q, %v", tt.env, tt.file, tt.want, apiKey, err)
+		}
+	}
+
+	os.Unsetenv(apiKeyDirEnv)
+	if _, err := readExternalAPIKey("", filepath.Join(dir, "ns1-key")); !errors.Is(err, errAPIKeyNotFound) {
+		t.Errorf("expected no file read without %s, got %v", apiKeyDirEnv, err)
+	}
+}
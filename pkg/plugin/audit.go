@@ -0,0 +1,50 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+type auditContextKey struct{}
+
+// auditLogger returns the logger of the audit trail of a request, with the
+// identity of the Grafana user. The audit trail ignores the log level of the
+// datasource, it must not be turned off by mistake.
+func auditLogger(pCtx backend.PluginContext, requestID string) log.Logger {
+	fields := []interface{}{"requestId", requestID}
+	if pCtx.User != nil {
+		fields = append(fields, "user", pCtx.User.Login, "email", pCtx.User.Email,
+			"role", pCtx.User.Role)
+	}
+	return withFields(pluginContextLogger(Logger, pCtx), fields...)
+}
+
+func withAuditLogger(ctx context.Context, logger log.Logger) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, logger)
+}
+
+// auditQuery logs who ran the query, when the audit trail is enabled. The
+// retries aren't logged again.
+func auditQuery(ctx context.Context, refID string, qm *queryModel) {
+	logger, ok := ctx.Value(auditContextKey{}).(log.Logger)
+	if !ok || isRetry(ctx) {
+		return
+	}
+
+	if _, found := queryTypeLabels[qm.QueryType]; found {
+		logger.Info("Query audit", "refId", refID, "queryType", qm.QueryType, "app", qm.AppID, "job", qm.JobID, "zone", qm.Zone,
+			"domain", qm.Domain, "type", qm.RecordType, "monitoringJob", qm.MonitoringJobID,
+			"from", qm.From.UTC().Format(time.RFC3339), "to", qm.To.UTC().Format(time.RFC3339))
+		return
+	}
+	logger.Info("Query audit", "refId", refID, "app", qm.AppID, "job", qm.JobID,
+		"metric", qm.MetricType, "agg", qm.Aggregation, "geo", qm.Geo, "asn", qm.ASN,
+		"from", qm.From.UTC().Format(time.RFC3339), "to", qm.To.UTC().Format(time.RFC3339))
+}
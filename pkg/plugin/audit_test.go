@@ -0,0 +1,41 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestAuditQuery(t *testing.T) {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	qm := &queryModel{AppID: "app1", JobID: "job1", MetricType: metricTypePerformance,
+		Aggregation: "p95", Geo: "*", ASN: "*", From: from, To: from.Add(time.Hour)}
+
+	// Nothing is logged when the audit trail is disabled.
+	auditQuery(context.Background(), "A", qm)
+
+	recorder := &recordingLogger{}
+	pCtx := backend.PluginContext{OrgID: 1, User: &backend.User{Login: "jdoe", Role: "Viewer"}}
+	logger := withFields(recorder, auditLogger(pCtx, "req-1").(*fieldsLogger).fields...)
+	ctx := withAuditLogger(context.Background(), logger)
+
+	auditQuery(ctx, "A", qm)
+	auditQuery(withRetry(ctx), "A", qm)
+
+	if len(recorder.lines) != 1 {
+		t.Fatalf("expected a single audit entry, got %v", recorder.lines)
+	}
+	for _, expected := range []string{"Query audit", "userjdoe", "roleViewer", "requestIdreq-1",
+		"jobjob1", "aggp95", "from2022-01-01T00:00:00Z", "to2022-01-01T01:00:00Z"} {
+		if !strings.Contains(recorder.lines[0], expected) {
+			t.Errorf("expected %q in %q", expected, recorder.lines[0])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxBaselineWeeks is how far back the baseline of a query can go.
+const maxBaselineWeeks = 52
+
+const week = 7 * 24 * time.Hour
+
+var errInvalidBaseline = newInvalidQueryError("invalid baseline, expected a number of weeks between 1 and 52")
+
+// checkBaseline rejects the baselines out of range, and the baselines of the
+// queries that don't return a series of the data.
+func checkBaseline(qm *queryModel) error {
+	if qm.BaselineWeeks == 0 {
+		return nil
+	}
+	if qm.BaselineWeeks < 0 || qm.BaselineWeeks > maxBaselineWeeks {
+		return fmt.Errorf("%w: %d", errInvalidBaseline, qm.BaselineWeeks)
+	}
+	if qm.Reduce != "" || qm.Condition != nil {
+		return fmt.Errorf("%w: the baseline needs the series, not a reducer nor a condition", errInvalidBaseline)
+	}
+	return nil
+}
+
+// baselineLabel describes the baseline in the legend, e.g. 2 weeks ago.
+func baselineLabel(weeks int) string {
+	if weeks == 1 {
+		return "1 week ago"
+	}
+	return fmt.Sprintf("%d weeks ago", weeks)
+}
+
+// getBaseline fetches the series of the same window BaselineWeeks weeks
+// before the range of the query, rolled up like the series when the query has
+// a quantile, and aligns it on the times of the series. The points without
+// baseline are null, all of them when NS1 has no data for the window.
+func (p *PulsarDatasource) getBaseline(ctx context.Context, apiKey string, qm *queryModel, times []time.Time, quantileInterval time.Duration) ([]*float64, error) {
+	shift := time.Duration(qm.BaselineWeeks) * week
+	baseline := *qm
+	baseline.From = qm.From.Add(-shift)
+	baseline.To = qm.To.Add(-shift)
+
+	baseTimes, baseValues, err := p.getData(ctx, apiKey, &baseline)
+	if errors.Is(err, errNoDataFound) {
+		return make([]*float64, len(times)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if qm.Quantile > 0 {
+		baseTimes, baseValues, _ = quantileOverTime(baseTimes, baseValues, quantileInterval, qm.Quantile)
+	}
+	for i := range baseTimes {
+		baseTimes[i] = baseTimes[i].Add(shift)
+	}
+	return alignSeries(times, baseTimes, baseValues), nil
+}
+
+// alignSeries returns the values of the other series at the times, the
+// closest point within half a step of the series, or null. Both series are
+// sorted by time.
+func alignSeries(times, otherTimes []time.Time, otherValues []float64) []*float64 {
+	aligned := make([]*float64, len(times))
+	if len(times) == 0 || len(otherTimes) == 0 {
+		return aligned
+	}
+	tolerance := time.Duration(0)
+	if len(times) > 1 {
+		tolerance = times[len(times)-1].Sub(times[0]) / time.Duration(len(times)-1) / 2
+	}
+
+	j := 0
+	for i, t := range times {
+		// Move to the last point of the other series not after the time.
+		for j+1 < len(otherTimes) && !otherTimes[j+1].After(t) {
+			j++
+		}
+		closest := j
+		if j+1 < len(otherTimes) && otherTimes[j+1].Sub(t) < absDuration(t.Sub(otherTimes[j])) {
+			closest = j + 1
+		}
+		if absDuration(t.Sub(otherTimes[closest])) <= tolerance {
+			value := otherValues[closest]
+			aligned[i] = &value
+		}
+	}
+	return aligned
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
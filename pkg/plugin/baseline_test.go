@@ -0,0 +1,84 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestAlignSeries(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	times := []time.Time{start, start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)}
+	otherTimes := []time.Time{start.Add(-20 * time.Second), start.Add(70 * time.Second), start.Add(3 * time.Minute)}
+
+	aligned := alignSeries(times, otherTimes, []float64{1, 2, 3})
+	if aligned[0] == nil || *aligned[0] != 1 || aligned[1] == nil || *aligned[1] != 2 {
+		t.Errorf("expected the closest points within half a step, got %v", aligned)
+	}
+	if aligned[2] != nil {
+		t.Errorf("expected no baseline without a close point, got %v", *aligned[2])
+	}
+	if aligned[3] == nil || *aligned[3] != 3 {
+		t.Errorf("expected the point at the same time, got %v", aligned[3])
+	}
+}
+
+func TestQueryBaseline(t *testing.T) {
+	to := time.Unix(1600003600, 0)
+	ns1 := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		if r.URL.Query().Get("jobs") == "" {
+			ns1.ServeHTTP(w, r)
+			return
+		}
+		// 40 a week ago, 20 today.
+		value := 20
+		if start < to.Add(-week+time.Hour).Unix() {
+			value = 40
+		}
+		fmt.Fprintf(w, `[{"timestamp": %d, "job1": %d}, {"timestamp": %d, "job1": %d}]`, start, value, start+60, value)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 100,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "baselineWeeks": 1}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	fields := res.Frames[0].Fields
+	if len(fields) != 3 || fields[2].Name != "baseline" {
+		t.Fatalf("expected a baseline field, got %d fields", len(fields))
+	}
+	if value := fields[2].At(1).(*float64); value == nil || *value != 40 || fields[1].At(1) != 20.0 {
+		t.Errorf("expected the value of a week ago next to the current one, got %v", value)
+	}
+	if name := fields[2].Config.DisplayNameFromDS; name != fields[1].Config.DisplayNameFromDS+" 1 week ago" {
+		t.Errorf("unexpected display name %q", name)
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "baselineWeeks": 60}`); !errors.Is(res.Error, errInvalidBaseline) {
+		t.Errorf("expected errInvalidBaseline, got %v", res.Error)
+	}
+}
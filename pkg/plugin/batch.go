@@ -0,0 +1,222 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+type dataBatchContextKey struct{}
+
+// dataBatch coalesces the data requests of the queries of a QueryDataRequest
+// differing by their job only: the first query of a group fetches the points
+// of every job of the group in a single NS1 request, jobs being a comma
+// separated list, and the others read theirs from it. It also keeps the
+// queries it parsed, so they aren't parsed again.
+type dataBatch struct {
+	mu sync.Mutex
+	// jobs are the jobs of each group of queries.
+	jobs map[string][]string
+	// fetched are the results of the requests of the groups, by group and
+	// API key.
+	fetched map[string]*batchResult
+	// parsed are the queries of the request, by ref ID, until they run.
+	parsed map[string]*parsedQuery
+}
+
+type batchResult struct {
+	once sync.Once
+	data []map[string]float64
+	err  error
+	// timings, debug and lookups are those of the request of the group,
+	// added to every query of the group.
+	timings *queryTimings
+	debug   *queryDebug
+	lookups *cacheLookups
+}
+
+// parsedQuery is a query parsed by the batch.
+type parsedQuery struct {
+	json     []byte
+	qm       *queryModel
+	warnings []string
+	err      error
+}
+
+// batchKey identifies the group of the query: the same metric, aggregation,
+// range, geo and ASN.
+func batchKey(qm *queryModel) string {
+	return fmt.Sprintf("%s|%s|%d|%d|%s|%s", qm.MetricType, qm.Aggregation, qm.From.Unix(), qm.To.Unix(), qm.Geo, qm.ASN)
+}
+
+// newDataBatch parses the queries of the request and groups the Pulsar ones.
+// The queries fetching their data their own way, the queries of the apps and
+// jobs not exposed to the user, and the groups of a single job, are left out.
+// The jobs not exposed are known from the catalog of the key of the queries,
+// which then read it from the cache.
+func (p *PulsarDatasource) newDataBatch(ctx context.Context, pCtx backend.PluginContext, queries []backend.DataQuery) *dataBatch {
+	batch := &dataBatch{
+		jobs:    make(map[string][]string),
+		fetched: make(map[string]*batchResult),
+		parsed:  make(map[string]*parsedQuery, len(queries)),
+	}
+	for _, query := range queries {
+		qm := &queryModel{}
+		warnings, err := qm.parse(query.JSON)
+		batch.parsed[query.RefID] = &parsedQuery{json: query.JSON, qm: qm, warnings: warnings, err: err}
+	}
+
+	var catalog *GetAppsResponse
+	if p.appFilter(ctx) != nil {
+		_, apiKey, err := p.queryKeys(pCtx)
+		if err != nil {
+			// The queries fail on their own with the error.
+			return batch
+		}
+		if catalog, _, err = p.catalog(ctx, apiKey); err != nil {
+			loggerFromContext(ctx).Warn("Failed to get the catalog, the queries aren't batched", "error", err)
+			return batch
+		}
+	}
+
+	for _, query := range queries {
+		parsed := batch.parsed[query.RefID]
+		if parsed.err != nil || !bytes.Equal(parsed.json, query.JSON) {
+			continue
+		}
+		// The defaults are applied to a copy, the query applies them again.
+		qm := *parsed.qm
+		if _, found := queryTypeLabels[qm.QueryType]; found || qm.LastPoints > 0 || qm.Band != nil {
+			continue
+		}
+		qm.applyDefaults(p.settings)
+		qm.validate()
+		if !qm.canQuery() || (catalog != nil && checkQueryAllowed(&qm, catalog) != nil) {
+			continue
+		}
+		qm.From, qm.To = query.TimeRange.From, query.TimeRange.To
+		key := batchKey(&qm)
+		if !containsString(batch.jobs[key], qm.JobID) {
+			batch.jobs[key] = append(batch.jobs[key], qm.JobID)
+		}
+	}
+	for key, jobs := range batch.jobs {
+		if len(jobs) < 2 {
+			delete(batch.jobs, key)
+			continue
+		}
+		sort.Strings(jobs)
+	}
+	return batch
+}
+
+// parse returns the query as parsed by the batch, the first time only, as the
+// query model is changed by the query, or parses it.
+func (b *dataBatch) parse(query backend.DataQuery) (*queryModel, []string, error) {
+	if b != nil {
+		b.mu.Lock()
+		parsed, found := b.parsed[query.RefID]
+		delete(b.parsed, query.RefID)
+		b.mu.Unlock()
+		if found && bytes.Equal(parsed.json, query.JSON) {
+			return parsed.qm, parsed.warnings, parsed.err
+		}
+	}
+	qm := &queryModel{}
+	warnings, err := qm.parse(query.JSON)
+	return qm, warnings, err
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// withDataBatch returns a copy of the context carrying the batch.
+func withDataBatch(ctx context.Context, batch *dataBatch) context.Context {
+	return context.WithValue(ctx, dataBatchContextKey{}, batch)
+}
+
+// dataBatchFromContext returns the batch of the request, nil if none. The
+// retries aren't batched, they'd get the failure of the batch again.
+func dataBatchFromContext(ctx context.Context) *dataBatch {
+	if isRetry(ctx) {
+		return nil
+	}
+	batch, _ := ctx.Value(dataBatchContextKey{}).(*dataBatch)
+	return batch
+}
+
+// groupOf returns the jobs of the group of the query, nil when the query isn't
+// batched.
+func (b *dataBatch) groupOf(qm *queryModel) (string, []string) {
+	if b == nil {
+		return "", nil
+	}
+	key := batchKey(qm)
+	jobs := b.jobs[key]
+	if !containsString(jobs, qm.JobID) {
+		return "", nil
+	}
+	return key, jobs
+}
+
+// fetch returns the points of the group, fetched once per API key. The timings
+// and the debug information of the request are added to every query of the
+// group, as if each had sent it.
+func (b *dataBatch) fetch(ctx context.Context, key, apiKey string, fetch func(context.Context) ([]map[string]float64, error)) ([]map[string]float64, error) {
+	b.mu.Lock()
+	result, found := b.fetched[key+"|"+apiKey]
+	if !found {
+		result = &batchResult{}
+		b.fetched[key+"|"+apiKey] = result
+	}
+	b.mu.Unlock()
+
+	result.once.Do(func() {
+		result.timings, result.debug, result.lookups = newQueryTimings(), &queryDebug{}, &cacheLookups{}
+		result.data, result.err = fetch(withCacheLookups(withQueryDebug(withTimings(ctx, result.timings), result.debug),
+			result.lookups))
+	})
+	timingsFromContext(ctx).add(result.timings)
+	queryDebugFromContext(ctx).addRequests(result.debug)
+	cacheLookupsFromContext(ctx).add(result.lookups)
+	return result.data, result.err
+}
+
+// fetchBatched gets the data points of the query from the request of its
+// group, or on its own when it isn't batched or when a job of the group is
+// unknown to NS1, failing the whole group. The points of the group lacking
+// the job are kept, as missing values, like in the response of the job alone.
+func (pc *PulsarClient) fetchBatched(ctx context.Context, apiKey, endpoint, key string, jobs []string, query *queryModel) ([]map[string]float64, error) {
+	if jobs == nil {
+		return pc.fetchRange(ctx, apiKey, endpoint, query)
+	}
+	data, err := dataBatchFromContext(ctx).fetch(ctx, key, apiKey, func(ctx context.Context) ([]map[string]float64, error) {
+		recordFeatureUse(ctx, featureBatching)
+		group := *query
+		group.JobID = strings.Join(jobs, ",")
+		return pc.fetchRange(ctx, apiKey, endpoint, &group)
+	})
+	if errors.Is(err, errJobNotFound) {
+		return pc.fetchRange(ctx, apiKey, endpoint, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,220 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryDataBatch(t *testing.T) {
+	server := testutil.NewServer(
+		testutil.OptionApps(testutil.App{AppID: "app1", Name: "App 1", Jobs: []testutil.Job{
+			{JobID: "job1", Name: "Job 1", TypeID: "latency"},
+			{JobID: "job2", Name: "Job 2", TypeID: "latency"},
+			{JobID: "job3", Name: "Job 3", TypeID: "latency"},
+		}}),
+		testutil.OptionValue("job1", 10), testutil.OptionValue("job2", 20), testutil.OptionValue("job3", 30))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(refID, json string) backend.DataQuery {
+		return backend.DataQuery{
+			RefID:         refID,
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		}
+	}
+	resp, err := p.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+			},
+		},
+		Queries: []backend.DataQuery{
+			query("A", `{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50"}`),
+			query("B", `{"appid": "app1", "jobid": "job2", "metricType": "performance", "agg": "p50"}`),
+			query("C", `{"appid": "app1", "jobid": "job3", "metricType": "performance", "agg": "p50", "geo": "EU"}`),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for refID, expected := range map[string]float64{"A": 10, "B": 20, "C": 30} {
+		res := resp.Responses[refID]
+		if res.Error != nil {
+			t.Fatalf("%s: %v", refID, res.Error)
+		}
+		if values := res.Frames[0].Fields[1]; values.Len() != 60 || values.At(0) != expected {
+			t.Errorf("%s: expected the 60 points of its job, got %d points of %v", refID, values.Len(), values.At(0))
+		}
+	}
+	// A and B share a request, C is of another geo.
+	if requests := server.NS1.Requests("/pulsar/query/performance/time"); requests != 2 {
+		t.Errorf("expected 2 data requests, got %d", requests)
+	}
+}
+
+func TestQueryDataBatchMatchesUnbatched(t *testing.T) {
+	catalog := testutil.NewNS1(testutil.OptionApps(
+		testutil.App{AppID: "app1", Name: "App 1", Jobs: []testutil.Job{
+			{JobID: "job1", Name: "Job 1", TypeID: "latency"},
+			{JobID: "job2", Name: "Job 2", TypeID: "latency"},
+		}},
+		testutil.App{AppID: "app2", Name: "App 2", Jobs: []testutil.Job{
+			{JobID: "job3", Name: "Job 3", TypeID: "latency"},
+		}},
+	))
+	// job2 only has a value for the first minute.
+	var batched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			catalog.ServeHTTP(w, r)
+			return
+		}
+		jobs := strings.Split(r.URL.Query().Get("jobs"), ",")
+		if len(jobs) > 1 {
+			batched = append(batched, r.URL.Query().Get("jobs"))
+		}
+		points := []map[string]float64{{"timestamp": 1600003480}, {"timestamp": 1600003540}}
+		for _, job := range jobs {
+			points[0][job] = 2
+			if job != "job2" {
+				points[1][job] = 1
+			}
+		}
+		_ = json.NewEncoder(w).Encode(points)
+	}))
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.AllowedApps = []string{"app1"}
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	queries := []backend.DataQuery{
+		{RefID: "A", JSON: []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50"}`)},
+		{RefID: "B", JSON: []byte(`{"appid": "app1", "jobid": "job2", "metricType": "performance", "agg": "p50"}`)},
+		// job3 belongs to app2, not exposed by the datasource.
+		{RefID: "C", JSON: []byte(`{"appid": "app1", "jobid": "job3", "metricType": "performance", "agg": "p50"}`)},
+	}
+	queryData := func(queries ...backend.DataQuery) *backend.QueryDataResponse {
+		for i := range queries {
+			queries[i].TimeRange = backend.TimeRange{From: to.Add(-time.Hour), To: to}
+			queries[i].MaxDataPoints = 1000
+		}
+		resp, err := p.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+					DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+				},
+			},
+			Queries: queries,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := queryData(queries...)
+	if len(batched) != 1 || batched[0] != "job1,job2" {
+		t.Errorf("expected job1 and job2 batched without job3, got %v", batched)
+	}
+	if !errors.Is(resp.Responses["C"].Error, errAppNotAllowed) {
+		t.Errorf("expected the job of app2 not allowed, got %v", resp.Responses["C"].Error)
+	}
+	for _, query := range queries[:2] {
+		res, alone := resp.Responses[query.RefID], queryData(query).Responses[query.RefID]
+		if res.Error != nil || alone.Error != nil {
+			t.Fatalf("%s: %v, %v", query.RefID, res.Error, alone.Error)
+		}
+		values, aloneValues := res.Frames[0].Fields[1], alone.Frames[0].Fields[1]
+		if values.Len() != 2 || values.Len() != aloneValues.Len() {
+			t.Fatalf("%s: expected the 2 points of the job alone, got %d and %d", query.RefID, values.Len(), aloneValues.Len())
+		}
+		for i := 0; i < values.Len(); i++ {
+			if values.At(i) != aloneValues.At(i) {
+				t.Errorf("%s: point %d: expected %v, got %v", query.RefID, i, aloneValues.At(i), values.At(i))
+			}
+		}
+	}
+}
+
+func TestQueryDataBatchDebug(t *testing.T) {
+	server := testutil.NewServer(
+		testutil.OptionValue("job1", 10), testutil.OptionValue("job2", 20))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(refID, json string) backend.DataQuery {
+		return backend.DataQuery{
+			RefID:         refID,
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		}
+	}
+	resp, err := p.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+			},
+		},
+		Queries: []backend.DataQuery{
+			query("A", `{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50", "debug": true}`),
+			query("B", `{"appid": "app1", "jobid": "job2", "metricType": "performance", "agg": "p50", "debug": true}`),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both queries show the request of the group, and its round trip.
+	for _, refID := range []string{"A", "B"} {
+		res := resp.Responses[refID]
+		if res.Error != nil {
+			t.Fatalf("%s: %v", refID, res.Error)
+		}
+		meta := res.Frames[0].Meta
+		if !strings.Contains(meta.ExecutedQueryString, "jobs=job1,job2") {
+			t.Errorf("%s: expected the request of the group, got %q", refID, meta.ExecutedQueryString)
+		}
+		for _, stat := range meta.Stats {
+			if stat.DisplayName == "NS1 round trip" && stat.Value <= 0 {
+				t.Errorf("%s: expected the round trip of the group timed", refID)
+			}
+		}
+	}
+}
+
+func TestDataBatchParse(t *testing.T) {
+	query := backend.DataQuery{RefID: "A", JSON: []byte(`{"appid": "app1", "jobid": "job1"}`)}
+	batch := (&PulsarDatasource{settings: defaultSettings()}).newDataBatch(context.Background(),
+		backend.PluginContext{}, []backend.DataQuery{query})
+
+	// The query is parsed once, then parsed again, for the retries.
+	parsed := batch.parsed["A"].qm
+	qm, _, err := batch.parse(query)
+	if err != nil || qm != parsed || qm.JobID != "job1" {
+		t.Fatalf("expected the query parsed by the batch, got %v", err)
+	}
+	again, _, err := batch.parse(query)
+	if err != nil || again == qm || again.JobID != "job1" {
+		t.Errorf("expected the query parsed again, got %v", err)
+	}
+}
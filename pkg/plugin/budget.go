@@ -0,0 +1,139 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const defaultUserRequestBurst = 10
+
+var errRateBudgetExceeded = errors.New("NS1 request budget of the user exhausted, try again in a few moments")
+
+// The outcomes of the NS1 requests held back by the budget of their user.
+const (
+	budgetDelayed  = "delayed"
+	budgetRejected = "rejected"
+)
+
+// rateBudget is the token bucket limiting the NS1 requests of a user, so the
+// large dashboards of one user can't use up the rate limit of the whole
+// account. A nil budget doesn't limit anything.
+type rateBudget struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateBudget(rate float64, burst int) *rateBudget {
+	return &rateBudget{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token, returning how long to wait for it. The tokens go
+// negative, so the requests waiting are served in order.
+func (b *rateBudget) reserve(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a token reserved but not used.
+func (b *rateBudget) cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens++
+}
+
+// wait blocks until the budget allows a request. The requests that would
+// wait longer than maxWait, or past the end of ctx, are rejected.
+func (b *rateBudget) wait(ctx context.Context, maxWait time.Duration) error {
+	if b == nil {
+		return nil
+	}
+
+	delay := b.reserve(time.Now())
+	if delay == 0 {
+		return nil
+	}
+	if delay > maxWait {
+		b.cancel()
+		budgetThrottledTotal.WithLabelValues(budgetRejected).Inc()
+		return fmt.Errorf("%w: the next request is allowed in %s", errRateBudgetExceeded,
+			delay.Truncate(time.Second))
+	}
+
+	budgetThrottledTotal.WithLabelValues(budgetDelayed).Inc()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return fmt.Errorf("%w: %v", errRateBudgetExceeded, ctx.Err())
+	}
+}
+
+// rateBudgets holds the budget of every user of the datasource.
+type rateBudgets struct {
+	lock  sync.Mutex
+	users map[string]*rateBudget
+}
+
+// forUser returns the budget of the user, or nil when the requests aren't
+// limited.
+func (bs *rateBudgets) forUser(settings *Settings, user *backend.User) *rateBudget {
+	if settings.UserRequestRate == 0 || user == nil || user.Login == "" {
+		return nil
+	}
+
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	if bs.users == nil {
+		bs.users = make(map[string]*rateBudget)
+	}
+	budget, exists := bs.users[user.Login]
+	if !exists {
+		budget = newRateBudget(settings.UserRequestRate, settings.userRequestBurst())
+		bs.users[user.Login] = budget
+	}
+	return budget
+}
+
+type rateBudgetContextKey struct{}
+
+func withRateBudget(ctx context.Context, budget *rateBudget) context.Context {
+	if budget == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, rateBudgetContextKey{}, budget)
+}
+
+// rateBudgetFromContext returns the budget of the user running the request,
+// nil if none.
+func rateBudgetFromContext(ctx context.Context) *rateBudget {
+	budget, _ := ctx.Value(rateBudgetContextKey{}).(*rateBudget)
+	return budget
+}
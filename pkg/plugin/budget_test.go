@@ -0,0 +1,71 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestRateBudget(t *testing.T) {
+	now := time.Now()
+	budget := &rateBudget{rate: 2, burst: 2, tokens: 2, last: now}
+
+	for i := 0; i < 2; i++ {
+		if delay := budget.reserve(now); delay != 0 {
+			t.Fatalf("expected the burst to be served at once, got %s", delay)
+		}
+	}
+	if delay := budget.reserve(now); delay != 500*time.Millisecond {
+		t.Fatalf("expected to wait for the next token, got %s", delay)
+	}
+	if delay := budget.reserve(now); delay != time.Second {
+		t.Fatalf("expected to wait behind the previous request, got %s", delay)
+	}
+
+	// The tokens refill over time, up to the burst.
+	if delay := budget.reserve(now.Add(time.Hour)); delay != 0 || budget.tokens != 1 {
+		t.Fatalf("expected the budget to be refilled, got %s and %v tokens", delay, budget.tokens)
+	}
+
+	budget.tokens = -10
+	if err := budget.wait(context.Background(), time.Second); !errors.Is(err, errRateBudgetExceeded) {
+		t.Fatalf("expected the request to be rejected, got %v", err)
+	}
+	if budget.tokens > -10 {
+		t.Fatalf("expected the rejected request to give its token back, got %v", budget.tokens)
+	}
+
+	var unlimited *rateBudget
+	if err := unlimited.wait(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateBudgets(t *testing.T) {
+	settings := defaultSettings()
+	user := &backend.User{Login: "jdoe"}
+
+	var budgets rateBudgets
+	if budgets.forUser(settings, user) != nil {
+		t.Fatal("expected no budget by default")
+	}
+
+	settings.UserRequestRate = 1
+	budget := budgets.forUser(settings, user)
+	if budget == nil || budget.burst != defaultUserRequestBurst {
+		t.Fatalf("expected a budget with the default burst, got %+v", budget)
+	}
+	if budgets.forUser(settings, user) != budget {
+		t.Error("expected the user to keep their budget")
+	}
+	if budgets.forUser(settings, &backend.User{Login: "other"}) == budget {
+		t.Error("expected each user to have their own budget")
+	}
+}
@@ -0,0 +1,183 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// Version is the version of the plugin, set at build time with
+// -ldflags "-X github.com/ns1labs/grafana-pulsar-datasource/pkg/plugin.Version=<version>".
+var Version = "dev"
+
+// maxRecentErrors is the number of query errors kept for the diagnostic
+// bundle.
+const maxRecentErrors = 50
+
+type recentError struct {
+	Time    time.Time   `json:"time"`
+	RefID   string      `json:"refId"`
+	Source  ErrorSource `json:"source"`
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+}
+
+// recentErrors keeps the last query errors, the oldest being dropped first.
+type recentErrors struct {
+	lock    sync.Mutex
+	entries []recentError
+}
+
+func (re *recentErrors) add(refID string, queryErr *QueryError) {
+	re.lock.Lock()
+	defer re.lock.Unlock()
+
+	if len(re.entries) == maxRecentErrors {
+		re.entries = re.entries[1:]
+	}
+	re.entries = append(re.entries, recentError{
+		Time:    time.Now().UTC(),
+		RefID:   refID,
+		Source:  queryErr.Source,
+		Status:  queryErr.Status,
+		Message: queryErr.Error(),
+	})
+}
+
+func (re *recentErrors) list() []recentError {
+	re.lock.Lock()
+	defer re.lock.Unlock()
+	return append([]recentError{}, re.entries...)
+}
+
+type versionInfo struct {
+	Plugin    string `json:"plugin"`
+	Go        string `json:"go"`
+	PluginSDK string `json:"pluginSdk,omitempty"`
+	NS1SDK    string `json:"ns1Sdk,omitempty"`
+}
+
+func getVersionInfo() versionInfo {
+	info := versionInfo{Plugin: Version, Go: runtime.Version()}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range buildInfo.Deps {
+			switch dep.Path {
+			case "github.com/grafana/grafana-plugin-sdk-go":
+				info.PluginSDK = dep.Version
+			case "gopkg.in/ns1/ns1-go.v2":
+				info.NS1SDK = dep.Version
+			}
+		}
+	}
+
+	return info
+}
+
+type appsCacheSummary struct {
+	Apps      int       `json:"apps"`
+	Jobs      int       `json:"jobs"`
+	ExpiresOn time.Time `json:"expiresOn"`
+}
+
+type cacheSummary struct {
+	// Apps has an entry per API key, the keys themselves being left out.
+	Apps         []appsCacheSummary `json:"apps"`
+	QueryTTL     string             `json:"queryTTL"`
+	QueryEntries int                `json:"queryEntries"`
+}
+
+func (pc *PulsarClient) cacheSummary() cacheSummary {
+	summary := cacheSummary{
+		Apps:     []appsCacheSummary{},
+		QueryTTL: pc.queryCache.ttl.String(),
+	}
+
+	pc.dataLock.RLock()
+	for _, data := range pc.data {
+		apps := data.getAppsResponse()
+		summary.Apps = append(summary.Apps, appsCacheSummary{
+			Apps:      len(apps.Apps),
+			Jobs:      len(apps.JobsMap),
+			ExpiresOn: data.expiresOn,
+		})
+	}
+	pc.dataLock.RUnlock()
+	sort.Slice(summary.Apps, func(i, j int) bool {
+		return summary.Apps[i].ExpiresOn.Before(summary.Apps[j].ExpiresOn)
+	})
+
+	pc.queryCache.lock.Lock()
+	summary.QueryEntries = len(pc.queryCache.entries)
+	pc.queryCache.lock.Unlock()
+
+	return summary
+}
+
+type diagnosticBundle struct {
+	GeneratedAt time.Time   `json:"generatedAt"`
+	Version     versionInfo `json:"version"`
+	Settings    Settings    `json:"settings"`
+	// SecureFields lists the names of the secure fields set, not their values.
+	SecureFields []string        `json:"secureFields"`
+	Cache        *cacheSummary   `json:"cache,omitempty"`
+	SelfTest     *selfTestResult `json:"selfTest,omitempty"`
+	RecentErrors []recentError   `json:"recentErrors"`
+}
+
+// handleDebugBundle returns a snapshot of the datasource state to attach to
+// support tickets. It holds no secret: the API keys are left out, and the
+// whole bundle is redacted in case a key made it into an error message.
+func (p *PulsarDatasource) handleDebugBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bundle := diagnosticBundle{
+		GeneratedAt:  time.Now().UTC(),
+		Version:      getVersionInfo(),
+		SecureFields: []string{},
+		RecentErrors: p.recentErrors.list(),
+	}
+	if p.settings != nil {
+		bundle.Settings = *p.settings
+		bundle.Settings.Endpoint = redactURL(p.settings.Endpoint)
+		bundle.Settings.FallbackEndpoint = redactURL(p.settings.FallbackEndpoint)
+	}
+	if instanceSettings := httpadapter.PluginConfigFromContext(r.Context()).DataSourceInstanceSettings; instanceSettings != nil {
+		for field := range instanceSettings.DecryptedSecureJSONData {
+			bundle.SecureFields = append(bundle.SecureFields, field)
+		}
+		sort.Strings(bundle.SecureFields)
+	}
+	var redactor *redactor
+	if p.pulsarClient != nil {
+		summary := p.pulsarClient.cacheSummary()
+		bundle.Cache = &summary
+		redactor = p.pulsarClient.redactor
+	}
+	if p.selfTest != nil {
+		bundle.SelfTest = p.selfTest.latest()
+	}
+
+	body, err := json.Marshal(bundle)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write([]byte(redactor.redact(string(body)))); err != nil {
+		Logger.Error("Failed to write the resource response", "error", err)
+	}
+}
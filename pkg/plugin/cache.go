@@ -0,0 +1,185 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type queryCacheEntry struct {
+	data      []map[string]float64
+	expiresOn time.Time
+}
+
+// queryCache keeps the data points returned by the Pulsar query endpoints for
+// ttl. A zero ttl disables the cache. Like the apps catalog, it only lives in
+// memory: the job names and traffic data are sensitive, and must be encrypted
+// with a key derived from the secure settings if they are ever written to
+// disk, as the fixtures are with newFixtureCipher.
+type queryCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]queryCacheEntry
+}
+
+func newQueryCache(ttl time.Duration) *queryCache {
+	return &queryCache{
+		ttl:     ttl,
+		entries: make(map[string]queryCacheEntry),
+	}
+}
+
+func (qc *queryCache) enabled() bool {
+	return qc.ttl > 0
+}
+
+func (qc *queryCache) get(key string) ([]map[string]float64, bool) {
+	if !qc.enabled() {
+		return nil, false
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	entry, found := qc.entries[key]
+	if !found || time.Now().After(entry.expiresOn) {
+		recordCacheLookup(cacheQuery, false)
+		return nil, false
+	}
+	recordCacheLookup(cacheQuery, true)
+	return entry.data, true
+}
+
+func (qc *queryCache) set(key string, data []map[string]float64) {
+	if !qc.enabled() {
+		return
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	// Drop the expired entries, so the cache doesn't grow with every new
+	// time range.
+	now := time.Now()
+	for k, entry := range qc.entries {
+		if now.After(entry.expiresOn) {
+			delete(qc.entries, k)
+		}
+	}
+
+	qc.entries[key] = queryCacheEntry{data: data, expiresOn: now.Add(qc.ttl)}
+}
+
+// alignRange widens the range to the multiples of the ttl around it, so the
+// queries relative to now made within the same ttl window share the cache
+// entry. The points out of the range of the query are trimmed by trimRange.
+// The aligned to is usually past now, so the entry lacks the points recorded
+// after it was fetched: the newest points may show up to a ttl late, like any
+// other change of the data.
+func alignRange(from, to time.Time, ttl time.Duration) (time.Time, time.Time) {
+	alignedTo := to.Truncate(ttl)
+	if alignedTo.Before(to) {
+		alignedTo = alignedTo.Add(ttl)
+	}
+	return from.Truncate(ttl), alignedTo
+}
+
+// trimRange returns the points between from and to, both included. The points
+// are sorted by time, and shared with the cache, so they are sliced, not
+// modified.
+func trimRange(points []map[string]float64, from, to time.Time) []map[string]float64 {
+	start, end := 0, len(points)
+	for start < end && int64(points[start]["timestamp"]) < from.Unix() {
+		start++
+	}
+	for end > start && int64(points[end-1]["timestamp"]) > to.Unix() {
+		end--
+	}
+	return points[start:end]
+}
+
+// setCacheControl tells Grafana, and the browser, the resource can be reused
+// for ttl. The responses depend on the user, so they aren't shared.
+func setCacheControl(w http.ResponseWriter, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int64(ttl/time.Second)))
+}
+
+type cacheLookupsContextKey struct{}
+
+// cacheLookups counts the query cache hits and misses of a query. A nil
+// cacheLookups counts nothing.
+type cacheLookups struct {
+	lock   sync.Mutex
+	hits   int
+	misses int
+}
+
+func withCacheLookups(ctx context.Context, lookups *cacheLookups) context.Context {
+	return context.WithValue(ctx, cacheLookupsContextKey{}, lookups)
+}
+
+func cacheLookupsFromContext(ctx context.Context) *cacheLookups {
+	lookups, _ := ctx.Value(cacheLookupsContextKey{}).(*cacheLookups)
+	return lookups
+}
+
+func (cl *cacheLookups) record(hit bool) {
+	if cl == nil {
+		return
+	}
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if hit {
+		cl.hits++
+	} else {
+		cl.misses++
+	}
+}
+
+// add adds the lookups of other, the lookups of a request shared with other
+// queries.
+func (cl *cacheLookups) add(other *cacheLookups) {
+	if cl == nil || other == nil {
+		return
+	}
+	other.lock.Lock()
+	hits, misses := other.hits, other.misses
+	other.lock.Unlock()
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.hits += hits
+	cl.misses += misses
+}
+
+// cacheStats describes the cache TTL of the query results in the query
+// inspector, when enabled, and whether the cache answered the query.
+func cacheStats(ttl time.Duration, lookups *cacheLookups) []data.QueryStat {
+	if ttl <= 0 {
+		return nil
+	}
+	stats := []data.QueryStat{{
+		FieldConfig: data.FieldConfig{DisplayName: "Cache TTL", Unit: "s"},
+		Value:       ttl.Seconds(),
+	}}
+	if lookups == nil {
+		return stats
+	}
+	lookups.lock.Lock()
+	defer lookups.lock.Unlock()
+	return append(stats,
+		data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Cache hits"}, Value: float64(lookups.hits)},
+		data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Cache misses"}, Value: float64(lookups.misses)},
+	)
+}
@@ -0,0 +1,95 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+var errInvalidCondition = newInvalidQueryError("invalid condition, expected an operator among >, >=, < and <=, and a duration such as 10m")
+
+// queryCondition is a condition evaluated on the series of a query, e.g. the
+// p95 above 200ms for 10 minutes. The query then returns 1 for the points of
+// the intervals breaching the condition, and 0 for the others.
+type queryCondition struct {
+	Operator string  `json:"operator"`
+	Value    float64 `json:"value"`
+	// For is how long the condition must hold for the interval to be a
+	// breach, none by default.
+	For string `json:"for"`
+}
+
+// duration returns the parsed For of the condition.
+func (c *queryCondition) duration() (time.Duration, error) {
+	if c.For == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(c.For)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%w: invalid duration %q", errInvalidCondition, c.For)
+	}
+	return duration, nil
+}
+
+// check rejects the unknown operators and the invalid durations.
+func (c *queryCondition) check() error {
+	switch c.Operator {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("%w: unknown operator %q", errInvalidCondition, c.Operator)
+	}
+	_, err := c.duration()
+	return err
+}
+
+func (c *queryCondition) holds(value float64) bool {
+	switch c.Operator {
+	case ">":
+		return value > c.Value
+	case ">=":
+		return value >= c.Value
+	case "<":
+		return value < c.Value
+	default:
+		return value <= c.Value
+	}
+}
+
+func (c *queryCondition) String() string {
+	if c.For == "" {
+		return fmt.Sprintf("%s %g", c.Operator, c.Value)
+	}
+	return fmt.Sprintf("%s %g for %s", c.Operator, c.Value, c.For)
+}
+
+// evaluate returns the breaches of the condition: 1 for every point of the
+// intervals during which the condition held for at least its duration, from
+// the first point matching to the last one, and 0 for the other points.
+func (c *queryCondition) evaluate(times []time.Time, values []float64) []float64 {
+	duration, _ := c.duration()
+	breaches := make([]float64, len(values))
+
+	start := -1
+	for i := range values {
+		if !c.holds(values[i]) {
+			start = -1
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		switch {
+		case times[i].Sub(times[start]) < duration:
+		case i > start && breaches[i-1] == 1:
+			breaches[i] = 1
+		default:
+			for j := start; j <= i; j++ {
+				breaches[j] = 1
+			}
+		}
+	}
+	return breaches
+}
@@ -0,0 +1,83 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestConditionEvaluate(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	values := []float64{250, 100, 210, 220, 230, 90, 300}
+	times := make([]time.Time, len(values))
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Minute)
+	}
+
+	tests := []struct {
+		condition queryCondition
+		breaches  string
+	}{
+		{queryCondition{Operator: ">", Value: 200}, "[1 0 1 1 1 0 1]"},
+		{queryCondition{Operator: ">", Value: 200, For: "2m"}, "[0 0 1 1 1 0 0]"},
+		{queryCondition{Operator: ">", Value: 200, For: "3m"}, "[0 0 0 0 0 0 0]"},
+		{queryCondition{Operator: "<=", Value: 100}, "[0 1 0 0 0 1 0]"},
+	}
+	for _, tt := range tests {
+		if err := tt.condition.check(); err != nil {
+			t.Fatal(err)
+		}
+		if breaches := fmt.Sprint(tt.condition.evaluate(times, values)); breaches != tt.breaches {
+			t.Errorf("%s: expected %s, got %s", &tt.condition, tt.breaches, breaches)
+		}
+	}
+
+	for _, invalid := range []queryCondition{{Operator: "=="}, {Operator: ">", For: "ten minutes"}, {Operator: ">", For: "-1m"}} {
+		if err := invalid.check(); !errors.Is(err, errInvalidCondition) {
+			t.Errorf("%+v: expected errInvalidCondition, got %v", invalid, err)
+		}
+	}
+}
+
+func TestQueryCondition(t *testing.T) {
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			fmt.Fprint(w, `[{"timestamp": 1600000000, "job1": 120}, {"timestamp": 1600000060, "job1": 300},
+				{"timestamp": 1600000120, "job1": 310}]`)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	res := p.queryWithKey(context.Background(), "key", backend.DataQuery{
+		RefID: "A",
+		JSON: []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p95",
+			"condition": {"operator": ">", "value": 200, "for": "1m"}, "reduce": "max"}`),
+		TimeRange: backend.TimeRange{From: time.Unix(1599999000, 0), To: time.Unix(1600001000, 0)},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	value := res.Frames[0].Fields[0]
+	if value.Len() != 1 || value.At(0).(float64) != 1 {
+		t.Errorf("expected the breach to be reduced to 1, got %v", value)
+	}
+	if !strings.HasSuffix(value.Config.DisplayNameFromDS, " > 200 for 1m") {
+		t.Errorf("expected the condition in the display name, got %q", value.Config.DisplayNameFromDS)
+	}
+}
@@ -0,0 +1,45 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+var errInvalidCumulative = newInvalidQueryError("invalid cumulative query")
+
+// checkCumulative rejects the cumulative queries of the other metrics than
+// the decisions, whose points are counts, and of the queries that don't
+// return a series of the counts.
+func checkCumulative(qm *queryModel) error {
+	if !qm.Cumulative {
+		return nil
+	}
+	if qm.MetricType != metricTypeDecisions {
+		return fmt.Errorf("%w: only the decisions are counts, not the %s", errInvalidCumulative, qm.MetricType)
+	}
+	if qm.Condition != nil || qm.Quantile > 0 || qm.Band != nil || qm.BaselineWeeks > 0 {
+		return fmt.Errorf("%w: the running total can't be combined with a condition, a quantile, a band nor a baseline",
+			errInvalidCumulative)
+	}
+	return nil
+}
+
+// cumulativeSum returns the running total of the counts from the start of the
+// range, cut to the latest maxPoints points.
+func cumulativeSum(times []time.Time, values []float64, maxPoints int64) ([]time.Time, []float64) {
+	totals := make([]float64, len(values))
+	total := 0.0
+	for i, value := range values {
+		total += value
+		totals[i] = total
+	}
+	if maxPoints > 0 && int64(len(totals)) > maxPoints {
+		offset := int64(len(totals)) - maxPoints
+		return times[offset:], totals[offset:]
+	}
+	return times, totals
+}
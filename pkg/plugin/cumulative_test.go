@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryCumulative(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 5))
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.EnableDecisions = true
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 10,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "decisions", "agg": "avg", "cumulative": true}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	// The totals count the 60 points of the range, the latest 10 are kept.
+	values := res.Frames[0].Fields[1]
+	if values.Len() != 10 || values.At(9) != 300.0 || values.At(0) != 255.0 {
+		t.Errorf("expected the running total of the whole range, got %d points up to %v", values.Len(), values.At(values.Len()-1))
+	}
+
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "decisions", "agg": "avg", "cumulative": true, "reduce": "last"}`)
+	if res.Error != nil || res.Frames[0].Fields[0].At(0) != 300.0 {
+		t.Errorf("expected the total of the range, got %v", res.Error)
+	}
+
+	// The envelope downsamples the running total of the whole range to the
+	// max data points.
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "decisions", "agg": "avg", "cumulative": true, "envelope": true}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	fields := res.Frames[0].Fields
+	if len(fields) != 5 || fields[3].Name != "max" {
+		t.Fatalf("expected the min, max and count fields, got %d fields", len(fields))
+	}
+	if fields[0].Len() > 11 || fields[2].At(0) != 5.0 || fields[3].At(fields[3].Len()-1) != 300.0 {
+		t.Errorf("expected the envelope of the running total, got %d points from %v up to %v",
+			fields[0].Len(), fields[2].At(0), fields[3].At(fields[3].Len()-1))
+	}
+
+	for _, json := range []string{
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "cumulative": true}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "decisions", "cumulative": true, "quantile": 0.5}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidCumulative) {
+			t.Errorf("%s: expected errInvalidCumulative, got %v", json, res.Error)
+		}
+	}
+}
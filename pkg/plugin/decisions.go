@@ -0,0 +1,200 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeDecisionAnswers is the type of the queries of the Pulsar decisions
+// of a record, broken down by the answer chosen.
+const queryTypeDecisionAnswers = "decisionAnswers"
+
+var errInvalidDecisionsQuery = newInvalidQueryError("invalid decision answers query, the record needs its zone, domain and type")
+
+// findApp returns the app if visible through the datasource.
+func (p *PulsarDatasource) findApp(ctx context.Context, apiKey, appID string) (App, error) {
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return App{}, err
+	}
+	app, found := appsResponse.AppsMap[appID]
+	if allowed := p.appFilter(ctx); !found || (allowed != nil && !allowed(appID)) {
+		return App{}, fmt.Errorf("%w: %s", errAppNotAllowed, appID)
+	}
+	return app, nil
+}
+
+// pushDecisions sends the decisions of every job of the app more recent than
+// last, along with the share of the traffic each job got. It returns the time
+// of the latest decisions sent.
+func (p *PulsarDatasource) pushDecisions(ctx context.Context, apiKey string, channel *streamChannel, last time.Time, sender *backend.StreamSender) (time.Time, error) {
+	app, err := p.findApp(ctx, apiKey, channel.appID)
+	if err != nil || len(app.Jobs) == 0 {
+		return last, err
+	}
+
+	jobIDs := make([]string, len(app.Jobs))
+	for i, job := range app.Jobs {
+		jobIDs[i] = job.JobID
+	}
+	qm := channel.query(app.AppID, last)
+	qm.JobID = strings.Join(jobIDs, ",")
+
+	points, err := p.pulsarClient.fetchNewPoints(ctx, apiKey, qm)
+	if err != nil {
+		return last, err
+	}
+
+	var times []time.Time
+	counts := make([][]float64, len(app.Jobs))
+	shares := make([][]float64, len(app.Jobs))
+	for _, point := range points {
+		times = append(times, time.Unix(int64(point["timestamp"]), 0))
+
+		var total float64
+		for _, jobID := range jobIDs {
+			total += point[jobID]
+		}
+		for i, jobID := range jobIDs {
+			share := 0.0
+			if total > 0 {
+				share = point[jobID] / total * 100
+			}
+			counts[i] = append(counts[i], point[jobID])
+			shares[i] = append(shares[i], share)
+		}
+	}
+	if len(times) == 0 {
+		return last, nil
+	}
+
+	frame := data.NewFrame("decisions", data.NewField("time", nil, times))
+	for i, job := range app.Jobs {
+		frame.Fields = append(frame.Fields,
+			data.NewField(job.Name+" decisions", nil, counts[i]),
+			data.NewField(job.Name+" share", nil, shares[i]),
+		)
+	}
+	if err = sender.SendFrame(frame, data.IncludeAll); err != nil {
+		return last, err
+	}
+
+	return times[len(times)-1], nil
+}
+
+// GetPulsarStage returns the stage of the filter chain of the record running
+// the Pulsar filter, as "<filter> (stage <n>/<total>)", or "" when the record
+// has no Pulsar filter.
+func (pc *PulsarClient) GetPulsarStage(ctx context.Context, apiKey, zone, domain, recordType string) (stage string, err error) {
+	_, span := startSpan(ctx, "GetPulsarStage", "zone", zone, "domain", domain, "type", recordType)
+	defer func() { span.end(err) }()
+
+	record, _, err := pc.getAPIClient(apiKey).Records.Get(zone, domain, strings.ToUpper(recordType))
+	if err != nil {
+		return "", convertZoneError(err)
+	}
+
+	for i, filter := range record.Filters {
+		if filter != nil && !filter.Disabled && strings.HasPrefix(filter.Type, "pulsar") {
+			return fmt.Sprintf("%s (stage %d/%d)", filter.Type, i+1, len(record.Filters)), nil
+		}
+	}
+	return "", nil
+}
+
+// GetDecisionAnswers returns the decisions of the record over the time range
+// of the query. The points are keyed by answer, as the decisions of the jobs
+// are keyed by job.
+func (pc *PulsarClient) GetDecisionAnswers(ctx context.Context, apiKey string, qm *queryModel) (points []map[string]float64, err error) {
+	ctx, span := startSpan(ctx, "GetDecisionAnswers", "domain", qm.Domain, "type", qm.RecordType)
+	defer func() { span.end(err) }()
+
+	query := url.Values{}
+	query.Set("start", fmt.Sprint(qm.From.Unix()))
+	query.Set("end", fmt.Sprint(qm.To.Unix()))
+	query.Set("record", qm.Domain)
+	query.Set("type", strings.ToUpper(qm.RecordType))
+	query.Set("area", "GLOBAL")
+	if qm.Geo != "*" {
+		query.Set("area", qm.Geo)
+	}
+	if qm.ASN != "*" {
+		query.Set("asn", qm.ASN)
+	}
+	apiURL, err := url.Parse(pc.getAPIClient(apiKey).Endpoint.String() + "pulsar/query/decisions/answers/time?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return pc.fetchData(ctx, apiKey, apiURL)
+}
+
+// queryDecisionAnswers answers the decision answers queries with a series per
+// answer of the record, named after the answer and the stage of the filter
+// chain making the decisions.
+func (p *PulsarDatasource) queryDecisionAnswers(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	qm.applyDefaults(p.settings)
+	qm.validate()
+	if err := p.settings.checkFeatures(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if qm.Zone == "" || qm.Domain == "" || qm.RecordType == "" {
+		return backend.DataResponse{Error: errInvalidDecisionsQuery}
+	}
+
+	stage, err := p.pulsarAPI().GetPulsarStage(ctx, apiKey, qm.Zone, qm.Domain, qm.RecordType)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	points, err := p.pulsarAPI().GetDecisionAnswers(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	seen := make(map[string]bool)
+	var answers []string
+	for _, point := range points {
+		for answer := range point {
+			if answer != "timestamp" && !seen[answer] {
+				seen[answer] = true
+				answers = append(answers, answer)
+			}
+		}
+	}
+	sort.Strings(answers)
+
+	times := make([]time.Time, len(points))
+	for i, point := range points {
+		times[i] = time.Unix(int64(point["timestamp"]), 0)
+	}
+	res := backend.DataResponse{}
+	for _, answer := range answers {
+		values := make([]float64, len(points))
+		for i, point := range points {
+			values[i] = point[answer]
+		}
+		name := answer
+		if qm.Alias != "" {
+			name = qm.Alias + " " + answer
+		}
+		if stage != "" {
+			name += " via " + stage
+		}
+		res.Frames = append(res.Frames, data.NewFrame(name,
+			data.NewField("time", nil, times),
+			data.NewField(name, nil, values),
+		))
+	}
+	return res
+}
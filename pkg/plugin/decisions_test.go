@@ -0,0 +1,136 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestDecisionsStream(t *testing.T) {
+	now := time.Now().Unix()
+	var jobs string
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/decisions/") {
+			jobs = r.URL.Query().Get("jobs")
+			fmt.Fprintf(w, `[{"timestamp": %d, "job1": 30, "job2": 10}]`, now)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{
+		settings:     defaultSettings(),
+		pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL)),
+	}
+	p.settings.EnableStreaming = true
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+	}}
+	subscribe := func() backend.SubscribeStreamStatus {
+		res, err := p.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			PluginContext: pCtx,
+			Path:          "decisions/app1/GLOBAL",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Status
+	}
+
+	if status := subscribe(); status != backend.SubscribeStreamStatusPermissionDenied {
+		t.Errorf("expected the channel to be denied while decisions are disabled, got %v", status)
+	}
+	p.settings.EnableDecisions = true
+	if status := subscribe(); status != backend.SubscribeStreamStatusOK {
+		t.Errorf("expected the subscription to be allowed, got %v", status)
+	}
+
+	channel, err := parseStreamPath("decisions/app1/GLOBAL", p.settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := &streamPacketSender{}
+	last, err := p.pushDecisions(context.Background(), "key", channel, time.Now().Add(-time.Hour), backend.NewStreamSender(packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jobs != "job1,job2" || last.Unix() != now || len(packets.packets) != 1 {
+		t.Fatalf("unexpected stream: jobs %q, last %d, %d packets", jobs, last.Unix(), len(packets.packets))
+	}
+
+	frame := &data.Frame{}
+	if err = json.Unmarshal(packets.packets[0].Data, frame); err != nil {
+		t.Fatal(err)
+	}
+	// time, then the decisions and share of both jobs.
+	if len(frame.Fields) != 5 {
+		t.Fatalf("expected 5 fields, got %d", len(frame.Fields))
+	}
+	if share, _ := frame.Fields[2].FloatAt(0); share != 75 {
+		t.Errorf("expected a 75%% share for job1, got %v", share)
+	}
+}
+
+func TestQueryDecisionAnswers(t *testing.T) {
+	var query string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/zones/example.com/www.example.com/A", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"zone": "example.com", "domain": "www.example.com", "type": "A",
+			"filters": [{"filter": "up"}, {"filter": "pulsar_sort"}, {"filter": "select_first_n"}]}`)
+	})
+	mux.HandleFunc("/pulsar/query/decisions/answers/time", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprint(w, `[{"timestamp": 1600000000, "1.1.1.1": 10, "2.2.2.2": 5}, {"timestamp": 1600000060, "2.2.2.2": 8}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	settings := defaultSettings()
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := func() *queryModel {
+		return &queryModel{QueryType: queryTypeDecisionAnswers, Zone: "example.com", Domain: "www.example.com", RecordType: "a",
+			From: time.Unix(1599990000, 0), To: time.Unix(1600010000, 0)}
+	}
+
+	res := p.queryDecisionAnswers(context.Background(), "key", qm())
+	if !errors.Is(res.Error, errFeatureDisabled) {
+		t.Fatalf("expected errFeatureDisabled, got %v", res.Error)
+	}
+
+	settings.EnableDecisions = true
+	res = p.queryDecisionAnswers(context.Background(), "key", qm())
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if !strings.Contains(query, "record=www.example.com") || !strings.Contains(query, "type=A") || !strings.Contains(query, "area=GLOBAL") {
+		t.Errorf("unexpected decisions query %s", query)
+	}
+	if len(res.Frames) != 2 || res.Frames[0].Name != "1.1.1.1 via pulsar_sort (stage 2/3)" {
+		t.Fatalf("expected a series per answer, got %v", res.Frames)
+	}
+	if values := res.Frames[1].Fields[1]; values.Len() != 2 || values.At(1).(float64) != 8 {
+		t.Errorf("unexpected decisions of the second answer %v", values)
+	}
+
+	res = p.queryDecisionAnswers(context.Background(), "key", &queryModel{Domain: "www.example.com"})
+	if !errors.Is(res.Error, errInvalidDecisionsQuery) {
+		t.Errorf("expected errInvalidDecisionsQuery, got %v", res.Error)
+	}
+}
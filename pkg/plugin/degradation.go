@@ -0,0 +1,98 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// errorRateWindow is the period over which the upstream error rate is
+	// computed, split in errorRateBuckets.
+	errorRateWindow  = 5 * time.Minute
+	errorRateBuckets = 10
+	// minErrorRateSamples keeps a couple of failed queries on an idle
+	// datasource from reporting it degraded.
+	minErrorRateSamples      = 10
+	defaultDegradedErrorRate = 50
+)
+
+type errorRateBucket struct {
+	start  time.Time
+	total  int
+	failed int
+}
+
+// errorRate tracks the share of the queries failing because of NS1 over the
+// last errorRateWindow.
+type errorRate struct {
+	lock    sync.Mutex
+	buckets [errorRateBuckets]errorRateBucket
+}
+
+func (er *errorRate) record(failed bool) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+
+	now := time.Now()
+	width := errorRateWindow / errorRateBuckets
+	start := now.Truncate(width)
+	bucket := &er.buckets[(now.UnixNano()/int64(width))%errorRateBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = errorRateBucket{start: start}
+	}
+
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+}
+
+// rate returns the percentage of failed queries over the window, and the
+// number of queries it is computed from.
+func (er *errorRate) rate() (float64, int) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+
+	var total, failed int
+	since := time.Now().Add(-errorRateWindow)
+	for _, bucket := range er.buckets {
+		if bucket.start.After(since) {
+			total += bucket.total
+			failed += bucket.failed
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return 100 * float64(failed) / float64(total), total
+}
+
+// degradation returns a message describing the degradation of the
+// datasource, empty when the upstream error rate is below the threshold.
+func (p *PulsarDatasource) degradation() string {
+	rate, samples := p.errorRate.rate()
+	if samples < minErrorRateSamples || rate < p.settings.degradedErrorRate() {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%% of the queries failed because of the NS1 API over the last %s",
+		rate, errorRateWindow)
+}
+
+// addDegradationNotice warns the users that the datasource, not their
+// query, is failing.
+func addDegradationNotice(res *backend.DataResponse, degradation string) {
+	for _, frame := range res.Frames {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "The datasource is degraded, the data may be incomplete: " + degradation,
+		})
+	}
+}
@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestDegradation(t *testing.T) {
+	p := &PulsarDatasource{settings: &Settings{DegradedErrorRate: 40}}
+
+	for i := 0; i < minErrorRateSamples-1; i++ {
+		p.errorRate.record(true)
+	}
+	if degradation := p.degradation(); degradation != "" {
+		t.Fatalf("expected no degradation below %d queries, got %q", minErrorRateSamples, degradation)
+	}
+
+	for i := 0; i < 6; i++ {
+		p.errorRate.record(false)
+	}
+	rate, samples := p.errorRate.rate()
+	if samples != 15 || rate != 60 {
+		t.Fatalf("expected a 60%% error rate over 15 queries, got %v%% over %d", rate, samples)
+	}
+	degradation := p.degradation()
+	if !strings.HasPrefix(degradation, "60% of the queries failed") {
+		t.Fatalf("unexpected degradation %q", degradation)
+	}
+
+	res := backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}}
+	addDegradationNotice(&res, degradation)
+	notices := res.Frames[0].Meta.Notices
+	if len(notices) != 1 || notices[0].Severity != data.NoticeSeverityWarning ||
+		!strings.Contains(notices[0].Text, degradation) {
+		t.Errorf("unexpected notices %+v", notices)
+	}
+
+	p.settings.DegradedErrorRate = 70
+	if degradation := p.degradation(); degradation != "" {
+		t.Errorf("expected no degradation below the threshold, got %q", degradation)
+	}
+}
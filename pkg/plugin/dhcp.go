@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeDHCPScopes is the type of the queries of the lease utilization of
+// the DHCP scopes, on the private NS1 installations (DDI).
+const queryTypeDHCPScopes = "dhcpScopes"
+
+var errDHCPNotFound = errors.New("DHCP scope not found")
+
+// DHCPScope is the lease utilization of a DHCP scope.
+type DHCPScope struct {
+	ID         int
+	Name       string
+	Prefix     string
+	ScopeGroup *int
+	Size       float64
+	Leases     int
+}
+
+// utilization returns the share of the addresses of the scope leased, in
+// percent.
+func (s *DHCPScope) utilization() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.Leases) / s.Size * 100
+}
+
+// prefixSize returns the number of addresses of the prefix, 0 when it isn't
+// valid.
+func prefixSize(prefix string) float64 {
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return 0
+	}
+	ones, bits := ipNet.Mask.Size()
+	return math.Pow(2, float64(bits-ones))
+}
+
+// GetDHCPScopes returns the DHCP scopes, with their leases, sorted by ID. The
+// leases are counted out of the DDI lease list.
+func (pc *PulsarClient) GetDHCPScopes(ctx context.Context, apiKey string) (scopes []DHCPScope, err error) {
+	ctx, span := startSpan(ctx, "GetDHCPScopes")
+	defer func() { span.end(err) }()
+
+	dhcpScopes, _, err := pc.getAPIClient(apiKey).Scope.List()
+	if err != nil {
+		return nil, convertClientError(err, errDHCPNotFound)
+	}
+
+	var leases []struct {
+		ScopeID int `json:"scope_id"`
+	}
+	if err = pc.getJSON(ctx, apiKey, "dhcp/lease", errDHCPNotFound, &leases); err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int)
+	for _, lease := range leases {
+		counts[lease.ScopeID]++
+	}
+
+	scopes = make([]DHCPScope, 0, len(dhcpScopes))
+	for _, dhcpScope := range dhcpScopes {
+		scope := DHCPScope{ID: dhcpScope.ID, ScopeGroup: dhcpScope.IDScopeGroup, Leases: counts[dhcpScope.ID]}
+		if details := dhcpScope.AddressDetails; details != nil {
+			scope.Name = details.Name
+			scope.Prefix = details.Prefix
+			scope.Size = prefixSize(details.Prefix)
+		}
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].ID < scopes[j].ID })
+
+	return scopes, nil
+}
+
+// queryDHCPScopes answers the DHCP scopes queries with a table of the lease
+// utilization of the scopes.
+func (p *PulsarDatasource) queryDHCPScopes(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if err := p.settings.checkFeatures(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	scopes, err := p.pulsarAPI().GetDHCPScopes(ctx, apiKey)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	frame := data.NewFrame("dhcp",
+		data.NewField("scope", nil, []int64{}),
+		data.NewField("name", nil, []string{}),
+		data.NewField("prefix", nil, []string{}),
+		data.NewField("scope group", nil, []*int64{}),
+		data.NewField("size", nil, []float64{}),
+		data.NewField("leases", nil, []int64{}),
+		data.NewField("utilization", nil, []float64{}),
+	)
+	for i := range scopes {
+		s := &scopes[i]
+		var group *int64
+		if s.ScopeGroup != nil {
+			id := int64(*s.ScopeGroup)
+			group = &id
+		}
+		frame.AppendRow(int64(s.ID), s.Name, s.Prefix, group, s.Size, int64(s.Leases), s.utilization())
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryDHCPScopes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dhcp/scope":
+			fmt.Fprint(w, `[
+				{"id": 2, "scope_group_id": 1, "address_details": {"name": "office", "prefix": "10.0.1.0/24"}},
+				{"id": 1, "address_details": {"name": "lab", "prefix": "10.0.0.0/30"}}
+			]`)
+		case "/dhcp/lease":
+			fmt.Fprint(w, `[{"scope_id": 1}, {"scope_id": 1}, {"scope_id": 2}]`)
+		}
+	}))
+	defer server.Close()
+
+	settings := defaultSettings()
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := &queryModel{QueryType: queryTypeDHCPScopes}
+	if res := p.queryDHCPScopes(context.Background(), "key", qm); !errors.Is(res.Error, errFeatureDisabled) {
+		t.Fatalf("expected errFeatureDisabled, got %v", res.Error)
+	}
+
+	settings.EnableDHCP = true
+	res := p.queryDHCPScopes(context.Background(), "key", qm)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 2 {
+		t.Fatalf("expected a row per scope, got %d", rows)
+	}
+	if frame.Fields[1].At(0) != "lab" || frame.Fields[4].At(0).(float64) != 4 || frame.Fields[6].At(0).(float64) != 50 {
+		t.Errorf("expected the lab scope half leased first, got %v", frame.Fields)
+	}
+	if group := frame.Fields[3].At(1).(*int64); group == nil || *group != 1 {
+		t.Errorf("expected the scope group of the office scope, got %v", group)
+	}
+}
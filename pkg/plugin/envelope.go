@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+var errInvalidEnvelope = newInvalidQueryError("invalid envelope")
+
+// checkEnvelope rejects the envelopes of the queries that don't return a
+// series of the data, or that roll it up already.
+func checkEnvelope(qm *queryModel) error {
+	if !qm.Envelope {
+		return nil
+	}
+	if qm.Reduce != "" || qm.Condition != nil || qm.Quantile > 0 {
+		return fmt.Errorf("%w: the envelope needs the series, not a reducer, a condition nor a quantile", errInvalidEnvelope)
+	}
+	return nil
+}
+
+// downsample splits the range in maxPoints buckets of the same length, aligned
+// on from, and returns the mean, the minimum, the maximum and the number of
+// the points of each bucket, at the time of its first point. The series of up
+// to maxPoints points is kept as is, its minimum and maximum being its values.
+func downsample(times []time.Time, values []float64, from, to time.Time, maxPoints int64) ([]time.Time, []float64, []float64, []float64, []int64) {
+	if maxPoints <= 0 || int64(len(values)) <= maxPoints || !to.After(from) {
+		counts := make([]int64, len(values))
+		for i := range counts {
+			counts[i] = 1
+		}
+		return times, values, append([]float64{}, values...), append([]float64{}, values...), counts
+	}
+
+	width := to.Sub(from) / time.Duration(maxPoints)
+	if to.Sub(from)%time.Duration(maxPoints) != 0 {
+		width++
+	}
+	bucketOf := func(t time.Time) int64 {
+		offset := t.Sub(from)
+		bucket := int64(offset / width)
+		if offset < 0 && offset%width != 0 {
+			bucket--
+		}
+		return bucket
+	}
+
+	var (
+		bucketTimes               []time.Time
+		means, minimums, maximums []float64
+		counts                    []int64
+		current                   int64
+		sum                       float64
+		count                     int
+	)
+	flush := func() {
+		if count > 0 {
+			means = append(means, sum/float64(count))
+			counts = append(counts, int64(count))
+		}
+	}
+	for i, t := range times {
+		value := values[i]
+		if bucket := bucketOf(t); count == 0 || bucket != current {
+			flush()
+			current, sum, count = bucket, 0, 0
+			bucketTimes = append(bucketTimes, t)
+			minimums = append(minimums, value)
+			maximums = append(maximums, value)
+		}
+		sum += value
+		count++
+		last := len(minimums) - 1
+		if value < minimums[last] {
+			minimums[last] = value
+		}
+		if value > maximums[last] {
+			maximums[last] = value
+		}
+	}
+	flush()
+	return bucketTimes, means, minimums, maximums, counts
+}
@@ -0,0 +1,84 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestDownsample(t *testing.T) {
+	from := time.Unix(1600000000, 0)
+	times := make([]time.Time, 12)
+	values := make([]float64, 12)
+	for i := range times {
+		times[i] = from.Add(time.Duration(i) * time.Minute)
+		values[i] = float64(i)
+	}
+	// A spike hidden by the mean of its bucket.
+	values[5] = 100
+
+	bucketTimes, means, minimums, maximums, counts := downsample(times, values, from, from.Add(12*time.Minute), 3)
+	if len(bucketTimes) != 3 || !bucketTimes[1].Equal(times[4]) {
+		t.Fatalf("expected 3 buckets of 4 minutes, got %v", bucketTimes)
+	}
+	if means[1] != 29.25 || minimums[1] != 4 || maximums[1] != 100 {
+		t.Errorf("expected the mean, min and max of the bucket, got %v, %v and %v", means[1], minimums[1], maximums[1])
+	}
+	if counts[0] != 4 || counts[2] != 4 {
+		t.Errorf("expected the points of the buckets, got %v", counts)
+	}
+
+	// The short series are kept as is.
+	if bucketTimes, means, minimums, _, _ := downsample(times, values, from, from.Add(12*time.Minute), 100); len(bucketTimes) != 12 ||
+		means[5] != 100 || minimums[5] != 100 {
+		t.Errorf("expected the series as is, got %d points", len(bucketTimes))
+	}
+}
+
+func TestQueryEnvelope(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 10))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 6,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "envelope": true}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	fields := res.Frames[0].Fields
+	if len(fields) != 5 || fields[2].Name != "min" || fields[3].Name != "max" || fields[4].Name != "count" {
+		t.Fatalf("expected the min, max and count fields, got %d fields", len(fields))
+	}
+	var total int64
+	for i := 0; i < fields[4].Len(); i++ {
+		total += fields[4].At(i).(int64)
+	}
+	if total != 60 {
+		t.Errorf("expected the buckets to count the 60 points of the hour, got %d", total)
+	}
+	// The whole hour is covered, not only its latest 6 minutes.
+	if first := fields[0].At(0).(time.Time); fields[0].Len() > 7 || first.After(to.Add(-50*time.Minute)) {
+		t.Errorf("expected buckets over the whole range, got %d from %v", fields[0].Len(), first)
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "reduce": "max", "envelope": true}`); !errors.Is(res.Error, errInvalidEnvelope) {
+		t.Errorf("expected errInvalidEnvelope, got %v", res.Error)
+	}
+}
@@ -0,0 +1,225 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+)
+
+var (
+	errJobNotFound         = errors.New("Pulsar job not found — it may have been deleted")
+	errAppNotFound         = errors.New("Pulsar app not found — it may have been deleted")
+	errRateLimited         = errors.New("NS1 API rate limit reached, try again in a few moments")
+	errUpstreamUnavailable = errors.New("NS1 API is currently unavailable, try again later")
+	errUnexpectedStatus    = errors.New("unexpected response from the NS1 API")
+)
+
+// APIError is the decoded error body returned by the NS1 API. NS1 reports the
+// reason for a rejected request (unknown job, invalid aggregation, time range
+// too long...) in the message field of a JSON object. The kind holds the user
+// oriented error matching the status code, so callers can use errors.Is.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.kind == nil:
+		return fmt.Sprintf("NS1 API error (%d): %s", e.StatusCode, e.Message)
+	case e.Message == "":
+		return e.kind.Error()
+	default:
+		return fmt.Sprintf("%s: %s", e.kind, e.Message)
+	}
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// newAPIError maps the status code to the matching error kind. notFound is
+// the error used for 404, as its meaning depends on the requested resource.
+func newAPIError(statusCode int, message string, notFound error) *APIError {
+	apiErr := &APIError{StatusCode: statusCode, Message: message}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		apiErr.kind = errAuthorizationDenied
+	case statusCode == http.StatusNotFound:
+		apiErr.kind = notFound
+	case statusCode == http.StatusTooManyRequests:
+		apiErr.kind = errRateLimited
+	case statusCode >= http.StatusInternalServerError:
+		apiErr.kind = errUpstreamUnavailable
+	case statusCode == http.StatusBadRequest:
+		// The message is the only meaningful information for a bad request.
+		if message == "" {
+			apiErr.kind = errDataRetrieval
+		}
+	default:
+		apiErr.kind = errUnexpectedStatus
+	}
+
+	return apiErr
+}
+
+// permissionError tells the API key is valid but isn't allowed to run an
+// operation (403), as opposed to being invalid (401).
+type permissionError struct {
+	operation string
+	err       error
+}
+
+func (e *permissionError) Error() string {
+	return fmt.Sprintf("the API key isn't allowed to %s: %s", e.operation, e.err)
+}
+
+func (e *permissionError) Unwrap() error {
+	return e.err
+}
+
+// asPermissionError converts the 403 errors into permissionErrors for the
+// operation, leaving the other errors untouched.
+func asPermissionError(err error, operation string) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+		return &permissionError{operation: operation, err: err}
+	}
+	return err
+}
+
+// rejectedDataKey tells apart the rejections of the dedicated data key, when
+// dataKey is set: they must not trigger the fallback to the secondary key,
+// which replaces the catalog key only.
+func rejectedDataKey(err error, dataKey bool) error {
+	var apiErr *APIError
+	if dataKey && errors.As(err, &apiErr) && errors.Is(apiErr.kind, errAuthorizationDenied) {
+		return &APIError{StatusCode: apiErr.StatusCode, Message: apiErr.Message, kind: errDataKeyRejected}
+	}
+	return err
+}
+
+// checkResponse returns nil for successful NS1 responses, and an APIError
+// built out of the status code and body otherwise.
+func checkResponse(resp *http.Response, notFound error) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	if raw, err := io.ReadAll(resp.Body); err == nil && len(raw) > 0 {
+		// Best effort, the body may not be JSON at all (e.g. proxies).
+		_ = json.Unmarshal(raw, &body)
+	}
+
+	return newAPIError(resp.StatusCode, body.Message, notFound)
+}
+
+// convertClientError converts the errors returned by the ns1-go library into
+// APIErrors, so they get the same treatment as the ones from direct queries.
+func convertClientError(err error, notFound error) error {
+	var restErr *ns1api.Error
+	if errors.As(err, &restErr) && restErr.Resp != nil {
+		return newAPIError(restErr.Resp.StatusCode, restErr.Message, notFound)
+	}
+
+	return err
+}
+
+// ErrorSource tells whether an error originated in the plugin itself or
+// downstream, in the NS1 API or the user input. It matches the error source
+// of the newer plugin SDK versions.
+type ErrorSource string
+
+const (
+	ErrorSourcePlugin     ErrorSource = "plugin"
+	ErrorSourceDownstream ErrorSource = "downstream"
+)
+
+// QueryError is the error set on the DataResponse of a failed query. Status
+// is the HTTP status code describing the failure, so NS1 outages are told
+// apart from plugin bugs.
+type QueryError struct {
+	Source ErrorSource
+	Status int
+	err    error
+}
+
+func (e *QueryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.err
+}
+
+// redact returns a copy of the error without the API keys in its message.
+func (e *QueryError) redact(r *redactor) *QueryError {
+	return &QueryError{Source: e.Source, Status: e.Status, err: r.redactError(e.err)}
+}
+
+// isUpstreamFailure tells whether the NS1 API failed or couldn't be reached,
+// as opposed to rejecting the query.
+func (e *QueryError) isUpstreamFailure() bool {
+	return e.Source == ErrorSourceDownstream && e.Status >= http.StatusInternalServerError
+}
+
+// classifyError finds the source and status of a query error.
+func classifyError(err error) *QueryError {
+	var (
+		queryErr  *QueryError
+		apiErr    *APIError
+		urlErr    *url.Error
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.As(err, &queryErr):
+		return queryErr
+	case errors.As(err, &apiErr):
+		status := apiErr.StatusCode
+		if status >= http.StatusInternalServerError {
+			status = http.StatusBadGateway
+		}
+		return &QueryError{Source: ErrorSourceDownstream, Status: status, err: err}
+	case errors.As(err, &urlErr):
+		if urlErr.Timeout() {
+			return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusGatewayTimeout, err: err}
+		}
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusBadGateway, err: err}
+	case errors.Is(err, errNoDataFound):
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusNotFound, err: err}
+	case errors.Is(err, errReportTimeout):
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusGatewayTimeout, err: err}
+	case errors.Is(err, errReportFailed):
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusBadGateway, err: err}
+	case errors.Is(err, errInvalidQuery), errors.Is(err, errFeatureDisabled), errors.Is(err, errRangeTooLong),
+		errors.Is(err, errResponseTooLarge):
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusBadRequest, err: err}
+	case errors.Is(err, errRateBudgetExceeded):
+		return &QueryError{Source: ErrorSourcePlugin, Status: http.StatusTooManyRequests, err: err}
+	case errors.Is(err, errAppNotAllowed):
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusForbidden, err: err}
+	case errors.Is(err, errDecryptedSecureDataNil), errors.Is(err, errAPIKeyNotFound):
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusUnauthorized, err: err}
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		// The query sent by the frontend can't be decoded.
+		return &QueryError{Source: ErrorSourceDownstream, Status: http.StatusBadRequest, err: err}
+	default:
+		return &QueryError{Source: ErrorSourcePlugin, Status: http.StatusInternalServerError, err: err}
+	}
+}
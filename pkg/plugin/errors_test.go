@@ -0,0 +1,186 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func newResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	err := checkResponse(newResponse(http.StatusBadRequest,
+		`{"message": "invalid aggregation: p42"}`), errJobNotFound)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.Message != "invalid aggregation: p42" {
+		t.Errorf("unexpected message %q", apiErr.Message)
+	}
+
+	for _, body := range []string{"", "not json", `{"other": 1}`} {
+		err = checkResponse(newResponse(http.StatusBadRequest, body), errJobNotFound)
+		if !errors.Is(err, errDataRetrieval) {
+			t.Errorf("body %q: expected fallback error, got %v", body, err)
+		}
+	}
+
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusUnauthorized, errAuthorizationDenied},
+		{http.StatusForbidden, errAuthorizationDenied},
+		{http.StatusNotFound, errJobNotFound},
+		{http.StatusTooManyRequests, errRateLimited},
+		{http.StatusBadGateway, errUpstreamUnavailable},
+		{http.StatusTeapot, errUnexpectedStatus},
+	}
+	for _, tt := range tests {
+		err = checkResponse(newResponse(tt.status, ""), errJobNotFound)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("status %d: expected %v, got %v", tt.status, tt.want, err)
+		}
+	}
+
+	if err = checkResponse(newResponse(http.StatusOK, "[]"), errJobNotFound); err != nil {
+		t.Errorf("unexpected error for a successful response: %v", err)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err    error
+		source ErrorSource
+		status int
+	}{
+		{newAPIError(http.StatusServiceUnavailable, "", errJobNotFound), ErrorSourceDownstream, http.StatusBadGateway},
+		{newAPIError(http.StatusNotFound, "", errJobNotFound), ErrorSourceDownstream, http.StatusNotFound},
+		{&url.Error{Op: "Get", URL: "https://api.nsone.net", Err: errors.New("refused")}, ErrorSourceDownstream, http.StatusBadGateway},
+		{errNoDataFound, ErrorSourceDownstream, http.StatusNotFound},
+		{errAPIKeyNotFound, ErrorSourceDownstream, http.StatusUnauthorized},
+		{errors.New("unexpected"), ErrorSourcePlugin, http.StatusInternalServerError},
+		{fmt.Errorf("%w: quantile 2", errInvalidQuantile), ErrorSourceDownstream, http.StatusBadRequest},
+		{errJobTypeMismatch, ErrorSourceDownstream, http.StatusBadRequest},
+		{errFeatureDisabled, ErrorSourceDownstream, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		queryErr := classifyError(tt.err)
+		if queryErr.Source != tt.source || queryErr.Status != tt.status {
+			t.Errorf("%v: expected %s/%d, got %s/%d", tt.err, tt.source, tt.status,
+				queryErr.Source, queryErr.Status)
+		}
+		if !errors.Is(queryErr, tt.err) {
+			t.Errorf("%v: the classified error must wrap the original one", tt.err)
+		}
+	}
+}
+
+func TestErrorsAsNoData(t *testing.T) {
+	alertRetryDelay = 0
+	defer func() { alertRetryDelay = time.Second }()
+	tests := []struct {
+		status         int
+		errorsAsNoData bool
+		alert          bool
+		noData         bool
+	}{
+		{http.StatusServiceUnavailable, true, false, true},
+		{http.StatusInternalServerError, true, false, true},
+		{http.StatusServiceUnavailable, false, false, false},
+		// The alert rules need the errors to tell a failure from a drop.
+		{http.StatusServiceUnavailable, true, true, false},
+		// Only the upstream failures are hidden, not the rejected queries.
+		{http.StatusBadRequest, true, false, false},
+		{http.StatusNotFound, true, false, false},
+	}
+	for _, tt := range tests {
+		server := testutil.NewServer(testutil.OptionDataStatus(tt.status))
+		settings := defaultSettings()
+		settings.ErrorsAsNoData = tt.errorsAsNoData
+		p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+		headers := map[string]string{}
+		if tt.alert {
+			headers[fromAlertHeader] = "true"
+		}
+		resp, err := p.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+			}},
+			Headers: headers,
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`),
+				TimeRange: backend.TimeRange{From: time.Unix(1600000000, 0), To: time.Unix(1600003600, 0)},
+			}},
+		})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := resp.Responses["A"]
+		name := fmt.Sprintf("status %d, errorsAsNoData %v, alert %v", tt.status, tt.errorsAsNoData, tt.alert)
+		if !tt.noData {
+			if res.Error == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+			continue
+		}
+		if res.Error != nil {
+			t.Errorf("%s: expected no data, got %v", name, res.Error)
+			continue
+		}
+		if len(res.Frames) != 1 || res.Frames[0].Rows() != 0 {
+			t.Errorf("%s: expected an empty frame, got %d frames", name, len(res.Frames))
+			continue
+		}
+		notices := res.Frames[0].Meta.Notices
+		if len(notices) == 0 || notices[0].Severity != data.NoticeSeverityWarning ||
+			!strings.HasPrefix(notices[0].Text, "No data: ") {
+			t.Errorf("%s: expected a warning, got %+v", name, notices)
+		}
+	}
+}
+
+func TestInvalidQueryErrors(t *testing.T) {
+	for _, err := range []error{
+		errInvalidQPSQuery, errInvalidMonitoringQuery, errInvalidDecisionsQuery, errInvalidUsageQuery,
+		errJobTypeMismatch, errInvalidReportQuery, errInvalidShedLoadQuery, errInvalidAnnotationQuery,
+		errInvalidNoDataPolicy, errInvalidReducer, errInvalidCondition, errInvalidQuantile, errInvalidBand,
+		errInvalidForecast, errInvalidBaseline, errInvalidEnvelope, errInvalidResample, errInvalidCumulative,
+		errInvalidTimeout, errInvalidLastPoints, errInvalidAggregations, errInvalidUnit, errInvalidHealthScore,
+		errInvalidStatusBoard,
+	} {
+		if !errors.Is(err, errInvalidQuery) {
+			t.Errorf("%v: expected errInvalidQuery", err)
+		}
+	}
+	if errors.Is(errInvalidQuantile, errInvalidBand) {
+		t.Error("expected the sentinels to stay apart")
+	}
+	if message := errInvalidEnvelope.Error(); message != "invalid envelope" {
+		t.Errorf("expected the message of the sentinel alone, got %q", message)
+	}
+}
@@ -0,0 +1,224 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// The formats of the exports.
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+)
+
+// exportRequest is the query to export, as saved by the query editor, and its
+// time range. The max data points default to all the points of the range.
+type exportRequest struct {
+	Query         json.RawMessage `json:"query"`
+	From          time.Time       `json:"from"`
+	To            time.Time       `json:"to"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Interval      Duration        `json:"interval"`
+}
+
+// handleExport runs the query of the request like the panels do, with the
+// credentials and the restrictions of the datasource, and streams its frames
+// as CSV, a row per point with a column per field, or as NDJSON, an object per
+// point. The format parameter picks it, CSV by default. The first column, or
+// key, names the frame of the row.
+func (p *PulsarDatasource) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		http.Error(w, fmt.Sprintf("format must be %q or %q, got %q", exportFormatCSV, exportFormatNDJSON, format),
+			http.StatusBadRequest)
+		return
+	}
+
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid export request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Query) == 0 || req.From.IsZero() || req.To.IsZero() || !req.To.After(req.From) {
+		http.Error(w, "invalid export request: the query and a from before the to are required", http.StatusBadRequest)
+		return
+	}
+	if req.MaxDataPoints <= 0 {
+		req.MaxDataPoints = math.MaxInt64
+	}
+
+	headers := make(map[string]string)
+	for _, name := range []string{requestIDHeader, traceparentHeader} {
+		if value := r.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	resp, err := p.QueryData(r.Context(), &backend.QueryDataRequest{
+		PluginContext: httpadapter.PluginConfigFromContext(r.Context()),
+		Headers:       headers,
+		Queries: []backend.DataQuery{{
+			RefID:         "A",
+			JSON:          req.Query,
+			TimeRange:     backend.TimeRange{From: req.From, To: req.To},
+			MaxDataPoints: req.MaxDataPoints,
+			Interval:      time.Duration(req.Interval),
+		}},
+	})
+	if err == nil {
+		err = resp.Responses["A"].Error
+	}
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+
+	frames := resp.Responses["A"].Frames
+	setCacheControl(w, time.Duration(p.settings.CacheTTL))
+	if format == exportFormatNDJSON {
+		err = writeNDJSON(w, frames)
+	} else {
+		err = writeCSV(w, frames)
+	}
+	if err != nil {
+		// The status is sent already: the client gets a truncated export.
+		loggerOrDefault(p.logger).Warn("Failed to write the export", "error", err)
+	}
+}
+
+// flushFrame sends the rows of the frame written to the client, and releases
+// the frame, so the export doesn't hold the rows sent already.
+func flushFrame(w http.ResponseWriter, frames data.Frames, i int) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	frames[i] = nil
+}
+
+// writeCSV writes the rows of the frames, frame by frame, the header being the
+// union of their columns. The columns a frame doesn't have are left empty.
+func writeCSV(w http.ResponseWriter, frames data.Frames) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	header := []string{"frame"}
+	columns := make(map[string]int)
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			name := exportColumn(field)
+			if _, found := columns[name]; !found {
+				columns[name] = len(header)
+				header = append(header, name)
+			}
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for f, frame := range frames {
+		rows, _ := frame.RowLen()
+		for i := 0; i < rows; i++ {
+			record := make([]string, len(header))
+			record[0] = frame.Name
+			for _, field := range frame.Fields {
+				record[columns[exportColumn(field)]] = csvValue(field, i)
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		flushFrame(w, frames, f)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeNDJSON writes an object per row of the frames, frame by frame.
+func writeNDJSON(w http.ResponseWriter, frames data.Frames) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for f, frame := range frames {
+		rows, _ := frame.RowLen()
+		for i := 0; i < rows; i++ {
+			row := map[string]interface{}{"frame": frame.Name}
+			for _, field := range frame.Fields {
+				row[exportColumn(field)] = jsonValue(field, i)
+			}
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		flushFrame(w, frames, f)
+	}
+	return nil
+}
+
+// exportColumn names the column of the field: its display name, or its name.
+func exportColumn(field *data.Field) string {
+	if field.Config != nil && field.Config.DisplayNameFromDS != "" {
+		return field.Config.DisplayNameFromDS
+	}
+	return field.Name
+}
+
+// csvValue formats the value of the field at the row, the times as RFC 3339
+// and the nulls as empty.
+func csvValue(field *data.Field, i int) string {
+	value, ok := field.ConcreteAt(i)
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonValue returns the value of the field at the row, the numbers JSON can't
+// encode, NaN and the infinities, as null.
+func jsonValue(field *data.Field, i int) interface{} {
+	value, ok := field.ConcreteAt(i)
+	if !ok {
+		return nil
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	}
+	return value
+}
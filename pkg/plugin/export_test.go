@@ -0,0 +1,134 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestExportResource(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 42))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	export := func(url, body string) (int, []byte) {
+		sender := &resourceSender{}
+		err := p.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+					DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+				},
+			},
+			Method: http.MethodPost,
+			Path:   "export",
+			URL:    url,
+			Body:   []byte(body),
+		}, sender)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var respBody []byte
+		for _, resp := range sender.responses {
+			respBody = append(respBody, resp.Body...)
+		}
+		return sender.responses[0].Status, respBody
+	}
+	body := `{"query": {"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "alias": "latency"},
+		"from": "2020-09-13T12:26:40Z", "to": "2020-09-13T13:26:40Z"}`
+
+	status, resp := export("export", body)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", status, resp)
+	}
+	records, err := csv.NewReader(bytes.NewReader(resp)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header, then every point of the hour.
+	if len(records) != 61 || records[0][0] != "frame" || records[0][2] != "latency" {
+		t.Fatalf("expected the header and 60 rows, got %d records, header %v", len(records), records[0])
+	}
+	if records[1][1] != "2020-09-13T12:27:00Z" || records[1][2] != "42" {
+		t.Errorf("unexpected row %v", records[1])
+	}
+
+	status, resp = export("export?format=ndjson", body)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", status, resp)
+	}
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(resp))
+	for scanner.Scan() {
+		var row map[string]interface{}
+		if err = json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 60 || rows[0]["latency"] != 42.0 || rows[0]["frame"] != "response" {
+		t.Errorf("expected an object per point, got %d rows", len(rows))
+	}
+
+	for _, tt := range []struct {
+		url, body string
+		status    int
+	}{
+		{"export?format=xml", body, http.StatusBadRequest},
+		{"export", `{"query": {"appid": "app1"}}`, http.StatusBadRequest},
+		{"export", `{"query": {"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "quantile": 2},
+			"from": "2020-09-13T12:26:40Z", "to": "2020-09-13T13:26:40Z"}`, http.StatusBadRequest},
+	} {
+		if status, resp := export(tt.url, tt.body); status != tt.status {
+			t.Errorf("%s %s: expected %d, got %d (%s)", tt.url, tt.body, tt.status, status, resp)
+		}
+	}
+}
+
+// failingWriter fails the writes of the response body, like a client gone.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestExportWrite(t *testing.T) {
+	newFrames := func() data.Frames {
+		return data.Frames{data.NewFrame("response",
+			data.NewField("time", nil, []time.Time{time.Unix(1600000000, 0)}),
+			data.NewField("value", nil, []float64{42}))}
+	}
+
+	for name, write := range map[string]func(http.ResponseWriter, data.Frames) error{
+		exportFormatCSV:    writeCSV,
+		exportFormatNDJSON: writeNDJSON,
+	} {
+		frames := newFrames()
+		recorder := httptest.NewRecorder()
+		if err := write(recorder, frames); err != nil || recorder.Body.Len() == 0 {
+			t.Errorf("%s: expected the rows written, got %v", name, err)
+		}
+		if !recorder.Flushed || frames[0] != nil {
+			t.Errorf("%s: expected the frame flushed and released", name)
+		}
+		if err := write(failingWriter{httptest.NewRecorder()}, newFrames()); err == nil {
+			t.Errorf("%s: expected the write error", name)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	fixtureModeRecord = "record"
+	fixtureModeReplay = "replay"
+	// FixtureKey is the key to get the secret the fixtures are encrypted with
+	// from the decrypted secure data. It is shared along with the fixtures to
+	// replay them.
+	FixtureKey = "fixtureKey"
+)
+
+var errFixtureUnreadable = errors.New("fixture not readable with the fixtureKey")
+
+// fixture is an NS1 response saved to disk by the record mode.
+type fixture struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// fixtureFile returns the file of the fixture of the request. The time range
+// of the request is left out, so the fixtures recorded for a relative range
+// are replayed whenever the dashboard is opened.
+func fixtureFile(dir string, req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("start")
+	query.Del("end")
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.Path + "?" + query.Encode()))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".fixture")
+}
+
+// newFixtureCipher returns the AES-GCM cipher of the fixtures, keyed with
+// the SHA-256 of the secret.
+func newFixtureCipher(secret string) cipher.AEAD {
+	key := sha256.Sum256([]byte(secret))
+	// The key size and the block size are the ones expected, these can't fail.
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// sealFixture encrypts the fixture, the random nonce leading the file.
+func sealFixture(aead cipher.AEAD, f fixture) ([]byte, error) {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, raw, nil), nil
+}
+
+// openFixture decrypts the fixture sealed by sealFixture.
+func openFixture(aead cipher.AEAD, sealed []byte) (fixture, error) {
+	var f fixture
+	if len(sealed) < aead.NonceSize() {
+		return f, errFixtureUnreadable
+	}
+	raw, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return f, errFixtureUnreadable
+	}
+	err = json.Unmarshal(raw, &f)
+	return f, err
+}
+
+// recordTransport saves the NS1 responses to the fixtures directory, without
+// the API keys and encrypted, the latest response of a request replacing the
+// previous one. The responses are read up to the maximum response size.
+type recordTransport struct {
+	next      http.RoundTripper
+	dir       string
+	aead      cipher.AEAD
+	redactor  *redactor
+	limitBody func(*http.Response) (io.Reader, error)
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.redactor.add(req.Header.Get(apiKeyHeader))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := t.limitBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	body, err := io.ReadAll(reader)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	raw, err := sealFixture(t.aead, fixture{
+		Method: req.Method,
+		URL:    t.redactor.redact(req.URL.String()),
+		Status: resp.StatusCode,
+		Body:   t.redactor.redact(string(body)),
+	})
+	if err == nil {
+		err = os.MkdirAll(t.dir, 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(fixtureFile(t.dir, req), raw, 0o600)
+	}
+	if err != nil {
+		loggerFromContext(req.Context()).Warn("Failed to record the NS1 response", "url", t.redactor.redact(req.URL.String()), "error", err)
+	}
+	return resp, nil
+}
+
+// replayTransport answers the NS1 requests with the recorded fixtures,
+// without calling NS1. The requests not recorded get a 404.
+type replayTransport struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	raw, err := os.ReadFile(fixtureFile(t.dir, req))
+	if os.IsNotExist(err) {
+		return mockResponse(req, http.StatusNotFound, map[string]string{"message": "no fixture recorded for the request"}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := openFixture(t.aead, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixture for %s: %w", req.URL.Path, err)
+	}
+	resp := mockResponse(req, f.Status, nil)
+	resp.Body = io.NopCloser(bytes.NewReader([]byte(f.Body)))
+	resp.ContentLength = int64(len(f.Body))
+	return resp, nil
+}
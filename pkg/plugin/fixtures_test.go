@@ -0,0 +1,150 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/pulsar/apps":
+			// The key echoed by the API doesn't make it to the fixtures.
+			fmt.Fprintf(w, `[{"appid": "app1", "name": "App of %s", "active": true}]`, r.Header.Get(apiKeyHeader))
+			return
+		case strings.HasPrefix(r.URL.Path, "/pulsar/query/"):
+			fmt.Fprint(w, `[{"timestamp": 1600000000, "job1": 12}]`)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	qm := &queryModel{AppID: "app1", JobID: "job1", MetricType: metricTypePerformance, Aggregation: "avg", Geo: "*", ASN: "*",
+		From: time.Unix(1599990000, 0), To: time.Unix(1600010000, 0), MaxDataPoints: 100}
+	recorder := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientFixtures(fixtureModeRecord, dir, "fixture-secret"))
+	if _, err := recorder.GetApps(context.Background(), "secret-key", OptionAppFetchJobs(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := recorder.GetData(context.Background(), "secret-key", qm); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.fixture"))
+	if len(files) != 3 {
+		t.Fatalf("expected the apps, jobs and data to be recorded, got %d fixtures", len(files))
+	}
+	for _, file := range files {
+		raw, _ := os.ReadFile(file)
+		if strings.Contains(string(raw), "App of") || strings.Contains(string(raw), "job1") {
+			t.Errorf("expected %s to be encrypted", file)
+		}
+		f, err := openFixture(newFixtureCipher("fixture-secret"), raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(f.URL+f.Body, "secret-key") {
+			t.Errorf("expected the API key to be redacted from %+v", f)
+		}
+	}
+
+	// The fixtures are replayed without NS1, whatever the time range.
+	replayer := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientFixtures(fixtureModeReplay, dir, "fixture-secret"))
+	apps, err := replayer.GetApps(context.Background(), offlineAPIKey, OptionAppFetchJobs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps.JobsMap) != 2 || apps.AppsMap["app1"].Name != "App of "+redacted {
+		t.Errorf("expected the recorded apps and jobs, got %+v", apps.Apps)
+	}
+	later := *qm
+	later.From, later.To = qm.From.Add(time.Hour), qm.To.Add(time.Hour)
+	if _, values, err := replayer.GetData(context.Background(), offlineAPIKey, &later); err != nil || len(values) != 1 || values[0] != 12 {
+		t.Errorf("expected the recorded data, got %v (%v)", values, err)
+	}
+
+	other := *qm
+	other.JobID = "job2"
+	if _, _, err = replayer.GetData(context.Background(), offlineAPIKey, &other); !errors.Is(err, errJobNotFound) {
+		t.Errorf("expected the requests not recorded to be not found, got %v", err)
+	}
+
+	wrongKey := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientFixtures(fixtureModeReplay, dir, "other-secret"))
+	if _, err = wrongKey.GetApps(context.Background(), offlineAPIKey); !errors.Is(err, errFixtureUnreadable) {
+		t.Errorf("expected the fixtures unreadable with another key, got %v", err)
+	}
+}
+
+func TestRecordMaxResponseSize(t *testing.T) {
+	dir := t.TempDir()
+	server := testutil.NewServer(testutil.OptionValue("job1", 5))
+	defer server.Close()
+
+	recorder := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientMaxResponseSize(100),
+		OptionClientFixtures(fixtureModeRecord, dir, "fixture-secret"))
+	if _, err := recorder.GetApps(context.Background(), "key", OptionAppFetchJobs(true)); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("expected errResponseTooLarge, got %v", err)
+	}
+	// The apps are under the maximum, the jobs over it.
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("expected the oversized jobs not to be recorded, got %v", files)
+	}
+}
+
+func TestFixtureSettings(t *testing.T) {
+	root := t.TempDir()
+	os.Setenv(fixtureDirEnv, root)
+	defer os.Unsetenv(fixtureDirEnv)
+
+	tests := []struct {
+		jsonData string
+		key      string
+		dir      string
+	}{
+		{`{"fixtureMode": "record", "fixtureDir": "support"}`, "fixture-secret", filepath.Join(root, "support")},
+		{`{"fixtureMode": "replay", "fixtureDir": "` + filepath.Join(root, "support") + `"}`, "fixture-secret", filepath.Join(root, "support")},
+		{`{"fixtureMode": "record", "fixtureDir": "support"}`, "", ""},
+		{`{"fixtureMode": "record", "fixtureDir": "/var/lib/grafana"}`, "fixture-secret", ""},
+		{`{"fixtureMode": "record", "fixtureDir": "../support"}`, "fixture-secret", ""},
+	}
+	for _, tt := range tests {
+		settings, err := parseSettings(backend.DataSourceInstanceSettings{
+			JSONData:                []byte(tt.jsonData),
+			DecryptedSecureJSONData: map[string]string{FixtureKey: tt.key},
+		})
+		if tt.dir == "" {
+			if err == nil {
+				t.Errorf("%s, key %q: expected an error", tt.jsonData, tt.key)
+			}
+			continue
+		}
+		if err != nil || settings.FixtureDir != tt.dir {
+			t.Errorf("%s: expected the directory %s, got %+v, %v", tt.jsonData, tt.dir, settings, err)
+		}
+	}
+
+	os.Unsetenv(fixtureDirEnv)
+	if _, err := parseSettings(backend.DataSourceInstanceSettings{
+		JSONData:                []byte(`{"fixtureMode": "record", "fixtureDir": "support"}`),
+		DecryptedSecureJSONData: map[string]string{FixtureKey: "fixture-secret"},
+	}); !errors.Is(err, errOutsideServerDir) {
+		t.Errorf("expected no fixtures without %s, got %v", fixtureDirEnv, err)
+	}
+}
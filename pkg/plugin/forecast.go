@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// forecastMaxPoints caps the points of the projection past the range, the
+// step of the series widened to keep within it.
+const forecastMaxPoints = 1000
+
+var errInvalidForecast = newInvalidQueryError("invalid forecast, expected a duration such as 7d")
+
+// forecastDuration returns how far past the end of the range the trend of the
+// query is projected.
+func (qm *queryModel) forecastDuration() (time.Duration, error) {
+	duration, err := parseDuration(qm.Forecast)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%w: %q", errInvalidForecast, qm.Forecast)
+	}
+	return duration, nil
+}
+
+// checkForecast rejects the invalid durations, and the forecasts of the
+// queries that don't return a series of the data.
+func checkForecast(qm *queryModel) error {
+	if qm.Forecast == "" {
+		return nil
+	}
+	if _, err := qm.forecastDuration(); err != nil {
+		return err
+	}
+	if qm.Reduce != "" || qm.Condition != nil {
+		return fmt.Errorf("%w: the forecast needs the series, not a reducer nor a condition", errInvalidForecast)
+	}
+	return nil
+}
+
+// linearTrend fits a line to the series by least squares, returning the value
+// at t. The series needs two points at different times.
+func linearTrend(times []time.Time, values []float64) (func(t time.Time) float64, bool) {
+	if len(times) < 2 {
+		return nil, false
+	}
+	// The times are relative to the first point, so the squares keep their
+	// precision.
+	origin := times[0]
+	var sumX, sumY, sumXY, sumXX float64
+	for i, t := range times {
+		x := t.Sub(origin).Seconds()
+		sumX += x
+		sumY += values[i]
+		sumXY += x * values[i]
+		sumXX += x * x
+	}
+	n := float64(len(times))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil, false
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+	return func(t time.Time) float64 {
+		return intercept + slope*t.Sub(origin).Seconds()
+	}, true
+}
+
+// forecastFrame returns the linear trend of the series over its points, then
+// projected up to the duration past to, at the step of the series. None when
+// the series is too short for a trend.
+func forecastFrame(times []time.Time, values []float64, to time.Time, duration time.Duration, labels data.Labels, label string) *data.Frame {
+	trend, ok := linearTrend(times, values)
+	if !ok {
+		return nil
+	}
+
+	step := times[len(times)-1].Sub(times[0]) / time.Duration(len(times)-1)
+	end := to.Add(duration)
+	if future := end.Sub(times[len(times)-1]); step <= 0 || future/step > forecastMaxPoints {
+		step = future / forecastMaxPoints
+	}
+
+	forecastTimes := append([]time.Time{}, times...)
+	for t := times[len(times)-1].Add(step); step > 0 && !t.After(end); t = t.Add(step) {
+		forecastTimes = append(forecastTimes, t)
+	}
+	forecastValues := make([]float64, len(forecastTimes))
+	for i, t := range forecastTimes {
+		forecastValues[i] = trend(t)
+	}
+
+	forecastField := data.NewField("forecast", labels, forecastValues)
+	forecastField.Config = &data.FieldConfig{DisplayNameFromDS: label + " forecast"}
+	return data.NewFrame("forecast", data.NewField("time", nil, forecastTimes), forecastField)
+}
@@ -0,0 +1,83 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestForecastFrame(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	times := make([]time.Time, 10)
+	values := make([]float64, 10)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Hour)
+		// A growth of 2 per hour, with some noise.
+		values[i] = 100 + 2*float64(i) + float64(i%2)
+	}
+
+	frame := forecastFrame(times, values, times[9], 5*time.Hour, nil, "Job 1")
+	if frame == nil {
+		t.Fatal("expected a forecast")
+	}
+	if frame.Rows() != 15 {
+		t.Fatalf("expected the 10 points of the series and 5 projected, got %d", frame.Rows())
+	}
+	last := frame.Fields[1].At(14).(float64)
+	if math.Abs(last-(100.3636+2.0303*14)) > 0.01 {
+		t.Errorf("expected the projection of the trend, got %v", last)
+	}
+	if frame.Fields[1].Config.DisplayNameFromDS != "Job 1 forecast" {
+		t.Errorf("unexpected display name %q", frame.Fields[1].Config.DisplayNameFromDS)
+	}
+
+	if frame := forecastFrame(times[:1], values[:1], times[0], time.Hour, nil, ""); frame != nil {
+		t.Errorf("expected no forecast out of a single point")
+	}
+	// The long forecasts are capped.
+	if frame := forecastFrame(times, values, times[9], 10000*time.Hour, nil, ""); frame.Rows() > 10+forecastMaxPoints {
+		t.Errorf("expected up to %d projected points, got %d", forecastMaxPoints, frame.Rows()-10)
+	}
+}
+
+func TestQueryForecast(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 50))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 100,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "forecast": "1h"}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(res.Frames) != 2 || res.Frames[1].Name != "forecast" {
+		t.Fatalf("expected a forecast frame, got %d frames", len(res.Frames))
+	}
+	times := res.Frames[1].Fields[0]
+	// The projection keeps the step of a minute of the series.
+	if end := times.At(times.Len() - 1).(time.Time); end.After(to.Add(time.Hour)) || end.Before(to.Add(59*time.Minute)) {
+		t.Errorf("expected the forecast to end an hour past the range, got %v", end)
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "forecast": "someday"}`); !errors.Is(res.Error, errInvalidForecast) {
+		t.Errorf("expected errInvalidForecast, got %v", res.Error)
+	}
+}
@@ -0,0 +1,206 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// healthProbeRange is the time range requested to check the Pulsar data
+// endpoints respond, kept short so the probe stays cheap.
+const healthProbeRange = time.Minute
+
+// maxClockSkew is the clock difference with NS1 above which the queries
+// relative to now may miss the latest data points, or get none.
+const maxClockSkew = time.Minute
+
+var errDataPermissionDenied = errors.New("the API key can list the Pulsar jobs but isn't " +
+	"allowed to read their data")
+
+// healthDetails is the outcome of the checks run by CheckHealth, reported to
+// Grafana as the JSON details of the result.
+type healthDetails struct {
+	Apps int `json:"apps"`
+	Jobs int `json:"jobs"`
+	// LatencyMs is the time taken by the NS1 API to list the apps and jobs.
+	LatencyMs int64 `json:"latencyMs"`
+	// DataEndpoint is "ok" when the Pulsar query endpoints answered, "skipped"
+	// when there is no job to query, and the error otherwise.
+	DataEndpoint string `json:"dataEndpoint"`
+	// ClockSkewMs is how far ahead of the NS1 servers the local clock is,
+	// negative when behind. Not set when NS1 doesn't report its time.
+	ClockSkewMs *int64 `json:"clockSkewMs,omitempty"`
+	// Degraded tells a large share of the recent queries failed because of
+	// NS1.
+	Degraded bool `json:"degraded"`
+	// SecondaryKeyInUse tells the primary API key was rejected.
+	SecondaryKeyInUse bool `json:"secondaryKeyInUse"`
+	// FallbackSince is set when the fallback endpoint is in use.
+	FallbackSince *time.Time `json:"fallbackSince,omitempty"`
+	// SelfTest is the latest result of the background self-test, if enabled.
+	SelfTest *selfTestResult `json:"selfTest,omitempty"`
+}
+
+const (
+	dataEndpointOK      = "ok"
+	dataEndpointSkipped = "skipped"
+)
+
+// diagnose lists the apps and jobs visible to the key and queries the data of
+// the first job, to report what the datasource can actually see. Keys allowed
+// to list the jobs but not to read their data are rejected.
+func (p *PulsarDatasource) diagnose(ctx context.Context, client *PulsarClient, apiKey string) (*healthDetails, error) {
+	details := &healthDetails{}
+
+	start := time.Now()
+	apps, err := client.GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the Pulsar apps: %w", err)
+	}
+	details.LatencyMs = time.Since(start).Milliseconds()
+
+	if p.settings.restrictsApps() {
+		apps = apps.filter(p.settings.isAppAllowed)
+	}
+	details.Apps = len(apps.Apps)
+	details.Jobs = len(apps.JobsMap)
+
+	// The Date header has a one second precision, so does the skew.
+	if serverTime, err := client.ping(apiKey); err == nil && !serverTime.IsZero() {
+		skew := time.Since(serverTime).Truncate(time.Second).Milliseconds()
+		details.ClockSkewMs = &skew
+	}
+
+	details.DataEndpoint = dataEndpointSkipped
+	for _, app := range apps.Apps {
+		if len(app.Jobs) == 0 {
+			continue
+		}
+		details.DataEndpoint = dataEndpointOK
+		err = client.probeData(ctx, apiKey, app.Jobs[0].JobID)
+		if errors.Is(err, errAuthorizationDenied) {
+			return nil, &permissionError{operation: operationReadData, err: errDataPermissionDenied}
+		}
+		if err != nil {
+			details.DataEndpoint = client.redactor.redact(err.Error())
+		}
+		break
+	}
+
+	return details, nil
+}
+
+// message summarizes the details for the health check result.
+func (d *healthDetails) message() string {
+	parts := []string{
+		fmt.Sprintf("%d apps and %d jobs visible", d.Apps, d.Jobs),
+		fmt.Sprintf("NS1 API latency %dms", d.LatencyMs),
+	}
+
+	switch d.DataEndpoint {
+	case dataEndpointOK:
+		parts = append(parts, "Pulsar data endpoints responding")
+	case dataEndpointSkipped:
+		parts = append(parts, "Pulsar data endpoints not checked, no job found")
+	default:
+		parts = append(parts, "Pulsar data endpoints failing: "+d.DataEndpoint)
+	}
+
+	if skew := d.clockSkew(); skew > maxClockSkew || skew < -maxClockSkew {
+		parts = append(parts, fmt.Sprintf("warning: the local clock is off by %s from NS1, "+
+			"queries relative to now may return no data", skew))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (d *healthDetails) clockSkew() time.Duration {
+	if d.ClockSkewMs == nil {
+		return 0
+	}
+	return time.Duration(*d.ClockSkewMs) * time.Millisecond
+}
+
+func (d *healthDetails) toJSON() []byte {
+	b, err := json.Marshal(d)
+	if err != nil {
+		Logger.Error("Failed to encode the health details", "error", err)
+		return nil
+	}
+	return b
+}
+
+// probeData queries the performance data of the job over the last minutes.
+func (pc *PulsarClient) probeData(ctx context.Context, apiKey, jobID string) error {
+	now := time.Now()
+	qm := &queryModel{
+		JobID:      jobID,
+		MetricType: metricTypePerformance,
+		Geo:        "*",
+		ASN:        "*",
+		From:       now.Add(-healthProbeRange),
+		To:         now,
+	}
+
+	apiURL, err := pc.buildURL(pc.getAPIClient(apiKey).Endpoint.String(), qm)
+	if err != nil {
+		return err
+	}
+
+	_, err = pc.fetchData(ctx, apiKey, apiURL)
+	return err
+}
+
+// describeHealthError tells what kind of failure the health check ran into,
+// so admins know whether to fix the network, the key or wait for the rate
+// limit to reset.
+func describeHealthError(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		opErr        *net.OpError
+		urlErr       *url.Error
+		unknownCA    x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		certErr      x509.CertificateInvalidError
+		tlsRecordErr tls.RecordHeaderError
+		permErr      *permissionError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("DNS resolution of the NS1 API host failed, check the endpoint and "+
+			"the DNS configuration: %s", err)
+	case errors.As(err, &unknownCA), errors.As(err, &hostnameErr), errors.As(err, &certErr),
+		errors.As(err, &tlsRecordErr):
+		return fmt.Sprintf("TLS handshake with the NS1 API failed, check the endpoint and the "+
+			"certificates trusted by Grafana: %s", err)
+	case errors.As(err, &urlErr) && urlErr.Timeout():
+		return fmt.Sprintf("NS1 API request timed out, check the network and the timeout "+
+			"setting: %s", err)
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return fmt.Sprintf("connection to the NS1 API failed, check the network, firewall and "+
+			"proxy configuration: %s", err)
+	case errors.As(err, &permErr):
+		return fmt.Sprintf("NS1 API key valid, but not allowed to %s (403): grant the key the "+
+			"permission to %s", permErr.operation, permErr.operation)
+	case errors.Is(err, errAuthorizationDenied):
+		return "NS1 API key rejected (401/403), check the key and its permissions"
+	case errors.Is(err, errRateLimited):
+		return "NS1 API rate limit reached (429), retry later or lower the query load of the key"
+	case errors.Is(err, errUpstreamUnavailable):
+		return fmt.Sprintf("NS1 API failed (5xx), retry later: %s", err)
+	default:
+		return err.Error()
+	}
+}
@@ -0,0 +1,296 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		dataStatus   int
+		dataEndpoint string
+	}{
+		{http.StatusOK, dataEndpointOK},
+		{http.StatusServiceUnavailable, errUpstreamUnavailable.Error()},
+		{http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		server := testutil.NewServer(testutil.OptionDataStatus(tt.dataStatus))
+		p := &PulsarDatasource{settings: defaultSettings()}
+
+		details, err := p.diagnose(context.Background(), NewPulsarClient(OptionClientEndpoint(server.URL)), "key")
+		server.Close()
+		if tt.dataStatus == http.StatusForbidden {
+			if !errors.Is(err, errDataPermissionDenied) {
+				t.Errorf("expected a data permission error, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if details.Apps != 1 || details.Jobs != 2 {
+			t.Errorf("expected 1 app and 2 jobs, got %+v", details)
+		}
+		if details.DataEndpoint != tt.dataEndpoint {
+			t.Errorf("expected data endpoint %q, got %q", tt.dataEndpoint, details.DataEndpoint)
+		}
+		if !strings.Contains(details.message(), "1 apps and 2 jobs visible") {
+			t.Errorf("unexpected message %q", details.message())
+		}
+	}
+}
+
+func TestCheckHealthUsesSettings(t *testing.T) {
+	server := testutil.NewServer()
+	defer server.Close()
+
+	instanceSettings := &backend.DataSourceInstanceSettings{
+		JSONData:                []byte(fmt.Sprintf(`{"endpoint": %q}`, server.URL)),
+		DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+	}
+	settings, err := parseSettings(*instanceSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &PulsarDatasource{settings: settings}
+	res, err := p.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: instanceSettings},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != backend.HealthStatusOk {
+		t.Fatalf("expected a successful health check, got %q", res.Message)
+	}
+	if !strings.Contains(res.Message, "1 apps and 2 jobs visible") {
+		t.Errorf("the configured endpoint wasn't used: %q", res.Message)
+	}
+}
+
+func TestCheckHealthGrafanaTransport(t *testing.T) {
+	server := httptest.NewTLSServer(testutil.NewNS1())
+	defer server.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	for _, withCACert := range []bool{false, true} {
+		instanceSettings := backend.DataSourceInstanceSettings{
+			JSONData:                []byte(fmt.Sprintf(`{"endpoint": %q, "tlsAuthWithCACert": %v}`, server.URL, withCACert)),
+			DecryptedSecureJSONData: map[string]string{APIKey: "key", "tlsCACert": caCert},
+		}
+		instance, err := NewPulsarDatasource(instanceSettings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := instance.(*PulsarDatasource)
+
+		health, err := p.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &instanceSettings},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.pulsarClient.GetApps(context.Background(), "key")
+		p.Dispose()
+
+		// The certificate of the server is only trusted through the CA set in
+		// Grafana, by both the health check and the queries.
+		if healthy := health.Status == backend.HealthStatusOk; healthy != withCACert {
+			t.Errorf("tlsAuthWithCACert %v: unexpected health check %v: %q", withCACert, health.Status, health.Message)
+		}
+		if (err == nil) != withCACert {
+			t.Errorf("tlsAuthWithCACert %v: unexpected apps error %v", withCACert, err)
+		}
+	}
+}
+
+func TestDiagnoseClockSkew(t *testing.T) {
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-10*time.Minute).UTC().Format(http.TimeFormat))
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings()}
+	details, err := p.diagnose(context.Background(), NewPulsarClient(OptionClientEndpoint(server.URL)), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if skew := details.clockSkew(); skew < 9*time.Minute || skew > 11*time.Minute {
+		t.Errorf("expected a 10 minutes skew, got %s", skew)
+	}
+	if !strings.Contains(details.message(), "the local clock is off") {
+		t.Errorf("expected a clock skew warning, got %q", details.message())
+	}
+}
+
+func TestDescribeHealthError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&url.Error{Op: "Get", URL: "https://ns1", Err: &net.DNSError{Err: "no such host", Name: "ns1"}}, "DNS resolution"},
+		{&url.Error{Op: "Get", URL: "https://ns1", Err: x509.UnknownAuthorityError{}}, "TLS handshake"},
+		{&url.Error{Op: "Get", URL: "https://ns1", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, "connection to the NS1 API failed"},
+		{newAPIError(http.StatusUnauthorized, "", errAppNotFound), "key rejected"},
+		{asPermissionError(newAPIError(http.StatusForbidden, "", errAppNotFound), operationListApps),
+			"not allowed to list the Pulsar apps"},
+		{newAPIError(http.StatusTooManyRequests, "", errAppNotFound), "rate limit"},
+		{newAPIError(http.StatusBadGateway, "", errAppNotFound), "NS1 API failed"},
+	}
+
+	for _, tt := range tests {
+		if message := describeHealthError(tt.err); !strings.Contains(message, tt.expected) {
+			t.Errorf("%v: expected %q in %q", tt.err, tt.expected, message)
+		}
+	}
+}
+
+func TestCheckHealthUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL
+	server.Close()
+
+	settings := defaultSettings()
+	settings.Endpoint = endpoint + "/"
+	p := &PulsarDatasource{settings: settings}
+	res, err := p.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != backend.HealthStatusError || !strings.Contains(res.Message, "connection to the NS1 API failed") {
+		t.Errorf("unexpected result %v: %q", res.Status, res.Message)
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	var probed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = append(probed, r.URL.Path)
+		switch r.Header.Get(apiKeyHeader) {
+		case "invalid":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "unscoped":
+			w.WriteHeader(http.StatusForbidden)
+		}
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL))
+	if err := pc.CheckAPIKey("valid"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	err := pc.CheckAPIKey("invalid")
+	var permErr *permissionError
+	if !errors.Is(err, errAuthorizationDenied) || errors.As(err, &permErr) {
+		t.Errorf("expected an invalid key, got %v", err)
+	}
+
+	err = pc.CheckAPIKey("unscoped")
+	if !errors.As(err, &permErr) || permErr.operation != operationListApps ||
+		!errors.Is(err, errAuthorizationDenied) {
+		t.Errorf("expected a missing permission, got %v", err)
+	}
+
+	for _, path := range probed {
+		if path != "/pulsar/apps" {
+			t.Errorf("expected the key to be checked listing the apps, got %s", path)
+		}
+	}
+}
+
+func TestDataAPIKey(t *testing.T) {
+	keys := make(map[string]string)
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			keys["data"] = key
+			if key != "data-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		} else {
+			keys["catalog"] = key
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL))
+	p := &PulsarDatasource{settings: defaultSettings()}
+	details, err := p.diagnose(withDataAPIKey(context.Background(), "data-key"), pc, "catalog-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.DataEndpoint != dataEndpointOK || keys["catalog"] != "catalog-key" || keys["data"] != "data-key" {
+		t.Errorf("expected the apps listed with the catalog key and the data read with the data key, got %v", keys)
+	}
+
+	// A rejected data key doesn't trigger the fallback of the catalog key.
+	err = pc.probeData(withDataAPIKey(context.Background(), "revoked-key"), "catalog-key", "job1")
+	if !errors.Is(err, errDataKeyRejected) || errors.Is(err, errAuthorizationDenied) {
+		t.Errorf("expected the data key to be rejected, got %v", err)
+	}
+}
+
+func TestCheckHealthRedactsKeys(t *testing.T) {
+	// NS1 may echo any key of the account, not only the one checked.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, `{"message": "backend rejected primary-key and data-key"}`)
+	}))
+	defer server.Close()
+
+	instanceSettings := backend.DataSourceInstanceSettings{
+		JSONData:                []byte(fmt.Sprintf(`{"endpoint": %q}`, server.URL)),
+		DecryptedSecureJSONData: map[string]string{APIKey: "primary-key", DataAPIKey: "data-key"},
+	}
+	instance, err := NewPulsarDatasource(instanceSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := instance.(*PulsarDatasource)
+	defer p.Dispose()
+
+	res, err := p.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &instanceSettings},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusError || !strings.Contains(res.Message, "backend rejected") ||
+		strings.Contains(res.Message, "-key") {
+		t.Errorf("expected the keys redacted from the failed health check, got %q", res.Message)
+	}
+}
@@ -0,0 +1,204 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeHealthScore is the type of the queries of the health score of the
+// jobs, blending their availability and their performance.
+const queryTypeHealthScore = "healthScore"
+
+// defaultHealthMaxLatency is the latency scoring 0 for the jobs without
+// request timeout.
+const defaultHealthMaxLatency = time.Second
+
+var errInvalidHealthScore = newInvalidQueryError("invalid health score query")
+
+// healthScore weighs the availability and the performance of the health
+// score, the same by default, and sets the latency scoring 0, the request
+// timeout of the job by default.
+type healthScore struct {
+	AvailabilityWeight float64 `json:"availabilityWeight"`
+	PerformanceWeight  float64 `json:"performanceWeight"`
+	MaxLatency         string  `json:"maxLatency"`
+}
+
+// check rejects the negative weights and the invalid latencies.
+func (h *healthScore) check() error {
+	if h.AvailabilityWeight < 0 || h.PerformanceWeight < 0 {
+		return fmt.Errorf("%w: the weights must be positive", errInvalidHealthScore)
+	}
+	if h.MaxLatency != "" {
+		if latency, err := parseDuration(h.MaxLatency); err != nil || latency <= 0 {
+			return fmt.Errorf("%w: invalid maxLatency %q", errInvalidHealthScore, h.MaxLatency)
+		}
+	}
+	return nil
+}
+
+// weights returns the weights of the availability and of the performance,
+// adding up to 1.
+func (h *healthScore) weights() (float64, float64) {
+	availability, performance := h.AvailabilityWeight, h.PerformanceWeight
+	if availability+performance == 0 {
+		availability, performance = 1, 1
+	}
+	return availability / (availability + performance), performance / (availability + performance)
+}
+
+// maxLatency returns the latency, in milliseconds, scoring 0 for the job.
+func (h *healthScore) maxLatency(job Job) float64 {
+	if latency, err := parseDuration(h.MaxLatency); err == nil && latency > 0 {
+		return float64(latency) / float64(time.Millisecond)
+	}
+	if job.RequestTimeout > 0 {
+		return float64(job.RequestTimeout)
+	}
+	return float64(defaultHealthMaxLatency) / float64(time.Millisecond)
+}
+
+// score blends the availability, in percent, and the latency, in
+// milliseconds, into a score between 0 and 100: the latency scores 100 at 0
+// and 0 from the max latency on.
+func (h *healthScore) score(availability, latency, maxLatency float64) float64 {
+	availabilityWeight, performanceWeight := h.weights()
+	performance := 100 * math.Max(0, 1-latency/maxLatency)
+	return availabilityWeight*math.Min(math.Max(availability, 0), 100) + performanceWeight*performance
+}
+
+// bucketMeans returns the mean of the points of each bucket of the interval,
+// aligned on the epoch, by the start of the bucket.
+func bucketMeans(times []time.Time, values []float64, interval time.Duration) map[time.Time]float64 {
+	sums := make(map[time.Time]float64)
+	counts := make(map[time.Time]int)
+	for i, t := range times {
+		start := t.Truncate(interval)
+		sums[start] += values[i]
+		counts[start]++
+	}
+	for start, count := range counts {
+		sums[start] /= float64(count)
+	}
+	return sums
+}
+
+// queryHealthScore answers the health score queries with the score of the job
+// of the query, or of every job of its app, per interval of the panel: a
+// field per job, on a shared time axis. The intervals missing the
+// availability or the performance of a job are null.
+func (p *PulsarDatasource) queryHealthScore(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if err := p.settings.checkFeatures(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if qm.AppID == "" {
+		return backend.DataResponse{Error: fmt.Errorf("%w: the app is missing", errInvalidHealthScore)}
+	}
+	health := qm.HealthScore
+	if health == nil {
+		health = &healthScore{}
+	}
+	if err := health.check(); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+	if err = checkQueryAllowed(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if err = checkJobType(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	qm.applyDefaults(p.settings)
+	qm.validate()
+	if qm.Aggregation == "" {
+		qm.Aggregation = defaultStreamAggregation
+	}
+	interval := qm.panelInterval()
+	// The buckets need all their points, not only the latest ones.
+	qm.MaxDataPoints = math.MaxInt64
+
+	app := appsResponse.AppsMap[qm.AppID]
+	var (
+		jobs   []Job
+		scores []map[time.Time]float64
+		starts = make(map[time.Time]bool)
+	)
+	for _, job := range app.Jobs {
+		if (qm.JobID != "" && job.JobID != qm.JobID) || (qm.JobType != "" && job.Type != qm.JobType) {
+			continue
+		}
+		jobQuery := *qm
+		jobQuery.JobID = job.JobID
+		jobQuery.MetricType = metricTypeAvailability
+		jobQuery.Aggregation = defaultStreamAggregation
+		availabilityTimes, availabilityValues, err := p.getData(ctx, apiKey, &jobQuery)
+		if err != nil && !errors.Is(err, errNoDataFound) {
+			return backend.DataResponse{Error: err}
+		}
+		jobQuery.MetricType = metricTypePerformance
+		jobQuery.Aggregation = qm.Aggregation
+		performanceTimes, performanceValues, err := p.getData(ctx, apiKey, &jobQuery)
+		if err != nil && !errors.Is(err, errNoDataFound) {
+			return backend.DataResponse{Error: err}
+		}
+
+		availability := bucketMeans(availabilityTimes, availabilityValues, interval)
+		maxLatency := health.maxLatency(job)
+		jobScores := make(map[time.Time]float64)
+		for start, latency := range bucketMeans(performanceTimes, performanceValues, interval) {
+			if available, found := availability[start]; found {
+				jobScores[start] = health.score(available, latency, maxLatency)
+				starts[start] = true
+			}
+		}
+		jobs = append(jobs, job)
+		scores = append(scores, jobScores)
+	}
+
+	times := make([]time.Time, 0, len(starts))
+	for start := range starts {
+		times = append(times, start)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	frame := data.NewFrame("health score", data.NewField("time", nil, times))
+	for i, job := range jobs {
+		values := make([]*float64, len(times))
+		for j, t := range times {
+			if score, found := scores[i][t]; found {
+				values[j] = &score
+			}
+		}
+		labels := data.Labels{"appid": qm.AppID, "jobid": job.JobID}
+		if job.Name != "" {
+			labels["job"] = job.Name
+		}
+		min, max := data.ConfFloat64(0), data.ConfFloat64(100)
+		field := data.NewField("score", labels, values)
+		field.Config = &data.FieldConfig{DisplayNameFromDS: job.Name, Min: &min, Max: &max}
+		frame.Fields = append(frame.Fields, field)
+	}
+	frame.Meta = &data.FrameMeta{Custom: appsResponse.Apps}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestHealthScore(t *testing.T) {
+	health := &healthScore{}
+	if score := health.score(100, 0, 1000); score != 100 {
+		t.Errorf("expected a perfect score, got %v", score)
+	}
+	if score := health.score(90, 250, 1000); score != 82.5 {
+		t.Errorf("expected the mean of 90 and 75, got %v", score)
+	}
+	if score := health.score(100, 5000, 1000); score != 50 {
+		t.Errorf("expected the latencies over the max to score 0, got %v", score)
+	}
+
+	health = &healthScore{AvailabilityWeight: 3, PerformanceWeight: 1}
+	if score := health.score(80, 500, 1000); score != 72.5 {
+		t.Errorf("expected the weighted score, got %v", score)
+	}
+	if latency := (&healthScore{}).maxLatency(Job{RequestTimeout: 2000}); latency != 2000 {
+		t.Errorf("expected the request timeout of the job, got %v", latency)
+	}
+	if latency := (&healthScore{MaxLatency: "500ms"}).maxLatency(Job{RequestTimeout: 2000}); latency != 500 {
+		t.Errorf("expected the max latency of the query, got %v", latency)
+	}
+}
+
+func TestQueryHealthScore(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 100))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			Interval:      10 * time.Minute,
+			MaxDataPoints: 1000,
+		})
+	}
+
+	// The fake NS1 returns 100 for both metrics: a full availability and a
+	// latency of a tenth of the max one.
+	res := query(`{"queryType": "healthScore", "appid": "app1", "healthScore": {"maxLatency": "1s"}}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	// The hour overlaps 7 buckets of 10 minutes aligned on the epoch.
+	fields := res.Frames[0].Fields
+	if len(fields) != 3 || fields[0].Len() != 7 {
+		t.Fatalf("expected the time and a field per job, got %d fields", len(fields))
+	}
+	if score := fields[1].At(0).(*float64); score == nil || math.Abs(*score-95) > 1e-9 || fields[1].Labels["jobid"] != "job1" {
+		t.Errorf("expected the score of job1 per 10 minutes, got %v", score)
+	}
+	// job2 has no data.
+	if score := fields[2].At(0).(*float64); score != nil {
+		t.Errorf("expected no score for job2, got %v", *score)
+	}
+
+	for _, json := range []string{
+		`{"queryType": "healthScore"}`,
+		`{"queryType": "healthScore", "appid": "app1", "healthScore": {"availabilityWeight": -1}}`,
+		`{"queryType": "healthScore", "appid": "app1", "healthScore": {"maxLatency": "fast"}}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidHealthScore) {
+			t.Errorf("%s: expected errInvalidHealthScore, got %v", json, res.Error)
+		}
+	}
+}
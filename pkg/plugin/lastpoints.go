@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxLastPoints caps the points of the queries of the latest points, a week
+// of points at one a minute.
+const maxLastPoints = 10080
+
+// lastPointsWindows are the windows back from now searched in turn for the
+// latest points, so the jobs reporting rarely, or not yet, are found without
+// fetching a week of data for the others.
+var lastPointsWindows = []time.Duration{time.Hour, 24 * time.Hour, week}
+
+var errInvalidLastPoints = newInvalidQueryError("invalid last points, expected a number of points between 1 and 10080")
+
+// checkLastPoints rejects the numbers of points out of range, and the bands,
+// whose history comes before the range of the query.
+func checkLastPoints(qm *queryModel) error {
+	if qm.LastPoints == 0 {
+		return nil
+	}
+	if qm.LastPoints < 0 || qm.LastPoints > maxLastPoints {
+		return fmt.Errorf("%w: %d", errInvalidLastPoints, qm.LastPoints)
+	}
+	if qm.Band != nil {
+		return fmt.Errorf("%w: the band needs the history before the range", errInvalidLastPoints)
+	}
+	return nil
+}
+
+// getLastPoints fetches the latest LastPoints points of the query, whatever
+// the range of the dashboard, widening the window back from now until it has
+// them, within the max range of the datasource. The range of the query is
+// moved to the last window searched.
+func (p *PulsarDatasource) getLastPoints(ctx context.Context, apiKey string, qm *queryModel) ([]time.Time, []float64, error) {
+	var (
+		to     = time.Now()
+		times  []time.Time
+		values []float64
+		err    error
+	)
+	for i, window := range lastPointsWindows {
+		last := i == len(lastPointsWindows)-1
+		if max := time.Duration(p.settings.MaxRange); max > 0 && p.settings.MaxRangeMode == maxRangeModeReject && window >= max {
+			window, last = max, true
+		}
+		qm.From, qm.To = to.Add(-window), to
+		fetch := *qm
+		fetch.MaxDataPoints = int64(qm.LastPoints)
+		times, values, err = p.getData(ctx, apiKey, &fetch)
+		if err != nil && !errors.Is(err, errNoDataFound) {
+			return nil, nil, err
+		}
+		if len(values) >= qm.LastPoints || last {
+			break
+		}
+	}
+	return times, values, err
+}
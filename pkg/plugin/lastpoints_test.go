@@ -0,0 +1,66 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryLastPoints(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 5))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	// The range of the dashboard is a year ago, without data.
+	to := time.Now().AddDate(-1, 0, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "lastPoints": 5}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	times := res.Frames[0].Fields[0]
+	if times.Len() != 5 || time.Since(times.At(4).(time.Time)) > time.Minute {
+		t.Errorf("expected the latest 5 points, got %d points", times.Len())
+	}
+	if requests := server.NS1.Requests("/pulsar/query/performance/time"); requests != 1 {
+		t.Errorf("expected the last hour to have the points, got %d requests", requests)
+	}
+
+	// The last hour has 60 points at most, the last day is searched.
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50", "lastPoints": 100}`)
+	if res.Error != nil || res.Frames[0].Fields[0].Len() != 100 {
+		t.Errorf("expected the latest 100 points, got %v", res.Error)
+	}
+
+	// A job without data is searched up to a week back.
+	res = query(`{"appid": "app1", "jobid": "job2", "metricType": "performance", "agg": "p90", "lastPoints": 5}`)
+	if !errors.Is(res.Error, errNoDataFound) {
+		t.Errorf("expected errNoDataFound, got %v", res.Error)
+	}
+
+	for _, json := range []string{
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "lastPoints": -1}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "lastPoints": 20000}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "lastPoints": 5, "band": {"window": "1h"}}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidLastPoints) {
+			t.Errorf("%s: expected errInvalidLastPoints, got %v", json, res.Error)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The log levels of the logLevel setting, from the most to the least
+// verbose.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+func isValidLogLevel(level string) bool {
+	return logLevelIndex(level) >= 0
+}
+
+func logLevelIndex(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// levelLogger drops the messages below its level.
+type levelLogger struct {
+	next  log.Logger
+	level int
+}
+
+// withLevel returns a logger dropping the messages of logger below level.
+// Every message is kept when level is empty, Grafana filtering them instead.
+func withLevel(logger log.Logger, level string) log.Logger {
+	if level == "" {
+		return logger
+	}
+	return &levelLogger{next: logger, level: logLevelIndex(level)}
+}
+
+func (l *levelLogger) Debug(msg string, args ...interface{}) {
+	if l.level <= 0 {
+		l.next.Debug(msg, args...)
+	}
+}
+
+func (l *levelLogger) Info(msg string, args ...interface{}) {
+	if l.level <= 1 {
+		l.next.Info(msg, args...)
+	}
+}
+
+func (l *levelLogger) Warn(msg string, args ...interface{}) {
+	if l.level <= 2 {
+		l.next.Warn(msg, args...)
+	}
+}
+
+func (l *levelLogger) Error(msg string, args ...interface{}) {
+	l.next.Error(msg, args...)
+}
+
+// loggerOrDefault returns logger, Logger when nil.
+func loggerOrDefault(logger log.Logger) log.Logger {
+	if logger == nil {
+		return Logger
+	}
+	return logger
+}
+
+type loggerContextKey struct{}
+
+// fieldsLogger adds its fields to every message, so the log lines of a
+// request can be correlated with the panel that sent it.
+type fieldsLogger struct {
+	next   log.Logger
+	fields []interface{}
+}
+
+// withFields returns a logger adding the key value pairs to the messages of
+// logger.
+func withFields(logger log.Logger, fields ...interface{}) log.Logger {
+	if parent, ok := logger.(*fieldsLogger); ok {
+		return &fieldsLogger{
+			next:   parent.next,
+			fields: append(parent.fields[:len(parent.fields):len(parent.fields)], fields...),
+		}
+	}
+	return &fieldsLogger{next: logger, fields: fields}
+}
+
+func (l *fieldsLogger) args(args []interface{}) []interface{} {
+	return append(l.fields[:len(l.fields):len(l.fields)], args...)
+}
+
+func (l *fieldsLogger) Debug(msg string, args ...interface{}) { l.next.Debug(msg, l.args(args)...) }
+func (l *fieldsLogger) Info(msg string, args ...interface{})  { l.next.Info(msg, l.args(args)...) }
+func (l *fieldsLogger) Warn(msg string, args ...interface{})  { l.next.Warn(msg, l.args(args)...) }
+func (l *fieldsLogger) Error(msg string, args ...interface{}) { l.next.Error(msg, l.args(args)...) }
+
+// withLogger returns a copy of ctx carrying logger.
+func withLogger(ctx context.Context, logger log.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// loggerFromContext returns the logger of the request, Logger when ctx has
+// none.
+func loggerFromContext(ctx context.Context) log.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(log.Logger); ok {
+		return logger
+	}
+	return Logger
+}
+
+// pluginContextLogger returns a logger adding the datasource UID and the
+// organization ID of the request to the messages of logger.
+func pluginContextLogger(logger log.Logger, pCtx backend.PluginContext) log.Logger {
+	fields := []interface{}{"orgId", pCtx.OrgID}
+	if pCtx.DataSourceInstanceSettings != nil {
+		fields = append(fields, "datasourceUid", pCtx.DataSourceInstanceSettings.UID)
+	}
+	return withFields(loggerOrDefault(logger), fields...)
+}
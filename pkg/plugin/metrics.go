@@ -0,0 +1,225 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics are registered in the default Prometheus registry, which the
+// plugin SDK serves to Grafana through the CollectMetrics call.
+const metricsNamespace = "ns1_pulsar_datasource"
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ns1_requests_total",
+		Help:      "Requests sent to the NS1 API, by endpoint, status code and result (success, retry or error).",
+	}, []string{"endpoint", "status", "result"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ns1_request_duration_seconds",
+		Help:      "Duration of the requests sent to the NS1 API, by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Lookups of the apps and query caches, by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	queriesInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queries_in_flight",
+		Help:      "Queries being processed.",
+	})
+
+	queryErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_errors_total",
+		Help:      "Failed queries, by error source and status code.",
+	}, []string{"source", "status"})
+
+	queriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queries_total",
+		Help:      "Queries run, by metric type. The queries listing the apps only have no metric type, the DNS QPS and monitoring ones have their own.",
+	}, []string{"metric_type"})
+
+	featureUsageTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "feature_usage_total",
+		Help:      "Uses of the optional features: streaming, chunking and decisions.",
+	}, []string{"feature"})
+
+	budgetThrottledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "budget_throttled_total",
+		Help:      "NS1 requests held back by the request budget of their user, by result (delayed or rejected).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, cacheRequestsTotal,
+		queriesInFlight, queryErrorsTotal, queriesTotal, featureUsageTotal, budgetThrottledTotal)
+}
+
+const (
+	cacheApps  = "apps"
+	cacheQuery = "query"
+)
+
+// The results of the NS1 requests. The retries are counted apart from the
+// first attempts, whatever their outcome, so the extra load they put on the
+// rate limit of the key shows up.
+const (
+	requestSuccess = "success"
+	requestRetry   = "retry"
+	requestError   = "error"
+)
+
+type retryContextKey struct{}
+
+// withRetry marks the requests sent with ctx as retries.
+func withRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryContextKey{}, true)
+}
+
+func isRetry(ctx context.Context) bool {
+	return ctx.Value(retryContextKey{}) != nil
+}
+
+func requestResult(req *http.Request, resp *http.Response, err error) string {
+	switch {
+	case isRetry(req.Context()):
+		return requestRetry
+	case err != nil || resp.StatusCode >= http.StatusBadRequest:
+		return requestError
+	default:
+		return requestSuccess
+	}
+}
+
+// The optional features whose use is counted.
+const (
+	featureStreaming = "streaming"
+	featureChunking  = "chunking"
+	featureDecisions = "decisions"
+	featureBatching  = "batching"
+)
+
+// recordQuery counts the query by metric type, leaving out the retries so
+// every query is counted once.
+// queryTypeLabels are the metric types counting the queries beyond the Pulsar
+// data.
+var queryTypeLabels = map[string]string{
+	queryTypeDNSQPS:            "dns_qps",
+	queryTypeMonitoringStatus:  "monitoring_status",
+	queryTypeMonitoringMetrics: "monitoring_metrics",
+	queryTypeNotifications:     "notifications",
+	queryTypeActivity:          "activity",
+	queryTypeJobChanges:        "job_changes",
+	queryTypeUsage:             "usage",
+	queryTypeDecisionAnswers:   "decision_answers",
+	queryTypeShedLoad:          "shed_load",
+	queryTypeDHCPScopes:        "dhcp_scopes",
+	queryTypeReport:            "report",
+	queryTypeAnnotation:        "annotation",
+	queryTypeOutages:           "outages",
+	queryTypeRouteMapChanges:   "route_map_changes",
+	queryTypeHealthScore:       "health_score",
+	queryTypeStatusBoard:       "status_board",
+}
+
+func recordQuery(ctx context.Context, qm *queryModel) {
+	if isRetry(ctx) {
+		return
+	}
+	metricType := qm.MetricType
+	if label, found := queryTypeLabels[qm.QueryType]; found {
+		metricType = label
+	} else if !qm.canQuery() {
+		metricType = "none"
+	}
+	queriesTotal.WithLabelValues(metricType).Inc()
+	if metricType == metricTypeDecisions || qm.QueryType == queryTypeDecisionAnswers {
+		recordFeatureUse(ctx, featureDecisions)
+	}
+}
+
+func recordFeatureUse(ctx context.Context, feature string) {
+	if !isRetry(ctx) {
+		featureUsageTotal.WithLabelValues(feature).Inc()
+	}
+}
+
+func recordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequestsTotal.WithLabelValues(cache, result).Inc()
+}
+
+func recordQueryError(queryErr *QueryError) {
+	queryErrorsTotal.WithLabelValues(string(queryErr.Source), strconv.Itoa(queryErr.Status)).Inc()
+}
+
+// metricsTransport measures the requests sent to the NS1 API.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	endpoint := endpointLabel(req.URL.Path)
+
+	resp, err := t.next.RoundTrip(req)
+
+	requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	requestsTotal.WithLabelValues(endpoint, status, requestResult(req, resp, err)).Inc()
+
+	return resp, err
+}
+
+// endpointLabel names the NS1 endpoint of the path, leaving out the IDs so
+// the number of label values stays bounded.
+func endpointLabel(path string) string {
+	// The zones and records aren't part of the label, they are unbounded.
+	if strings.Contains(path, "stats/qps") {
+		return "stats/qps"
+	}
+	if strings.Contains(path, "stats/usage") {
+		return "stats/usage"
+	}
+	i := strings.Index(path, "pulsar/")
+	if i < 0 {
+		return "other"
+	}
+
+	parts := strings.Split(strings.Trim(path[i:], "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[1] == "query":
+		return "pulsar/query/" + parts[2]
+	case len(parts) >= 4 && parts[1] == "apps" && parts[3] == "jobs":
+		return "pulsar/apps/jobs"
+	case len(parts) == 2 && parts[1] == "apps":
+		return "pulsar/apps"
+	default:
+		return "other"
+	}
+}
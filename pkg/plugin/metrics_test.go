@@ -0,0 +1,103 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEndpointLabel(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/v1/pulsar/apps", "pulsar/apps"},
+		{"/v1/pulsar/apps/app1/jobs", "pulsar/apps/jobs"},
+		{"/v1/pulsar/query/performance/time", "pulsar/query/performance"},
+		{"/pulsar/query/availability/time", "pulsar/query/availability"},
+		{"/v1/stats/qps/example.com/www.example.com/A", "stats/qps"},
+		{"/v1/stats/usage?period=24h&aggregate=true", "stats/usage"},
+		{"/v1/", "other"},
+	}
+
+	for _, tt := range tests {
+		if label := endpointLabel(tt.path); label != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.expected, label)
+		}
+	}
+}
+
+func TestRequestResults(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secondary.Close()
+
+	count := func(status, result string) float64 {
+		return testutil.ToFloat64(requestsTotal.WithLabelValues("pulsar/apps", status, result))
+	}
+	failures, retries, successes := count("503", requestError), count("200", requestRetry),
+		count("200", requestSuccess)
+
+	pc := NewPulsarClient(
+		OptionClientEndpoint(primary.URL+"/v1"),
+		OptionClientFallbackEndpoint(secondary.URL+"/v1"),
+	)
+	// The last failure of the primary is retried on the secondary, which then
+	// gets the next request.
+	for i := 0; i <= failoverThreshold; i++ {
+		resp, err := pc.httpClient.Get(pc.endpoint + "pulsar/apps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if n := count("503", requestError) - failures; n != failoverThreshold {
+		t.Errorf("expected %d errors, got %v", failoverThreshold, n)
+	}
+	if n := count("200", requestRetry) - retries; n != 1 {
+		t.Errorf("expected a retry, got %v", n)
+	}
+	if n := count("200", requestSuccess) - successes; n != 1 {
+		t.Errorf("expected a success, got %v", n)
+	}
+}
+
+func TestRecordQuery(t *testing.T) {
+	count := func(metricType string) float64 {
+		return testutil.ToFloat64(queriesTotal.WithLabelValues(metricType))
+	}
+	decisions := func() float64 {
+		return testutil.ToFloat64(featureUsageTotal.WithLabelValues(featureDecisions))
+	}
+	none, queried, used := count("none"), count(metricTypeDecisions), decisions()
+
+	ctx := context.Background()
+	qm := &queryModel{AppID: "app1", JobID: "job1", MetricType: metricTypeDecisions, Aggregation: "avg"}
+	recordQuery(ctx, qm)
+	// The retries of a query aren't counted again.
+	recordQuery(withRetry(ctx), qm)
+	recordQuery(ctx, &queryModel{MetricType: metricTypePerformance})
+
+	if n := count(metricTypeDecisions) - queried; n != 1 {
+		t.Errorf("expected a decisions query, got %v", n)
+	}
+	if n := decisions() - used; n != 1 {
+		t.Errorf("expected a use of the decisions, got %v", n)
+	}
+	if n := count("none") - none; n != 1 {
+		t.Errorf("expected a query without metric type, got %v", n)
+	}
+}
@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// offlineAPIKey is the API key of the mock and replay modes, which need
+	// none.
+	offlineAPIKey = "offline-api-key"
+	// mockStep is the interval of the synthetic data points, widened to keep
+	// up to mockMaxPoints per request.
+	mockStep      = time.Minute
+	mockMaxPoints = 1440
+)
+
+// mockApps are the synthetic apps and jobs served by the mock mode.
+var mockApps = []struct {
+	AppID    string            `json:"appid"`
+	Name     string            `json:"name"`
+	Active   bool              `json:"active"`
+	Category string            `json:"category"`
+	Tags     map[string]string `json:"tags"`
+	jobs     []mockJob
+}{
+	{AppID: "mockapp1", Name: "Demo CDN", Active: true, Category: "web", Tags: map[string]string{"env": "prod"},
+		jobs: []mockJob{
+			{JobID: "mockjob1", Name: "CDN A", TypeID: "latency"},
+			{JobID: "mockjob2", Name: "CDN B", TypeID: "latency"},
+			{JobID: "mockjob3", Name: "Community CDN", TypeID: "latency", Community: true},
+		}},
+	{AppID: "mockapp2", Name: "Demo API", Active: true, Category: "api", Tags: map[string]string{"env": "staging"},
+		jobs: []mockJob{
+			{JobID: "mockjob4", Name: "API East", TypeID: "latency"},
+			{JobID: "mockjob5", Name: "API West", TypeID: "latency"},
+		}},
+}
+
+type mockJob struct {
+	JobID     string `json:"jobid"`
+	Name      string `json:"name"`
+	TypeID    string `json:"typeid"`
+	Community bool   `json:"community"`
+}
+
+// mockAggregationFactors scale the synthetic latencies by aggregation, so the
+// percentiles keep their order.
+var mockAggregationFactors = map[string]float64{
+	"min": 0.6, "p50": 0.9, "avg": 1, "p75": 1.1, "p90": 1.3, "p95": 1.5, "p99": 2, "max": 2.5,
+}
+
+// mockTransport answers the NS1 requests with deterministic synthetic data,
+// the same request always getting the same response, so the demos, the end to
+// end tests and the dashboards work offline. Only the Pulsar apps, jobs and
+// data are served.
+type mockTransport struct{}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if i := strings.Index(path, "/pulsar/"); i >= 0 {
+		path = path[i+1:]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case req.Method != http.MethodGet:
+	case len(parts) == 2 && parts[0] == "pulsar" && parts[1] == "apps":
+		return mockResponse(req, http.StatusOK, mockApps), nil
+	case len(parts) == 4 && parts[0] == "pulsar" && parts[1] == "apps" && parts[3] == "jobs":
+		for _, app := range mockApps {
+			if app.AppID != parts[2] {
+				continue
+			}
+			jobs := make([]map[string]interface{}, 0, len(app.jobs))
+			for _, job := range app.jobs {
+				jobs = append(jobs, map[string]interface{}{
+					"jobid": job.JobID, "name": job.Name, "typeid": job.TypeID, "community": job.Community,
+					"appid": app.AppID, "active": true, "config": map[string]int{"request_timeout_millis": 5000},
+				})
+			}
+			return mockResponse(req, http.StatusOK, jobs), nil
+		}
+	case len(parts) == 4 && parts[0] == "pulsar" && parts[1] == "query" && parts[3] == "time":
+		if points, ok := mockPoints(parts[2], req.URL.Query()); ok {
+			return mockResponse(req, http.StatusOK, points), nil
+		}
+	}
+
+	return mockResponse(req, http.StatusNotFound, map[string]string{"message": "not available in mock mode"}), nil
+}
+
+func mockResponse(req *http.Request, status int, body interface{}) *http.Response {
+	raw, _ := json.Marshal(body)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(raw)),
+		ContentLength: int64(len(raw)),
+		Request:       req,
+	}
+}
+
+// mockNoise returns a number between 0 and 1 out of the seed and the time.
+func mockNoise(seed uint32, t int64) float64 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/%d", seed, t)
+	return float64(h.Sum32()) / math.MaxUint32
+}
+
+// mockPoints returns the synthetic points of the metric over the range of the
+// query, for every job of the query.
+func mockPoints(metric string, query map[string][]string) ([]map[string]float64, bool) {
+	get := func(name string) string {
+		if values := query[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	start, errStart := strconv.ParseInt(get("start"), 10, 64)
+	end, errEnd := strconv.ParseInt(get("end"), 10, 64)
+	if errStart != nil || errEnd != nil || end < start || get("jobs") == "" {
+		return nil, false
+	}
+	if metric != metricTypePerformance && metric != metricTypeAvailability && metric != metricTypeDecisions {
+		return nil, false
+	}
+
+	step := int64(mockStep.Seconds())
+	if points := (end - start) / step; points > mockMaxPoints {
+		step *= (points + mockMaxPoints - 1) / mockMaxPoints
+	}
+	factor, found := mockAggregationFactors[get("agg")]
+	if !found {
+		factor = 1
+	}
+
+	jobs := strings.Split(get("jobs"), ",")
+	seeds := make([]uint32, len(jobs))
+	for i, job := range jobs {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%s/%s/%s/%s", job, metric, get("area"), get("asn"))
+		seeds[i] = h.Sum32()
+	}
+
+	var points []map[string]float64
+	for t := (start + step - 1) / step * step; t <= end; t += step {
+		point := map[string]float64{"timestamp": float64(t)}
+		// A daily cycle, with its own phase and level per job.
+		for i, job := range jobs {
+			seed := seeds[i]
+			cycle := math.Sin(2*math.Pi*float64(t)/86400 + float64(seed%360)*math.Pi/180)
+			noise := mockNoise(seed, t)
+			var value float64
+			switch metric {
+			case metricTypePerformance:
+				value = (float64(40+seed%80)*(1+0.25*cycle) + 10*noise) * factor
+			case metricTypeAvailability:
+				value = 99.5 + 0.5*noise
+				// Every job has its outages, an hour long.
+				if mockNoise(seed, t/3600) < 0.05 {
+					value = 80 + 15*noise
+				}
+			default:
+				value = math.Round(float64(500+seed%1000) * (1 + 0.5*cycle) * (0.9 + 0.2*noise))
+			}
+			point[job] = math.Round(value*100) / 100
+		}
+		points = append(points, point)
+	}
+	return points, true
+}
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestMockMode(t *testing.T) {
+	instanceSettings := backend.DataSourceInstanceSettings{JSONData: []byte(`{"mock": true}`)}
+	instance, err := NewPulsarDatasource(instanceSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := instance.(*PulsarDatasource)
+	defer p.Dispose()
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &instanceSettings}
+
+	health, err := p.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pCtx})
+	if err != nil || health.Status != backend.HealthStatusOk {
+		t.Fatalf("expected the mock datasource to be healthy, got %+v (%v)", health, err)
+	}
+
+	to := time.Unix(1600000000, 0)
+	query := func() *backend.DataResponse {
+		resp, err := p.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: pCtx,
+			Queries: []backend.DataQuery{{
+				RefID:         "A",
+				JSON:          []byte(`{"appid": "mockapp1", "jobid": "mockjob2", "metricType": "performance", "agg": "p95"}`),
+				TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+				MaxDataPoints: 1000,
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := resp.Responses["A"]
+		return &res
+	}
+
+	first, second := query(), query()
+	if first.Error != nil {
+		t.Fatal(first.Error)
+	}
+	values := first.Frames[0].Fields[1]
+	if values.Len() != 60 || values.Labels["job"] != "CDN B" {
+		t.Fatalf("expected a point per minute of the mock job, got %d points of %v", values.Len(), values.Labels)
+	}
+	for i := 0; i < values.Len(); i++ {
+		if values.At(i) != second.Frames[0].Fields[1].At(i) {
+			t.Fatalf("expected the same data for the same query, got %v and %v at %d", values.At(i), second.Frames[0].Fields[1].At(i), i)
+		}
+	}
+
+	// Long ranges are served with fewer points.
+	points, ok := mockPoints(metricTypeAvailability, map[string][]string{
+		"start": {"1500000000"}, "end": {"1600000000"}, "jobs": {"mockjob1,mockjob4"},
+	})
+	if !ok || len(points) > mockMaxPoints || len(points[0]) != 3 {
+		t.Errorf("expected up to %d points of both jobs, got %d", mockMaxPoints, len(points))
+	}
+}
@@ -0,0 +1,307 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/monitor"
+)
+
+const (
+	// queryTypeMonitoringStatus is the type of the queries of the current
+	// status of the NS1 monitoring jobs, per region.
+	queryTypeMonitoringStatus = "monitoringStatus"
+	// queryTypeMonitoringMetrics is the type of the queries of the metrics of
+	// a NS1 monitoring job, such as its response time.
+	queryTypeMonitoringMetrics = "monitoringMetrics"
+	// queryTypeNotifications is the type of the queries of the recent NS1
+	// monitoring failures, the events NS1 notifies.
+	queryTypeNotifications = "notifications"
+	// notificationsLimit is the most status changes fetched at once.
+	notificationsLimit = 1000
+	// defaultMonitoringMetric is the metric of the monitoring jobs queried
+	// when none is set.
+	defaultMonitoringMetric = "rtt"
+)
+
+var (
+	errMonitoringJobNotFound  = errors.New("NS1 monitoring job not found")
+	errInvalidMonitoringQuery = newInvalidQueryError("invalid monitoring query, the metrics need the monitoring job")
+)
+
+// statsPeriod is a period over which NS1 serves statistics, ending now.
+type statsPeriod struct {
+	name     string
+	duration time.Duration
+}
+
+// monitoringPeriods are the periods of the metrics served by NS1, shortest
+// first.
+var monitoringPeriods = []statsPeriod{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// MonitoringStatus is the status of a monitoring job in a region, or its
+// global status when the region is "global".
+type MonitoringStatus struct {
+	JobID  string
+	Name   string
+	Type   string
+	Region string
+	Status string
+	Since  time.Time
+}
+
+// MonitoringFailure is a period during which a monitoring job failed in a
+// region.
+type MonitoringFailure struct {
+	JobID  string
+	Name   string
+	Region string
+	Status string
+	Since  time.Time
+	// Until is nil while the job is still failing.
+	Until *time.Time
+}
+
+// MonitoringSeries is a metric of a monitoring job in a region.
+type MonitoringSeries struct {
+	Region  string
+	Metric  string
+	Times   []time.Time
+	Values  []float64
+	Average float64
+}
+
+// GetMonitoringStatuses returns the statuses of the monitoring jobs, or of the
+// job when jobID is set, sorted by job name and region.
+func (pc *PulsarClient) GetMonitoringStatuses(ctx context.Context, apiKey, jobID string) (statuses []MonitoringStatus, err error) {
+	_, span := startSpan(ctx, "GetMonitoringStatuses", "job", jobID)
+	defer func() { span.end(err) }()
+
+	jobs, _, err := pc.getAPIClient(apiKey).Jobs.List()
+	if err != nil {
+		return nil, convertClientError(err, errMonitoringJobNotFound)
+	}
+
+	found := false
+	for _, job := range jobs {
+		if jobID != "" && job.ID != jobID {
+			continue
+		}
+		found = true
+		for region, status := range job.Status {
+			if status == nil {
+				continue
+			}
+			statuses = append(statuses, MonitoringStatus{
+				JobID:  job.ID,
+				Name:   job.Name,
+				Type:   job.Type,
+				Region: region,
+				Status: status.Status,
+				Since:  time.Unix(int64(status.Since), 0),
+			})
+		}
+	}
+	if jobID != "" && !found {
+		return nil, newAPIError(http.StatusNotFound, "", errMonitoringJobNotFound)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].Region < statuses[j].Region
+	})
+
+	return statuses, nil
+}
+
+// GetMonitoringFailures returns the failures of the monitoring jobs over the
+// time range of the query, the latest first.
+func (pc *PulsarClient) GetMonitoringFailures(ctx context.Context, apiKey string, qm *queryModel) (failures []MonitoringFailure, err error) {
+	ctx, span := startSpan(ctx, "GetMonitoringFailures", "job", qm.MonitoringJobID)
+	defer func() { span.end(err) }()
+
+	// The history only has the IDs of the jobs.
+	statuses, err := pc.GetMonitoringStatuses(ctx, apiKey, qm.MonitoringJobID)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(statuses))
+	for _, status := range statuses {
+		names[status.JobID] = status.Name
+	}
+
+	var history []monitor.StatusLog
+	path := fmt.Sprintf("monitoring/history?start=%d&end=%d&limit=%d", qm.From.Unix(), qm.To.Unix(), notificationsLimit)
+	if err = pc.getJSON(ctx, apiKey, path, errMonitoringJobNotFound, &history); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range history {
+		if entry.Status == "up" || (qm.MonitoringJobID != "" && entry.Job != qm.MonitoringJobID) {
+			continue
+		}
+		failure := MonitoringFailure{
+			JobID:  entry.Job,
+			Name:   names[entry.Job],
+			Region: entry.Region,
+			Status: entry.Status,
+			Since:  time.Unix(int64(entry.Since), 0),
+		}
+		if entry.Until > 0 {
+			until := time.Unix(int64(entry.Until), 0)
+			failure.Until = &until
+		}
+		failures = append(failures, failure)
+	}
+	sort.SliceStable(failures, func(i, j int) bool { return failures[i].Since.After(failures[j].Since) })
+
+	return failures, nil
+}
+
+// shortestPeriod returns the shortest of the periods, sorted shortest first,
+// covering the time range up to now.
+func shortestPeriod(periods []statsPeriod, from time.Time, now time.Time) string {
+	for _, period := range periods {
+		if now.Sub(from) <= period.duration {
+			return period.name
+		}
+	}
+	return periods[len(periods)-1].name
+}
+
+// GetMonitoringMetrics returns the metric of the monitoring job in each of
+// its regions, over the time range of the query. NS1 serves the metrics over
+// fixed periods ending now, the points outside of the range are dropped.
+func (pc *PulsarClient) GetMonitoringMetrics(ctx context.Context, apiKey string, qm *queryModel) (series []MonitoringSeries, err error) {
+	metric := qm.MonitoringMetric
+	if metric == "" {
+		metric = defaultMonitoringMetric
+	}
+	ctx, span := startSpan(ctx, "GetMonitoringMetrics", "job", qm.MonitoringJobID, "metric", metric)
+	defer func() { span.end(err) }()
+
+	// region -> metric -> values
+	body := map[string]map[string]struct {
+		Avg   float64       `json:"avg"`
+		Graph [][2]*float64 `json:"graph"`
+	}{}
+	path := fmt.Sprintf("monitoring/metrics/%s?period=%s", url.PathEscape(qm.MonitoringJobID), shortestPeriod(monitoringPeriods, qm.From, time.Now()))
+	if err = pc.getJSON(ctx, apiKey, path, errMonitoringJobNotFound, &body); err != nil {
+		return nil, err
+	}
+
+	for region, metrics := range body {
+		values, found := metrics[metric]
+		if !found {
+			continue
+		}
+		s := MonitoringSeries{Region: region, Metric: metric, Average: values.Avg}
+		for _, point := range values.Graph {
+			if point[0] == nil || point[1] == nil {
+				continue
+			}
+			t := time.Unix(int64(*point[0]), 0)
+			if t.Before(qm.From) || t.After(qm.To) {
+				continue
+			}
+			s.Times = append(s.Times, t)
+			s.Values = append(s.Values, *point[1])
+		}
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Region < series[j].Region })
+
+	return series, nil
+}
+
+// queryMonitoringStatus answers the monitoring status queries with a table of
+// the statuses of the jobs per region.
+func (p *PulsarDatasource) queryMonitoringStatus(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	statuses, err := p.pulsarAPI().GetMonitoringStatuses(ctx, apiKey, qm.MonitoringJobID)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	frame := data.NewFrame("monitoring",
+		data.NewField("job", nil, []string{}),
+		data.NewField("name", nil, []string{}),
+		data.NewField("type", nil, []string{}),
+		data.NewField("region", nil, []string{}),
+		data.NewField("status", nil, []string{}),
+		data.NewField("since", nil, []time.Time{}),
+	)
+	for _, status := range statuses {
+		frame.AppendRow(status.JobID, status.Name, status.Type, status.Region, status.Status, status.Since)
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// queryNotifications answers the notifications queries with a table of the
+// failures of the monitoring jobs, the latest first.
+func (p *PulsarDatasource) queryNotifications(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	failures, err := p.pulsarAPI().GetMonitoringFailures(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	frame := data.NewFrame("notifications",
+		data.NewField("time", nil, []time.Time{}),
+		data.NewField("until", nil, []*time.Time{}),
+		data.NewField("job", nil, []string{}),
+		data.NewField("region", nil, []string{}),
+		data.NewField("status", nil, []string{}),
+		data.NewField("text", nil, []string{}),
+	)
+	for _, f := range failures {
+		name := f.Name
+		if name == "" {
+			name = f.JobID
+		}
+		text := fmt.Sprintf("monitoring job %s %s in %s", name, f.Status, f.Region)
+		frame.AppendRow(f.Since, f.Until, name, f.Region, f.Status, text)
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// queryMonitoringMetrics answers the monitoring metrics queries with a time
+// series per region of the job.
+func (p *PulsarDatasource) queryMonitoringMetrics(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if qm.MonitoringJobID == "" {
+		return backend.DataResponse{Error: errInvalidMonitoringQuery}
+	}
+
+	series, err := p.pulsarAPI().GetMonitoringMetrics(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	res := backend.DataResponse{}
+	for _, s := range series {
+		name := s.Region + " " + s.Metric
+		if qm.Alias != "" {
+			name = qm.Alias + " " + s.Region
+		}
+		res.Frames = append(res.Frames, data.NewFrame(name,
+			data.NewField("time", nil, s.Times),
+			data.NewField(name, nil, s.Values),
+		))
+	}
+	return res
+}
@@ -0,0 +1,125 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryMonitoring(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/monitoring/jobs":
+			fmt.Fprint(w, `[
+				{"id": "j2", "name": "web", "job_type": "http", "status": {"global": {"since": 1600000000, "status": "up"}, "lga": {"since": 1600000000, "status": "down"}}},
+				{"id": "j1", "name": "dns", "job_type": "dns", "status": {"global": {"since": 1600000000, "status": "up"}}}
+			]`)
+		case "/monitoring/metrics/j1":
+			query = r.URL.RawQuery
+			fmt.Fprintf(w, `{"sjc": {"rtt": {"avg": 12, "graph": [[%d, 10], [%d, 14]]}, "loss": {"avg": 0, "graph": []}}, "lga": {"rtt": {"avg": 20, "graph": [[%d, 20]]}}}`,
+				now.Add(-2*time.Hour).Unix(), now.Add(-time.Minute).Unix(), now.Add(-time.Minute).Unix())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "job not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	ctx := context.Background()
+
+	res := p.queryMonitoringStatus(ctx, "key", &queryModel{})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 3 {
+		t.Fatalf("expected a row per job and region, got %d", rows)
+	}
+	if frame.Fields[1].At(0) != "dns" || frame.Fields[3].At(1) != "global" || frame.Fields[4].At(2) != "down" {
+		t.Errorf("expected the rows sorted by job and region, got %v", frame.Fields)
+	}
+
+	res = p.queryMonitoringStatus(ctx, "key", &queryModel{MonitoringJobID: "missing"})
+	if !errors.Is(res.Error, errMonitoringJobNotFound) {
+		t.Errorf("expected errMonitoringJobNotFound, got %v", res.Error)
+	}
+
+	qm := &queryModel{MonitoringJobID: "j1", From: now.Add(-30 * time.Minute), To: now}
+	res = p.queryMonitoringMetrics(ctx, "key", qm)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if query != "period=1h" {
+		t.Errorf("expected the shortest period covering the range, got %s", query)
+	}
+	if len(res.Frames) != 2 || res.Frames[0].Name != "lga rtt" || res.Frames[1].Name != "sjc rtt" {
+		t.Fatalf("expected a frame per region, got %v", res.Frames)
+	}
+	if values := res.Frames[1].Fields[1]; values.Len() != 1 || values.At(0).(float64) != 14 {
+		t.Errorf("expected the points outside of the range to be dropped, got %v", values)
+	}
+
+	res = p.queryMonitoringMetrics(ctx, "key", &queryModel{})
+	if !errors.Is(res.Error, errInvalidMonitoringQuery) {
+		t.Errorf("expected errInvalidMonitoringQuery, got %v", res.Error)
+	}
+}
+
+func TestShortestPeriod(t *testing.T) {
+	now := time.Now()
+	tests := map[time.Duration]string{
+		30 * time.Minute:     "1h",
+		3 * time.Hour:        "24h",
+		48 * time.Hour:       "7d",
+		20 * 24 * time.Hour:  "30d",
+		365 * 24 * time.Hour: "30d",
+	}
+	for ago, period := range tests {
+		if got := shortestPeriod(monitoringPeriods, now.Add(-ago), now); got != period {
+			t.Errorf("expected %s for %s, got %s", period, ago, got)
+		}
+	}
+}
+
+func TestQueryNotifications(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/monitoring/jobs":
+			fmt.Fprint(w, `[{"id": "j1", "name": "web", "status": {"global": {"since": 1600000000, "status": "up"}}}]`)
+		case "/monitoring/history":
+			fmt.Fprint(w, `[
+				{"job": "j1", "region": "lga", "status": "down", "since": 1600000000, "until": 1600000300},
+				{"job": "j1", "region": "lga", "status": "up", "since": 1600000300},
+				{"job": "j1", "region": "sjc", "status": "down", "since": 1600000600}
+			]`)
+		}
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	res := p.queryNotifications(context.Background(), "key", &queryModel{From: time.Unix(1599990000, 0), To: time.Unix(1600010000, 0)})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 2 {
+		t.Fatalf("expected the failures only, got %d rows", rows)
+	}
+	if frame.Fields[3].At(0) != "sjc" || frame.Fields[1].At(0).(*time.Time) != nil {
+		t.Errorf("expected the ongoing failure first, got %v", frame.Fields)
+	}
+	if text := frame.Fields[5].At(1); text != "monitoring job web down in lga" {
+		t.Errorf("unexpected notification text %v", text)
+	}
+}
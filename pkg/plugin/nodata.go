@@ -0,0 +1,73 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// The no data policies of the queries, telling what an empty NS1 response
+// produces.
+const (
+	// noDataError fails the query with errNoDataFound, the default.
+	noDataError = "error"
+	// noDataEmpty returns the series without any point.
+	noDataEmpty = "empty"
+	// noDataZero returns a series of zeros over the range.
+	noDataZero = "zero"
+)
+
+var errInvalidNoDataPolicy = newInvalidQueryError("invalid no data policy, expected error, empty or zero")
+
+// checkNoDataPolicy rejects the unknown no data policies.
+func checkNoDataPolicy(qm *queryModel) error {
+	switch qm.NoData {
+	case "", noDataError, noDataEmpty, noDataZero:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errInvalidNoDataPolicy, qm.NoData)
+	}
+}
+
+// noDataMaxPoints bounds the zeros of a series, whatever the maximum number of
+// points of the panel.
+const noDataMaxPoints = 10000
+
+// noDataSeries returns the series replacing an empty NS1 response, as set by
+// the no data policy of the query, or false when the query must fail. The
+// zeros are spaced by the interval of the query, up to maxDataPoints, the
+// maximum number of points of the panel before the transforms lift it, and
+// noDataMaxPoints, or placed at both ends of the range when the interval is
+// unknown.
+func noDataSeries(qm *queryModel, maxDataPoints int64) ([]time.Time, []float64, bool) {
+	switch qm.NoData {
+	case noDataEmpty:
+		return []time.Time{}, []float64{}, true
+	case noDataZero:
+		points := maxDataPoints
+		if points <= 0 || points > noDataMaxPoints {
+			points = noDataMaxPoints
+		}
+		if points < 2 {
+			// Both ends of the range at least.
+			points = 2
+		}
+		step := qm.Interval
+		if step > 0 && qm.To.Sub(qm.From)/step >= time.Duration(points) {
+			step = qm.To.Sub(qm.From) / time.Duration(points-1)
+		}
+		if step <= 0 {
+			return []time.Time{qm.From, qm.To}, []float64{0, 0}, true
+		}
+		var times []time.Time
+		for t := qm.From; !t.After(qm.To); t = t.Add(step) {
+			times = append(times, t)
+		}
+		return times, make([]float64, len(times)), true
+	default:
+		return nil, nil, false
+	}
+}
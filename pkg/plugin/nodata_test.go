@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestNoDataSeries(t *testing.T) {
+	from := time.Unix(1600000000, 0)
+	tests := []struct {
+		qm            queryModel
+		maxDataPoints int64
+		points        int
+		ok            bool
+	}{
+		{queryModel{}, 0, 0, false},
+		{queryModel{NoData: noDataError}, 0, 0, false},
+		{queryModel{NoData: noDataEmpty}, 0, 0, true},
+		{queryModel{NoData: noDataZero, From: from, To: from.Add(time.Hour)}, 0, 2, true},
+		{queryModel{NoData: noDataZero, From: from, To: from.Add(time.Hour), Interval: time.Minute}, 1000, 61, true},
+		{queryModel{NoData: noDataZero, From: from, To: from.Add(time.Hour), Interval: time.Second}, 100, 100, true},
+		{queryModel{NoData: noDataZero, From: from, To: from.Add(time.Hour), Interval: time.Second}, 1, 2, true},
+		{queryModel{NoData: noDataZero, From: from, To: from.Add(time.Hour), Interval: time.Millisecond}, 0, noDataMaxPoints, true},
+		{queryModel{NoData: noDataZero, From: from, To: from.Add(time.Hour), Interval: time.Millisecond}, math.MaxInt64, noDataMaxPoints, true},
+	}
+
+	for _, tt := range tests {
+		times, values, ok := noDataSeries(&tt.qm, tt.maxDataPoints)
+		if ok != tt.ok || len(times) != tt.points || len(values) != tt.points {
+			t.Errorf("%+v: expected %d points (%v), got %d (%v)", tt.qm, tt.points, tt.ok, len(times), ok)
+		}
+	}
+}
+
+func TestQueryNoData(t *testing.T) {
+	server := testutil.NewServer()
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	query := func(policy string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID: "A",
+			JSON: []byte(fmt.Sprintf(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "noData": %q}`,
+				policy)),
+			TimeRange:     backend.TimeRange{From: time.Unix(1600000000, 0), To: time.Unix(1600003600, 0)},
+			Interval:      time.Minute,
+			MaxDataPoints: 1000,
+		})
+	}
+
+	if res := query(""); !errors.Is(res.Error, errNoDataFound) {
+		t.Errorf("expected errNoDataFound by default, got %v", res.Error)
+	}
+	if res := query(noDataEmpty); res.Error != nil || res.Frames[0].Fields[1].Len() != 0 {
+		t.Errorf("expected an empty series, got %v", res.Error)
+	}
+	if res := query(noDataZero); res.Error != nil || res.Frames[0].Fields[1].Len() != 61 {
+		t.Errorf("expected a point of zero per minute, got %v", res.Error)
+	}
+	if res := query("null"); !errors.Is(res.Error, errInvalidNoDataPolicy) {
+		t.Errorf("expected errInvalidNoDataPolicy, got %v", res.Error)
+	}
+
+	// The running total lifts the maximum number of points of the query,
+	// not the one of the zeros.
+	p.settings.EnableDecisions = true
+	res := p.queryWithKey(context.Background(), "key", backend.DataQuery{
+		RefID: "A",
+		JSON: []byte(`{"appid": "app1", "jobid": "job1", "metricType": "decisions", "agg": "sum",
+			"noData": "zero", "cumulative": true}`),
+		TimeRange:     backend.TimeRange{From: time.Unix(1600000000, 0), To: time.Unix(1600003600, 0)},
+		Interval:      time.Millisecond,
+		MaxDataPoints: 100,
+	})
+	if res.Error != nil || res.Frames[0].Fields[1].Len() != 100 {
+		t.Errorf("expected the zeros bounded by the points of the panel, got %v", res.Error)
+	}
+}
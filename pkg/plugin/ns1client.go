@@ -5,42 +5,88 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
 	"gopkg.in/ns1/ns1-go.v2/rest/model/pulsar"
 )
 
 const (
 	timeout = time.Second * 15
+	// defaultEndpoint is the public NS1 API endpoint.
+	defaultEndpoint = "https://api.nsone.net/v1/"
 	// APIKey is the key to get the NS1 API Key from the decrypted secure data.
 	APIKey                 = "apiKey"
 	metricTypePerformance  = "performance"
 	metricTypeAvailability = "availability"
 	metricTypeDecisions    = "decisions"
 	appsDefaultTTL         = 600 * time.Second
+	// keepAliveInterval must stay below the idle timeout of the transport
+	// (90 seconds by default) to keep the connection open.
+	keepAliveInterval = 60 * time.Second
 )
 
 var (
 	errAuthorizationDenied = errors.New("invalid API key")
 	errDataRetrieval       = errors.New("error retrieving data, make sure start " +
 		"and and end times don't overlap and the time span it's no longer than 30 days")
-	errNoDataFound = errors.New("no data found")
+	errNoDataFound     = errors.New("no data found")
+	errDataKeyRejected = errors.New("data API key rejected, check the key and its permission to read the Pulsar data")
+)
 
-	httpClient = &http.Client{Timeout: timeout}
+// The operations run by the datasource, each needing a permission of the API
+// key.
+const (
+	operationListApps     = "list the Pulsar apps"
+	operationListJobs     = "list the Pulsar jobs"
+	operationReadData     = "read the Pulsar data"
+	operationReadActivity = "read the account activity"
+)
+
+// The types of the Pulsar jobs.
+const (
+	// jobTypeCommunity is the type of the HTTP jobs shared by the NS1
+	// community.
+	jobTypeCommunity = "community"
+	// jobTypeHTTP is the type of the HTTP jobs of the account.
+	jobTypeHTTP = "http"
+	// jobTypeCustom is the type of the bring your own data jobs, whose values
+	// aren't necessarily latencies in milliseconds.
+	jobTypeCustom = "custom"
 )
 
 // Job is a basic model to put info usable by the frontend.
 type Job struct {
 	JobID string `json:"jobid"`
 	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	// RequestTimeout is the time, in milliseconds, after which NS1 counts a
+	// measurement of the job as failed.
+	RequestTimeout int `json:"requestTimeoutMillis,omitempty"`
+}
+
+// jobType returns the type of the Pulsar job.
+func jobType(pjob *pulsar.PulsarJob) string {
+	switch {
+	case pjob.Community:
+		return jobTypeCommunity
+	case pjob.TypeID == jobTypeCustom:
+		return jobTypeCustom
+	default:
+		return jobTypeHTTP
+	}
 }
 
 // App is a basic model to exchange information with the frontend.
@@ -48,6 +94,18 @@ type App struct {
 	AppID string `json:"appid"`
 	Name  string `json:"name,omitempty"`
 	Jobs  []Job  `json:"jobs"`
+	// Category and Tags are the metadata maintained in NS1 to group the
+	// apps, e.g. by business unit or environment.
+	Category string            `json:"category,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// pulsarApp is a Pulsar app as listed by the NS1 API, along with the metadata
+// the ns1-go model leaves out.
+type pulsarApp struct {
+	pulsar.Application
+	Category string            `json:"category"`
+	Tags     map[string]string `json:"tags"`
 }
 
 // GetAppsResponse holds the App and Job info in two formats: A slice to be
@@ -58,6 +116,29 @@ type GetAppsResponse struct {
 	JobsMap map[string]Job
 }
 
+// filter returns a copy of the response holding only the apps, and their
+// jobs, for which allowed returns true.
+func (r *GetAppsResponse) filter(allowed func(appID string) bool) *GetAppsResponse {
+	filtered := &GetAppsResponse{
+		Apps:    make([]App, 0, len(r.Apps)),
+		AppsMap: make(map[string]App),
+		JobsMap: make(map[string]Job),
+	}
+
+	for _, app := range r.Apps {
+		if !allowed(app.AppID) {
+			continue
+		}
+		filtered.Apps = append(filtered.Apps, app)
+		filtered.AppsMap[app.AppID] = app
+		for _, job := range app.Jobs {
+			filtered.JobsMap[job.JobID] = job
+		}
+	}
+
+	return filtered
+}
+
 // PulsarAppParameters are all the options available to retrieve Apps and Jobs.
 // The options are dynamically provided.
 type PulsarAppParameters struct {
@@ -69,8 +150,8 @@ type PulsarAppParameters struct {
 type PulsarAppParameter func(p *PulsarAppParameters)
 
 // PulsarData is the data struct for caching Apps and Jobs.
-// Given that the plugin instance can use only one API Key, these values will be
-// the same for any user of the plugin.
+// The apps depend on the NS1 account, so PulsarClient keeps one PulsarData
+// per API key.
 // The ttl field it's expressed in seconds.
 type PulsarData struct {
 	applications *GetAppsResponse
@@ -116,7 +197,172 @@ func NewPulsarData(appsResponse *GetAppsResponse, ttl time.Duration) *PulsarData
 type PulsarClient struct {
 	apiClientCache map[string]*ns1api.Client
 	apiClientLock  sync.RWMutex
-	data           *PulsarData
+	data           map[string]*PulsarData
+	dataLock       sync.RWMutex
+	httpClient     *http.Client
+	done           chan struct{}
+	closeOnce      sync.Once
+
+	debug            bool
+	logger           log.Logger
+	redactor         *redactor
+	endpoint         string
+	fallbackEndpoint string
+	authHeader       string
+	authScheme       string
+	identityHeader   string
+	failover         *failoverTransport
+	timeout          time.Duration
+	appsTTL          time.Duration
+	queryCache       *queryCache
+	chunkSize        time.Duration
+	transport        http.RoundTripper
+	fixtureMode      string
+	fixtureDir       string
+	fixtureKey       string
+	maxResponseSize  int64
+}
+
+// PulsarClientOption configures the PulsarClient on creation.
+type PulsarClientOption func(pc *PulsarClient)
+
+// OptionClientDebug logs every request made to the NS1 API, with its status
+// code and timing. The API key is redacted.
+func OptionClientDebug(debug bool) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.debug = debug
+	}
+}
+
+// OptionClientLogger sets the logger of the client.
+func OptionClientLogger(logger log.Logger) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.logger = logger
+	}
+}
+
+// OptionClientRedactor sets the redactor learning the API keys used by the
+// client, so they never show up in the logs and errors.
+func OptionClientRedactor(r *redactor) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.redactor = r
+	}
+}
+
+// OptionClientTransport sends the NS1 requests through the transport instead
+// of the default one, nil keeping the default.
+func OptionClientTransport(transport http.RoundTripper) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.transport = transport
+	}
+}
+
+// OptionClientFixtures records the NS1 responses to the directory, or
+// replays them instead of calling NS1, as set by the mode: record or replay.
+// The fixtures are encrypted with the key.
+func OptionClientFixtures(mode, dir, key string) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.fixtureMode = mode
+		pc.fixtureDir = dir
+		pc.fixtureKey = key
+	}
+}
+
+// OptionClientEndpoint sets the NS1 API endpoint. An empty endpoint keeps the
+// default one.
+func OptionClientEndpoint(endpoint string) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		if endpoint != "" {
+			pc.endpoint = withTrailingSlash(endpoint)
+		}
+	}
+}
+
+// OptionClientFallbackEndpoint sets the NS1 API endpoint used when the primary
+// one fails repeatedly. Failover is disabled when the endpoint is empty.
+func OptionClientFallbackEndpoint(endpoint string) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		if endpoint != "" {
+			pc.fallbackEndpoint = withTrailingSlash(endpoint)
+		}
+	}
+}
+
+// OptionClientAuth sends the API key in header, prefixed with scheme (e.g.
+// "Bearer"), for the private NS1 installations not using the X-NSONE-Key
+// header. Empty values keep the NS1 API authentication.
+func OptionClientAuth(header, scheme string) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.authHeader = header
+		pc.authScheme = scheme
+	}
+}
+
+// OptionClientIdentityHeader sends the login of the Grafana user running the
+// query in header (e.g. "X-On-Behalf-Of") with the data requests, so the NS1
+// account owners can tell who uses the shared key. Disabled when empty.
+func OptionClientIdentityHeader(header string) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.identityHeader = header
+	}
+}
+
+// withTrailingSlash makes sure the paths resolve relative to the whole
+// endpoint, including its version path (e.g. /v1/).
+func withTrailingSlash(endpoint string) string {
+	return strings.TrimSuffix(endpoint, "/") + "/"
+}
+
+// OptionClientTimeout sets the timeout of every request to the NS1 API.
+func OptionClientTimeout(timeout time.Duration) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		if timeout > 0 {
+			pc.timeout = timeout
+		}
+	}
+}
+
+// OptionClientAppsTTL sets how long the apps and jobs are cached.
+func OptionClientAppsTTL(ttl time.Duration) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		if ttl > 0 {
+			pc.appsTTL = ttl
+		}
+	}
+}
+
+// OptionClientCacheTTL caches the data points of the queries for ttl, so the
+// same query repeated by several viewers hits the NS1 API only once. The
+// cache is disabled when ttl is zero.
+func OptionClientCacheTTL(ttl time.Duration) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.queryCache = newQueryCache(ttl)
+	}
+}
+
+// OptionClientChunkSize splits the queries longer than chunkSize into several
+// requests, each one spanning chunkSize at most. Disabled when zero.
+func OptionClientChunkSize(chunkSize time.Duration) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.chunkSize = chunkSize
+	}
+}
+
+// OptionClientMaxResponseSize sets the largest response of the NS1 API read,
+// in bytes. The larger responses fail. No limit when zero.
+func OptionClientMaxResponseSize(maxResponseSize int64) PulsarClientOption {
+	return func(pc *PulsarClient) {
+		pc.maxResponseSize = maxResponseSize
+	}
+}
+
+// FailoverStatus reports whether the requests are currently sent to the
+// fallback endpoint, and since when.
+func (pc *PulsarClient) FailoverStatus() (bool, time.Time) {
+	if pc.failover == nil {
+		return false, time.Time{}
+	}
+	return pc.failover.status()
 }
 
 // getAPIClient maintains a local cache of the NS1 api clients for each API key
@@ -127,9 +373,11 @@ func (pc *PulsarClient) getAPIClient(apiKey string) *ns1api.Client {
 
 	client, exists := pc.apiClientCache[apiKey]
 	if !exists {
+		pc.redactor.add(apiKey)
 		client = ns1api.NewClient(
-			&http.Client{Timeout: timeout},
+			pc.httpClient,
 			ns1api.SetAPIKey(apiKey),
+			ns1api.SetEndpoint(pc.endpoint),
 		)
 		pc.apiClientCache[apiKey] = client
 	}
@@ -137,29 +385,28 @@ func (pc *PulsarClient) getAPIClient(apiKey string) *ns1api.Client {
 	return client
 }
 
-// CheckAPIKey verifies the provided API key against the NS1 API. It returns
-// error if the key is invalid, meaning that the authorization was denied.
+// CheckAPIKey verifies the provided API key against the NS1 API, listing the
+// Pulsar apps (GET pulsar/apps): the cheapest request, which only needs the
+// permission to view the Pulsar apps. It returns an error wrapping
+// errAuthorizationDenied when the key is invalid (401), and a permissionError
+// when the key lacks the permission (403).
 func (pc *PulsarClient) CheckAPIKey(apiKey string) error {
-	var response *http.Response
-
-	client := ns1api.NewClient(httpClient, ns1api.SetAPIKey(apiKey))
-
-	// This will return a 400 error,but we just need to know if the API key
-	// is correct.
-	_, response, _ = client.PulsarJobs.List("*")
-	if response != nil {
-		if response.StatusCode == http.StatusUnauthorized ||
-			response.StatusCode == http.StatusForbidden {
-			return errAuthorizationDenied
-		}
+	if _, err := pc.ping(apiKey); err != nil {
+		return asPermissionError(err, operationListApps)
 	}
+	return nil
+}
 
-	// Update the client as the api key may have changed
-	pc.apiClientLock.Lock()
-	defer pc.apiClientLock.Unlock()
-	pc.apiClientCache[apiKey] = client
+func (pc *PulsarClient) getData(apiKey string) *PulsarData {
+	pc.dataLock.RLock()
+	defer pc.dataLock.RUnlock()
+	return pc.data[apiKey]
+}
 
-	return nil
+func (pc *PulsarClient) setData(apiKey string, data *PulsarData) {
+	pc.dataLock.Lock()
+	defer pc.dataLock.Unlock()
+	pc.data[apiKey] = data
 }
 
 // OptionAppFetchJobs indicates the GetApp function to retrieve the Job list for
@@ -180,15 +427,19 @@ func PulsarAppFetchInactive(fetchInactive bool) PulsarAppParameter {
 
 // GetApps query the NS1 API and retrieves the Pulsar Apps and optionally their
 // Pulsar Jobs.
-func (pc *PulsarClient) GetApps(apiKey string, params ...PulsarAppParameter) (*GetAppsResponse, error) {
-	var (
-		pulsarApps []*pulsar.Application
-		err        error
-	)
+func (pc *PulsarClient) GetApps(ctx context.Context, apiKey string, params ...PulsarAppParameter) (appsResponse *GetAppsResponse, err error) {
+	var pulsarApps []pulsarApp
+
+	ctx, span := startSpan(ctx, "GetApps")
+	defer func() { span.end(err) }()
 
-	if pc.data != nil && !pc.data.isExpired() {
-		return pc.data.getAppsResponse(), nil
+	if data := pc.getData(apiKey); data != nil && !data.isExpired() {
+		recordCacheLookup(cacheApps, true)
+		span.setAttributes("cache", "hit")
+		return data.getAppsResponse(), nil
 	}
+	recordCacheLookup(cacheApps, false)
+	span.setAttributes("cache", "miss")
 
 	parameters := &PulsarAppParameters{
 		FetchInactiveApps: false,
@@ -198,44 +449,46 @@ func (pc *PulsarClient) GetApps(apiKey string, params ...PulsarAppParameter) (*G
 		param(parameters)
 	}
 
-	apiClient := pc.getAPIClient(apiKey)
-
-	pulsarApps, _, err = apiClient.Applications.List()
-	if err != nil {
-		return nil, err
+	// The apps are listed directly, the ns1-go model dropping their metadata.
+	if err = pc.getJSON(ctx, apiKey, "pulsar/apps", errAppNotFound, &pulsarApps); err != nil {
+		return nil, asPermissionError(err, operationListApps)
 	}
 
-	appsResponse := &GetAppsResponse{
-		Apps:    make([]App, len(pulsarApps)),
+	appsResponse = &GetAppsResponse{
+		Apps:    make([]App, 0, len(pulsarApps)),
 		AppsMap: make(map[string]App),
 		JobsMap: make(map[string]Job),
 	}
 
-	for i, pulsarApp := range pulsarApps {
+	for _, pulsarApp := range pulsarApps {
 		if !pulsarApp.Active && !parameters.FetchInactiveApps {
 			// skip inactive apps
 			continue
 		}
-		appsResponse.Apps[i] = App{
-			AppID: pulsarApp.ID,
-			Name:  pulsarApp.Name,
-			Jobs:  []Job{},
+		app := App{
+			AppID:    pulsarApp.ID,
+			Name:     pulsarApp.Name,
+			Jobs:     []Job{},
+			Category: pulsarApp.Category,
+			Tags:     pulsarApp.Tags,
 		}
-		appsResponse.AppsMap[pulsarApp.ID] = appsResponse.Apps[i]
 
 		if parameters.FetchJobs {
-			appsResponse.Apps[i].Jobs, err = pc.GetJobs(apiKey, pulsarApp.ID, params...)
+			app.Jobs, err = pc.GetJobs(ctx, apiKey, pulsarApp.ID, params...)
 			if err != nil {
 				return nil, err
 			}
-			for _, j := range appsResponse.Apps[i].Jobs {
+			for _, j := range app.Jobs {
 				appsResponse.JobsMap[j.JobID] = j
 			}
 		}
+
+		appsResponse.Apps = append(appsResponse.Apps, app)
+		appsResponse.AppsMap[pulsarApp.ID] = app
 	}
 
 	// replace current data
-	pc.data = NewPulsarData(appsResponse, appsDefaultTTL)
+	pc.setData(apiKey, NewPulsarData(appsResponse, pc.appsTTL))
 
 	return appsResponse, nil
 }
@@ -249,17 +502,16 @@ func OptionJobsFetchInactive(fetchInactive bool) PulsarAppParameter {
 }
 
 // GetJobs retrieves a Job slice given the appID.
-func (pc *PulsarClient) GetJobs(apiKey, appID string, params ...PulsarAppParameter) ([]Job, error) {
-	var (
-		jobs  []Job
-		err   error
-		pjobs []*pulsar.PulsarJob
-	)
+func (pc *PulsarClient) GetJobs(ctx context.Context, apiKey, appID string, params ...PulsarAppParameter) (jobs []Job, err error) {
+	var pjobs []*pulsar.PulsarJob
+
+	_, span := startSpan(ctx, "GetJobs", "app", appID)
+	defer func() { span.end(err) }()
 
 	apiClient := pc.getAPIClient(apiKey)
 	pjobs, _, err = apiClient.PulsarJobs.List(appID)
 	if err != nil {
-		return nil, err
+		return nil, asPermissionError(convertClientError(err, errAppNotFound), operationListJobs)
 	}
 
 	parameters := PulsarAppParameters{}
@@ -267,16 +519,22 @@ func (pc *PulsarClient) GetJobs(apiKey, appID string, params ...PulsarAppParamet
 		param(&parameters)
 	}
 
-	jobs = make([]Job, len(pjobs))
-	for i, pjob := range pjobs {
-		if parameters.FetchInactiveJobs {
+	jobs = make([]Job, 0, len(pjobs))
+	for _, pjob := range pjobs {
+		if !pjob.Active && !parameters.FetchInactiveJobs {
+			// skip inactive jobs
 			continue
 		}
 
-		jobs[i] = Job{
+		job := Job{
 			JobID: pjob.JobID,
 			Name:  pjob.Name,
+			Type:  jobType(pjob),
 		}
+		if pjob.Config != nil && pjob.Config.RequestTimeoutMillis != nil {
+			job.RequestTimeout = *pjob.Config.RequestTimeoutMillis
+		}
+		jobs = append(jobs, job)
 	}
 
 	return jobs, nil
@@ -285,9 +543,12 @@ func (pc *PulsarClient) GetJobs(apiKey, appID string, params ...PulsarAppParamet
 func (pc *PulsarClient) buildURL(endpoint string, qm *queryModel) (*url.URL, error) {
 	var urlStr string
 
-	if qm.MetricType == metricTypePerformance {
+	switch qm.MetricType {
+	case metricTypePerformance:
 		urlStr = fmt.Sprintf("%spulsar/query/performance/time", endpoint)
-	} else {
+	case metricTypeDecisions:
+		urlStr = fmt.Sprintf("%spulsar/query/decisions/time", endpoint)
+	default:
 		urlStr = fmt.Sprintf("%spulsar/query/availability/time", endpoint)
 	}
 
@@ -315,47 +576,35 @@ func (pc *PulsarClient) buildURL(endpoint string, qm *queryModel) (*url.URL, err
 //  - A slice of times. This is passed to the Frame.
 //  - A slice of values. This is passed to the Frame.
 //  - An error if something goes wrong.
-func (pc *PulsarClient) GetData(apiKey string, query *queryModel) ([]time.Time, []float64, error) {
+func (pc *PulsarClient) GetData(ctx context.Context, apiKey string, query *queryModel) (times []time.Time, values []float64, err error) {
 	var (
-		apiURL *url.URL
-		resp   *http.Response
-		err    error
-		times  []time.Time
-		values []float64
-		body   []byte
+		data   []map[string]float64
 		offset int64
 	)
 
-	apiClient := pc.getAPIClient(apiKey)
+	ctx, span := startSpan(ctx, "GetData", "job", query.JobID, "metric", query.MetricType,
+		"range", query.To.Sub(query.From))
+	defer func() { span.end(err) }()
 
-	if apiURL, err = pc.buildURL(apiClient.Endpoint.String(), query); err != nil {
-		return nil, nil, err
-	}
-
-	req := &http.Request{
-		Method: http.MethodGet,
-		URL:    apiURL,
-		Header: map[string][]string{
-			"X-NSONE-Key": []string{apiKey},
-		},
+	apiClient := pc.getAPIClient(apiKey)
+	// The group is of the range of the query, before its alignment.
+	batchKey, batchJobs := dataBatchFromContext(ctx).groupOf(query)
+
+	original := query
+	if pc.queryCache.enabled() {
+		// Align the range on the cache TTL, so the queries relative to now
+		// made within the same TTL window share the cache entry.
+		aligned := *query
+		aligned.From, aligned.To = alignRange(query.From, query.To, pc.queryCache.ttl)
+		query = &aligned
 	}
 
-	if resp, err = httpClient.Do(req); err != nil {
+	if data, err = pc.fetchBatched(ctx, apiKey, apiClient.Endpoint.String(), batchKey, batchJobs, query); err != nil {
 		return nil, nil, err
 	}
-	defer resp.Body.Close()
-	// This error can be returned by the API.
-	if resp.StatusCode == http.StatusBadRequest {
-		return nil, nil, errDataRetrieval
-	}
-
-	if body, err = io.ReadAll(resp.Body); err != nil {
-		return nil, nil, err
-	}
-
-	data := make([]map[string]float64, 0)
-	if err = json.Unmarshal(body, &data); err != nil {
-		return nil, nil, err
+	queryDebugFromContext(ctx).addRawPoints(len(data))
+	if query != original {
+		data = trimRange(data, original.From, original.To)
 	}
 
 	size := int64(len(data))
@@ -384,9 +633,261 @@ func (pc *PulsarClient) GetData(apiKey string, query *queryModel) ([]time.Time,
 	return times, values, nil
 }
 
+// fetchRange gets the data points of the query, splitting its time range in
+// chunks no longer than the chunk size of the client, when set.
+func (pc *PulsarClient) fetchRange(ctx context.Context, apiKey, endpoint string, query *queryModel) ([]map[string]float64, error) {
+	if pc.chunkSize <= 0 || query.To.Sub(query.From) <= pc.chunkSize {
+		apiURL, err := pc.buildURL(endpoint, query)
+		if err != nil {
+			return nil, err
+		}
+		return pc.fetchData(ctx, apiKey, apiURL)
+	}
+
+	var (
+		data          []map[string]float64
+		lastTimestamp = math.Inf(-1)
+	)
+	recordFeatureUse(ctx, featureChunking)
+	for from := query.From; from.Before(query.To); from = from.Add(pc.chunkSize) {
+		chunk := *query
+		chunk.From = from
+		chunk.To = from.Add(pc.chunkSize)
+		if chunk.To.After(query.To) {
+			chunk.To = query.To
+		}
+
+		apiURL, err := pc.buildURL(endpoint, &chunk)
+		if err != nil {
+			return nil, err
+		}
+		chunkData, err := pc.fetchData(ctx, apiKey, apiURL)
+		if err != nil {
+			return nil, err
+		}
+
+		// The chunks share their boundaries, skip the repeated points.
+		for _, point := range chunkData {
+			if point["timestamp"] > lastTimestamp {
+				data = append(data, point)
+				lastTimestamp = point["timestamp"]
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// fetchData gets the data points from a Pulsar query endpoint. Each point is
+// a map holding the timestamp and the value of every requested job. The
+// points are served from the query cache when enabled.
+func (pc *PulsarClient) fetchData(ctx context.Context, apiKey string, apiURL *url.URL) ([]map[string]float64, error) {
+	var (
+		resp *http.Response
+		err  error
+		body []byte
+	)
+
+	// The data queries may use their own key.
+	dataKey := dataAPIKeyFromContext(ctx, apiKey)
+	cacheKey := dataKey + apiURL.String()
+	data, found := pc.queryCache.get(cacheKey)
+	if pc.queryCache.enabled() {
+		cacheLookupsFromContext(ctx).record(found)
+	}
+	if found {
+		queryDebugFromContext(ctx).addRequest(debugRequest{method: http.MethodGet,
+			url: pc.redactor.redact(redactURL(apiURL.String())), cached: true})
+		return data, nil
+	}
+	// Only the requests actually sent to NS1 count against the budget of the
+	// user.
+	if err = rateBudgetFromContext(ctx).wait(ctx, pc.requestTimeout(ctx)); err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    apiURL,
+		Header: map[string][]string{
+			apiKeyHeader: []string{dataKey},
+		},
+	}
+	// The context carries the logger, the request ID and the span of the
+	// query.
+	req = req.WithContext(ctx)
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	if user := userFromContext(ctx); pc.identityHeader != "" && user != nil && user.Login != "" {
+		req.Header.Set(pc.identityHeader, user.Login)
+	}
+	if span := spanFromContext(ctx); span != nil {
+		req.Header.Set(traceparentHeader, span.traceparent())
+	}
+
+	timings := timingsFromContext(ctx)
+	requestStart := time.Now()
+	if resp, err = pc.httpClientFor(ctx).Do(req); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// The body tells the actual reason when the API rejects the query.
+	if err = checkResponse(resp, errJobNotFound); err != nil {
+		return nil, rejectedDataKey(err, dataKey != apiKey)
+	}
+
+	reader, err := pc.limitBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = io.ReadAll(reader); err != nil {
+		return nil, err
+	}
+	timings.since(phaseRequest, requestStart)
+
+	decodeStart := time.Now()
+	data = make([]map[string]float64, 0)
+	if err = json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	timings.since(phaseDecode, decodeStart)
+
+	pc.queryCache.set(cacheKey, data)
+
+	return data, nil
+}
+
+// getJSON decodes into v the response of NS1 to a GET of the path, relative to
+// the endpoint. notFound is the error of the 404 responses.
+func (pc *PulsarClient) getJSON(ctx context.Context, apiKey, path string, notFound error, v interface{}) error {
+	return pc.sendJSON(ctx, apiKey, http.MethodGet, path, nil, notFound, v)
+}
+
+// sendJSON sends the request to the path, relative to the endpoint, with body
+// encoded as JSON unless nil, and decodes the response into v unless nil.
+func (pc *PulsarClient) sendJSON(ctx context.Context, apiKey, method, path string, body interface{}, notFound error, v interface{}) error {
+	resp, err := pc.send(ctx, apiKey, method, path, body, notFound)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	reader, err := pc.limitBody(resp)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(reader).Decode(v)
+}
+
+// send sends the request to the path, relative to the endpoint, with body
+// encoded as JSON unless nil. The caller closes the body of the successful
+// responses, the others are returned as errors.
+func (pc *PulsarClient) send(ctx context.Context, apiKey, method, path string, body interface{}, notFound error) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, pc.getAPIClient(apiKey).Endpoint.String()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(apiKeyHeader, apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	if span := spanFromContext(ctx); span != nil {
+		req.Header.Set(traceparentHeader, span.traceparent())
+	}
+	if err = rateBudgetFromContext(ctx).wait(ctx, pc.requestTimeout(ctx)); err != nil {
+		return nil, err
+	}
+
+	resp, err := pc.httpClientFor(ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkResponse(resp, notFound); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Prewarm establishes a connection to the NS1 API, paying the DNS and TLS
+// handshake latency upfront, and keeps it alive by pinging the API every
+// interval until Close is called.
+func (pc *PulsarClient) Prewarm(interval time.Duration) {
+	endpoint := pc.endpoint
+
+	ping := func() {
+		req, err := http.NewRequest(http.MethodHead, endpoint, nil)
+		if err != nil {
+			return
+		}
+		resp, err := pc.httpClient.Do(req)
+		if err != nil {
+			pc.logger.Warn("Failed to prewarm the NS1 API connection", "error", err)
+			return
+		}
+		// Drain the body so the connection goes back to the pool.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		ping()
+		for {
+			select {
+			case <-pc.done:
+				return
+			case <-ticker.C:
+				ping()
+			}
+		}
+	}()
+}
+
+// Close stops any background work started by the client.
+func (pc *PulsarClient) Close() {
+	pc.closeOnce.Do(func() {
+		close(pc.done)
+	})
+}
+
 // NewPulsarClient is the default constructor for the Pulsar Client object.
-func NewPulsarClient() *PulsarClient {
-	return &PulsarClient{
-		apiClientCache: make(map[string]*ns1api.Client),
+func NewPulsarClient(opts ...PulsarClientOption) *PulsarClient {
+	pc := &PulsarClient{
+		apiClientCache:  make(map[string]*ns1api.Client),
+		data:            make(map[string]*PulsarData),
+		done:            make(chan struct{}),
+		logger:          Logger,
+		redactor:        newRedactor(),
+		endpoint:        defaultEndpoint,
+		timeout:         timeout,
+		appsTTL:         appsDefaultTTL,
+		queryCache:      newQueryCache(0),
+		maxResponseSize: defaultMaxResponseSize * megabyte,
+	}
+	for _, opt := range opts {
+		opt(pc)
 	}
+	pc.httpClient = pc.newHTTPClient()
+
+	return pc
 }
@@ -8,6 +8,7 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -52,7 +53,7 @@ func TestPulsarClient_GetPulsarApps(t *testing.T) {
 	apiKey := getApiKey(t)
 	client := NewPulsarClient()
 
-	apps, err := client.GetApps(apiKey, OptionAppFetchJobs(true))
+	apps, err := client.GetApps(context.Background(), apiKey, OptionAppFetchJobs(true))
 	if err != nil {
 		t.Errorf("error getting pulsar apps: %v", err)
 		return
@@ -0,0 +1,250 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+// pingTransport reports the method of the requests, answering them with an
+// empty response without calling NS1.
+type pingTransport chan string
+
+func (t pingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t <- req.Method
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestPrewarm(t *testing.T) {
+	pings := make(pingTransport, 100)
+	pc := NewPulsarClient()
+	pc.httpClient = &http.Client{Transport: pings}
+	pc.Prewarm(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		select {
+		case method := <-pings:
+			if method != http.MethodHead {
+				t.Errorf("expected a HEAD ping, got %s", method)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected the connection kept alive, got %d pings", i)
+		}
+	}
+
+	pc.Close()
+	// A ping may be in flight when closing.
+	time.Sleep(50 * time.Millisecond)
+	for len(pings) > 0 {
+		<-pings
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(pings) != 0 {
+		t.Errorf("expected no ping once closed, got %d", len(pings))
+	}
+}
+
+func TestPrewarmSetting(t *testing.T) {
+	for jsonData, prewarm := range map[string]bool{`{}`: false, `{"prewarm": true}`: true} {
+		settings, err := parseSettings(backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if settings.Prewarm != prewarm {
+			t.Errorf("%s: expected prewarm %v, got %v", jsonData, prewarm, settings.Prewarm)
+		}
+	}
+}
+
+func TestGetJobsInactive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"jobid": "active", "typeid": "latency", "active": true},
+			{"jobid": "inactive", "typeid": "latency", "active": false}]`)
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL))
+	tests := []struct {
+		fetchInactive bool
+		jobs          []string
+	}{
+		{false, []string{"active"}},
+		{true, []string{"active", "inactive"}},
+	}
+	for _, tt := range tests {
+		jobs, err := pc.GetJobs(context.Background(), "key", "app1", OptionJobsFetchInactive(tt.fetchInactive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jobIDs []string
+		for _, job := range jobs {
+			jobIDs = append(jobIDs, job.JobID)
+		}
+		if fmt.Sprint(jobIDs) != fmt.Sprint(tt.jobs) {
+			t.Errorf("fetch inactive %v: expected jobs %v, got %v", tt.fetchInactive, tt.jobs, jobIDs)
+		}
+	}
+}
+
+func TestGetDataCache(t *testing.T) {
+	var hits int
+	now := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprintf(w, `[{"timestamp": %d, "job1": 42}]`, now.Add(-30*time.Minute).Unix())
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientCacheTTL(time.Minute))
+	for i := 0; i < 3; i++ {
+		_, values, err := pc.GetData(context.Background(), "key", &queryModel{
+			JobID:         "job1",
+			MetricType:    metricTypePerformance,
+			Aggregation:   "p50",
+			Geo:           "*",
+			ASN:           "*",
+			From:          now.Add(-time.Hour),
+			To:            now,
+			MaxDataPoints: 100,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 1 || values[0] != 42 {
+			t.Fatalf("unexpected values %v", values)
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("expected a single request to NS1, got %d", hits)
+	}
+}
+
+func TestGetDataCacheRange(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 42))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientCacheTTL(5*time.Minute))
+	// The last 15 minutes, out of the 5 minutes windows.
+	to := time.Unix(1600000000, 0).Truncate(5 * time.Minute).Add(3 * time.Minute)
+	from := to.Add(-15 * time.Minute)
+	times, _, err := pc.GetData(context.Background(), "key", &queryModel{
+		JobID:         "job1",
+		MetricType:    metricTypePerformance,
+		Aggregation:   "p50",
+		Geo:           "*",
+		ASN:           "*",
+		From:          from,
+		To:            to,
+		MaxDataPoints: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 16 || !times[0].Equal(from) || !times[len(times)-1].Equal(to) {
+		t.Errorf("expected the points of the range only, up to its end, got %d points from %s to %s",
+			len(times), times[0], times[len(times)-1])
+	}
+}
+
+func TestGetDataChunks(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// Every chunk returns its boundaries as data points.
+		fmt.Fprintf(w, `[{"timestamp": %s, "job1": 1}, {"timestamp": %s, "job1": 2}]`,
+			r.URL.Query().Get("start"), r.URL.Query().Get("end"))
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientChunkSize(24*time.Hour))
+	to := time.Unix(1640001600, 0)
+	times, _, err := pc.GetData(context.Background(), "key", &queryModel{
+		JobID:         "job1",
+		MetricType:    metricTypeAvailability,
+		Geo:           "*",
+		ASN:           "*",
+		From:          to.Add(-72 * time.Hour),
+		To:            to,
+		MaxDataPoints: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 3 {
+		t.Errorf("expected 3 chunks, got %d", requests)
+	}
+	// The shared boundaries are returned once.
+	if len(times) != 4 {
+		t.Errorf("expected 4 data points, got %d", len(times))
+	}
+}
+
+func TestIdentityHeader(t *testing.T) {
+	var onBehalfOf []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		onBehalfOf = append(onBehalfOf, r.Header.Get("X-On-Behalf-Of"))
+		fmt.Fprint(w, `[{"timestamp": 1640001600, "job1": 42}]`)
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL), OptionClientIdentityHeader("X-On-Behalf-Of"))
+	now := time.Now()
+	qm := &queryModel{JobID: "job1", MetricType: metricTypePerformance, Geo: "*", ASN: "*",
+		From: now.Add(-time.Hour), To: now, MaxDataPoints: 100}
+
+	ctx := withUser(context.Background(), &backend.User{Login: "jdoe"})
+	if _, _, err := pc.GetData(ctx, "key", qm); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pc.GetData(context.Background(), "key", qm); err != nil {
+		t.Fatal(err)
+	}
+	if len(onBehalfOf) != 2 || onBehalfOf[0] != "jdoe" || onBehalfOf[1] != "" {
+		t.Errorf("expected the login of the user to be sent when known, got %q", onBehalfOf)
+	}
+}
+
+func TestJobTypes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pulsar/apps" {
+			fmt.Fprint(w, `[{"appid": "app1", "name": "App 1", "active": true}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"jobid": "job1", "typeid": "latency", "community": true, "active": true},
+			{"jobid": "job2", "typeid": "latency", "active": true},
+			{"jobid": "job3", "typeid": "custom", "active": true}]`)
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL))
+	apps, err := pc.GetApps(context.Background(), "key", OptionAppFetchJobs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for jobID, jobType := range map[string]string{"job1": jobTypeCommunity, "job2": jobTypeHTTP, "job3": jobTypeCustom} {
+		if apps.JobsMap[jobID].Type != jobType {
+			t.Errorf("expected %s to be a %s job, got %q", jobID, jobType, apps.JobsMap[jobID].Type)
+		}
+	}
+
+	if err = checkJobType(&queryModel{JobID: "job3", JobType: jobTypeCustom}, apps); err != nil {
+		t.Error(err)
+	}
+	if err = checkJobType(&queryModel{JobID: "job2", JobType: jobTypeCustom}, apps); !errors.Is(err, errJobTypeMismatch) {
+		t.Errorf("expected errJobTypeMismatch, got %v", err)
+	}
+}
@@ -6,9 +6,11 @@ package plugin
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -31,11 +33,17 @@ var Logger = log.DefaultLogger
 var (
 	_ backend.QueryDataHandler      = (*PulsarDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*PulsarDatasource)(nil)
+	_ backend.CallResourceHandler   = (*PulsarDatasource)(nil)
+	_ backend.StreamHandler         = (*PulsarDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*PulsarDatasource)(nil)
 
 	errDataSourceInstanceSettingsNil = errors.New("data source instance settings not present in the plugin context")
 	errDecryptedSecureDataNil        = errors.New("secure decrypted data not found")
 	errAPIKeyNotFound                = errors.New("NS1 API key not found")
+	errAppNotAllowed                 = errors.New("the Pulsar app is not available through this datasource")
+	errFeatureDisabled               = errors.New("feature not enabled for this datasource")
+	errRangeTooLong                  = errors.New("time range too long, select a shorter one")
+	errJobTypeMismatch               = newInvalidQueryError("the Pulsar job is not of the type of the query")
 )
 
 type queryModel struct {
@@ -45,9 +53,104 @@ type queryModel struct {
 	Geo         string `json:"geo"`
 	ASN         string `json:"asn"`
 	Aggregation string `json:"agg"`
+	Alias       string `json:"alias"`
+	// Aggregations are the aggregations of the agg given as a list, each
+	// returned in a field of its own.
+	Aggregations []string `json:"-"`
+	// QueryType selects the DNS QPS queries, of the account or of the Zone,
+	// or of the Domain and RecordType of the zone.
+	QueryType  string `json:"queryType"`
+	Zone       string `json:"zone"`
+	Domain     string `json:"domain"`
+	RecordType string `json:"recordType"`
+	// MonitoringJobID and MonitoringMetric select the NS1 monitoring job, and
+	// its metric, of the monitoring queries.
+	MonitoringJobID  string `json:"monitoringJobId"`
+	MonitoringMetric string `json:"monitoringMetric"`
+	// UsageByNetwork splits the account usage queries per NS1 network.
+	UsageByNetwork bool `json:"usageByNetwork"`
+	// Networks restricts the usage queries to a comma separated list of NS1
+	// network IDs, each with its own series.
+	Networks string `json:"networks"`
+	// JobType restricts the query to the jobs of this type: community, http
+	// or custom.
+	JobType string `json:"jobType"`
+	// ReportType and ReportScope define the NS1 report of the report queries,
+	// e.g. num_queries over the account.
+	ReportType  string `json:"reportType"`
+	ReportScope string `json:"reportScope"`
+	// Threshold is the availability, in percent, below which the annotation
+	// queries report a drop.
+	Threshold float64 `json:"threshold"`
+	// NoData is the no data policy of the query: error, empty or zero.
+	NoData string `json:"noData"`
+	// Reduce returns the last, mean or max value of the series over the
+	// range, instead of the series.
+	Reduce string `json:"reduce"`
+	// Condition turns the series into the breaches of the condition, 1 when
+	// breached and 0 otherwise.
+	Condition *queryCondition `json:"condition"`
+	// Quantile rolls the series up to buckets of QuantileInterval, the
+	// interval of the panel by default, each point being the quantile of the
+	// points of its bucket, between 0 and 1.
+	Quantile         float64 `json:"quantile"`
+	QuantileInterval string  `json:"quantileInterval"`
+	// Band adds the lower and upper bounds of the expected range of the
+	// series, its rolling mean plus or minus a number of standard deviations.
+	Band *anomalyBand `json:"band"`
+	// HealthScore weighs the availability and the performance of the
+	// health score queries.
+	HealthScore *healthScore `json:"healthScore"`
+	// Forecast projects the linear trend of the series this long past the
+	// end of the range, in a frame of its own.
+	Forecast string `json:"forecast"`
+	// BaselineWeeks adds the series of the same window this many weeks ago,
+	// aligned on the range, to compare the day to the usual one.
+	BaselineWeeks int `json:"baselineWeeks"`
+	// Envelope downsamples the series to the max data points, rather than
+	// keeping the latest points, with the min and max of each bucket.
+	Envelope bool `json:"envelope"`
+	// Resample moves the series onto the multiples of the interval of the
+	// panel: linear, nearest or previous.
+	Resample string `json:"resample"`
+	// Cumulative returns the running total of the decisions from the start
+	// of the range.
+	Cumulative bool `json:"cumulative"`
+	// Timeout overrides the timeout of the NS1 requests of the query, up to
+	// the maximum of the datasource.
+	Timeout string `json:"timeout"`
+	// LastPoints returns the latest points of the job, this many, whatever
+	// the range of the dashboard.
+	LastPoints int `json:"lastPoints"`
+	// Unit converts the values: the performance to ms or s, the
+	// availability to percent or ratio.
+	Unit string `json:"unit"`
+	// Version is the version of the query model the query was saved with.
+	Version int `json:"version"`
+	// Debug adds the requests sent to NS1, and the points they returned, to
+	// the frames of the query.
+	Debug bool `json:"debug"`
 	From,
 	To time.Time
 	MaxDataPoints int64
+	Interval      time.Duration
+}
+
+// applyDefaults fills the fields omitted by the query with the defaults
+// configured for the datasource.
+func (qm *queryModel) applyDefaults(settings *Settings) {
+	if qm.Geo == "" {
+		qm.Geo = settings.DefaultGeo
+	}
+	if qm.ASN == "" {
+		qm.ASN = settings.DefaultASN
+	}
+	if qm.Aggregation == "" {
+		qm.Aggregation = settings.DefaultAggregation
+	}
+	if qm.MetricType == "" {
+		qm.MetricType = settings.DefaultMetricType
+	}
 }
 
 func (qm *queryModel) validate() {
@@ -59,19 +162,94 @@ func (qm *queryModel) validate() {
 	}
 }
 
+// check rejects the invalid options of a Pulsar query.
+func (qm *queryModel) check() error {
+	checks := []func(*queryModel) error{
+		checkNoDataPolicy, checkReducer, checkResample, checkQuantile, checkForecast, checkBaseline,
+		checkEnvelope, checkCumulative, checkLastPoints, checkAggregations, checkUnit,
+	}
+	for _, check := range checks {
+		if err := check(qm); err != nil {
+			return err
+		}
+	}
+	if qm.Band != nil {
+		if err := qm.Band.check(qm); err != nil {
+			return err
+		}
+	}
+	if qm.Condition != nil {
+		return qm.Condition.check()
+	}
+	return nil
+}
+
 func (qm *queryModel) canQuery() bool {
 	return qm.AppID != "" && qm.JobID != "" && qm.MetricType != "" && qm.Aggregation != ""
 }
 
 // NewPulsarDatasource creates a new datasource instance.
-func NewPulsarDatasource(_ backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &PulsarDatasource{}, nil
+func NewPulsarDatasource(instanceSettings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	settings, err := parseSettings(instanceSettings)
+	if err != nil {
+		return nil, err
+	}
+	if settings.transport, err = grafanaTransport(instanceSettings); err != nil {
+		return nil, fmt.Errorf("invalid datasource settings: %w", err)
+	}
+
+	// The secure data holds the API keys, they are redacted from the logs and
+	// errors from the start.
+	redactor := newRedactor()
+	for _, secret := range instanceSettings.DecryptedSecureJSONData {
+		redactor.add(secret)
+	}
+	logger := withRedaction(settings.logger(), redactor)
+
+	client := NewPulsarClient(append(settings.clientOptions(), OptionClientRedactor(redactor),
+		OptionClientLogger(logger))...)
+	if settings.Prewarm {
+		client.Prewarm(keepAliveInterval)
+	}
+
+	ds := &PulsarDatasource{
+		settings:     settings,
+		pulsarClient: client,
+		logger:       logger,
+	}
+	ds.streams.logger = ds.logger
+	ds.resourceHandler = ds.newResourceHandler()
+
+	if settings.SelfTestInterval > 0 {
+		pCtx := backend.PluginContext{DataSourceInstanceSettings: &instanceSettings}
+		ds.selfTest = &selfTest{}
+		ds.selfTest.run(client, time.Duration(settings.SelfTestInterval), func() (string, error) {
+			keys, err := getAPIKeysFromContext(pCtx, settings)
+			if err != nil {
+				return "", err
+			}
+			return keys.pick(&ds.keyFallback), nil
+		})
+	}
+
+	return ds, nil
 }
 
 // PulsarDatasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type PulsarDatasource struct {
-	pulsarClient *PulsarClient
+	settings        *Settings
+	logger          log.Logger
+	pulsarClient    *PulsarClient
+	api             PulsarAPI
+	keyFallback     keyFallback
+	resourceHandler backend.CallResourceHandler
+	selfTest        *selfTest
+	recentErrors    recentErrors
+	errorRate       errorRate
+	keyRotation     keyRotation
+	budgets         rateBudgets
+	streams         streamHub
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -79,6 +257,24 @@ type PulsarDatasource struct {
 // be disposed and a new one will be created using NewPulsarDatasource factory function.
 func (p *PulsarDatasource) Dispose() {
 	// Clean up datasource instance resources.
+	if p.pulsarClient != nil {
+		p.pulsarClient.Close()
+	}
+	p.streams.close()
+}
+
+// ensureInitialized sets the defaults of the datasources not created by
+// NewPulsarDatasource.
+func (p *PulsarDatasource) ensureInitialized() {
+	if p.settings == nil {
+		p.settings = defaultSettings()
+	}
+	if p.pulsarClient == nil {
+		p.pulsarClient = NewPulsarClient()
+	}
+	if p.logger == nil {
+		p.logger = withRedaction(p.settings.logger(), p.pulsarClient.redactor)
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -89,13 +285,64 @@ func (p *PulsarDatasource) QueryData(ctx context.Context, req *backend.QueryData
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
-	if p.pulsarClient == nil {
-		p.pulsarClient = NewPulsarClient()
+	p.ensureInitialized()
+
+	reqID := requestID(req.Headers)
+	logger := withFields(pluginContextLogger(p.logger, req.PluginContext), "requestId", reqID)
+	ctx = withLogger(withRequestID(ctx, reqID), logger)
+	ctx = withUser(ctx, req.PluginContext.User)
+	ctx = withRateBudget(ctx, p.budgets.forUser(p.settings, req.PluginContext.User))
+	if p.settings.AuditLog {
+		ctx = withAuditLogger(ctx, auditLogger(req.PluginContext, reqID))
+	}
+	if p.settings.Tracing {
+		var span *span
+		ctx, span = startTrace(ctx, req.Headers[traceparentHeader], "QueryData",
+			"queries", len(req.Queries))
+		defer span.end(nil)
 	}
 
+	alert := isAlertQuery(req.Headers)
+	ctx = withDataBatch(ctx, p.newDataBatch(ctx, req.PluginContext, req.Queries))
+
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
-		res := p.query(ctx, req.PluginContext, q)
+		queryLogger := withFields(logger, "refId", q.RefID)
+		timings := newQueryTimings()
+		queryCtx, span := startSpan(withTimings(withLogger(ctx, queryLogger), timings), "query", "refId", q.RefID,
+			"range", q.TimeRange.To.Sub(q.TimeRange.From))
+		start := time.Now()
+		queriesInFlight.Inc()
+		res := p.query(queryCtx, req.PluginContext, q)
+		if alert {
+			res = p.retryAlertQuery(queryCtx, req.PluginContext, q, res)
+		}
+		queriesInFlight.Dec()
+		span.end(res.Error)
+		duration := time.Since(start)
+		if res.Error != nil {
+			queryErr := classifyError(res.Error).redact(p.pulsarClient.redactor)
+			p.errorRate.record(queryErr.isUpstreamFailure())
+			recordQueryError(queryErr)
+			p.recentErrors.add(q.RefID, queryErr)
+			queryLogger.Error("Query failed", append([]interface{}{"duration", duration,
+				"source", queryErr.Source, "status", queryErr.Status, "error", queryErr},
+				timings.logArgs()...)...)
+			// The alert rules need the errors to tell a failure from a drop.
+			if p.settings.ErrorsAsNoData && !alert && queryErr.isUpstreamFailure() {
+				res = asNoData(res, queryErr)
+			} else {
+				res.Error = queryErr
+			}
+		} else {
+			p.errorRate.record(false)
+			queryLogger.Debug("Query done", append([]interface{}{"duration", duration},
+				timings.logArgs()...)...)
+		}
+
+		if degradation := p.degradation(); degradation != "" {
+			addDegradationNotice(&res, degradation)
+		}
 
 		// save the response in a hashmap
 		// based on with RefID as identifier
@@ -105,82 +352,516 @@ func (p *PulsarDatasource) QueryData(ctx context.Context, req *backend.QueryData
 	return response, nil
 }
 
+// catalog returns the apps and jobs of the key, timed as the catalog phase of
+// the query, and whether they were filtered down to the apps visible to the
+// user running the request.
+func (p *PulsarDatasource) catalog(ctx context.Context, apiKey string) (*GetAppsResponse, bool, error) {
+	catalogStart := time.Now()
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	timingsFromContext(ctx).since(phaseCatalog, catalogStart)
+	if err != nil {
+		return nil, false, err
+	}
+	allowed := p.appFilter(ctx)
+	if allowed == nil {
+		return appsResponse, false, nil
+	}
+	return appsResponse.filter(allowed), true, nil
+}
+
+// checkQueryAllowed rejects the queries for apps, or jobs, not exposed by the
+// datasource.
+func checkQueryAllowed(qm *queryModel, appsResponse *GetAppsResponse) error {
+	if _, exists := appsResponse.AppsMap[qm.AppID]; qm.AppID != "" && !exists {
+		return fmt.Errorf("%w: %s", errAppNotAllowed, qm.AppID)
+	}
+	if _, exists := appsResponse.JobsMap[qm.JobID]; qm.JobID != "" && !exists {
+		return fmt.Errorf("%w: job %s", errAppNotAllowed, qm.JobID)
+	}
+	return nil
+}
+
+// checkJobType rejects the queries for a job of another type than the one of
+// the query, when set.
+func checkJobType(qm *queryModel, appsResponse *GetAppsResponse) error {
+	if job, exists := appsResponse.JobsMap[qm.JobID]; qm.JobType != "" && exists && job.Type != qm.JobType {
+		return fmt.Errorf("%w: job %s is a %s job, not a %s one", errJobTypeMismatch, qm.JobID, job.Type, qm.JobType)
+	}
+	return nil
+}
+
+type userContextKey struct{}
+
+// withUser sets the Grafana user running the requests with ctx: their role
+// restricts their apps when roleApps maps it, and their login may be
+// forwarded to NS1.
+func withUser(ctx context.Context, user *backend.User) context.Context {
+	if user == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// userFromContext returns the Grafana user running the request, nil if
+// unknown.
+func userFromContext(ctx context.Context) *backend.User {
+	user, _ := ctx.Value(userContextKey{}).(*backend.User)
+	return user
+}
+
+// appFilter returns whether the app is visible to the user running the
+// request, or nil when the apps aren't restricted.
+func (p *PulsarDatasource) appFilter(ctx context.Context) func(appID string) bool {
+	role := ""
+	if user := userFromContext(ctx); user != nil {
+		role = user.Role
+	}
+	return p.settings.appFilter(role)
+}
+
+// asNoData converts a failed response into empty frames with a warning, so
+// the panels degrade gracefully instead of showing an error.
+func asNoData(res backend.DataResponse, queryErr *QueryError) backend.DataResponse {
+	noData := backend.DataResponse{Frames: make(data.Frames, 0, len(res.Frames))}
+
+	for _, frame := range res.Frames {
+		empty := frame.EmptyCopy()
+		empty.Meta = frame.Meta
+		empty.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "No data: " + queryErr.Error(),
+		})
+		noData.Frames = append(noData.Frames, empty)
+	}
+
+	return noData
+}
+
+// tagPlaceholder matches the {{tag:<name>}} placeholders of the labels.
+var tagPlaceholder = regexp.MustCompile(`{{tag:([^}]+)}}`)
+
 // buildLabel creates a custom label for the time series. Puts all the relevant
-// info on the string.
-func buildLabel(appName, jobName string, qm *queryModel) string {
-	return fmt.Sprintf("%s (%s):%s (%s):%s:%s:%s:%s", appName, qm.AppID,
-		jobName, qm.JobID, qm.MetricType, qm.Aggregation, qm.Geo, qm.ASN,
-	)
+// info on the string, unless a template is given: the placeholders {{app}},
+// {{appid}}, {{job}}, {{jobid}}, {{jobtype}}, {{metric}}, {{agg}}, {{geo}},
+// {{asn}} and {{category}} are then replaced with their value, and
+// {{tag:<name>}} with the value of the tag of the app.
+func buildLabel(app App, job Job, qm *queryModel, template string) string {
+	if template == "" {
+		return fmt.Sprintf("%s (%s):%s (%s):%s:%s:%s:%s", app.Name, qm.AppID,
+			job.Name, qm.JobID, qm.MetricType, qm.Aggregation, qm.Geo, qm.ASN,
+		)
+	}
+
+	label := strings.NewReplacer(
+		"{{app}}", app.Name,
+		"{{appid}}", qm.AppID,
+		"{{job}}", job.Name,
+		"{{jobid}}", qm.JobID,
+		"{{jobtype}}", job.Type,
+		"{{metric}}", qm.MetricType,
+		"{{agg}}", qm.Aggregation,
+		"{{geo}}", qm.Geo,
+		"{{asn}}", qm.ASN,
+		"{{category}}", app.Category,
+	).Replace(template)
+	return tagPlaceholder.ReplaceAllStringFunc(label, func(placeholder string) string {
+		return app.Tags[tagPlaceholder.FindStringSubmatch(placeholder)[1]]
+	})
+}
+
+// queryKeys returns the API keys of the request, and the key its queries
+// start with: the primary one, or the secondary one once the primary one was
+// rejected.
+func (p *PulsarDatasource) queryKeys(pCtx backend.PluginContext) (*apiKeys, string, error) {
+	keys, err := p.apiKeys(pCtx)
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, keys.pick(&p.keyFallback), nil
 }
 
-func (p *PulsarDatasource) query(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+func (p *PulsarDatasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	keys, apiKey, err := p.queryKeys(pCtx)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	ctx = withDataAPIKey(ctx, keys.data)
+	response := p.queryWithKey(ctx, apiKey, query)
+
+	// Retry with the secondary key when NS1 starts rejecting the primary one.
+	if errors.Is(response.Error, errAuthorizationDenied) && apiKey == keys.primary && keys.secondary != "" {
+		p.keyFallback.activate(loggerFromContext(ctx))
+		response = p.queryWithKey(withRetry(ctx), keys.secondary, query)
+	}
+
+	return response
+}
+
+func (p *PulsarDatasource) queryWithKey(ctx context.Context, apiKey string, query backend.DataQuery) (response backend.DataResponse) {
 	var (
 		qm           = &queryModel{}
-		response     backend.DataResponse
 		times        = []time.Time{query.TimeRange.From, query.TimeRange.To}
 		values       = []float64{0, 0}
 		err          error
-		apiKey       string
 		dataLabel    string
+		labels       data.Labels
+		thresholds   *data.ThresholdsConfig
 		appsResponse *GetAppsResponse
+		points       int
 	)
 
-	apiKey, err = getAPIKeyFromContext(pCtx)
+	start := time.Now()
+	defer func() {
+		p.logSlowQuery(ctx, apiKey, qm, points, time.Since(start))
+	}()
+
+	// Parse the JSON into our queryModel, unless the batch of the request did.
+	qm, warnings, err := dataBatchFromContext(ctx).parse(query)
 	if err != nil {
 		response.Error = err
 		return response
 	}
+	if len(warnings) > 0 {
+		loggerFromContext(ctx).Warn("Query fields ignored", "warnings", warnings)
+		defer func() {
+			addQueryWarnings(&response, warnings)
+		}()
+	}
+	defer func() {
+		setPreferredVisualization(&response, preferredVisualization(qm))
+	}()
+	if qm.Debug {
+		debug := &queryDebug{}
+		ctx = withQueryDebug(ctx, debug)
+		defer func() {
+			debug.addTo(&response)
+		}()
+	}
+	lookups := &cacheLookups{}
+	ctx = withCacheLookups(ctx, lookups)
+	queryTimeout, err := p.settings.queryTimeout(qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	ctx = withQueryTimeout(ctx, queryTimeout)
+	qm.From = query.TimeRange.From
+	qm.To = query.TimeRange.To
+	qm.MaxDataPoints = query.MaxDataPoints
+	qm.Interval = query.Interval
+
+	if _, found := queryTypeLabels[qm.QueryType]; !found {
+		// The Pulsar queries get the defaults, and "*" for an empty geo and ASN.
+		qm.applyDefaults(p.settings)
+		qm.validate()
+		spanFromContext(ctx).setAttributes("job", qm.JobID, "metric", qm.MetricType)
+	}
+	recordQuery(ctx, qm)
+	auditQuery(ctx, query.RefID, qm)
+
+	// The queries beyond the Pulsar data have their own handlers.
+	switch qm.QueryType {
+	case queryTypeDNSQPS:
+		return p.queryDNSQPS(ctx, apiKey, qm)
+	case queryTypeMonitoringStatus:
+		return p.queryMonitoringStatus(ctx, apiKey, qm)
+	case queryTypeMonitoringMetrics:
+		return p.queryMonitoringMetrics(ctx, apiKey, qm)
+	case queryTypeNotifications:
+		return p.queryNotifications(ctx, apiKey, qm)
+	case queryTypeActivity:
+		return p.queryActivity(ctx, apiKey, qm)
+	case queryTypeJobChanges:
+		return p.queryJobChanges(ctx, apiKey, qm)
+	case queryTypeUsage:
+		return p.queryUsage(ctx, apiKey, qm)
+	case queryTypeDecisionAnswers:
+		return p.queryDecisionAnswers(ctx, apiKey, qm)
+	case queryTypeShedLoad:
+		return p.queryShedLoad(ctx, apiKey, qm)
+	case queryTypeDHCPScopes:
+		return p.queryDHCPScopes(ctx, apiKey, qm)
+	case queryTypeReport:
+		return p.queryReport(ctx, apiKey, qm)
+	case queryTypeAnnotation, queryTypeOutages:
+		return p.queryAnnotation(ctx, apiKey, qm)
+	case queryTypeRouteMapChanges:
+		return p.queryRouteMapChanges(ctx, apiKey, qm)
+	case queryTypeHealthScore:
+		return p.queryHealthScore(ctx, apiKey, qm)
+	case queryTypeStatusBoard:
+		return p.queryStatusBoard(ctx, apiKey, qm)
+	}
 
-	// Unmarshal the JSON into our queryModel.
-	response.Error = json.Unmarshal(query.JSON, qm)
-	if response.Error != nil {
+	if err = p.settings.checkFeatures(qm); err != nil {
+		response.Error = err
+		return response
+	}
+	if err = qm.check(); err != nil {
+		response.Error = err
 		return response
 	}
-	// convert the "" to "*" for geo and asn
-	qm.validate()
+	// The interval of the panel, before the max data points are lifted.
+	resampleInterval := qm.panelInterval()
+	var bandWindow time.Duration
+	if qm.Band != nil {
+		bandWindow, _ = qm.Band.window()
+	}
+	unit := p.settings.unit(qm)
+	// The maximum number of points of the panel, before the transforms
+	// below lift it.
+	panelPoints := qm.MaxDataPoints
+	cumulativePoints, envelopePoints := panelPoints, panelPoints
+	if qm.Envelope {
+		// The envelope downsamples the running total of the whole range.
+		cumulativePoints = math.MaxInt64
+	}
+	if qm.Cumulative {
+		// The totals count from the start of the range, not from the
+		// latest points.
+		qm.MaxDataPoints = math.MaxInt64
+	}
+	if qm.Envelope {
+		// The buckets cover the whole range, not only the latest points.
+		qm.MaxDataPoints = math.MaxInt64
+	}
+	var quantileInterval time.Duration
+	if qm.Quantile > 0 {
+		quantileInterval, _ = qm.quantileInterval()
+		// The buckets need all their points, not only the latest ones.
+		qm.MaxDataPoints = math.MaxInt64
+	}
+	if qm.Reduce != "" {
+		// The reducers cover the whole range, not only the latest points.
+		qm.MaxDataPoints = math.MaxInt64
+	}
 
-	appsResponse, err = p.pulsarClient.GetApps(apiKey, OptionAppFetchJobs(true))
+	timings := timingsFromContext(ctx)
+	appsResponse, filtered, err := p.catalog(ctx, apiKey)
 	if err != nil {
 		response.Error = err
 		return response
 	}
+	if filtered {
+		if err = checkQueryAllowed(qm, appsResponse); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+	if err = checkJobType(qm, appsResponse); err != nil {
+		response.Error = err
+		return response
+	}
 
 	// create data frame response.
 	frame := data.NewFrame("response")
 
-	qm.From = query.TimeRange.From
-	qm.To = query.TimeRange.To
-	qm.MaxDataPoints = query.MaxDataPoints
-
+	var (
+		lower, upper []*float64
+		minimums     []float64
+		maximums     []float64
+		baseline     []*float64
+		counts       []int64
+		aggregated   [][]*float64
+		aggLabels    []data.Labels
+		aggNames     []string
+		forecast     *data.Frame
+	)
 	if qm.canQuery() {
-		times, values, err = p.pulsarClient.GetData(apiKey, qm)
+		fetch := qm
+		if qm.Band != nil {
+			fetch = qm.Band.historyQuery(qm, bandWindow)
+		}
+		getData := p.getData
+		if qm.LastPoints > 0 {
+			getData = p.getLastPoints
+		}
+		queryTimes, queryValues, err := getData(ctx, apiKey, fetch)
+		if errors.Is(err, errNoDataFound) {
+			if noDataTimes, noDataValues, ok := noDataSeries(qm, panelPoints); ok {
+				queryTimes, queryValues, err = noDataTimes, noDataValues, nil
+			}
+		}
+		if err != nil {
+			// The frame is still returned, as the query editor needs the apps.
+			response.Error = err
+		} else {
+			// Converted first, the transforms and the conditions work on
+			// the values in the unit of the query.
+			scaleValues(queryValues, unitDivisor(unit))
+			times, values = queryTimes, queryValues
+			points = len(queryValues)
+		}
 
 		app := appsResponse.AppsMap[qm.AppID]
 		job := appsResponse.JobsMap[qm.JobID]
-		dataLabel = buildLabel(app.Name, job.Name, qm)
+		dataLabel = buildLabel(app, job, qm, p.settings.labelTemplate(qm))
+		labels = seriesLabels(app, job, qm)
+		thresholds = p.settings.jobThresholds(app, job).config(qm.MetricType)
+		scaleThresholds(thresholds, unitDivisor(unit))
+		if qm.Cumulative && response.Error == nil {
+			times, values = cumulativeSum(times, values, cumulativePoints)
+			dataLabel += " total"
+		}
+		if qm.Envelope {
+			times, values, minimums, maximums, counts = downsample(times, values, qm.From, qm.To, envelopePoints)
+		}
+		if qm.Quantile > 0 {
+			if response.Error == nil {
+				times, values, counts = quantileOverTime(times, values, quantileInterval, qm.Quantile)
+			}
+			dataLabel += " " + quantileLabel(qm.Quantile, quantileInterval)
+		}
+		if qm.Resample != "" && response.Error == nil {
+			times, values = resample(times, values, resampleInterval, qm.Resample)
+			// The grid points aren't the buckets anymore.
+			counts = nil
+		}
+		if qm.Band != nil {
+			lower, upper = make([]*float64, len(values)), make([]*float64, len(values))
+			if response.Error == nil {
+				lower, upper = qm.Band.bands(times, values, bandWindow)
+			}
+			times, values, lower, upper = trimBefore(qm.From, times, values, lower, upper)
+			if counts != nil {
+				counts = counts[len(counts)-len(values):]
+			}
+		}
+		if qm.BaselineWeeks > 0 {
+			baseline = make([]*float64, len(values))
+			if response.Error == nil {
+				if baseline, err = p.getBaseline(ctx, apiKey, qm, times, quantileInterval); err != nil {
+					response.Error = err
+				}
+				scaleNullableValues(baseline, unitDivisor(unit))
+			}
+		}
+		if qm.Forecast != "" && response.Error == nil {
+			duration, _ := qm.forecastDuration()
+			forecast = forecastFrame(times, values, qm.To, duration, labels, dataLabel)
+		}
+		if qm.Condition != nil {
+			if response.Error == nil {
+				values = qm.Condition.evaluate(times, values)
+			}
+			dataLabel += " " + qm.Condition.String()
+			thresholds = nil
+		}
+		if len(qm.Aggregations) > 1 {
+			for _, agg := range qm.Aggregations[1:] {
+				aggQuery := *qm
+				aggQuery.Aggregation = agg
+				aggLabels = append(aggLabels, seriesLabels(app, job, &aggQuery))
+				aggNames = append(aggNames, buildLabel(app, job, &aggQuery, p.settings.labelTemplate(qm)))
+				aggregated = append(aggregated, make([]*float64, len(values)))
+			}
+			if response.Error == nil {
+				if aggregated, err = p.getAggregations(ctx, apiKey, qm, times); err != nil {
+					response.Error = err
+				}
+				for _, aggValues := range aggregated {
+					scaleNullableValues(aggValues, unitDivisor(unit))
+				}
+			}
+		}
 	}
 
 	// add fields.
-	frame.Fields = append(frame.Fields,
-		data.NewField("time", nil, times),
-		data.NewField(dataLabel, nil, values),
-	)
+	frameStart := time.Now()
+	valueField := data.NewField(valueFieldName, labels, values)
+	if qm.Reduce != "" {
+		// A single number, without the time field, for the alert rules and
+		// the stat panels.
+		valueField = data.NewField(valueFieldName, labels, reduceValues(values, qm.Reduce))
+	} else {
+		frame.Fields = append(frame.Fields, data.NewField("time", nil, times))
+	}
+	valueField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel, Thresholds: thresholds}
+	frame.Fields = append(frame.Fields, valueField)
+	if minimums != nil {
+		minField := data.NewField("min", labels, minimums)
+		minField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel + " min"}
+		maxField := data.NewField("max", labels, maximums)
+		maxField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel + " max"}
+		frame.Fields = append(frame.Fields, minField, maxField)
+	}
+	if lower != nil {
+		lowerField := data.NewField("lower", labels, lower)
+		lowerField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel + " lower"}
+		upperField := data.NewField("upper", labels, upper)
+		upperField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel + " upper"}
+		frame.Fields = append(frame.Fields, lowerField, upperField)
+	}
+	if baseline != nil {
+		baselineField := data.NewField("baseline", labels, baseline)
+		baselineField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel + " " + baselineLabel(qm.BaselineWeeks)}
+		frame.Fields = append(frame.Fields, baselineField)
+	}
+	for i, aggValues := range aggregated {
+		aggField := data.NewField(qm.Aggregations[i+1], aggLabels[i], aggValues)
+		aggField.Config = &data.FieldConfig{DisplayNameFromDS: aggNames[i], Thresholds: thresholds}
+		frame.Fields = append(frame.Fields, aggField)
+	}
+	if unit != "" && qm.Condition == nil {
+		setUnit(frame, unit)
+		setUnit(forecast, unit)
+	}
+	if counts != nil && qm.Reduce == "" {
+		// The number of points of each bucket, so the sparse buckets stand
+		// out from the dense ones.
+		countField := data.NewField("count", labels, counts)
+		countField.Config = &data.FieldConfig{DisplayNameFromDS: dataLabel + " count"}
+		frame.Fields = append(frame.Fields, countField)
+	}
 
-	frame.Meta = &data.FrameMeta{Custom: appsResponse.Apps}
+	timings.since(phaseFrame, frameStart)
+	frame.Meta = &data.FrameMeta{Custom: appsResponse.Apps,
+		Stats: append(timings.stats(), cacheStats(time.Duration(p.settings.CacheTTL), lookups)...)}
 
 	// add the frames to the response.
 	response.Frames = append(response.Frames, frame)
+	if forecast != nil {
+		response.Frames = append(response.Frames, forecast)
+	}
 
 	return response
 }
 
+// logSlowQuery warns about the queries slower than the slow query threshold,
+// so the dashboards that keep loading slowly can be found.
+func (p *PulsarDatasource) logSlowQuery(ctx context.Context, apiKey string, qm *queryModel, points int, duration time.Duration) {
+	threshold := time.Duration(p.settings.SlowQueryThreshold)
+	if threshold == 0 || duration < threshold || !qm.canQuery() {
+		return
+	}
+
+	// The key is sent as a header, the URL only needs its credentials
+	// redacted, if any.
+	apiURL, err := p.pulsarClient.buildURL(p.pulsarClient.getAPIClient(apiKey).Endpoint.String(), qm)
+	if err != nil {
+		return
+	}
+	loggerFromContext(ctx).Warn("Slow query", "duration", duration, "threshold", threshold,
+		"url", redactURL(apiURL.String()), "range", qm.To.Sub(qm.From), "points", points)
+}
+
+// getData fetches the data points of the query, once the query is known to be
+// within the limits of the datasource.
+func (p *PulsarDatasource) getData(ctx context.Context, apiKey string, qm *queryModel) ([]time.Time, []float64, error) {
+	if err := p.settings.checkRange(qm); err != nil {
+		return nil, nil, err
+	}
+	return p.pulsarAPI().GetData(ctx, apiKey, qm)
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
-func (p *PulsarDatasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+func (p *PulsarDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	var (
-		apiKey string
+		keys   *apiKeys
 		err    error
 		client *PulsarClient
 	)
@@ -192,7 +873,11 @@ func (p *PulsarDatasource) CheckHealth(_ context.Context, req *backend.CheckHeal
 		}, nil
 	}
 
-	apiKey, err = getAPIKeyFromContext(req.PluginContext)
+	if p.settings == nil {
+		p.settings = defaultSettings()
+	}
+
+	keys, err = getAPIKeysFromContext(req.PluginContext, p.settings)
 	if err != nil {
 		if errors.Is(err, errDataSourceInstanceSettingsNil) {
 			return &backend.CheckHealthResult{
@@ -207,9 +892,14 @@ func (p *PulsarDatasource) CheckHealth(_ context.Context, req *backend.CheckHeal
 			}, nil
 		}
 		if errors.Is(err, errAPIKeyNotFound) {
+			message := "API key not present"
+			if err != errAPIKeyNotFound {
+				// The key is referenced from an environment variable or file.
+				message = err.Error()
+			}
 			return &backend.CheckHealthResult{
 				Status:  backend.HealthStatusError,
-				Message: "API key not present",
+				Message: message,
 			}, nil
 		}
 		return &backend.CheckHealthResult{
@@ -218,39 +908,76 @@ func (p *PulsarDatasource) CheckHealth(_ context.Context, req *backend.CheckHeal
 		}, nil
 	}
 
-	client = NewPulsarClient()
+	// Check the configuration actually used by the queries: endpoints,
+	// timeout and transport. A new client is used so the apps aren't served
+	// from the cache. It shares the redactor of the datasource, so the
+	// messages and logs leave out every key of the datasource, not only the
+	// checked one.
+	redactor := newRedactor()
+	if p.pulsarClient != nil {
+		redactor = p.pulsarClient.redactor
+	}
+	redactor.add(keys.primary, keys.secondary, keys.data)
+	client = NewPulsarClient(append(p.settings.clientOptions(), OptionClientRedactor(redactor),
+		OptionClientLogger(withRedaction(p.settings.logger(), redactor)))...)
+
+	apiKey := keys.primary
+	keyMessage := ""
+	if err = client.CheckAPIKey(keys.primary); err != nil {
+		if !errors.Is(err, errAuthorizationDenied) || keys.secondary == "" {
+			return &backend.CheckHealthResult{
+				Status:  backend.HealthStatusError,
+				Message: client.redactor.redact(describeHealthError(err)),
+			}, nil
+		}
+		if err = client.CheckAPIKey(keys.secondary); err != nil {
+			return &backend.CheckHealthResult{
+				Status:  backend.HealthStatusError,
+				Message: "both the primary and the secondary API keys were rejected: " + client.redactor.redact(describeHealthError(err)),
+			}, nil
+		}
+		p.keyFallback.activate(loggerOrDefault(p.logger))
+		apiKey = keys.secondary
+		keyMessage = ", but degraded: the primary API key was rejected and the secondary one is in use"
+	} else {
+		p.keyFallback.reset()
+	}
 
-	if err = client.CheckAPIKey(apiKey); err != nil {
+	details, err := p.diagnose(withDataAPIKey(ctx, keys.data), client, apiKey)
+	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
-			Message: err.Error(),
+			Message: client.redactor.redact(describeHealthError(err)),
 		}, nil
 	}
+	details.SecondaryKeyInUse = apiKey != keys.primary
+	if p.selfTest != nil {
+		details.SelfTest = p.selfTest.latest()
+	}
 
 	if p.pulsarClient == nil {
 		p.pulsarClient = client
 	}
 
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "Data source status correct",
-	}, nil
-}
-
-func getAPIKeyFromContext(pluginContext backend.PluginContext) (string, error) {
-	if pluginContext.DataSourceInstanceSettings == nil {
-		return "", errDataSourceInstanceSettingsNil
-	}
-
-	dsis := pluginContext.DataSourceInstanceSettings
-	if dsis.DecryptedSecureJSONData == nil {
-		return "", errDecryptedSecureDataNil
+	if degradation := p.degradation(); degradation != "" {
+		details.Degraded = true
+		if keyMessage == "" {
+			keyMessage = ", but degraded: " + degradation
+		} else {
+			keyMessage += ", and " + degradation
+		}
 	}
 
-	apiKey, exists := dsis.DecryptedSecureJSONData[APIKey]
-	if !exists {
-		return "", errAPIKeyNotFound
+	message := fmt.Sprintf("Data source status correct%s (%s)", keyMessage, details.message())
+	if failedOver, since := p.pulsarClient.FailoverStatus(); failedOver {
+		details.FallbackSince = &since
+		message = fmt.Sprintf("%s, and the primary NS1 endpoint is failing: using the fallback "+
+			"endpoint since %s", message, since.Format(time.RFC3339))
 	}
 
-	return apiKey, nil
+	return &backend.CheckHealthResult{
+		Status:      backend.HealthStatusOk,
+		Message:     message,
+		JSONDetails: details.toJSON(),
+	}, nil
 }
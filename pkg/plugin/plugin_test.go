@@ -6,10 +6,13 @@ package plugin_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
-	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/plugin"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
 )
 
 // This is where the tests for the datasource backend live.
@@ -32,3 +35,45 @@ func TestQueryData(t *testing.T) {
 		t.Fatal("QueryData must return a response")
 	}
 }
+
+func TestQueryDataFakeNS1(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionAPIKey("key"), testutil.OptionValue("job1", 42))
+	defer server.Close()
+
+	instanceSettings := backend.DataSourceInstanceSettings{
+		JSONData:                []byte(fmt.Sprintf(`{"endpoint": %q}`, server.URL)),
+		DecryptedSecureJSONData: map[string]string{plugin.APIKey: "key"},
+	}
+	instance, err := plugin.NewPulsarDatasource(instanceSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := instance.(*plugin.PulsarDatasource)
+	defer ds.Dispose()
+
+	to := time.Unix(1600000000, 0)
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &instanceSettings},
+		Queries: []backend.DataQuery{{
+			RefID:         "A",
+			JSON:          []byte(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50"}`),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	values := res.Frames[0].Fields[1]
+	if values.Len() != 60 || values.At(0) != 42.0 {
+		t.Errorf("expected a point of 42 per minute, got %d points", values.Len())
+	}
+	if server.NS1.Requests("/pulsar/apps") != 1 {
+		t.Errorf("expected the apps to be fetched once, got %d requests", server.NS1.Requests("/pulsar/apps"))
+	}
+}
@@ -0,0 +1,99 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// queryTypeDNSQPS is the type of the queries of the NS1 DNS queries per
+	// second, instead of the Pulsar data.
+	queryTypeDNSQPS = "dnsQps"
+	// qpsLag is how late the NS1 QPS are, the rate being computed over the
+	// minute before.
+	qpsLag = 30 * time.Second
+)
+
+var (
+	errZoneNotFound    = errors.New("NS1 zone or record not found")
+	errInvalidQPSQuery = newInvalidQueryError("invalid DNS QPS query, the record needs its zone and type")
+)
+
+// qpsPath returns the stats/qps path of the account, of the zone, or of the
+// record.
+func qpsPath(zone, domain, recordType string) string {
+	path := "stats/qps"
+	if zone != "" {
+		path += "/" + url.PathEscape(zone)
+	}
+	if domain != "" {
+		path += "/" + url.PathEscape(domain) + "/" + url.PathEscape(strings.ToUpper(recordType))
+	}
+	return path
+}
+
+// GetQPS returns the DNS queries per second of the account, of the zone, or
+// of the record, when set. NS1 only serves the current rate, lagging by about
+// 30 seconds.
+func (pc *PulsarClient) GetQPS(ctx context.Context, apiKey string, qm *queryModel) (qps float64, err error) {
+	ctx, span := startSpan(ctx, "GetQPS", "zone", qm.Zone, "domain", qm.Domain, "type", qm.RecordType)
+	defer func() { span.end(err) }()
+
+	body := struct {
+		QPS *float64 `json:"qps"`
+	}{}
+	if err = pc.getJSON(ctx, apiKey, qpsPath(qm.Zone, qm.Domain, qm.RecordType), errZoneNotFound, &body); err != nil {
+		return 0, err
+	}
+	if body.QPS == nil {
+		return 0, fmt.Errorf("%w: no qps in the NS1 response", errUnexpectedStatus)
+	}
+
+	return *body.QPS, nil
+}
+
+// qpsLabel returns the name of the QPS series: the alias if any, or what the
+// QPS are of.
+func qpsLabel(qm *queryModel) string {
+	switch {
+	case qm.Alias != "":
+		return qm.Alias
+	case qm.Domain != "":
+		return fmt.Sprintf("%s %s QPS", qm.Domain, strings.ToUpper(qm.RecordType))
+	case qm.Zone != "":
+		return qm.Zone + " QPS"
+	default:
+		return "account QPS"
+	}
+}
+
+// queryDNSQPS answers the DNS QPS queries with a time series of the current
+// QPS, the only value NS1 serves: the panels refreshing regularly build up
+// the history.
+func (p *PulsarDatasource) queryDNSQPS(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if qm.Domain != "" && (qm.Zone == "" || qm.RecordType == "") {
+		return backend.DataResponse{Error: errInvalidQPSQuery}
+	}
+
+	qps, err := p.pulsarAPI().GetQPS(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	frame := data.NewFrame("qps",
+		data.NewField("time", nil, []time.Time{time.Now().Add(-qpsLag)}),
+		data.NewField(qpsLabel(qm), nil, []float64{qps}),
+	)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
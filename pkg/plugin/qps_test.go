@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryDNSQPS(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/stats/qps/missing.com" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "zone not found"}`)
+			return
+		}
+		fmt.Fprint(w, `{"qps": 12.5}`)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	tests := []struct {
+		qm    queryModel
+		path  string
+		label string
+	}{
+		{queryModel{}, "/stats/qps", "account QPS"},
+		{queryModel{Zone: "example.com"}, "/stats/qps/example.com", "example.com QPS"},
+		{queryModel{Zone: "example.com", Domain: "www.example.com", RecordType: "a"},
+			"/stats/qps/example.com/www.example.com/A", "www.example.com A QPS"},
+		{queryModel{Zone: "example.com", Alias: "DNS"}, "/stats/qps/example.com", "DNS"},
+	}
+	for _, tt := range tests {
+		paths = nil
+		res := p.queryDNSQPS(context.Background(), "key", &tt.qm)
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		if len(paths) != 1 || paths[0] != tt.path {
+			t.Errorf("expected a request to %s, got %v", tt.path, paths)
+		}
+		field := res.Frames[0].Fields[1]
+		if field.Name != tt.label || field.At(0).(float64) != 12.5 {
+			t.Errorf("expected %s at 12.5, got %s at %v", tt.label, field.Name, field.At(0))
+		}
+	}
+
+	res := p.queryDNSQPS(context.Background(), "key", &queryModel{Zone: "missing.com"})
+	if !errors.Is(res.Error, errZoneNotFound) {
+		t.Errorf("expected errZoneNotFound, got %v", res.Error)
+	}
+	res = p.queryDNSQPS(context.Background(), "key", &queryModel{Domain: "www.example.com"})
+	if !errors.Is(res.Error, errInvalidQPSQuery) {
+		t.Errorf("expected errInvalidQPSQuery, got %v", res.Error)
+	}
+}
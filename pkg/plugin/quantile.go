@@ -0,0 +1,104 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var errInvalidQuantile = newInvalidQueryError("invalid quantile, expected a quantile between 0 and 1 and an interval such as 1h")
+
+// quantileInterval returns the buckets of the quantile of the query: its
+// QuantileInterval, or the interval of the panel.
+func (qm *queryModel) quantileInterval() (time.Duration, error) {
+	if qm.QuantileInterval != "" {
+		interval, err := parseDuration(qm.QuantileInterval)
+		if err != nil || interval <= 0 {
+			return 0, fmt.Errorf("%w: invalid interval %q", errInvalidQuantile, qm.QuantileInterval)
+		}
+		return interval, nil
+	}
+	return qm.panelInterval(), nil
+}
+
+// panelInterval returns the interval of the panel of the query, or the range
+// split in max data points buckets when Grafana doesn't send it, at least a
+// second.
+func (qm *queryModel) panelInterval() time.Duration {
+	if qm.Interval > 0 {
+		return qm.Interval
+	}
+	if qm.MaxDataPoints > 0 {
+		if interval := qm.To.Sub(qm.From) / time.Duration(qm.MaxDataPoints); interval > time.Second {
+			return interval
+		}
+	}
+	return time.Second
+}
+
+// checkQuantile rejects the quantiles out of ]0, 1] and the invalid
+// intervals.
+func checkQuantile(qm *queryModel) error {
+	if qm.Quantile == 0 && qm.QuantileInterval == "" {
+		return nil
+	}
+	if qm.Quantile <= 0 || qm.Quantile > 1 {
+		return fmt.Errorf("%w: %v", errInvalidQuantile, qm.Quantile)
+	}
+	_, err := qm.quantileInterval()
+	return err
+}
+
+// quantileLabel describes the quantile in the legend, e.g. p95 per 1h0m0s.
+func quantileLabel(quantile float64, interval time.Duration) string {
+	return fmt.Sprintf("p%s per %s", strconv.FormatFloat(math.Round(quantile*1000)/10, 'f', -1, 64), interval)
+}
+
+// quantileOverTime rolls the series up to buckets of the interval, aligned on
+// the epoch, each point being the quantile of the points of its bucket, at the
+// start of the bucket, along with the number of points of the bucket.
+// Averaging percentiles misleads, e.g. the mean of the per minute p50s hides
+// the slow minutes of the hour, the p95 of them doesn't.
+func quantileOverTime(times []time.Time, values []float64, interval time.Duration, quantile float64) ([]time.Time, []float64, []int64) {
+	var (
+		bucketTimes  []time.Time
+		bucketValues []float64
+		counts       []int64
+		bucket       []float64
+	)
+	for i := range times {
+		start := times[i].Truncate(interval)
+		if len(bucketTimes) == 0 || !start.Equal(bucketTimes[len(bucketTimes)-1]) {
+			if len(bucket) > 0 {
+				bucketValues = append(bucketValues, quantileOf(bucket, quantile))
+				counts = append(counts, int64(len(bucket)))
+			}
+			bucketTimes = append(bucketTimes, start)
+			bucket = bucket[:0]
+		}
+		bucket = append(bucket, values[i])
+	}
+	if len(bucket) > 0 {
+		bucketValues = append(bucketValues, quantileOf(bucket, quantile))
+		counts = append(counts, int64(len(bucket)))
+	}
+	return bucketTimes, bucketValues, counts
+}
+
+// quantileOf returns the quantile of the values, interpolated between the
+// closest ranks. The values are sorted in place.
+func quantileOf(values []float64, quantile float64) float64 {
+	sort.Float64s(values)
+	rank := quantile * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	if lower+1 >= len(values) {
+		return values[len(values)-1]
+	}
+	return values[lower] + (rank-float64(lower))*(values[lower+1]-values[lower])
+}
@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQuantileOverTime(t *testing.T) {
+	start := time.Unix(1600000200, 0)
+	var (
+		times  []time.Time
+		values []float64
+	)
+	// Two buckets of 10 minutes, the first one with a slow minute.
+	for i := 0; i < 20; i++ {
+		times = append(times, start.Add(time.Duration(i)*time.Minute))
+		values = append(values, float64(10+i%10))
+	}
+	values[3] = 100
+
+	bucketTimes, bucketValues, counts := quantileOverTime(times, values, 10*time.Minute, 0.5)
+	if len(bucketTimes) != 2 || !bucketTimes[0].Equal(start.Truncate(10*time.Minute)) {
+		t.Fatalf("expected 2 buckets aligned on 10 minutes, got %v", bucketTimes)
+	}
+	if bucketValues[0] != 15.5 || bucketValues[1] != 14.5 {
+		t.Errorf("expected the medians of the buckets, got %v", bucketValues)
+	}
+	if counts[0] != 10 || counts[1] != 10 {
+		t.Errorf("expected the points of the buckets, got %v", counts)
+	}
+
+	if _, max, _ := quantileOverTime(times, values, time.Hour, 1); max[0] != 100 {
+		t.Errorf("expected the max as the quantile 1, got %v", max)
+	}
+	if got := quantileOf([]float64{4, 1, 3, 2}, 0.5); got != 2.5 {
+		t.Errorf("expected the median interpolated between the closest ranks, got %v", got)
+	}
+}
+
+func TestQueryQuantile(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 20))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600005600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-6 * time.Hour), To: to},
+			Interval:      time.Minute,
+			MaxDataPoints: 10,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50", "quantile": 0.95, "quantileInterval": "1h"}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	values := res.Frames[0].Fields[1]
+	// The point at the end of the range starts a seventh hour.
+	if values.Len() != 7 || values.At(0) != 20.0 {
+		t.Errorf("expected a point per hour over the whole range, got %d", values.Len())
+	}
+	if name := values.Config.DisplayNameFromDS; !strings.HasSuffix(name, " p95 per 1h0m0s") {
+		t.Errorf("expected the quantile in the legend, got %q", name)
+	}
+
+	for _, json := range []string{
+		`{"appid": "app1", "jobid": "job1", "quantile": 95}`,
+		`{"appid": "app1", "jobid": "job1", "quantile": 0.9, "quantileInterval": "soon"}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidQuantile) {
+			t.Errorf("%s: expected errInvalidQuantile, got %v", json, res.Error)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type queryDebugContextKey struct{}
+
+// debugRequest is a request sent to NS1 by a query in debug mode.
+type debugRequest struct {
+	method   string
+	url      string
+	status   int
+	duration time.Duration
+	err      string
+	cached   bool
+}
+
+func (r debugRequest) String() string {
+	switch {
+	case r.cached:
+		return fmt.Sprintf("%s %s: served from the cache", r.method, r.url)
+	case r.err != "":
+		return fmt.Sprintf("%s %s: failed after %s: %s", r.method, r.url, r.duration, r.err)
+	default:
+		return fmt.Sprintf("%s %s: %d %s in %s", r.method, r.url, r.status, http.StatusText(r.status), r.duration)
+	}
+}
+
+// queryDebug collects the requests sent to NS1 by a query with the debug
+// flag, and the number of points NS1 returned, so an empty panel can be
+// explained from the query inspector. A nil queryDebug collects nothing.
+type queryDebug struct {
+	lock      sync.Mutex
+	requests  []debugRequest
+	rawPoints int
+}
+
+func withQueryDebug(ctx context.Context, debug *queryDebug) context.Context {
+	return context.WithValue(ctx, queryDebugContextKey{}, debug)
+}
+
+func queryDebugFromContext(ctx context.Context) *queryDebug {
+	debug, _ := ctx.Value(queryDebugContextKey{}).(*queryDebug)
+	return debug
+}
+
+func (d *queryDebug) addRequest(r debugRequest) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.requests = append(d.requests, r)
+}
+
+// addRequests adds the requests of other, the requests shared with other
+// queries.
+func (d *queryDebug) addRequests(other *queryDebug) {
+	if d == nil || other == nil {
+		return
+	}
+	other.lock.Lock()
+	requests := append([]debugRequest(nil), other.requests...)
+	other.lock.Unlock()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.requests = append(d.requests, requests...)
+}
+
+// addRawPoints counts the points returned by NS1, before they are cut to the
+// max data points of the query.
+func (d *queryDebug) addRawPoints(points int) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.rawPoints += points
+}
+
+// addTo adds the requests to the executed query of the frames of the
+// response, shown by the query inspector, and the point counts to their
+// stats.
+func (d *queryDebug) addTo(res *backend.DataResponse) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	lines := make([]string, 0, len(d.requests)+1)
+	for _, r := range d.requests {
+		lines = append(lines, r.String())
+	}
+	if len(d.requests) == 0 {
+		lines = append(lines, "no request sent to NS1")
+	}
+	if res.Error != nil {
+		lines = append(lines, "error: "+res.Error.Error())
+	}
+
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = strings.Join(lines, "\n")
+		frame.Meta.Stats = append(frame.Meta.Stats,
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "NS1 requests"}, Value: float64(len(d.requests))},
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Points returned by NS1"}, Value: float64(d.rawPoints)},
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Points in the frame"}, Value: float64(frame.Rows())},
+		)
+	}
+}
+
+// queryDebugTransport records the requests of the queries in debug mode, the
+// API keys and credentials redacted.
+type queryDebugTransport struct {
+	next     http.RoundTripper
+	redactor *redactor
+}
+
+func (t *queryDebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	debug := queryDebugFromContext(req.Context())
+	if debug == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	r := debugRequest{
+		method:   req.Method,
+		url:      t.redactor.redact(redactURL(req.URL.String())),
+		duration: time.Since(start).Round(time.Millisecond),
+	}
+	if err != nil {
+		r.err = t.redactor.redact(err.Error())
+	} else {
+		r.status = resp.StatusCode
+	}
+	debug.addRequest(r)
+	return resp, err
+}
@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryDebug(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 12))
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.Endpoint = server.URL
+	settings.CacheTTL = Duration(time.Minute)
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(settings.clientOptions()...)}
+	to := time.Unix(1600000000, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "secret-key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 10,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "debug": true}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	meta := res.Frames[0].Meta
+	if !strings.Contains(meta.ExecutedQueryString, "/pulsar/query/performance/time?") ||
+		!strings.Contains(meta.ExecutedQueryString, "200 OK") || strings.Contains(meta.ExecutedQueryString, "secret-key") {
+		t.Errorf("expected the redacted request to NS1, got %q", meta.ExecutedQueryString)
+	}
+	stats := make(map[string]float64)
+	for _, stat := range meta.Stats {
+		stats[stat.DisplayName] = stat.Value
+	}
+	// The range widened to the cache TTL holds 62 minutes.
+	if stats["Points returned by NS1"] != 62 || stats["Points in the frame"] != 10 {
+		t.Errorf("expected 62 points returned and 10 kept, got %v", stats)
+	}
+	if stats["Cache TTL"] != 60 || stats["Cache hits"] != 0 || stats["Cache misses"] != 1 {
+		t.Errorf("expected the cache TTL of the results and a cache miss, got %v", stats)
+	}
+
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "debug": true}`)
+	meta = res.Frames[0].Meta
+	if !strings.Contains(meta.ExecutedQueryString, "served from the cache") {
+		t.Errorf("expected the data to be served from the cache, got %q", meta.ExecutedQueryString)
+	}
+	for _, stat := range meta.Stats {
+		stats[stat.DisplayName] = stat.Value
+	}
+	if stats["Cache hits"] != 1 || stats["Cache misses"] != 0 {
+		t.Errorf("expected a cache hit, got %v", stats)
+	}
+
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`)
+	if meta := res.Frames[0].Meta; meta.ExecutedQueryString != "" {
+		t.Errorf("expected no debug output without the flag, got %q", meta.ExecutedQueryString)
+	}
+}
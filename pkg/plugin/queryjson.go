@@ -0,0 +1,295 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryModelVersion is the version of the query model. The queries saved
+// before the versioning carry none and are parsed as version 1.
+const queryModelVersion = 1
+
+var errInvalidQuery = errors.New("invalid query")
+
+// invalidQueryError is a sentinel of a query failing its validation. It
+// matches errInvalidQuery too, so the invalid queries are told apart at once.
+type invalidQueryError struct {
+	message string
+}
+
+func (e *invalidQueryError) Error() string {
+	return e.message
+}
+
+func (e *invalidQueryError) Unwrap() error {
+	return errInvalidQuery
+}
+
+// newInvalidQueryError returns a sentinel of the validation of the queries.
+func newInvalidQueryError(message string) error {
+	return &invalidQueryError{message: message}
+}
+
+// grafanaQueryFields are the fields Grafana adds to every query, ignored by
+// the datasource.
+var grafanaQueryFields = map[string]bool{
+	"refId": true, "hide": true, "key": true, "datasource": true, "datasourceId": true,
+	"intervalMs": true, "maxDataPoints": true,
+}
+
+// queryModelFields maps the JSON names of the query model to the index of
+// their field.
+var queryModelFields = func() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(queryModel{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// parse decodes the JSON of the query into the model, field by field. The
+// strings, numbers and booleans are converted to the type of their field when
+// unambiguous, e.g. a numeric job ID or a threshold typed as a string, and the
+// fields that can't be are all reported in the error. The unknown fields, e.g.
+// a typo, are returned as warnings rather than silently ignored.
+func (qm *queryModel) parse(raw []byte) (warnings []string, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	// The agg can be a list, a field per aggregation, the first one being
+	// the aggregation of the query.
+	if raw := bytes.TrimSpace(fields["agg"]); len(raw) > 0 && raw[0] == '[' {
+		if err = json.Unmarshal(raw, &qm.Aggregations); err != nil {
+			return nil, fmt.Errorf("%w: agg: expected a string or an array of strings", errInvalidQuery)
+		}
+		if len(qm.Aggregations) > 0 {
+			qm.Aggregation = qm.Aggregations[0]
+		}
+		delete(fields, "agg")
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fieldErrors []string
+	v := reflect.ValueOf(qm).Elem()
+	for _, name := range names {
+		index, known := queryModelFields[name]
+		if !known {
+			if !grafanaQueryFields[name] {
+				warnings = append(warnings, unknownFieldWarning(name))
+			}
+			continue
+		}
+		if err := decodeQueryField(fields[name], v.Field(index)); err != nil {
+			fieldErrors = append(fieldErrors, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return warnings, fmt.Errorf("%w: %s", errInvalidQuery, strings.Join(fieldErrors, "; "))
+	}
+
+	if qm.Version > queryModelVersion {
+		return warnings, fmt.Errorf("%w: version %d is newer than the version %d supported by the datasource, "+
+			"upgrade the plugin", errInvalidQuery, qm.Version, queryModelVersion)
+	}
+	if qm.Version == 0 {
+		qm.Version = queryModelVersion
+	}
+	return warnings, nil
+}
+
+// decodeQueryField decodes the JSON value into the field of the query model,
+// null leaving the field empty.
+func decodeQueryField(raw json.RawMessage, field reflect.Value) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			field.SetString(v)
+		case json.Number:
+			field.SetString(v.String())
+		case bool:
+			field.SetString(strconv.FormatBool(v))
+		default:
+			return fmt.Errorf("expected a string, got %s", jsonKind(value))
+		}
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			field.SetBool(v)
+		case string, json.Number:
+			b, err := strconv.ParseBool(fmt.Sprint(v))
+			if err != nil {
+				return fmt.Errorf("expected a boolean, got %q", v)
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("expected a boolean, got %s", jsonKind(value))
+		}
+	case reflect.Float64, reflect.Int:
+		var s string
+		switch v := value.(type) {
+		case json.Number:
+			s = v.String()
+		case string:
+			if s = strings.TrimSpace(v); s == "" {
+				field.Set(reflect.Zero(field.Type()))
+				return nil
+			}
+		default:
+			return fmt.Errorf("expected a number, got %s", jsonKind(value))
+		}
+		if field.Kind() == reflect.Int {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("expected an integer, got %q", s)
+			}
+			field.SetInt(int64(n))
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", s)
+		}
+		field.SetFloat(f)
+	default:
+		// The nested objects are decoded as is, their unknown fields rejected.
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		target := reflect.New(field.Type())
+		if err := decoder.Decode(target.Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return fmt.Errorf("expected %s, got %s", jsonKindOf(typeErr.Type), typeErr.Value)
+			}
+			return err
+		}
+		field.Set(target.Elem())
+	}
+	return nil
+}
+
+// jsonKind names the kind of the decoded JSON value in the errors.
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// jsonKindOf names the JSON kind expected for the type in the errors.
+func jsonKindOf(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Float64, reflect.Int, reflect.Int64:
+		return "a number"
+	case reflect.Slice:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// unknownFieldWarning warns about an unknown field of the query, suggesting
+// the known field it may be a typo of.
+func unknownFieldWarning(name string) string {
+	warning := fmt.Sprintf("unknown query field %q ignored", name)
+	lower := strings.ToLower(name)
+	best, bestDistance := "", 3
+	for known := range queryModelFields {
+		knownLower := strings.ToLower(known)
+		distance := editDistance(lower, knownLower)
+		if strings.HasPrefix(lower, knownLower) || strings.HasPrefix(knownLower, lower) {
+			distance = 0
+		}
+		if distance < bestDistance || (distance == bestDistance && best != "" && known < best) {
+			best, bestDistance = known, distance
+		}
+	}
+	if best != "" {
+		warning += fmt.Sprintf(", did you mean %q?", best)
+	}
+	return warning
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// addQueryWarnings adds the warnings of the parsing of the query to the frames
+// of the response, shown on the panel.
+func addQueryWarnings(res *backend.DataResponse, warnings []string) {
+	for _, frame := range res.Frames {
+		for _, warning := range warnings {
+			frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: warning})
+		}
+	}
+}
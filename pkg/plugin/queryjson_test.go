@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	var qm queryModel
+	warnings, err := qm.parse([]byte(`{"refId": "A", "datasource": {"uid": "x"}, "appid": "app1", "jobid": 42,
+		"threshold": "99.5", "usageByNetwork": "true", "noData": null, "aggregation": "p95"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qm.AppID != "app1" || qm.JobID != "42" || qm.Threshold != 99.5 || !qm.UsageByNetwork || qm.Version != queryModelVersion {
+		t.Errorf("unexpected query %+v", qm)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `"aggregation"`) || !strings.Contains(warnings[0], `did you mean "agg"?`) {
+		t.Errorf("expected a warning about the aggregation field, got %v", warnings)
+	}
+
+	tests := []struct {
+		json   string
+		errors []string
+	}{
+		{`{"appid": ["app1"], "threshold": "high"}`, []string{"appid: expected a string, got an array", `threshold: expected a number, got "high"`}},
+		{`{"usageByNetwork": "maybe"}`, []string{`usageByNetwork: expected a boolean, got "maybe"`}},
+		{`{"condition": {"operator": "gt", "valeu": 1}}`, []string{"condition:", `unknown field "valeu"`}},
+		{`{"version": 99}`, []string{"version 99 is newer"}},
+	}
+	for _, tt := range tests {
+		var qm queryModel
+		_, err := qm.parse([]byte(tt.json))
+		if !errors.Is(err, errInvalidQuery) {
+			t.Errorf("%s: expected errInvalidQuery, got %v", tt.json, err)
+			continue
+		}
+		for _, message := range tt.errors {
+			if !strings.Contains(err.Error(), message) {
+				t.Errorf("%s: expected %q in %q", tt.json, message, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// keyParamPattern matches the credentials passed as URL query parameters.
+	keyParamPattern = regexp.MustCompile(`(?i)([?&](?:api[_-]?key|key|token|x-nsone-key)=)[^&#\s"']+`)
+	// userinfoPattern matches the credentials of the URLs.
+	userinfoPattern = regexp.MustCompile(`(://)[^/?#\s@"']+@`)
+)
+
+// redactor removes the NS1 API keys it knows of, and the credentials of the
+// URLs, from the text leaving the plugin: logs, errors, frames and diagnostic
+// bundles. The keys are added as they are used, so the keys read from an
+// environment variable or a file are covered too. A nil redactor only
+// removes the credentials of the URLs.
+type redactor struct {
+	lock    sync.RWMutex
+	secrets map[string]struct{}
+}
+
+func newRedactor(secrets ...string) *redactor {
+	r := &redactor{secrets: make(map[string]struct{})}
+	r.add(secrets...)
+	return r
+}
+
+func (r *redactor) add(secrets ...string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, secret := range secrets {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			r.secrets[secret] = struct{}{}
+		}
+	}
+}
+
+// redact returns s without the known keys nor the credentials of its URLs.
+func (r *redactor) redact(s string) string {
+	if r != nil {
+		r.lock.RLock()
+		for secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+		r.lock.RUnlock()
+	}
+
+	s = keyParamPattern.ReplaceAllString(s, "${1}"+redacted)
+	return userinfoPattern.ReplaceAllString(s, "${1}"+redacted+"@")
+}
+
+// redactError returns err with a redacted message. The original error is
+// still available to errors.Is and errors.As.
+func (r *redactor) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := err.Error(); r.redact(msg) != msg {
+		return &redactedError{err: err, msg: r.redact(msg)}
+	}
+	return err
+}
+
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactingLogger redacts the messages, and their string and error values,
+// before logging them.
+type redactingLogger struct {
+	next     log.Logger
+	redactor *redactor
+}
+
+func withRedaction(logger log.Logger, r *redactor) log.Logger {
+	return &redactingLogger{next: logger, redactor: r}
+}
+
+func (l *redactingLogger) args(args []interface{}) []interface{} {
+	redactedArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			redactedArgs[i] = l.redactor.redact(v)
+		case error:
+			redactedArgs[i] = l.redactor.redact(v.Error())
+		case fmt.Stringer:
+			redactedArgs[i] = l.redactor.redact(v.String())
+		default:
+			redactedArgs[i] = arg
+		}
+	}
+	return redactedArgs
+}
+
+func (l *redactingLogger) Debug(msg string, args ...interface{}) {
+	l.next.Debug(l.redactor.redact(msg), l.args(args)...)
+}
+
+func (l *redactingLogger) Info(msg string, args ...interface{}) {
+	l.next.Info(l.redactor.redact(msg), l.args(args)...)
+}
+
+func (l *redactingLogger) Warn(msg string, args ...interface{}) {
+	l.next.Warn(l.redactor.redact(msg), l.args(args)...)
+}
+
+func (l *redactingLogger) Error(msg string, args ...interface{}) {
+	l.next.Error(l.redactor.redact(msg), l.args(args)...)
+}
+
+// redactingTransport learns the API key of every NS1 request and redacts the
+// errors of the requests, which hold their URL.
+type redactingTransport struct {
+	next     http.RoundTripper
+	redactor *redactor
+}
+
+func (t *redactingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.redactor.add(req.Header.Get(apiKeyHeader))
+
+	resp, err := t.next.RoundTrip(req)
+	return resp, t.redactor.redactError(err)
+}
+
+// redactAPIKey removes any occurrence of the API key from s.
+func redactAPIKey(s, apiKey string) string {
+	if apiKey == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, apiKey, redacted)
+}
+
+// redactURL hides the credentials of the URL, if any.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return (*redactor)(nil).redact(rawURL)
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	return keyParamPattern.ReplaceAllString(u.String(), "${1}"+redacted)
+}
@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+)
+
+// The reducers of the queries returning a single value instead of the series.
+const (
+	reduceLast = "last"
+	reduceMean = "mean"
+	reduceMax  = "max"
+)
+
+var errInvalidReducer = newInvalidQueryError("invalid reducer, expected last, mean or max")
+
+// checkReducer rejects the unknown reducers.
+func checkReducer(qm *queryModel) error {
+	switch qm.Reduce {
+	case "", reduceLast, reduceMean, reduceMax:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errInvalidReducer, qm.Reduce)
+	}
+}
+
+// reduceValues reduces the series to a single value, none when the series is
+// empty.
+func reduceValues(values []float64, reducer string) []float64 {
+	if len(values) == 0 {
+		return []float64{}
+	}
+
+	result := values[len(values)-1]
+	switch reducer {
+	case reduceMean:
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		result = sum / float64(len(values))
+	case reduceMax:
+		result = values[0]
+		for _, value := range values[1:] {
+			if value > result {
+				result = value
+			}
+		}
+	}
+	return []float64{result}
+}
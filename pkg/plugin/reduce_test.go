@@ -0,0 +1,73 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestReduceValues(t *testing.T) {
+	values := []float64{20, 80, 30, 10}
+	for reducer, expected := range map[string]float64{reduceLast: 10, reduceMean: 35, reduceMax: 80} {
+		if reduced := reduceValues(values, reducer); len(reduced) != 1 || reduced[0] != expected {
+			t.Errorf("%s: expected %v, got %v", reducer, expected, reduced)
+		}
+	}
+	if reduced := reduceValues(nil, reduceMax); len(reduced) != 0 {
+		t.Errorf("expected no value for an empty series, got %v", reduced)
+	}
+}
+
+func TestQueryReduce(t *testing.T) {
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			fmt.Fprint(w, `[{"timestamp": 1600000000, "job1": 120}, {"timestamp": 1600000060, "job1": 300},
+				{"timestamp": 1600000120, "job1": 90}]`)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	query := func(reducer string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID: "A",
+			JSON: []byte(fmt.Sprintf(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "reduce": %q}`,
+				reducer)),
+			TimeRange: backend.TimeRange{From: time.Unix(1599999000, 0), To: time.Unix(1600001000, 0)},
+			// The reducers ignore the maximum number of points of the panel.
+			MaxDataPoints: 1,
+		})
+	}
+
+	res := query(reduceMax)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if len(frame.Fields) != 1 || frame.Fields[0].Len() != 1 || frame.Fields[0].At(0).(float64) != 300 {
+		t.Fatalf("expected a single value, got %v", frame.Fields)
+	}
+	if frame.Fields[0].Labels["jobid"] != "job1" || frame.TimeSeriesSchema().Type != data.TimeSeriesTypeNot {
+		t.Errorf("expected a labelled number, got %v", frame.Fields[0].Labels)
+	}
+
+	if res = query("median"); !errors.Is(res.Error, errInvalidReducer) {
+		t.Errorf("expected errInvalidReducer, got %v", res.Error)
+	}
+}
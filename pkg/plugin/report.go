@@ -0,0 +1,216 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// queryTypeReport is the type of the queries of the NS1 reports, the
+	// datasets NS1 generates asynchronously for the long time ranges.
+	queryTypeReport = "report"
+	// defaultReportTimeout is how long a report can take to be generated.
+	defaultReportTimeout = time.Minute
+	// reportScopeAccount is the scope of the reports by default.
+	reportScopeAccount = "account"
+)
+
+// The statuses of the NS1 reports.
+const (
+	reportStatusAvailable = "available"
+	reportStatusFailed    = "failed"
+)
+
+var (
+	errReportNotFound     = errors.New("NS1 report not found")
+	errReportFailed       = errors.New("NS1 failed to generate the report")
+	errReportTimeout      = errors.New("NS1 took too long to generate the report, select a shorter time range")
+	errInvalidReportQuery = newInvalidQueryError("invalid report query, the report needs its type")
+)
+
+// reportPollInterval is how often the status of a report is checked.
+var reportPollInterval = 2 * time.Second
+
+// reportLayouts are the layouts of the times of the reports.
+var reportLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// dataset is a NS1 dataset, the definition of a report, along with the
+// reports generated out of it.
+type dataset struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Datatype struct {
+		Type  string            `json:"type"`
+		Scope string            `json:"scope"`
+		Data  map[string]string `json:"data"`
+	} `json:"datatype"`
+	Timeframe struct {
+		Aggregation string `json:"aggregation"`
+		From        int64  `json:"from"`
+		To          int64  `json:"to"`
+	} `json:"timeframe"`
+	ExportType      string   `json:"export_type"`
+	RecipientEmails []string `json:"recipient_emails"`
+	Reports         []struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	} `json:"reports,omitempty"`
+}
+
+// GetReport requests the report of the query, waits up to maxWait for NS1 to
+// generate it, and returns its rows, the header first. The dataset is deleted
+// once done.
+func (pc *PulsarClient) GetReport(ctx context.Context, apiKey string, qm *queryModel, maxWait time.Duration) (rows [][]string, err error) {
+	ctx, span := startSpan(ctx, "GetReport", "type", qm.ReportType)
+	defer func() { span.end(err) }()
+
+	request := dataset{Name: fmt.Sprintf("grafana %s %d", qm.ReportType, time.Now().Unix()), ExportType: "csv"}
+	request.Datatype.Type = qm.ReportType
+	request.Datatype.Scope = qm.ReportScope
+	if request.Datatype.Scope == "" {
+		request.Datatype.Scope = reportScopeAccount
+	}
+	request.Datatype.Data = map[string]string{}
+	request.Timeframe.Aggregation = "daily"
+	request.Timeframe.From = qm.From.Unix()
+	request.Timeframe.To = qm.To.Unix()
+	request.RecipientEmails = []string{}
+
+	var created dataset
+	if err = pc.sendJSON(ctx, apiKey, http.MethodPost, "datasets", &request, errReportNotFound, &created); err != nil {
+		return nil, err
+	}
+	path := "datasets/" + url.PathEscape(created.ID)
+	defer func() {
+		// The dataset is of no use once read, don't let them pile up.
+		if err := pc.sendJSON(context.Background(), apiKey, http.MethodDelete, path, nil, errReportNotFound, nil); err != nil {
+			pc.logger.Warn("Failed to delete the NS1 dataset", "dataset", created.ID, "error", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+	reportID := ""
+	for {
+		var current dataset
+		if err = pc.getJSON(ctx, apiKey, path, errReportNotFound, &current); err != nil {
+			return nil, reportError(ctx, err)
+		}
+		for _, report := range current.Reports {
+			switch report.Status {
+			case reportStatusAvailable:
+				reportID = report.ID
+			case reportStatusFailed:
+				return nil, errReportFailed
+			}
+		}
+		if reportID != "" {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, reportError(ctx, ctx.Err())
+		case <-time.After(reportPollInterval):
+		}
+	}
+
+	resp, err := pc.send(ctx, apiKey, http.MethodGet, path+"/reports/"+url.PathEscape(reportID), nil, errReportNotFound)
+	if err != nil {
+		return nil, reportError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	return csv.NewReader(resp.Body).ReadAll()
+}
+
+// reportError reports the requests interrupted by the deadline of the report
+// as the report taking too long.
+func reportError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errReportTimeout
+	}
+	return err
+}
+
+// reportField converts a column of the report into a field of numbers, or of
+// times, when all its values are, or of strings otherwise.
+func reportField(name string, values []string) *data.Field {
+	numbers := make([]*float64, len(values))
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			numbers = nil
+			break
+		}
+		numbers[i] = &number
+	}
+	if numbers != nil {
+		return data.NewField(name, nil, numbers)
+	}
+
+	for _, layout := range reportLayouts {
+		times := make([]*time.Time, len(values))
+		for i, value := range values {
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(layout, value)
+			if err != nil {
+				times = nil
+				break
+			}
+			times[i] = &t
+		}
+		if times != nil {
+			return data.NewField(name, nil, times)
+		}
+	}
+
+	return data.NewField(name, nil, values)
+}
+
+// queryReport answers the report queries with a frame of the report, a field
+// per column.
+func (p *PulsarDatasource) queryReport(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if qm.ReportType == "" {
+		return backend.DataResponse{Error: errInvalidReportQuery}
+	}
+
+	rows, err := p.pulsarAPI().GetReport(ctx, apiKey, qm, p.settings.reportTimeout())
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	frame := data.NewFrame("report")
+	if len(rows) > 0 {
+		for column, name := range rows[0] {
+			values := make([]string, 0, len(rows)-1)
+			for _, row := range rows[1:] {
+				value := ""
+				if column < len(row) {
+					value = row[column]
+				}
+				values = append(values, value)
+			}
+			frame.Fields = append(frame.Fields, reportField(name, values))
+		}
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
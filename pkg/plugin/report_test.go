@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueryReport(t *testing.T) {
+	defer func(interval time.Duration) { reportPollInterval = interval }(reportPollInterval)
+	reportPollInterval = time.Millisecond
+
+	var (
+		lock    sync.Mutex
+		polls   int
+		ready   = true
+		request dataset
+		deleted []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/datasets":
+			json.NewDecoder(r.Body).Decode(&request)
+			fmt.Fprint(w, `{"id": "d1"}`)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		case r.URL.Path == "/datasets/d1":
+			polls++
+			status := "generating"
+			if ready && polls > 1 {
+				status = "available"
+			}
+			fmt.Fprintf(w, `{"id": "d1", "reports": [{"id": "r1", "status": %q}]}`, status)
+		case r.URL.Path == "/datasets/d1/reports/r1":
+			fmt.Fprint(w, "date,network,queries\n2021-03-01,Managed,100\n2021-03-02,Managed,\n")
+		}
+	}))
+	defer server.Close()
+
+	settings := defaultSettings()
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := &queryModel{ReportType: "num_queries", From: time.Unix(1614556800, 0), To: time.Unix(1614729600, 0)}
+	res := p.queryReport(context.Background(), "key", qm)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if request.Datatype.Type != "num_queries" || request.Datatype.Scope != reportScopeAccount ||
+		request.Timeframe.From != 1614556800 || request.Timeframe.To != 1614729600 {
+		t.Errorf("unexpected dataset request %+v", request)
+	}
+	if polls != 2 {
+		t.Errorf("expected the report to be polled until available, got %d polls", polls)
+	}
+	if len(deleted) != 1 || deleted[0] != "/datasets/d1" {
+		t.Errorf("expected the dataset to be deleted, got %v", deleted)
+	}
+
+	frame := res.Frames[0]
+	if len(frame.Fields) != 3 || frame.Fields[0].Name != "date" {
+		t.Fatalf("expected a field per column, got %v", frame.Fields)
+	}
+	if day := frame.Fields[0].At(1).(*time.Time); day == nil || day.Day() != 2 {
+		t.Errorf("expected the dates to be parsed, got %v", day)
+	}
+	if network := frame.Fields[1].At(0); network != "Managed" {
+		t.Errorf("expected the network names as is, got %v", network)
+	}
+	if queries := frame.Fields[2]; *queries.At(0).(*float64) != 100 || queries.At(1).(*float64) != nil {
+		t.Errorf("expected the numbers to be parsed, got %v", queries)
+	}
+
+	ready = false
+	settings.ReportTimeout = Duration(20 * time.Millisecond)
+	if res = p.queryReport(context.Background(), "key", qm); !errors.Is(res.Error, errReportTimeout) {
+		t.Errorf("expected errReportTimeout, got %v", res.Error)
+	}
+	if res = p.queryReport(context.Background(), "key", &queryModel{}); !errors.Is(res.Error, errInvalidReportQuery) {
+		t.Errorf("expected errInvalidReportQuery, got %v", res.Error)
+	}
+}
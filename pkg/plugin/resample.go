@@ -0,0 +1,85 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// The methods of the resampling of the series onto the interval grid.
+const (
+	resampleLinear   = "linear"
+	resampleNearest  = "nearest"
+	resamplePrevious = "previous"
+)
+
+// maxResamplePoints caps the points of the grid, its interval widened to keep
+// within it.
+const maxResamplePoints = 100000
+
+var errInvalidResample = newInvalidQueryError("invalid resampling, expected linear, nearest or previous")
+
+// checkResample rejects the unknown methods, and the resampling of the
+// queries that don't return a series of the data.
+func checkResample(qm *queryModel) error {
+	switch qm.Resample {
+	case "":
+		return nil
+	case resampleLinear, resampleNearest, resamplePrevious:
+	default:
+		return fmt.Errorf("%w: %q", errInvalidResample, qm.Resample)
+	}
+	if qm.Reduce != "" || qm.Envelope {
+		return fmt.Errorf("%w: the resampling needs the series, not a reducer nor an envelope", errInvalidResample)
+	}
+	return nil
+}
+
+// resample returns the series at the multiples of the interval between its
+// first and its last point, so the series of the queries of a panel line up
+// point for point. The value at each time is interpolated linearly between
+// the points around it, or is the one of the nearest point, or of the latest
+// point before it.
+func resample(times []time.Time, values []float64, interval time.Duration, method string) ([]time.Time, []float64) {
+	if len(times) == 0 || interval <= 0 {
+		return times, values
+	}
+	first, last := times[0], times[len(times)-1]
+	if points := int64(last.Sub(first) / interval); points > maxResamplePoints {
+		interval *= time.Duration((points + maxResamplePoints - 1) / maxResamplePoints)
+	}
+
+	start := first.Truncate(interval)
+	if start.Before(first) {
+		start = start.Add(interval)
+	}
+	var (
+		gridTimes  []time.Time
+		gridValues []float64
+		j          int
+	)
+	for t := start; !t.After(last); t = t.Add(interval) {
+		// Move to the last point not after the time.
+		for j+1 < len(times) && !times[j+1].After(t) {
+			j++
+		}
+		value := values[j]
+		if j+1 < len(times) && !times[j].Equal(t) {
+			before, after := t.Sub(times[j]), times[j+1].Sub(t)
+			switch method {
+			case resampleLinear:
+				value += (values[j+1] - values[j]) * float64(before) / float64(before+after)
+			case resampleNearest:
+				if after < before {
+					value = values[j+1]
+				}
+			}
+		}
+		gridTimes = append(gridTimes, t)
+		gridValues = append(gridValues, value)
+	}
+	return gridTimes, gridValues
+}
@@ -0,0 +1,70 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestResample(t *testing.T) {
+	start := time.Unix(1600000010, 0)
+	// Points every 40 seconds, off the minute.
+	times := []time.Time{start, start.Add(40 * time.Second), start.Add(80 * time.Second), start.Add(120 * time.Second)}
+	values := []float64{0, 40, 80, 120}
+
+	tests := []struct {
+		method string
+		values []float64
+	}{
+		{resampleLinear, []float64{10, 70}},
+		{resampleNearest, []float64{0, 80}},
+		{resamplePrevious, []float64{0, 40}},
+	}
+	for _, tt := range tests {
+		gridTimes, gridValues := resample(times, values, time.Minute, tt.method)
+		if len(gridTimes) != 2 || gridTimes[0].Unix()%60 != 0 || !gridTimes[0].After(start) {
+			t.Fatalf("%s: expected 2 points on the minutes, got %v", tt.method, gridTimes)
+		}
+		if gridValues[0] != tt.values[0] || gridValues[1] != tt.values[1] {
+			t.Errorf("%s: expected %v, got %v", tt.method, tt.values, gridValues)
+		}
+	}
+}
+
+func TestQueryResample(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 10))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			Interval:      5 * time.Minute,
+			MaxDataPoints: 100,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "resample": "linear"}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	times := res.Frames[0].Fields[0]
+	if times.Len() != 12 || times.At(0).(time.Time).Unix()%300 != 0 {
+		t.Errorf("expected a point every 5 minutes, got %d", times.Len())
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "resample": "cubic"}`); !errors.Is(res.Error, errInvalidResample) {
+		t.Errorf("expected errInvalidResample, got %v", res.Error)
+	}
+}
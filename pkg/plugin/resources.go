@@ -0,0 +1,197 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// CallResource handles the resource calls sent from Grafana to the plugin,
+// routing them to the matching handler.
+func (p *PulsarDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	p.ensureInitialized()
+	if p.resourceHandler == nil {
+		p.resourceHandler = p.newResourceHandler()
+	}
+	return p.resourceHandler.CallResource(ctx, req, sender)
+}
+
+func (p *PulsarDatasource) newResourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/settings/validate", p.handleValidateSettings)
+	mux.HandleFunc("/health/details", p.handleHealthDetails)
+	mux.HandleFunc("/debug/bundle", p.handleDebugBundle)
+	mux.HandleFunc("/zones", p.handleZones)
+	mux.HandleFunc("/zones/", p.handleZones)
+	mux.HandleFunc("/networks", p.handleNetworks)
+	mux.HandleFunc("/apps", p.handleApps)
+	mux.HandleFunc("/thresholds", p.handleThresholds)
+	mux.HandleFunc("/export", p.handleExport)
+
+	return httpadapter.New(mux)
+}
+
+// validateSettingsRequest mirrors the datasource options of the configuration
+// page.
+type validateSettingsRequest struct {
+	JSONData         json.RawMessage   `json:"jsonData"`
+	SecureJSONData   map[string]string `json:"secureJsonData"`
+	SecureJSONFields map[string]bool   `json:"secureJsonFields"`
+}
+
+type validateSettingsResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// handleValidateSettings checks the datasource options before they are saved,
+// answering with 400 and the list of problems when they are not usable.
+func (p *PulsarDatasource) handleValidateSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req validateSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, validateSettingsResponse{
+			Errors: []string{"invalid request: " + err.Error()},
+		})
+		return
+	}
+
+	resp := validateSettingsResponse{Valid: true}
+
+	settings, err := parseSettings(backend.DataSourceInstanceSettings{JSONData: req.JSONData})
+	if err != nil {
+		resp.Errors = append(resp.Errors, err.Error())
+	} else if err = req.checkAPIKey(settings); err != nil {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	status := http.StatusOK
+	if len(resp.Errors) > 0 {
+		resp.Valid = false
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, resp)
+}
+
+// checkAPIKey makes sure an API key is being set, was already stored, or can
+// be read from the environment variable or file referenced by the settings.
+func (req *validateSettingsRequest) checkAPIKey(settings *Settings) error {
+	if req.SecureJSONData[APIKey] != "" || req.SecureJSONFields[APIKey] {
+		return nil
+	}
+	if settings.APIKeyEnv == "" && settings.APIKeyFile == "" {
+		return errors.New("an NS1 API key is required")
+	}
+
+	_, err := readExternalAPIKey(settings.APIKeyEnv, settings.APIKeyFile)
+	return err
+}
+
+// handleHealthDetails returns the latest result of the background self-test,
+// with a 503 status when it failed so it can be alerted on.
+func (p *PulsarDatasource) handleHealthDetails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p.selfTest == nil {
+		http.Error(w, "self-test not enabled (selfTestInterval)", http.StatusNotFound)
+		return
+	}
+
+	result := p.selfTest.latest()
+	switch {
+	case result == nil:
+		http.Error(w, "self-test not run yet", http.StatusServiceUnavailable)
+	case result.Status != selfTestStatusOK:
+		writeJSON(w, http.StatusServiceUnavailable, result)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		Logger.Error("Failed to write the resource response", "error", err)
+	}
+}
+
+// handleApps lists the apps, and their jobs, visible to the user, for the
+// template variables. The category and tag parameters, the latter as
+// <name>:<value> and repeatable, keep the apps with this metadata only, and
+// the jobType parameter the jobs of this type.
+func (p *PulsarDatasource) handleApps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := httpadapter.PluginConfigFromContext(r.Context())
+	keys, err := p.apiKeys(config)
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	ctx := withUser(r.Context(), config.User)
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, keys.pick(&p.keyFallback), p.settings.appParameters()...)
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+
+	query := r.URL.Query()
+	apps := make([]App, 0, len(appsResponse.Apps))
+	for _, app := range appsResponse.Apps {
+		if category := query.Get("category"); category != "" && app.Category != category {
+			continue
+		}
+		if !hasTags(app, query["tag"]) {
+			continue
+		}
+		if jobType := query.Get("jobType"); jobType != "" {
+			jobs := make([]Job, 0, len(app.Jobs))
+			for _, job := range app.Jobs {
+				if job.Type == jobType {
+					jobs = append(jobs, job)
+				}
+			}
+			app.Jobs = jobs
+		}
+		apps = append(apps, app)
+	}
+	setCacheControl(w, time.Duration(p.settings.AppsTTL))
+	writeJSON(w, http.StatusOK, apps)
+}
+
+// hasTags tells whether the app has all the tags, as <name>:<value>.
+func hasTags(app App, tags []string) bool {
+	for _, tag := range tags {
+		name, value := tag, ""
+		if i := strings.Index(tag, ":"); i >= 0 {
+			name, value = tag[:i], tag[i+1:]
+		}
+		if actual, found := app.Tags[name]; !found || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type resourceSender struct {
+	responses []*backend.CallResourceResponse
+}
+
+func (s *resourceSender) Send(resp *backend.CallResourceResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func callResource(t *testing.T, p *PulsarDatasource, method, path, body string) (int, []byte) {
+	t.Helper()
+
+	sender := &resourceSender{}
+	err := p.CallResource(context.Background(), &backend.CallResourceRequest{
+		Method: method,
+		Path:   path,
+		URL:    path,
+		Body:   []byte(body),
+	}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.responses) == 0 {
+		t.Fatal("no response sent")
+	}
+
+	var respBody []byte
+	for _, resp := range sender.responses {
+		respBody = append(respBody, resp.Body...)
+	}
+	return sender.responses[0].Status, respBody
+}
+
+func TestValidateSettings(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"jsonData": {"timeout": "30s"}, "secureJsonData": {"apiKey": "key"}}`, http.StatusOK},
+		{`{"jsonData": {}, "secureJsonFields": {"apiKey": true}}`, http.StatusOK},
+		{`{"jsonData": {"timeout": "1h"}, "secureJsonData": {"apiKey": "key"}}`, http.StatusBadRequest},
+		{`{"jsonData": {}}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		status, body := callResource(t, &PulsarDatasource{}, http.MethodPost, "settings/validate", tt.body)
+		if status != tt.status {
+			t.Errorf("%s: expected status %d, got %d (%s)", tt.body, tt.status, status, body)
+		}
+
+		var resp validateSettingsResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Valid != (tt.status == http.StatusOK) {
+			t.Errorf("%s: unexpected response %+v", tt.body, resp)
+		}
+	}
+}
+
+func TestZonesResources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zones":
+			fmt.Fprint(w, `[{"zone": "b.com"}, {"zone": "a.com"}]`)
+		case "/zones/a.com":
+			fmt.Fprint(w, `{"zone": "a.com", "records": [{"domain": "www.a.com", "type": "CNAME"},
+				{"domain": "a.com", "type": "A"}]}`)
+		case "/networks":
+			fmt.Fprint(w, `[{"network_id": 1, "name": "Dedicated"}, {"network_id": 0, "name": "Managed"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "zone not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	get := func(path string) (int, string) {
+		sender := &resourceSender{}
+		err := p.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+					DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+				},
+			},
+			Method: http.MethodGet,
+			Path:   path,
+			URL:    path,
+		}, sender)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sender.responses[0].Status, strings.TrimSpace(string(sender.responses[0].Body))
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"zones", http.StatusOK, `["a.com","b.com"]`},
+		{"zones/a.com/records", http.StatusOK, `[{"domain":"a.com","type":"A"},{"domain":"www.a.com","type":"CNAME"}]`},
+		{"zones/missing.com/records", http.StatusNotFound, errZoneNotFound.Error()},
+		{"zones/a.com", http.StatusNotFound, "404 page not found"},
+		{"networks", http.StatusOK, `[{"id":0,"name":"Managed"},{"id":1,"name":"Dedicated"}]`},
+	}
+	for _, tt := range tests {
+		if status, body := get(tt.path); status != tt.status || !strings.Contains(body, tt.body) {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.path, tt.status, tt.body, status, body)
+		}
+	}
+}
+
+func TestAppsResource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"appid": "a", "name": "A", "active": true, "category": "web", "tags": {"env": "prod"}},
+			{"appid": "b", "name": "B", "active": true, "category": "web", "tags": {"env": "staging"}},
+			{"appid": "c", "name": "C", "active": true, "category": "api", "tags": {"env": "prod"}}
+		]`)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	p.settings.DeniedApps = []string{"c"}
+	tests := []struct {
+		url  string
+		apps []string
+	}{
+		{"apps", []string{"a", "b"}},
+		{"apps?category=web&tag=env:prod", []string{"a"}},
+		{"apps?tag=env:prod&tag=team:dns", nil},
+	}
+	for _, tt := range tests {
+		sender := &resourceSender{}
+		err := p.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+					DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+				},
+			},
+			Method: http.MethodGet,
+			Path:   "apps",
+			URL:    tt.url,
+		}, sender)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var apps []App
+		if err = json.Unmarshal(sender.responses[0].Body, &apps); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, app := range apps {
+			ids = append(ids, app.AppID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.apps) {
+			t.Errorf("%s: expected %v, got %v", tt.url, tt.apps, ids)
+		}
+		// The catalog can be reused as long as the plugin caches it.
+		if cacheControl := sender.responses[0].Headers["Cache-Control"]; fmt.Sprint(cacheControl) != "[private, max-age=600]" {
+			t.Errorf("%s: expected the catalog cached for the apps TTL, got %v", tt.url, cacheControl)
+		}
+	}
+	if apps, _ := p.pulsarClient.GetApps(context.Background(), "key"); apps.AppsMap["a"].Tags["env"] != "prod" {
+		t.Errorf("expected the tags of the app, got %+v", apps.AppsMap["a"])
+	}
+}
+
+func TestThresholdsResource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pulsar/apps", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"appid": "app1", "name": "App 1", "active": true}]`)
+	})
+	mux.HandleFunc("/pulsar/apps/app1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"jobid": "job1", "name": "Job 1", "typeid": "latency", "active": true, "config": {"request_timeout_millis": 5000}},
+			{"jobid": "job2", "name": "Job 2", "typeid": "custom", "active": true, "config": {"request_timeout_millis": 5000}}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	p.settings.AvailabilityThreshold = 99
+	sender := &resourceSender{}
+	err := p.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+			},
+		},
+		Method: http.MethodGet,
+		Path:   "thresholds",
+		URL:    "thresholds?app=app1",
+	}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var thresholds []JobThresholds
+	if err = json.Unmarshal(sender.responses[0].Body, &thresholds); err != nil {
+		t.Fatal(err)
+	}
+	if len(thresholds) != 2 || thresholds[0].Latency == nil || *thresholds[0].Latency != 5000 || thresholds[0].Availability != 99 {
+		t.Fatalf("unexpected thresholds %s", sender.responses[0].Body)
+	}
+	if thresholds[1].Latency != nil {
+		t.Errorf("expected no latency threshold for the custom job, got %v", *thresholds[1].Latency)
+	}
+
+	config := thresholds[0].config(metricTypePerformance)
+	if config == nil || float64(config.Steps[1].Value) != 5000 {
+		t.Fatalf("expected the latency threshold in the field config, got %+v", config)
+	}
+	field := data.NewField(valueFieldName, nil, []float64{1})
+	field.Config = &data.FieldConfig{Thresholds: config}
+	if _, err = data.NewFrame("response", field).MarshalArrow(); err != nil {
+		t.Errorf("expected the thresholds to be encoded, got %v", err)
+	}
+	if config := thresholds[1].config(metricTypePerformance); config != nil {
+		t.Errorf("expected no field thresholds for the custom job, got %+v", config)
+	}
+}
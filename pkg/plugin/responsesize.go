@@ -0,0 +1,67 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// defaultMaxResponseSize is the largest response of the NS1 API read, in
+	// megabytes.
+	defaultMaxResponseSize = 50
+	// maxResponseSizeLimit bounds maxResponseSize, in megabytes, so it stays
+	// far from overflowing once in bytes.
+	maxResponseSizeLimit = 1024
+	// noResponseSizeLimit is the maxResponseSize turning the limit off.
+	noResponseSizeLimit = -1
+)
+
+const megabyte = 1 << 20
+
+var errResponseTooLarge = errors.New("response of the NS1 API too large, narrow your time range or lower the resolution")
+
+// limitedReader reads up to max bytes of the body, and fails with
+// errResponseTooLarge past them, so the oversized responses are dropped
+// without being buffered in memory.
+type limitedReader struct {
+	body io.Reader
+	read int64
+	max  int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if r.read += int64(n); r.read > r.max {
+		return n, fmt.Errorf("%w: over %d MB", errResponseTooLarge, r.max/megabyte)
+	}
+	return n, err
+}
+
+// maxResponseBytes returns the largest response of the NS1 API read, in
+// bytes, zero when the limit is turned off.
+func (s *Settings) maxResponseBytes() int64 {
+	if s.MaxResponseSize == noResponseSizeLimit {
+		return 0
+	}
+	return s.MaxResponseSize * megabyte
+}
+
+// limitBody returns the body of the response, failing once more than the
+// maximum response size is read. The responses announcing a larger length
+// fail right away.
+func (pc *PulsarClient) limitBody(resp *http.Response) (io.Reader, error) {
+	if pc.maxResponseSize <= 0 {
+		return resp.Body, nil
+	}
+	if resp.ContentLength > pc.maxResponseSize {
+		return nil, fmt.Errorf("%w: over %d MB", errResponseTooLarge, pc.maxResponseSize/megabyte)
+	}
+	// One byte past the maximum tells the larger responses apart.
+	return &limitedReader{body: io.LimitReader(resp.Body, pc.maxResponseSize+1), max: pc.maxResponseSize}, nil
+}
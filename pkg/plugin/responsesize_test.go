@@ -0,0 +1,102 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestMaxResponseSize(t *testing.T) {
+	ns1 := testutil.NewNS1(testutil.OptionValue("job1", 5))
+	var announced bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !announced {
+			// Stream the response, without announcing its length.
+			ns1.ServeHTTP(w, r)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		ns1.ServeHTTP(recorder, r)
+		w.Header().Set("Content-Length", strconv.Itoa(recorder.Body.Len()))
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(
+		OptionClientEndpoint(server.URL), OptionClientMaxResponseSize(4096))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string, span time.Duration) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:     "A",
+			JSON:      []byte(json),
+			TimeRange: backend.TimeRange{From: to.Add(-span), To: to},
+		})
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`, time.Hour); res.Error != nil {
+		t.Fatalf("expected the response under the maximum to be read, got %v", res.Error)
+	}
+	for _, announced = range []bool{false, true} {
+		res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p95"}`, 24*time.Hour)
+		if !errors.Is(res.Error, errResponseTooLarge) {
+			t.Fatalf("announced %v: expected errResponseTooLarge, got %v", announced, res.Error)
+		}
+		if !strings.Contains(res.Error.Error(), "narrow your time range") {
+			t.Errorf("announced %v: expected the error to tell how to get a smaller response, got %v", announced, res.Error)
+		}
+		if status := classifyError(res.Error).Status; status != http.StatusBadRequest {
+			t.Errorf("announced %v: expected a bad request, got %d", announced, status)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	pc := NewPulsarClient(OptionClientMaxResponseSize(10))
+	for body, tooLarge := range map[string]bool{
+		"0123456789":  false,
+		"0123456789A": true,
+	} {
+		reader, err := pc.limitBody(&http.Response{Body: io.NopCloser(strings.NewReader(body)), ContentLength: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := io.ReadAll(reader)
+		if tooLarge != errors.Is(err, errResponseTooLarge) {
+			t.Errorf("%q: expected too large %v, got %v", body, tooLarge, err)
+		}
+		if !tooLarge && string(read) != body {
+			t.Errorf("%q: expected the body, got %q", body, read)
+		}
+	}
+}
+
+func TestMaxResponseSizeSetting(t *testing.T) {
+	for jsonData, expected := range map[string]int64{
+		`{}`:                        defaultMaxResponseSize * megabyte,
+		`{"maxResponseSize": 10}`:   10 * megabyte,
+		`{"maxResponseSize": 1024}`: 1024 * megabyte,
+		`{"maxResponseSize": -1}`:   0,
+	} {
+		settings, err := parseSettings(backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes := settings.maxResponseBytes(); bytes != expected {
+			t.Errorf("%s: expected %d bytes, got %d", jsonData, expected, bytes)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+)
+
+// keyRotation notices the changes of the datasource configuration and of
+// the API keys, so the clients and catalogs cached for the previous keys are
+// evicted right away: the rotated keys take effect without restarting the
+// plugin, and the revoked ones stop being used. The keys read from an
+// environment variable or a file change without any settings update.
+type keyRotation struct {
+	lock    sync.Mutex
+	updated time.Time
+	// keys holds the last key used by each route, e.g. "" for the default
+	// key or "user/jdoe".
+	keys map[string]string
+}
+
+// apiKeys returns the API keys of the request, flushing the caches of the
+// client when the settings were updated, or the keys of the previous
+// requests when they changed.
+func (p *PulsarDatasource) apiKeys(pCtx backend.PluginContext) (*apiKeys, error) {
+	keys, err := getAPIKeysFromContext(pCtx, p.settings)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := pCtx.DataSourceInstanceSettings.Updated
+	stale, flushAll := p.keyRotation.track(updated, keys)
+	if flushAll {
+		p.logger.Info("Datasource settings updated, flushing the NS1 clients and catalogs")
+		p.pulsarClient.flush()
+	}
+	if stale != "" {
+		p.logger.Info("NS1 API key changed, evicting the clients and catalog of the previous one",
+			"route", keys.route)
+		p.pulsarClient.evictKey(stale)
+	}
+
+	return keys, nil
+}
+
+// track records the settings update time and the key of the route. It
+// returns the previous key of the route when it changed, and whether the
+// settings were updated since the last call.
+func (kr *keyRotation) track(updated time.Time, keys *apiKeys) (string, bool) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	if kr.keys == nil {
+		kr.keys = make(map[string]string)
+	}
+
+	flushAll := false
+	if updated.After(kr.updated) {
+		// The first update time seen is the one of the instance, its caches
+		// are fresh.
+		flushAll = !kr.updated.IsZero()
+		kr.updated = updated
+		if flushAll {
+			kr.keys = make(map[string]string)
+		}
+	}
+
+	previous, found := kr.keys[keys.route]
+	kr.keys[keys.route] = keys.primary
+	if found && previous != keys.primary {
+		return previous, flushAll
+	}
+	return "", flushAll
+}
+
+// evictKey drops the NS1 client, the catalog and the cached query results of
+// the API key.
+func (pc *PulsarClient) evictKey(apiKey string) {
+	pc.apiClientLock.Lock()
+	delete(pc.apiClientCache, apiKey)
+	pc.apiClientLock.Unlock()
+
+	pc.dataLock.Lock()
+	delete(pc.data, apiKey)
+	pc.dataLock.Unlock()
+
+	pc.queryCache.lock.Lock()
+	for key := range pc.queryCache.entries {
+		if strings.HasPrefix(key, apiKey) {
+			delete(pc.queryCache.entries, key)
+		}
+	}
+	pc.queryCache.lock.Unlock()
+}
+
+// flush drops the NS1 clients, the catalogs and the cached query results of
+// every API key.
+func (pc *PulsarClient) flush() {
+	pc.apiClientLock.Lock()
+	pc.apiClientCache = make(map[string]*ns1api.Client)
+	pc.apiClientLock.Unlock()
+
+	pc.dataLock.Lock()
+	pc.data = make(map[string]*PulsarData)
+	pc.dataLock.Unlock()
+
+	pc.queryCache.lock.Lock()
+	pc.queryCache.entries = make(map[string]queryCacheEntry)
+	pc.queryCache.lock.Unlock()
+}
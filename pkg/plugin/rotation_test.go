@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestKeyRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ns1-key")
+	if err := os.WriteFile(file, []byte("old-key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(apiKeyDirEnv, filepath.Dir(file))
+	defer os.Unsetenv(apiKeyDirEnv)
+
+	p := &PulsarDatasource{settings: &Settings{APIKeyFile: file}}
+	p.ensureInitialized()
+	pCtx := backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{Updated: time.Unix(1000, 0)},
+	}
+	cached := func(apiKey string) bool {
+		return p.pulsarClient.getData(apiKey) != nil
+	}
+
+	if _, err := p.apiKeys(pCtx); err != nil {
+		t.Fatal(err)
+	}
+	p.pulsarClient.setData("old-key", &PulsarData{})
+	p.pulsarClient.queryCache.entries["old-key/v1/pulsar/apps"] = queryCacheEntry{}
+
+	// The key of the file is rotated.
+	if err := os.WriteFile(file, []byte("new-key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := p.apiKeys(pCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.primary != "new-key" || cached("old-key") || len(p.pulsarClient.queryCache.entries) != 0 {
+		t.Fatal("expected the caches of the rotated key to be evicted")
+	}
+
+	// The same settings keep the caches.
+	p.pulsarClient.setData("new-key", &PulsarData{})
+	if _, err := p.apiKeys(pCtx); err != nil {
+		t.Fatal(err)
+	}
+	if !cached("new-key") {
+		t.Fatal("expected the caches of the current key to be kept")
+	}
+
+	// The settings are updated.
+	pCtx.DataSourceInstanceSettings.Updated = time.Unix(2000, 0)
+	if _, err := p.apiKeys(pCtx); err != nil {
+		t.Fatal(err)
+	}
+	if cached("new-key") {
+		t.Fatal("expected the caches to be flushed on settings update")
+	}
+}
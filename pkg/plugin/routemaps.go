@@ -0,0 +1,91 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeRouteMapChanges is the type of the queries of the changes made to
+// the Pulsar route maps, out of the account activity.
+const queryTypeRouteMapChanges = "routeMapChanges"
+
+var errRouteMapNotFound = errors.New("Pulsar route map not found")
+
+// RouteMap is a Pulsar route map, mapping the networks of the resolvers to
+// the answers, attached to an app.
+type RouteMap struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	AppID string `json:"appid"`
+}
+
+// GetRouteMaps lists the Pulsar route maps of the account.
+func (pc *PulsarClient) GetRouteMaps(ctx context.Context, apiKey string) (routeMaps []RouteMap, err error) {
+	ctx, span := startSpan(ctx, "GetRouteMaps")
+	defer func() { span.end(err) }()
+
+	if err = pc.getJSON(ctx, apiKey, "pulsar/routemaps", errRouteMapNotFound, &routeMaps); err != nil {
+		return nil, err
+	}
+	return routeMaps, nil
+}
+
+// queryRouteMapChanges answers the route map changes queries with a frame of
+// the changes made to the route maps of the apps visible to the user, or of
+// the app of the query, so the traffic shifts of the decisions can be traced
+// to the edits. The changes are picked out of the account activity by the ID
+// of the resource changed.
+func (p *PulsarDatasource) queryRouteMapChanges(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+	if err = checkQueryAllowed(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if qm.AppID == "" && qm.JobID != "" {
+		// The route maps are attached to the apps, the one of the job is used.
+		app, _, err := p.findJob(ctx, apiKey, qm.JobID)
+		if err != nil {
+			return backend.DataResponse{Error: err}
+		}
+		qm.AppID = app.AppID
+	}
+
+	allRouteMaps, err := p.pulsarAPI().GetRouteMaps(ctx, apiKey)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	routeMaps := make(map[string]RouteMap)
+	for _, routeMap := range allRouteMaps {
+		if _, visible := appsResponse.AppsMap[routeMap.AppID]; visible && (qm.AppID == "" || routeMap.AppID == qm.AppID) {
+			routeMaps[routeMap.ID] = routeMap
+		}
+	}
+
+	activity, err := p.pulsarAPI().GetActivity(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	changes := activity[:0]
+	for _, a := range activity {
+		if _, found := routeMaps[a.ResourceID]; found {
+			changes = append(changes, a)
+		}
+	}
+
+	frame := activityFrame(changes, func(a *Activity) string {
+		return "Pulsar route map " + routeMaps[a.ResourceID].Name
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
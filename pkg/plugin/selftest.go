@@ -0,0 +1,125 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minSelfTestInterval keeps the self-test from flooding the NS1 API.
+const minSelfTestInterval = 10 * time.Second
+
+const (
+	selfTestStatusOK    = "ok"
+	selfTestStatusError = "error"
+)
+
+// selfTestResult is the outcome of the latest self-test. The last error is
+// kept after the next successful run, so intermittent failures show up.
+type selfTestResult struct {
+	Status      string     `json:"status"`
+	CheckedAt   time.Time  `json:"checkedAt"`
+	LatencyMs   int64      `json:"latencyMs"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// selfTest probes the NS1 API periodically in the background, so a broken key
+// or an outage is noticed before the users see empty panels.
+type selfTest struct {
+	lock   sync.RWMutex
+	result *selfTestResult
+}
+
+// run probes the NS1 API every interval until the client is closed. apiKey
+// returns the key to probe with.
+func (st *selfTest) run(client *PulsarClient, interval time.Duration, apiKey func() (string, error)) {
+	probe := func() {
+		key, err := apiKey()
+		start := time.Now()
+		if err == nil {
+			_, err = client.ping(key)
+		}
+		if err != nil {
+			err = client.redactor.redactError(err)
+			client.logger.Warn("NS1 self-test failed", "error", err)
+		}
+		st.record(time.Since(start), err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		probe()
+		for {
+			select {
+			case <-client.done:
+				return
+			case <-ticker.C:
+				probe()
+			}
+		}
+	}()
+}
+
+func (st *selfTest) record(latency time.Duration, err error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	result := &selfTestResult{
+		Status:    selfTestStatusOK,
+		CheckedAt: time.Now().UTC(),
+		LatencyMs: latency.Milliseconds(),
+	}
+	if st.result != nil {
+		result.LastError = st.result.LastError
+		result.LastErrorAt = st.result.LastErrorAt
+	}
+	if err != nil {
+		result.Status = selfTestStatusError
+		result.LastError = err.Error()
+		result.LastErrorAt = &result.CheckedAt
+	}
+
+	st.result = result
+}
+
+// latest returns the result of the last run, nil until the first one is done.
+func (st *selfTest) latest() *selfTestResult {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	return st.result
+}
+
+// ping lists the Pulsar apps, the lightest request telling whether the API
+// and the key work. It returns the time of the NS1 server, zero when the
+// response has no Date header.
+func (pc *PulsarClient) ping(apiKey string) (time.Time, error) {
+	req, err := http.NewRequest(http.MethodGet, pc.endpoint+"pulsar/apps", nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set(apiKeyHeader, apiKey)
+
+	resp, err := pc.httpClient.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if err = checkResponse(resp, errAppNotFound); err != nil {
+		return time.Time{}, err
+	}
+	// Drain the body so the connection goes back to the pool.
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+		return time.Time{}, err
+	}
+
+	serverTime, _ := http.ParseTime(resp.Header.Get("Date"))
+	return serverTime, nil
+}
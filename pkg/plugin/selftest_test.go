@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestSelfTest(t *testing.T) {
+	server := testutil.NewServer()
+	defer server.Close()
+
+	client := NewPulsarClient(OptionClientEndpoint(server.URL))
+	defer client.Close()
+
+	p := &PulsarDatasource{selfTest: &selfTest{}}
+	if status, _ := callResource(t, p, http.MethodGet, "health/details", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 before the first run, got %d", status)
+	}
+
+	p.selfTest.run(client, time.Hour, func() (string, error) { return "key", nil })
+	for i := 0; i < 100 && p.selfTest.latest() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status, body := callResource(t, p, http.MethodGet, "health/details", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", status, body)
+	}
+
+	// A failure is reported, and remembered after the next successful run.
+	p.selfTest.record(time.Millisecond, errors.New("boom"))
+	if status, _ = callResource(t, p, http.MethodGet, "health/details", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 after a failure, got %d", status)
+	}
+	p.selfTest.record(time.Millisecond, nil)
+
+	status, body = callResource(t, p, http.MethodGet, "health/details", "")
+	var result selfTestResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || result.LastError != "boom" || result.LastErrorAt == nil {
+		t.Errorf("unexpected result %d %+v", status, result)
+	}
+}
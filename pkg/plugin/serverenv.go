@@ -0,0 +1,67 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The datasource settings are editable by the organization admins, so what
+// they can read from the Grafana host is bounded by the server admin: the
+// environment variables must carry the plugin prefix, and the files must be
+// in the directory set in the environment of the Grafana server.
+const (
+	// apiKeyEnvPrefix prefixes the environment variables apiKeyEnv can name.
+	apiKeyEnvPrefix = "PULSAR_"
+	// apiKeyDirEnv is the environment variable of the directory apiKeyFile
+	// must be in. The API key files can't be read when unset.
+	apiKeyDirEnv = "PULSAR_API_KEY_DIR"
+	// fixtureDirEnv is the environment variable of the directory fixtureDir
+	// must be in. The fixtures can't be recorded nor replayed when unset.
+	fixtureDirEnv = "PULSAR_FIXTURE_DIR"
+)
+
+var errOutsideServerDir = errors.New("path outside the directory set by the Grafana server admin")
+
+// pathInServerDir returns the path within the directory set in the environment
+// variable by the server admin, the relative paths being relative to it. The
+// paths escaping it, be it with .. or symbolic links, are rejected.
+func pathInServerDir(dirEnv, path string) (string, error) {
+	root := os.Getenv(dirEnv)
+	if root == "" {
+		return "", fmt.Errorf("%w: %s is not set", errOutsideServerDir, dirEnv)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	if !isWithin(root, path) {
+		return "", fmt.Errorf("%w %s: %s", errOutsideServerDir, dirEnv, path)
+	}
+
+	// The symbolic links are followed for the paths that exist already.
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("%w %s: %v", errOutsideServerDir, dirEnv, err)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil && !isWithin(resolvedRoot, resolved) {
+		return "", fmt.Errorf("%w %s: %s", errOutsideServerDir, dirEnv, path)
+	}
+	return path, nil
+}
+
+// isWithin tells whether the clean absolute path is the root or below it.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
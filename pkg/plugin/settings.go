@@ -0,0 +1,606 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const maxTimeout = 5 * time.Minute
+
+// aggregations are the aggregations supported by the Pulsar query endpoints.
+var aggregations = []string{"avg", "max", "min", "p50", "p75", "p90", "p95", "p99"}
+
+func isValidAggregation(agg string) bool {
+	for _, a := range aggregations {
+		if a == agg {
+			return true
+		}
+	}
+	return false
+}
+
+// headerNamePattern matches the valid HTTP header names (RFC 7230 tokens).
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// grafanaRoles are the organization roles of the Grafana users.
+var grafanaRoles = []string{"Viewer", "Editor", "Admin"}
+
+func isGrafanaRole(role string) bool {
+	for _, r := range grafanaRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	maxRangeModeReject = "reject"
+	maxRangeModeChunk  = "chunk"
+)
+
+// Duration is a time.Duration read from either a duration string ("90s",
+// "10m", "90d") or a number of seconds.
+type Duration time.Duration
+
+// parseDuration extends time.ParseDuration with a number of days, e.g. "90d".
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		*d = 0
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+	case string:
+		if v == "" {
+			*d = 0
+			return nil
+		}
+		parsed, err := parseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+
+	return nil
+}
+
+// FeatureFlags enable the capabilities still experimental, so they can ship
+// dark and be turned on per datasource.
+type FeatureFlags struct {
+	// EnableDecisions allows querying the Pulsar decisions metric type.
+	EnableDecisions bool `json:"enableDecisions"`
+	// EnableDHCP allows querying the DHCP scopes of the private NS1
+	// installations (DDI).
+	EnableDHCP bool `json:"enableDhcp"`
+	// EnableStreaming allows subscribing to the live channels.
+	EnableStreaming bool `json:"enableStreaming"`
+}
+
+// Settings holds the datasource configuration stored by Grafana in the
+// jsonData field.
+type Settings struct {
+	// Debug logs every NS1 request with its status code and timing.
+	Debug bool `json:"debug"`
+	// Mock serves synthetic apps, jobs and data instead of calling NS1, and
+	// needs no API key, for the demos and the development of the dashboards.
+	Mock bool `json:"mock"`
+	// FixtureMode records the NS1 responses to FixtureDir, without the API
+	// keys and encrypted with the fixtureKey secure setting, or replays them
+	// without calling NS1 nor needing an API key: record or replay.
+	// FixtureDir must be in the directory set by the server admin.
+	FixtureMode string `json:"fixtureMode"`
+	FixtureDir  string `json:"fixtureDir"`
+	// AuditLog logs the user, the query and the time range of every query, at
+	// the info level whatever LogLevel.
+	AuditLog bool `json:"auditLog"`
+	// LogLevel is the least severe level logged for the datasource, every
+	// message being sent to Grafana when empty.
+	LogLevel string `json:"logLevel"`
+	// Prewarm opens the connection to the NS1 API on instance creation and
+	// keeps it alive, so queries after idle periods skip the handshakes.
+	Prewarm bool `json:"prewarm"`
+	// StreamInterval is how often the live channels poll NS1 for new data.
+	StreamInterval Duration `json:"streamInterval"`
+	// AvailabilityThreshold is the availability, in percent, below which the
+	// events channels consider a job down.
+	AvailabilityThreshold float64 `json:"availabilityThreshold"`
+	// Tracing logs a span for every query and NS1 request, continuing the
+	// trace context of the Grafana requests.
+	Tracing bool `json:"tracing"`
+	// DegradedErrorRate is the percentage of queries failing because of NS1
+	// above which the datasource reports itself as degraded.
+	DegradedErrorRate float64 `json:"degradedErrorRate"`
+	// SlowQueryThreshold is the duration above which a query is logged as
+	// slow. Disabled when zero.
+	SlowQueryThreshold Duration `json:"slowQueryThreshold"`
+	// UserRequestRate is the number of NS1 requests per second each Grafana
+	// user can send, beyond UserRequestBurst. Unlimited when zero.
+	UserRequestRate  float64 `json:"userRequestRate"`
+	UserRequestBurst int     `json:"userRequestBurst"`
+	// ReportTimeout is how long the report queries wait for NS1 to generate
+	// the report.
+	ReportTimeout Duration `json:"reportTimeout"`
+	// SelfTestInterval is how often the NS1 API is probed in the background.
+	// Disabled when zero.
+	SelfTestInterval Duration `json:"selfTestInterval"`
+	// Endpoint overrides the NS1 API endpoint.
+	Endpoint string `json:"endpoint"`
+	// FallbackEndpoint receives the requests when Endpoint fails repeatedly.
+	FallbackEndpoint string `json:"fallbackEndpoint"`
+	// AuthHeader and AuthScheme set the header carrying the API key, and the
+	// scheme prefixing it, for the private NS1 installations (DDI) not using
+	// the X-NSONE-Key header of the NS1 API.
+	AuthHeader string `json:"authHeader"`
+	AuthScheme string `json:"authScheme"`
+	// IdentityHeader is the header sending the login of the Grafana user with
+	// the data requests, e.g. X-On-Behalf-Of. Disabled when empty.
+	IdentityHeader string `json:"identityHeader"`
+	// Timeout of every request made to the NS1 API.
+	Timeout Duration `json:"timeout"`
+	// MaxQueryTimeout is the longest timeout a query can ask for, the
+	// Timeout by default.
+	MaxQueryTimeout Duration `json:"maxQueryTimeout"`
+	// MaxResponseSize is the largest response of the NS1 API read, in
+	// megabytes, up to 1024. The larger ones fail instead of being buffered
+	// in memory. -1 turns the limit off.
+	MaxResponseSize int64 `json:"maxResponseSize"`
+	// AppsTTL is how long the apps and jobs are cached.
+	AppsTTL Duration `json:"appsTTL"`
+	// CacheTTL is how long the query results are cached. Disabled when zero.
+	CacheTTL Duration `json:"cacheTTL"`
+	// MaxRange is the longest time range a query can request. Longer queries
+	// are rejected, or fetched in chunks of MaxRange depending on MaxRangeMode.
+	MaxRange     Duration `json:"maxRange"`
+	MaxRangeMode string   `json:"maxRangeMode"`
+	// IncludeInactiveApps lists the apps marked as inactive along with the
+	// active ones.
+	IncludeInactiveApps bool `json:"includeInactiveApps"`
+	// IncludeInactiveJobs lists the jobs marked as inactive, so the data of
+	// retired jobs can still be graphed.
+	IncludeInactiveJobs bool `json:"includeInactiveJobs"`
+
+	// APIKeyEnv and APIKeyFile reference the NS1 API key for provisioned
+	// deployments. They are used when the key isn't in the secure data. The
+	// variable must start with PULSAR_, and the file be in PULSAR_API_KEY_DIR.
+	APIKeyEnv  string `json:"apiKeyEnv"`
+	APIKeyFile string `json:"apiKeyFile"`
+
+	// AllowedApps restricts the apps exposed by the datasource, when not
+	// empty. DeniedApps hides apps, even if they are allowed.
+	AllowedApps []string `json:"allowedApps"`
+	DeniedApps  []string `json:"deniedApps"`
+	// RoleApps further restricts the apps of the users of a Grafana role,
+	// e.g. {"Viewer": ["app1"]}. The roles not listed are restricted by
+	// AllowedApps and DeniedApps only.
+	RoleApps map[string][]string `json:"roleApps"`
+
+	// The defaults are applied to the queries omitting the matching field.
+	DefaultGeo         string `json:"defaultGeo"`
+	DefaultASN         string `json:"defaultAsn"`
+	DefaultAggregation string `json:"defaultAgg"`
+	DefaultMetricType  string `json:"defaultMetricType"`
+	// PerformanceUnit and AvailabilityUnit convert the values of the queries
+	// omitting their unit: the performance to ms or s, the availability to
+	// percent or ratio.
+	PerformanceUnit  string `json:"performanceUnit"`
+	AvailabilityUnit string `json:"availabilityUnit"`
+	// ErrorsAsNoData turns NS1 failures (timeouts, 5xx) into empty frames with
+	// a warning, instead of errors.
+	ErrorsAsNoData bool `json:"errorsAsNoData"`
+	// LabelTemplate is the legend of the queries without their own alias.
+	// It supports the same placeholders as the alias.
+	LabelTemplate string `json:"labelTemplate"`
+
+	FeatureFlags
+
+	// fixtureKey is the secret of the fixtures, from the secure data.
+	fixtureKey string
+	// transport honors the HTTP options of the datasource set in Grafana, it
+	// is shared by the queries and the health check.
+	transport http.RoundTripper
+}
+
+// setDefaults fills the values left empty.
+func (s *Settings) setDefaults() {
+	if s.Endpoint == "" {
+		s.Endpoint = defaultEndpoint
+	}
+	if s.Timeout == 0 {
+		s.Timeout = Duration(timeout)
+	}
+	if s.MaxQueryTimeout == 0 {
+		s.MaxQueryTimeout = s.Timeout
+	}
+	if s.MaxResponseSize == 0 {
+		s.MaxResponseSize = defaultMaxResponseSize
+	}
+	if s.AppsTTL == 0 {
+		s.AppsTTL = Duration(appsDefaultTTL)
+	}
+	if s.MaxRangeMode == "" {
+		s.MaxRangeMode = maxRangeModeReject
+	}
+}
+
+// validate checks the values are usable, returning an error describing the
+// first wrong one.
+func (s *Settings) validate() error {
+	if err := validateEndpoint("endpoint", s.Endpoint); err != nil {
+		return err
+	}
+	if err := validateEndpoint("fallbackEndpoint", s.FallbackEndpoint); err != nil {
+		return err
+	}
+	if s.FallbackEndpoint != "" && withTrailingSlash(s.FallbackEndpoint) == withTrailingSlash(s.Endpoint) {
+		return fmt.Errorf("fallbackEndpoint must be different from the endpoint")
+	}
+	if time.Duration(s.Timeout) < time.Second || time.Duration(s.Timeout) > maxTimeout {
+		return fmt.Errorf("timeout must be between 1s and %s, got %s", maxTimeout,
+			time.Duration(s.Timeout))
+	}
+	if time.Duration(s.MaxQueryTimeout) < time.Second || time.Duration(s.MaxQueryTimeout) > maxTimeout {
+		return fmt.Errorf("maxQueryTimeout must be between 1s and %s, got %s", maxTimeout,
+			time.Duration(s.MaxQueryTimeout))
+	}
+	if s.MaxResponseSize != noResponseSizeLimit && (s.MaxResponseSize < 0 || s.MaxResponseSize > maxResponseSizeLimit) {
+		return fmt.Errorf("maxResponseSize must be between 1 and %d megabytes, or %d for no limit, got %d",
+			maxResponseSizeLimit, noResponseSizeLimit, s.MaxResponseSize)
+	}
+	if s.EnableDHCP && withTrailingSlash(s.Endpoint) == withTrailingSlash(defaultEndpoint) {
+		return fmt.Errorf("enableDhcp needs the endpoint of the private NS1 installation")
+	}
+	if s.AuthHeader != "" && !headerNamePattern.MatchString(s.AuthHeader) {
+		return fmt.Errorf("authHeader must be a valid HTTP header name, got %q", s.AuthHeader)
+	}
+	if s.AuthScheme != "" && !headerNamePattern.MatchString(s.AuthScheme) {
+		return fmt.Errorf("authScheme must be a single word, e.g. Bearer, got %q", s.AuthScheme)
+	}
+	if s.IdentityHeader != "" && (!headerNamePattern.MatchString(s.IdentityHeader) ||
+		strings.EqualFold(s.IdentityHeader, apiKeyHeader) || strings.EqualFold(s.IdentityHeader, s.AuthHeader)) {
+		return fmt.Errorf("identityHeader must be a valid HTTP header name, other than the API key one, got %q",
+			s.IdentityHeader)
+	}
+	if s.APIKeyEnv != "" && !strings.HasPrefix(s.APIKeyEnv, apiKeyEnvPrefix) {
+		return fmt.Errorf("apiKeyEnv must start with %s, got %q", apiKeyEnvPrefix, s.APIKeyEnv)
+	}
+	if s.FixtureMode != "" && s.FixtureMode != fixtureModeRecord && s.FixtureMode != fixtureModeReplay {
+		return fmt.Errorf("fixtureMode must be %q or %q, got %q", fixtureModeRecord, fixtureModeReplay, s.FixtureMode)
+	}
+	if s.FixtureMode != "" && s.FixtureDir == "" {
+		return fmt.Errorf("fixtureMode needs the fixtureDir directory")
+	}
+	if s.FixtureMode != "" && s.fixtureKey == "" {
+		return fmt.Errorf("fixtureMode needs the %s secure setting", FixtureKey)
+	}
+	if s.FixtureMode != "" {
+		dir, err := pathInServerDir(fixtureDirEnv, s.FixtureDir)
+		if err != nil {
+			return fmt.Errorf("fixtureDir: %w", err)
+		}
+		s.FixtureDir = dir
+	}
+	if s.FixtureMode != "" && s.Mock {
+		return fmt.Errorf("fixtureMode can't be used along with mock")
+	}
+	if s.LogLevel != "" && !isValidLogLevel(s.LogLevel) {
+		return fmt.Errorf("logLevel must be one of %v, got %q", logLevels, s.LogLevel)
+	}
+	if s.StreamInterval != 0 && time.Duration(s.StreamInterval) < minStreamInterval {
+		return fmt.Errorf("streamInterval must be at least %s, got %s", minStreamInterval,
+			time.Duration(s.StreamInterval))
+	}
+	if s.AvailabilityThreshold < 0 || s.AvailabilityThreshold > 100 {
+		return fmt.Errorf("availabilityThreshold must be between 0 and 100, got %v", s.AvailabilityThreshold)
+	}
+	if s.SelfTestInterval != 0 && time.Duration(s.SelfTestInterval) < minSelfTestInterval {
+		return fmt.Errorf("selfTestInterval must be at least %s, got %s", minSelfTestInterval,
+			time.Duration(s.SelfTestInterval))
+	}
+	if s.DegradedErrorRate < 0 || s.DegradedErrorRate > 100 {
+		return fmt.Errorf("degradedErrorRate must be between 0 and 100, got %v", s.DegradedErrorRate)
+	}
+	if s.UserRequestRate < 0 {
+		return fmt.Errorf("userRequestRate must be positive, got %v", s.UserRequestRate)
+	}
+	if s.UserRequestBurst < 0 {
+		return fmt.Errorf("userRequestBurst must be positive, got %d", s.UserRequestBurst)
+	}
+	if s.SlowQueryThreshold < 0 {
+		return fmt.Errorf("slowQueryThreshold must be positive, got %s", time.Duration(s.SlowQueryThreshold))
+	}
+	if s.ReportTimeout < 0 {
+		return fmt.Errorf("reportTimeout must be positive, got %s", time.Duration(s.ReportTimeout))
+	}
+	if s.AppsTTL < 0 {
+		return fmt.Errorf("appsTTL must be positive, got %s", time.Duration(s.AppsTTL))
+	}
+	if s.CacheTTL < 0 {
+		return fmt.Errorf("cacheTTL must be positive, got %s", time.Duration(s.CacheTTL))
+	}
+	if s.MaxRange < 0 {
+		return fmt.Errorf("maxRange must be positive, got %s", time.Duration(s.MaxRange))
+	}
+	if s.MaxRangeMode != maxRangeModeReject && s.MaxRangeMode != maxRangeModeChunk {
+		return fmt.Errorf("maxRangeMode must be %q or %q, got %q", maxRangeModeReject,
+			maxRangeModeChunk, s.MaxRangeMode)
+	}
+	if s.DefaultAggregation != "" && !isValidAggregation(s.DefaultAggregation) {
+		return fmt.Errorf("defaultAgg must be one of %v, got %q", aggregations, s.DefaultAggregation)
+	}
+	for role := range s.RoleApps {
+		if !isGrafanaRole(role) {
+			return fmt.Errorf("roleApps roles must be one of %v, got %q", grafanaRoles, role)
+		}
+	}
+	if s.DefaultMetricType != "" && s.DefaultMetricType != metricTypePerformance &&
+		s.DefaultMetricType != metricTypeAvailability {
+		return fmt.Errorf("defaultMetricType must be %q or %q, got %q", metricTypePerformance,
+			metricTypeAvailability, s.DefaultMetricType)
+	}
+	if s.PerformanceUnit != "" && !isValidUnit(metricTypePerformance, s.PerformanceUnit) {
+		return fmt.Errorf("performanceUnit must be %q or %q, got %q", unitMilliseconds, unitSeconds, s.PerformanceUnit)
+	}
+	if s.AvailabilityUnit != "" && !isValidUnit(metricTypeAvailability, s.AvailabilityUnit) {
+		return fmt.Errorf("availabilityUnit must be %q or %q, got %q", unitPercent, unitRatio, s.AvailabilityUnit)
+	}
+
+	return nil
+}
+
+// clientOptions converts the settings into the matching PulsarClient options.
+func (s *Settings) clientOptions() []PulsarClientOption {
+	transport := s.transport
+	if s.Mock {
+		transport = &mockTransport{}
+	}
+	return []PulsarClientOption{
+		OptionClientTransport(transport),
+		OptionClientFixtures(s.FixtureMode, s.FixtureDir, s.fixtureKey),
+		OptionClientDebug(s.Debug),
+		OptionClientLogger(s.logger()),
+		OptionClientEndpoint(s.Endpoint),
+		OptionClientFallbackEndpoint(s.FallbackEndpoint),
+		OptionClientAuth(s.AuthHeader, s.AuthScheme),
+		OptionClientIdentityHeader(s.IdentityHeader),
+		OptionClientTimeout(time.Duration(s.Timeout)),
+		OptionClientAppsTTL(time.Duration(s.AppsTTL)),
+		OptionClientCacheTTL(time.Duration(s.CacheTTL)),
+		OptionClientChunkSize(s.chunkSize()),
+		OptionClientMaxResponseSize(s.maxResponseBytes()),
+	}
+}
+
+// offline tells whether the datasource serves its data without calling NS1,
+// nor needing an API key.
+func (s *Settings) offline() bool {
+	return s.Mock || s.FixtureMode == fixtureModeReplay
+}
+
+// logger returns the logger of the datasource, honoring its log level.
+func (s *Settings) logger() log.Logger {
+	return withLevel(Logger, s.LogLevel)
+}
+
+// chunkSize returns the longest range fetched in a single request, zero
+// meaning no limit.
+func (s *Settings) chunkSize() time.Duration {
+	if s.MaxRangeMode != maxRangeModeChunk {
+		return 0
+	}
+	return time.Duration(s.MaxRange)
+}
+
+// streamInterval returns how often the live channels are refreshed.
+func (s *Settings) streamInterval() time.Duration {
+	if s.StreamInterval == 0 {
+		return defaultStreamInterval
+	}
+	return time.Duration(s.StreamInterval)
+}
+
+// reportTimeout returns how long the report queries wait for the report.
+func (s *Settings) reportTimeout() time.Duration {
+	if s.ReportTimeout == 0 {
+		return defaultReportTimeout
+	}
+	return time.Duration(s.ReportTimeout)
+}
+
+// availabilityThreshold returns the availability below which a job is down.
+func (s *Settings) availabilityThreshold() float64 {
+	if s.AvailabilityThreshold == 0 {
+		return defaultAvailabilityThreshold
+	}
+	return s.AvailabilityThreshold
+}
+
+// degradedErrorRate returns the upstream error rate above which the
+// datasource is degraded.
+func (s *Settings) degradedErrorRate() float64 {
+	if s.DegradedErrorRate == 0 {
+		return defaultDegradedErrorRate
+	}
+	return s.DegradedErrorRate
+}
+
+// userRequestBurst returns the number of NS1 requests a user can send at
+// once.
+func (s *Settings) userRequestBurst() int {
+	if s.UserRequestBurst == 0 {
+		return defaultUserRequestBurst
+	}
+	return s.UserRequestBurst
+}
+
+// checkRange rejects the queries longer than the maximum range, unless they
+// are fetched in chunks.
+func (s *Settings) checkRange(qm *queryModel) error {
+	if s.MaxRange == 0 || s.MaxRangeMode != maxRangeModeReject {
+		return nil
+	}
+	if queryRange := qm.To.Sub(qm.From); queryRange > time.Duration(s.MaxRange) {
+		return fmt.Errorf("%w: the query spans %s, while the limit is %s", errRangeTooLong,
+			queryRange.Round(time.Second), time.Duration(s.MaxRange))
+	}
+	return nil
+}
+
+// labelTemplate returns the template for the legend of the query series.
+func (s *Settings) labelTemplate(qm *queryModel) string {
+	if qm.Alias != "" {
+		return qm.Alias
+	}
+	return s.LabelTemplate
+}
+
+// checkFeatures rejects the queries relying on a disabled feature.
+func (s *Settings) checkFeatures(qm *queryModel) error {
+	if (qm.MetricType == metricTypeDecisions || qm.QueryType == queryTypeDecisionAnswers) && !s.EnableDecisions {
+		return fmt.Errorf("%w: decisions metric type (enableDecisions)", errFeatureDisabled)
+	}
+	if qm.QueryType == queryTypeOutages && !s.EnableDecisions {
+		return fmt.Errorf("%w: insufficient decisions of the outages (enableDecisions)", errFeatureDisabled)
+	}
+	if qm.QueryType == queryTypeDHCPScopes && !s.EnableDHCP {
+		return fmt.Errorf("%w: DHCP scopes (enableDhcp)", errFeatureDisabled)
+	}
+	return nil
+}
+
+// checkStreaming rejects the live channels when streaming is disabled.
+func (s *Settings) checkStreaming() error {
+	if !s.EnableStreaming {
+		return fmt.Errorf("%w: live channels (enableStreaming)", errFeatureDisabled)
+	}
+	return nil
+}
+
+// restrictsApps tells whether an allowlist or denylist is configured.
+func (s *Settings) restrictsApps() bool {
+	return len(s.AllowedApps) > 0 || len(s.DeniedApps) > 0
+}
+
+// isAppAllowed tells whether the app can be seen and queried through the
+// datasource.
+func (s *Settings) isAppAllowed(appID string) bool {
+	for _, denied := range s.DeniedApps {
+		if denied == appID {
+			return false
+		}
+	}
+	if len(s.AllowedApps) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedApps {
+		if allowed == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// appFilter returns whether the app can be seen and queried by the users of
+// the role, or nil when the apps aren't restricted for them.
+func (s *Settings) appFilter(role string) func(appID string) bool {
+	roleApps, restrictsRole := s.RoleApps[role]
+	if !restrictsRole {
+		if s.restrictsApps() {
+			return s.isAppAllowed
+		}
+		return nil
+	}
+
+	return func(appID string) bool {
+		if !s.isAppAllowed(appID) {
+			return false
+		}
+		for _, allowed := range roleApps {
+			if allowed == appID {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// appParameters converts the settings into the options used to list the
+// apps and jobs.
+func (s *Settings) appParameters() []PulsarAppParameter {
+	return []PulsarAppParameter{
+		OptionAppFetchJobs(true),
+		PulsarAppFetchInactive(s.IncludeInactiveApps),
+		OptionJobsFetchInactive(s.IncludeInactiveJobs),
+	}
+}
+
+func validateEndpoint(name, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL, got %q", name, endpoint)
+	}
+
+	return nil
+}
+
+// defaultSettings returns the settings used when jsonData is empty.
+func defaultSettings() *Settings {
+	settings := &Settings{}
+	settings.setDefaults()
+	return settings
+}
+
+// parseSettings decodes and validates the jsonData of the datasource
+// instance. Missing values get their defaults.
+func parseSettings(instanceSettings backend.DataSourceInstanceSettings) (*Settings, error) {
+	settings := &Settings{}
+
+	if len(instanceSettings.JSONData) > 0 {
+		if err := json.Unmarshal(instanceSettings.JSONData, settings); err != nil {
+			return nil, fmt.Errorf("invalid datasource settings: %w", err)
+		}
+	}
+	settings.fixtureKey = instanceSettings.DecryptedSecureJSONData[FixtureKey]
+	settings.setDefaults()
+	if err := settings.validate(); err != nil {
+		return nil, fmt.Errorf("invalid datasource settings: %w", err)
+	}
+
+	return settings, nil
+}
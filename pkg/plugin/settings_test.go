@@ -0,0 +1,269 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestParseSettings(t *testing.T) {
+	settings, err := parseSettings(backend.DataSourceInstanceSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Endpoint != defaultEndpoint || time.Duration(settings.Timeout) != timeout ||
+		time.Duration(settings.AppsTTL) != appsDefaultTTL {
+		t.Errorf("unexpected defaults %+v", settings)
+	}
+
+	settings, err = parseSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"timeout": 30, "appsTTL": "1h", "endpoint": "https://ns1.example.com/v1"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(settings.Timeout) != 30*time.Second || time.Duration(settings.AppsTTL) != time.Hour {
+		t.Errorf("unexpected durations %+v", settings)
+	}
+
+	for _, jsonData := range []string{
+		`{"timeout": "forever"}`,
+		`{"timeout": "1h"}`,
+		`{"appsTTL": -5}`,
+		`{"endpoint": "api.nsone.net"}`,
+		`{"fallbackEndpoint": "https://api.nsone.net/v1"}`,
+		`{"debug": "yes"}`,
+		`{"defaultAgg": "median"}`,
+		`{"performanceUnit": "us"}`,
+		`{"maxResponseSize": -2}`,
+		`{"maxResponseSize": 2048}`,
+		`{"apiKeyEnv": "GF_SECURITY_SECRET_KEY"}`,
+		`{"defaultMetricType": "latency"}`,
+		`{"logLevel": "verbose"}`,
+		`{"enableDhcp": true}`,
+	} {
+		if _, err = parseSettings(backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)}); err == nil {
+			t.Errorf("%s: expected an error", jsonData)
+		}
+	}
+}
+
+func TestGetAPIKeysFromContext(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ns1-key")
+	if err := os.WriteFile(file, []byte("file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PULSAR_TEST_API_KEY", "env-key")
+	defer os.Unsetenv("PULSAR_TEST_API_KEY")
+	os.Setenv(apiKeyDirEnv, filepath.Dir(file))
+	defer os.Unsetenv(apiKeyDirEnv)
+
+	tests := []struct {
+		secureData map[string]string
+		settings   Settings
+		user       *backend.User
+		want       string
+	}{
+		{map[string]string{APIKey: "secure-key"}, Settings{APIKeyEnv: "PULSAR_TEST_API_KEY"}, nil, "secure-key"},
+		{map[string]string{APIKey: "secure-key", OrgAPIKeyPrefix + "1": "org-key"}, Settings{}, nil, "org-key"},
+		{nil, Settings{APIKeyEnv: "PULSAR_TEST_API_KEY", APIKeyFile: file}, nil, "env-key"},
+		{nil, Settings{APIKeyEnv: "PULSAR_TEST_MISSING_KEY", APIKeyFile: file}, nil, "file-key"},
+		{
+			map[string]string{OrgAPIKeyPrefix + "1": "org-key", UserAPIKeyPrefix + "jdoe": "user-key",
+				RoleAPIKeyPrefix + "Viewer": "role-key"},
+			Settings{}, &backend.User{Login: "jdoe", Role: "Viewer"}, "user-key",
+		},
+		{
+			map[string]string{OrgAPIKeyPrefix + "1": "org-key", RoleAPIKeyPrefix + "Viewer": "role-key"},
+			Settings{}, &backend.User{Login: "jdoe", Role: "Viewer"}, "role-key",
+		},
+		{
+			map[string]string{OrgAPIKeyPrefix + "1": "org-key", RoleAPIKeyPrefix + "Admin": "role-key"},
+			Settings{}, &backend.User{Login: "jdoe", Role: "Viewer"}, "org-key",
+		},
+	}
+	for _, tt := range tests {
+		keys, err := getAPIKeysFromContext(backend.PluginContext{
+			OrgID: 1,
+			User:  tt.user,
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: tt.secureData,
+			},
+		}, &tt.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys.primary != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, keys.primary)
+		}
+	}
+
+	_, err := getAPIKeysFromContext(backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{},
+	}, &Settings{APIKeyEnv: "PULSAR_TEST_MISSING_KEY"})
+	if !errors.Is(err, errAPIKeyNotFound) {
+		t.Errorf("expected errAPIKeyNotFound, got %v", err)
+	}
+}
+
+func TestAppsFiltering(t *testing.T) {
+	apps := &GetAppsResponse{Apps: []App{
+		{AppID: "a", Jobs: []Job{{JobID: "a1"}}},
+		{AppID: "b", Jobs: []Job{{JobID: "b1"}}},
+		{AppID: "c", Jobs: []Job{{JobID: "c1"}}},
+	}}
+	settings := &Settings{AllowedApps: []string{"a", "b"}, DeniedApps: []string{"b"}}
+
+	filtered := apps.filter(settings.isAppAllowed)
+	if len(filtered.Apps) != 1 || filtered.Apps[0].AppID != "a" {
+		t.Fatalf("unexpected apps %+v", filtered.Apps)
+	}
+
+	if err := checkQueryAllowed(&queryModel{AppID: "a", JobID: "a1"}, filtered); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, qm := range []*queryModel{{AppID: "b", JobID: "b1"}, {AppID: "a", JobID: "c1"}} {
+		if err := checkQueryAllowed(qm, filtered); !errors.Is(err, errAppNotAllowed) {
+			t.Errorf("%+v: expected errAppNotAllowed, got %v", qm, err)
+		}
+	}
+}
+
+func TestCheckFeatures(t *testing.T) {
+	tests := []struct {
+		qm      *queryModel
+		enable  func(*FeatureFlags)
+		feature string
+	}{
+		{&queryModel{MetricType: metricTypeDecisions}, func(f *FeatureFlags) { f.EnableDecisions = true }, "enableDecisions"},
+		{&queryModel{QueryType: queryTypeDecisionAnswers}, func(f *FeatureFlags) { f.EnableDecisions = true }, "enableDecisions"},
+		{&queryModel{QueryType: queryTypeOutages}, func(f *FeatureFlags) { f.EnableDecisions = true }, "enableDecisions"},
+		{&queryModel{QueryType: queryTypeDHCPScopes}, func(f *FeatureFlags) { f.EnableDHCP = true }, "enableDhcp"},
+	}
+	for _, tt := range tests {
+		settings := defaultSettings()
+		err := settings.checkFeatures(tt.qm)
+		if !errors.Is(err, errFeatureDisabled) || !strings.Contains(err.Error(), tt.feature) {
+			t.Errorf("%+v: expected the query to need %s, got %v", tt.qm, tt.feature, err)
+		}
+		tt.enable(&settings.FeatureFlags)
+		if err = settings.checkFeatures(tt.qm); err != nil {
+			t.Errorf("%+v: expected the query to be allowed with %s, got %v", tt.qm, tt.feature, err)
+		}
+	}
+
+	for _, qm := range []*queryModel{{MetricType: metricTypePerformance}, {MetricType: metricTypeAvailability}} {
+		if err := defaultSettings().checkFeatures(qm); err != nil {
+			t.Errorf("%+v: expected no feature needed, got %v", qm, err)
+		}
+	}
+
+	settings := defaultSettings()
+	if err := settings.checkStreaming(); !errors.Is(err, errFeatureDisabled) {
+		t.Errorf("expected the live channels disabled by default, got %v", err)
+	}
+	settings.EnableStreaming = true
+	if err := settings.checkStreaming(); err != nil {
+		t.Errorf("expected the live channels enabled, got %v", err)
+	}
+}
+
+func TestBuildLabel(t *testing.T) {
+	app := App{AppID: "a", Name: "Shop", Category: "web", Tags: map[string]string{"env": "prod", "team": ""}}
+	job := Job{Name: "CDN", Type: jobTypeCustom}
+	qm := &queryModel{AppID: "a", JobID: "j", MetricType: metricTypePerformance, Aggregation: "p95", Geo: "EUROPE",
+		ASN: "*"}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{{app}}", "Shop"},
+		{"{{appid}}", "a"},
+		{"{{job}}", "CDN"},
+		{"{{jobid}}", "j"},
+		{"{{jobtype}}", "custom"},
+		{"{{metric}}", "performance"},
+		{"{{agg}}", "p95"},
+		{"{{geo}}", "EUROPE"},
+		{"{{asn}}", "*"},
+		{"{{category}}", "web"},
+		{"{{tag:env}}", "prod"},
+		// The empty and unknown tags are left empty.
+		{"[{{tag:team}}]", "[]"},
+		{"[{{tag:owner}}]", "[]"},
+		// The unknown placeholders are kept as is.
+		{"{{region}}", "{{region}}"},
+		{"{{app}}/{{job}} {{metric}} [{{category}} {{tag:env}}]", "Shop/CDN performance [web prod]"},
+		// Without template, every field is in the label.
+		{"", "Shop (a):CDN (j):performance:p95:EUROPE:*"},
+	}
+	for _, tt := range tests {
+		if label := buildLabel(app, job, qm, tt.template); label != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.template, tt.want, label)
+		}
+	}
+}
+
+func TestLabelTemplate(t *testing.T) {
+	settings := &Settings{LabelTemplate: "{{job}} {{geo}}"}
+	if template := settings.labelTemplate(&queryModel{}); template != "{{job}} {{geo}}" {
+		t.Errorf("expected the template of the datasource without alias, got %q", template)
+	}
+	if template := settings.labelTemplate(&queryModel{Alias: "{{app}}"}); template != "{{app}}" {
+		t.Errorf("expected the alias of the query over the datasource template, got %q", template)
+	}
+	if template := (&Settings{}).labelTemplate(&queryModel{}); template != "" {
+		t.Errorf("expected the default label without template, got %q", template)
+	}
+}
+
+func TestRoleApps(t *testing.T) {
+	settings := defaultSettings()
+	settings.DeniedApps = []string{"b"}
+	settings.RoleApps = map[string][]string{"Viewer": {"a", "b"}, "Editor": {}}
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role    string
+		visible []string
+	}{
+		{"Viewer", []string{"a"}},
+		{"Editor", nil},
+		{"Admin", []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		p := &PulsarDatasource{settings: settings}
+		allowed := p.appFilter(withUser(context.Background(), &backend.User{Role: tt.role}))
+		var visible []string
+		for _, appID := range []string{"a", "b", "c"} {
+			if allowed(appID) {
+				visible = append(visible, appID)
+			}
+		}
+		if fmt.Sprint(visible) != fmt.Sprint(tt.visible) {
+			t.Errorf("%s: expected apps %v, got %v", tt.role, tt.visible, visible)
+		}
+	}
+
+	if (&Settings{}).appFilter("Viewer") != nil {
+		t.Error("expected no restriction without allowlists")
+	}
+	settings.RoleApps["Owner"] = []string{"a"}
+	if err := settings.validate(); err == nil {
+		t.Error("expected an unknown role to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// queryTypeShedLoad is the type of the queries of the load of the answers
+	// of a record shedding load, against its watermarks.
+	queryTypeShedLoad = "shedLoad"
+	// shedLoadFilter is the type of the filter shedding the load.
+	shedLoadFilter = "shed_load"
+	// defaultShedLoadMetric is the metric of the shed_load filters without
+	// one.
+	defaultShedLoadMetric = "loadavg"
+)
+
+// The states of the answers of a record shedding load.
+const (
+	shedStateOK       = "ok"
+	shedStateShedding = "shedding"
+	shedStateShed     = "shed"
+)
+
+var errInvalidShedLoadQuery = newInvalidQueryError("invalid shed load query, the record needs its zone, domain and type")
+
+// ShedLoad is the load of an answer of a record shedding load.
+type ShedLoad struct {
+	Answer string
+	Metric string
+	// Value is nil when it comes from a data feed, or isn't set.
+	Value *float64
+	// Feed is the data feed setting the value, if any.
+	Feed          string
+	LowWatermark  *float64
+	HighWatermark *float64
+}
+
+// state returns whether the answer is considered, partially shed, or shed by
+// the filter: the traffic is shed progressively between the watermarks.
+func (s *ShedLoad) state() string {
+	switch {
+	case s.Value == nil:
+		return ""
+	case s.HighWatermark != nil && *s.Value >= *s.HighWatermark:
+		return shedStateShed
+	case s.LowWatermark != nil && *s.Value > *s.LowWatermark:
+		return shedStateShedding
+	default:
+		return shedStateOK
+	}
+}
+
+// metaValue returns the number, or the data feed, of a metadata value.
+func metaValue(value interface{}) (*float64, string) {
+	switch v := value.(type) {
+	case float64:
+		return &v, ""
+	case int:
+		f := float64(v)
+		return &f, ""
+	case map[string]interface{}:
+		feed, _ := v["feed"].(string)
+		return nil, feed
+	default:
+		return nil, ""
+	}
+}
+
+// GetShedLoad returns the load of the answers of the record, and their
+// watermarks, against the metric of its shed_load filter. NS1 only has the
+// current values.
+func (pc *PulsarClient) GetShedLoad(ctx context.Context, apiKey string, qm *queryModel) (loads []ShedLoad, err error) {
+	_, span := startSpan(ctx, "GetShedLoad", "zone", qm.Zone, "domain", qm.Domain, "type", qm.RecordType)
+	defer func() { span.end(err) }()
+
+	record, _, err := pc.getAPIClient(apiKey).Records.Get(qm.Zone, qm.Domain, strings.ToUpper(qm.RecordType))
+	if err != nil {
+		return nil, convertZoneError(err)
+	}
+
+	metric := ""
+	for _, filter := range record.Filters {
+		if filter != nil && filter.Type == shedLoadFilter && !filter.Disabled {
+			metric, _ = filter.Config["metric"].(string)
+			if metric == "" {
+				metric = defaultShedLoadMetric
+			}
+		}
+	}
+	if metric == "" {
+		return nil, fmt.Errorf("%w: no shed_load filter on %s %s", errNoDataFound, qm.Domain, strings.ToUpper(qm.RecordType))
+	}
+
+	for _, answer := range record.Answers {
+		load := ShedLoad{Answer: strings.Join(answer.Rdata, " "), Metric: metric}
+		if meta := answer.Meta; meta != nil {
+			switch metric {
+			case "connections":
+				load.Value, load.Feed = metaValue(meta.Connections)
+			case "requests":
+				load.Value, load.Feed = metaValue(meta.Requests)
+			default:
+				load.Value, load.Feed = metaValue(meta.LoadAvg)
+			}
+			load.LowWatermark, _ = metaValue(meta.LowWatermark)
+			load.HighWatermark, _ = metaValue(meta.HighWatermark)
+		}
+		loads = append(loads, load)
+	}
+
+	return loads, nil
+}
+
+// queryShedLoad answers the shed load queries with a table of the answers of
+// the record, their load and watermarks, and their state.
+func (p *PulsarDatasource) queryShedLoad(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if qm.Zone == "" || qm.Domain == "" || qm.RecordType == "" {
+		return backend.DataResponse{Error: errInvalidShedLoadQuery}
+	}
+
+	loads, err := p.pulsarAPI().GetShedLoad(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	now := time.Now()
+	frame := data.NewFrame("shed load",
+		data.NewField("time", nil, []time.Time{}),
+		data.NewField("answer", nil, []string{}),
+		data.NewField("metric", nil, []string{}),
+		data.NewField("value", nil, []*float64{}),
+		data.NewField("low watermark", nil, []*float64{}),
+		data.NewField("high watermark", nil, []*float64{}),
+		data.NewField("state", nil, []string{}),
+		data.NewField("feed", nil, []string{}),
+	)
+	for i := range loads {
+		l := &loads[i]
+		frame.AppendRow(now, l.Answer, l.Metric, l.Value, l.LowWatermark, l.HighWatermark, l.state(), l.Feed)
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -0,0 +1,60 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryShedLoad(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zones/example.com/www.example.com/A":
+			fmt.Fprint(w, `{"zone": "example.com", "domain": "www.example.com", "type": "A",
+				"filters": [{"filter": "up"}, {"filter": "shed_load", "config": {"metric": "connections"}}],
+				"answers": [
+					{"answer": ["1.1.1.1"], "meta": {"connections": 50, "low_watermark": 100, "high_watermark": 200}},
+					{"answer": ["2.2.2.2"], "meta": {"connections": 150, "low_watermark": 100, "high_watermark": 200}},
+					{"answer": ["3.3.3.3"], "meta": {"connections": 250, "low_watermark": 100, "high_watermark": 200}},
+					{"answer": ["4.4.4.4"], "meta": {"connections": {"feed": "f1"}, "low_watermark": 100, "high_watermark": 200}}
+				]}`)
+		case "/zones/example.com/api.example.com/A":
+			fmt.Fprint(w, `{"zone": "example.com", "domain": "api.example.com", "type": "A", "filters": [{"filter": "up"}]}`)
+		}
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	res := p.queryShedLoad(context.Background(), "key", &queryModel{Zone: "example.com", Domain: "www.example.com", RecordType: "a"})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); rows != 4 {
+		t.Fatalf("expected a row per answer, got %d", rows)
+	}
+	for i, state := range []string{shedStateOK, shedStateShedding, shedStateShed, ""} {
+		if frame.Fields[6].At(i) != state {
+			t.Errorf("expected answer %v to be %q, got %q", frame.Fields[1].At(i), state, frame.Fields[6].At(i))
+		}
+	}
+	if frame.Fields[2].At(0) != "connections" || frame.Fields[7].At(3) != "f1" {
+		t.Errorf("expected the metric of the filter and the feed of the value, got %v", frame.Fields)
+	}
+
+	res = p.queryShedLoad(context.Background(), "key", &queryModel{Zone: "example.com", Domain: "api.example.com", RecordType: "A"})
+	if !errors.Is(res.Error, errNoDataFound) {
+		t.Errorf("expected errNoDataFound, got %v", res.Error)
+	}
+	res = p.queryShedLoad(context.Background(), "key", &queryModel{Domain: "www.example.com"})
+	if !errors.Is(res.Error, errInvalidShedLoadQuery) {
+		t.Errorf("expected errInvalidShedLoadQuery, got %v", res.Error)
+	}
+}
@@ -0,0 +1,98 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeStatusBoard is the type of the queries of the latest availability
+// and latency of the jobs, for the stat and gauge panels.
+const queryTypeStatusBoard = "statusBoard"
+
+var errInvalidStatusBoard = newInvalidQueryError("invalid status board query, the app is missing")
+
+// queryStatusBoard answers the status board queries with a single row: the
+// latest availability and latency over the range of the job of the query, or
+// of every job of its app, a field each, with the thresholds of the job. The
+// panels repeat over the fields, e.g. a gauge per job. The values are null
+// when the job has no data.
+func (p *PulsarDatasource) queryStatusBoard(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	if err := p.settings.checkFeatures(qm); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if qm.AppID == "" {
+		return backend.DataResponse{Error: errInvalidStatusBoard}
+	}
+
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+	if err = checkQueryAllowed(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	if err = checkJobType(qm, appsResponse); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	qm.applyDefaults(p.settings)
+	qm.validate()
+	if qm.Aggregation == "" {
+		qm.Aggregation = defaultStreamAggregation
+	}
+	// The latest point only.
+	qm.MaxDataPoints = 1
+	// Each metric gets the unit of the datasource.
+	qm.Unit = ""
+
+	app := appsResponse.AppsMap[qm.AppID]
+	frame := data.NewFrame("status board")
+	for _, job := range app.Jobs {
+		if (qm.JobID != "" && job.JobID != qm.JobID) || (qm.JobType != "" && job.Type != qm.JobType) {
+			continue
+		}
+		for _, metric := range []string{metricTypeAvailability, metricTypePerformance} {
+			jobQuery := *qm
+			jobQuery.JobID = job.JobID
+			jobQuery.MetricType = metric
+			if metric == metricTypeAvailability {
+				jobQuery.Aggregation = defaultStreamAggregation
+			}
+			unit := p.settings.unit(&jobQuery)
+
+			var latest *float64
+			_, values, err := p.getData(ctx, apiKey, &jobQuery)
+			if err != nil && !errors.Is(err, errNoDataFound) {
+				return backend.DataResponse{Error: err}
+			}
+			if len(values) > 0 {
+				value := values[len(values)-1] / unitDivisor(unit)
+				latest = &value
+			}
+
+			name := "availability"
+			if metric == metricTypePerformance {
+				name = "latency"
+			}
+			thresholds := p.settings.jobThresholds(app, job).config(metric)
+			scaleThresholds(thresholds, unitDivisor(unit))
+			field := data.NewField(name, seriesLabels(app, job, &jobQuery), []*float64{latest})
+			field.Config = &data.FieldConfig{DisplayNameFromDS: fmt.Sprintf("%s %s", job.Name, name),
+				Thresholds: thresholds, Unit: grafanaUnit(unit)}
+			frame.Fields = append(frame.Fields, field)
+		}
+	}
+	frame.Meta = &data.FrameMeta{Custom: appsResponse.Apps}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -0,0 +1,60 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryStatusBoard(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 97))
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.AvailabilityUnit = unitRatio
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		})
+	}
+
+	res := query(`{"queryType": "statusBoard", "appid": "app1"}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if rows, _ := frame.RowLen(); len(frame.Fields) != 4 || rows != 1 {
+		t.Fatalf("expected a row with the availability and latency of both jobs, got %d fields", len(frame.Fields))
+	}
+	availability, latency := frame.Fields[0], frame.Fields[1]
+	if value := availability.At(0).(*float64); value == nil || *value != 0.97 || availability.Config.Unit != "percentunit" {
+		t.Errorf("expected the latest availability in the unit of the datasource, got %v", value)
+	}
+	if value := latency.At(0).(*float64); value == nil || *value != 97 || latency.Labels["jobid"] != "job1" {
+		t.Errorf("expected the latest latency of job1, got %v", value)
+	}
+	if frame.Fields[2].At(0).(*float64) != nil {
+		t.Error("expected no availability for job2 without data")
+	}
+
+	res = query(`{"queryType": "statusBoard", "appid": "app1", "jobid": "job2"}`)
+	if res.Error != nil || len(res.Frames[0].Fields) != 2 {
+		t.Errorf("expected the fields of job2 only, got %v", res.Error)
+	}
+	if res := query(`{"queryType": "statusBoard"}`); !errors.Is(res.Error, errInvalidStatusBoard) {
+		t.Errorf("expected errInvalidStatusBoard, got %v", res.Error)
+	}
+}
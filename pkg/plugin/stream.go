@@ -0,0 +1,474 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultStreamInterval = 30 * time.Second
+	minStreamInterval     = 10 * time.Second
+	// streamBackfill is the range sent when the stream starts, so the panels
+	// don't start empty.
+	streamBackfill = 15 * time.Minute
+	// defaultStreamAggregation is used by the channels not selecting an
+	// aggregation, when the datasource has no default one.
+	defaultStreamAggregation = "avg"
+	// defaultAvailabilityThreshold is the availability, in percent, below
+	// which a job is considered down.
+	defaultAvailabilityThreshold = 95
+)
+
+const (
+	streamKindSeries    = "series"
+	streamKindEvents    = "events"
+	streamKindDecisions = "decisions"
+	streamKindLast      = "last"
+)
+
+var errInvalidChannel = errors.New("invalid stream channel")
+
+// streamChannel is a channel the panels can subscribe to, relative to the
+// ds/<datasource uid>/ scope. The series channels, perf/<jobid>/<geo>[/<agg>]
+// and avail/<jobid>/<geo>[/<agg>], push the data points. The events channel,
+// events/<jobid>/<geo>[/<threshold>], pushes the availability state changes.
+// The decisions channel, decisions/<appid>/<geo>, pushes the decisions of the
+// jobs of an app. The series channels prefixed with last/ only push the
+// latest value, when it changes.
+type streamChannel struct {
+	kind        string
+	metricType  string
+	appID       string
+	jobID       string
+	geo         string
+	aggregation string
+	threshold   float64
+}
+
+var streamMetricTypes = map[string]string{
+	"perf":      metricTypePerformance,
+	"avail":     metricTypeAvailability,
+	"events":    metricTypeAvailability,
+	"decisions": metricTypeDecisions,
+}
+
+func parseStreamPath(path string, settings *Settings) (*streamChannel, error) {
+	if seriesPath := strings.TrimPrefix(path, streamKindLast+"/"); seriesPath != path {
+		channel, err := parseStreamPath(seriesPath, settings)
+		if err != nil || channel.kind != streamKindSeries {
+			return nil, fmt.Errorf("%w: %q", errInvalidChannel, path)
+		}
+		channel.kind = streamKindLast
+		return channel, nil
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, fmt.Errorf("%w: %q", errInvalidChannel, path)
+	}
+
+	metricType, ok := streamMetricTypes[parts[0]]
+	if !ok || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("%w: %q", errInvalidChannel, path)
+	}
+
+	channel := &streamChannel{
+		kind:        streamKindSeries,
+		metricType:  metricType,
+		jobID:       parts[1],
+		geo:         parts[2],
+		aggregation: settings.DefaultAggregation,
+	}
+	if strings.EqualFold(channel.geo, "GLOBAL") {
+		channel.geo = "*"
+	}
+
+	if parts[0] == streamKindDecisions {
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: %q", errInvalidChannel, path)
+		}
+		channel.kind = streamKindDecisions
+		channel.appID, channel.jobID = parts[1], ""
+		channel.aggregation = ""
+		return channel, nil
+	}
+
+	if parts[0] == streamKindEvents {
+		channel.kind = streamKindEvents
+		channel.aggregation = defaultStreamAggregation
+		channel.threshold = settings.availabilityThreshold()
+		if len(parts) == 4 {
+			threshold, err := strconv.ParseFloat(parts[3], 64)
+			if err != nil || threshold < 0 || threshold > 100 {
+				return nil, fmt.Errorf("%w: invalid threshold %q", errInvalidChannel, parts[3])
+			}
+			channel.threshold = threshold
+		}
+		return channel, nil
+	}
+
+	if len(parts) == 4 {
+		channel.aggregation = parts[3]
+	}
+	if channel.aggregation == "" {
+		channel.aggregation = defaultStreamAggregation
+	}
+	if !isValidAggregation(channel.aggregation) {
+		return nil, fmt.Errorf("%w: unknown aggregation %q", errInvalidChannel, channel.aggregation)
+	}
+
+	return channel, nil
+}
+
+// query returns the query of the channel data points more recent than last.
+func (c *streamChannel) query(appID string, last time.Time) *queryModel {
+	return &queryModel{
+		AppID:       appID,
+		JobID:       c.jobID,
+		MetricType:  c.metricType,
+		Geo:         c.geo,
+		ASN:         "*",
+		Aggregation: c.aggregation,
+		// The start of the range is inclusive, and the last point was sent.
+		From: last.Add(time.Second),
+		To:   time.Now(),
+	}
+}
+
+// fetchNewPoints gets the data points of the stream query. Only the range
+// after the last point sent is requested, so the NS1 usage of the streams
+// follows the amount of new data rather than the size of the window.
+func (pc *PulsarClient) fetchNewPoints(ctx context.Context, apiKey string, qm *queryModel) ([]map[string]float64, error) {
+	points, err := pc.fetchRange(ctx, apiKey, pc.getAPIClient(apiKey).Endpoint.String(), qm)
+	if err != nil {
+		return nil, err
+	}
+
+	newPoints := points[:0]
+	for _, point := range points {
+		if int64(point["timestamp"]) >= qm.From.Unix() {
+			newPoints = append(newPoints, point)
+		}
+	}
+	return newPoints, nil
+}
+
+// findJob returns the app holding the job visible through the datasource.
+func (p *PulsarDatasource) findJob(ctx context.Context, apiKey, jobID string) (App, Job, error) {
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, apiKey, p.settings.appParameters()...)
+	if err != nil {
+		return App{}, Job{}, err
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+
+	for _, app := range appsResponse.Apps {
+		for _, job := range app.Jobs {
+			if job.JobID == jobID {
+				return app, job, nil
+			}
+		}
+	}
+	return App{}, Job{}, fmt.Errorf("%w: job %s", errAppNotAllowed, jobID)
+}
+
+// checkStreamAccess rejects the channels of the apps and jobs not visible
+// through the datasource, and of the disabled features.
+func (p *PulsarDatasource) checkStreamAccess(ctx context.Context, apiKey string, channel *streamChannel) error {
+	if channel.kind == streamKindDecisions {
+		if err := p.settings.checkFeatures(&queryModel{MetricType: channel.metricType}); err != nil {
+			return err
+		}
+		_, err := p.findApp(ctx, apiKey, channel.appID)
+		return err
+	}
+
+	_, _, err := p.findJob(ctx, apiKey, channel.jobID)
+	return err
+}
+
+// SubscribeStream allows the subscriptions to the channels of the jobs visible
+// through the datasource: the jobs must be in the apps catalog of the key, and
+// their app allowed by the datasource and by the role of the user.
+func (p *PulsarDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	p.ensureInitialized()
+
+	if err := p.settings.checkStreaming(); err != nil {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+	channel, err := parseStreamPath(req.Path, p.settings)
+	if err != nil {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	keys, err := p.apiKeys(req.PluginContext)
+	if err == nil {
+		err = p.checkStreamAccess(withUser(ctx, req.PluginContext.User), keys.pick(&p.keyFallback), channel)
+	}
+	switch {
+	case err == nil:
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+	case errors.Is(err, errAppNotAllowed), errors.Is(err, errFeatureDisabled),
+		errors.Is(err, errAPIKeyNotFound), errors.Is(err, errDecryptedSecureDataNil):
+		pluginContextLogger(p.logger, req.PluginContext).Info("Stream subscription denied", "path", req.Path,
+			"reason", err)
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	default:
+		return nil, err
+	}
+}
+
+// PublishStream rejects every publication, the channels are read-only.
+func (p *PulsarDatasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream pushes the new data points of the channel until Grafana ends the
+// stream. The streams of the same channel share a single poller.
+func (p *PulsarDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	p.ensureInitialized()
+
+	if err := p.settings.checkStreaming(); err != nil {
+		return err
+	}
+	channel, err := parseStreamPath(req.Path, p.settings)
+	if err != nil {
+		return err
+	}
+	keys, err := p.apiKeys(req.PluginContext)
+	if err != nil {
+		return err
+	}
+
+	// The organizations, users and roles may use different keys, they don't
+	// share pollers.
+	key := fmt.Sprintf("%d/%s/%s", req.PluginContext.OrgID, keys.route, req.Path)
+	logger := withFields(pluginContextLogger(p.logger, req.PluginContext), "path", req.Path)
+	err = p.streams.join(key, sender, func(ctx context.Context, sender *backend.StreamSender) {
+		p.pollStream(withLogger(ctx, logger), channel, keys, sender)
+	})
+	if err != nil {
+		return err
+	}
+	defer p.streams.leave(key, sender)
+	recordFeatureUse(ctx, featureStreaming)
+	if channel.kind == streamKindDecisions {
+		recordFeatureUse(ctx, featureDecisions)
+	}
+
+	// Once the datasource is disposed, e.g. when its settings change, the
+	// stream ends so Grafana restarts it on the new instance.
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-p.streams.closing():
+		return errStreamsClosed
+	}
+}
+
+// pollStream polls NS1 every stream interval and pushes the new data points
+// of the channel, until ctx is done.
+func (p *PulsarDatasource) pollStream(ctx context.Context, channel *streamChannel, keys *apiKeys, sender *backend.StreamSender) {
+	var (
+		push func(apiKey string) error
+		err  error
+	)
+	ctx = withDataAPIKey(ctx, keys.data)
+	last := time.Now().Add(-streamBackfill)
+	switch channel.kind {
+	case streamKindEvents:
+		tracker := &availabilityTracker{threshold: channel.threshold, last: last}
+		push = func(apiKey string) error {
+			return p.pushAvailabilityEvents(ctx, apiKey, channel, tracker, sender)
+		}
+	case streamKindDecisions:
+		push = func(apiKey string) error {
+			last, err = p.pushDecisions(ctx, apiKey, channel, last, sender)
+			return err
+		}
+	case streamKindLast:
+		tracker := &lastValueTracker{last: last}
+		push = func(apiKey string) error {
+			return p.pushLastValue(ctx, apiKey, channel, tracker, sender)
+		}
+	default:
+		push = func(apiKey string) error {
+			last, err = p.pushStreamData(ctx, apiKey, channel, last, sender)
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(p.settings.streamInterval())
+	defer ticker.Stop()
+
+	for {
+		if err = push(keys.pick(&p.keyFallback)); err != nil {
+			loggerFromContext(ctx).Warn("Failed to stream the Pulsar data", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamBatch holds the new data points of a channel.
+type streamBatch struct {
+	app    App
+	job    Job
+	query  *queryModel
+	times  []time.Time
+	values []float64
+}
+
+// fetchStreamData returns the data points of the channel more recent than
+// last.
+func (p *PulsarDatasource) fetchStreamData(ctx context.Context, apiKey string, channel *streamChannel, last time.Time) (*streamBatch, error) {
+	app, job, err := p.findJob(ctx, apiKey, channel.jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &streamBatch{app: app, job: job, query: channel.query(app.AppID, last)}
+	points, err := p.pulsarClient.fetchNewPoints(ctx, apiKey, batch.query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, point := range points {
+		batch.times = append(batch.times, time.Unix(int64(point["timestamp"]), 0))
+		batch.values = append(batch.values, point[channel.jobID])
+	}
+
+	return batch, nil
+}
+
+// pushStreamData sends the data points more recent than last, returning the
+// time of the latest point sent.
+func (p *PulsarDatasource) pushStreamData(ctx context.Context, apiKey string, channel *streamChannel, last time.Time, sender *backend.StreamSender) (time.Time, error) {
+	batch, err := p.fetchStreamData(ctx, apiKey, channel, last)
+	if err != nil || len(batch.times) == 0 {
+		return last, err
+	}
+
+	label := buildLabel(batch.app, batch.job, batch.query, p.settings.LabelTemplate)
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, batch.times),
+		data.NewField(label, nil, batch.values),
+	)
+	if err = sender.SendFrame(frame, data.IncludeAll); err != nil {
+		return last, err
+	}
+
+	return batch.times[len(batch.times)-1], nil
+}
+
+const (
+	availabilityUp   = "up"
+	availabilityDown = "down"
+)
+
+// availabilityTracker follows the availability state of a job, to tell when
+// it crosses the threshold.
+type availabilityTracker struct {
+	threshold float64
+	state     string
+	last      time.Time
+}
+
+// pushAvailabilityEvents sends an event for every change of the availability
+// state since the last call. The first state known is sent too, so the status
+// panels show the current state as soon as they subscribe.
+func (p *PulsarDatasource) pushAvailabilityEvents(ctx context.Context, apiKey string, channel *streamChannel, tracker *availabilityTracker, sender *backend.StreamSender) error {
+	batch, err := p.fetchStreamData(ctx, apiKey, channel, tracker.last)
+	if err != nil || len(batch.times) == 0 {
+		return err
+	}
+	times, values := batch.times, batch.values
+
+	var (
+		eventTimes  []time.Time
+		states      []string
+		previous    []string
+		eventValues []float64
+	)
+	for i, t := range times {
+		state := availabilityUp
+		if values[i] < tracker.threshold {
+			state = availabilityDown
+		}
+		if state != tracker.state {
+			eventTimes = append(eventTimes, t)
+			states = append(states, state)
+			previous = append(previous, tracker.state)
+			eventValues = append(eventValues, values[i])
+			tracker.state = state
+		}
+	}
+	tracker.last = times[len(times)-1]
+
+	if len(eventTimes) == 0 {
+		return nil
+	}
+
+	frame := data.NewFrame("events",
+		data.NewField("time", nil, eventTimes),
+		data.NewField("job", nil, repeatString(channel.jobID, len(eventTimes))),
+		data.NewField("state", nil, states),
+		data.NewField("previous", nil, previous),
+		data.NewField("availability", nil, eventValues),
+	)
+	return sender.SendFrame(frame, data.IncludeAll)
+}
+
+func repeatString(s string, n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = s
+	}
+	return values
+}
+
+// lastValueTracker remembers the latest value sent by a last value channel.
+type lastValueTracker struct {
+	last  time.Time
+	value *float64
+}
+
+// pushLastValue sends the latest data point of the channel, when its value
+// differs from the one sent before.
+func (p *PulsarDatasource) pushLastValue(ctx context.Context, apiKey string, channel *streamChannel, tracker *lastValueTracker, sender *backend.StreamSender) error {
+	batch, err := p.fetchStreamData(ctx, apiKey, channel, tracker.last)
+	if err != nil || len(batch.times) == 0 {
+		return err
+	}
+
+	latest := len(batch.times) - 1
+	tracker.last = batch.times[latest]
+	value := batch.values[latest]
+	if tracker.value != nil && *tracker.value == value {
+		return nil
+	}
+	tracker.value = &value
+
+	label := buildLabel(batch.app, batch.job, batch.query, p.settings.LabelTemplate)
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{batch.times[latest]}),
+		data.NewField(label, nil, []float64{value}),
+	)
+	return sender.SendFrame(frame, data.IncludeAll)
+}
@@ -0,0 +1,259 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+type streamPacketSender struct {
+	packets []*backend.StreamPacket
+}
+
+func (s *streamPacketSender) Send(packet *backend.StreamPacket) error {
+	s.packets = append(s.packets, packet)
+	return nil
+}
+
+func TestParseStreamPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected *streamChannel
+	}{
+		{"perf/job1/GLOBAL", &streamChannel{kind: streamKindSeries, metricType: metricTypePerformance,
+			jobID: "job1", geo: "*", aggregation: "avg"}},
+		{"avail/job1/US/p95", &streamChannel{kind: streamKindSeries, metricType: metricTypeAvailability,
+			jobID: "job1", geo: "US", aggregation: "p95"}},
+		{"events/job1/US", &streamChannel{kind: streamKindEvents, metricType: metricTypeAvailability,
+			jobID: "job1", geo: "US", aggregation: "avg", threshold: defaultAvailabilityThreshold}},
+		{"events/job1/US/99.5", &streamChannel{kind: streamKindEvents, metricType: metricTypeAvailability,
+			jobID: "job1", geo: "US", aggregation: "avg", threshold: 99.5}},
+		{"events/job1/US/high", nil},
+		{"last/avail/job1/US", &streamChannel{kind: streamKindLast, metricType: metricTypeAvailability,
+			jobID: "job1", geo: "US", aggregation: "avg"}},
+		{"last/events/job1/US", nil},
+		{"perf/job1", nil},
+		{"other/job1/US", nil},
+		{"perf/job1/US/median", nil},
+	}
+
+	for _, tt := range tests {
+		channel, err := parseStreamPath(tt.path, defaultSettings())
+		if tt.expected == nil {
+			if !errors.Is(err, errInvalidChannel) {
+				t.Errorf("%s: expected an invalid channel error, got %v", tt.path, err)
+			}
+			continue
+		}
+		if err != nil || *channel != *tt.expected {
+			t.Errorf("%s: expected %+v, got %+v (%v)", tt.path, tt.expected, channel, err)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	now := time.Now().Unix()
+	var start string
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			start = r.URL.Query().Get("start")
+			fmt.Fprintf(w, `[{"timestamp": %d, "job1": 1}, {"timestamp": %d, "job1": 2}]`, now-60, now)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{
+		settings:     defaultSettings(),
+		pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL)),
+	}
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{APIKey: "key"},
+	}}
+
+	// The live channels ship dark.
+	res, err := p.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		PluginContext: pCtx,
+		Path:          "perf/job1/GLOBAL",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.SubscribeStreamStatusPermissionDenied {
+		t.Errorf("expected the subscription to be denied while streaming is disabled, got %v", res.Status)
+	}
+	err = p.RunStream(context.Background(), &backend.RunStreamRequest{PluginContext: pCtx, Path: "perf/job1/GLOBAL"}, nil)
+	if !errors.Is(err, errFeatureDisabled) {
+		t.Errorf("expected the stream not to run while streaming is disabled, got %v", err)
+	}
+	p.settings.EnableStreaming = true
+
+	for path, status := range map[string]backend.SubscribeStreamStatus{
+		"perf/job1/GLOBAL": backend.SubscribeStreamStatusOK,
+		"perf/job3/GLOBAL": backend.SubscribeStreamStatusPermissionDenied,
+		"perf/job1":        backend.SubscribeStreamStatusNotFound,
+	} {
+		res, err := p.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			PluginContext: pCtx,
+			Path:          path,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != status {
+			t.Errorf("%s: expected status %v, got %v", path, status, res.Status)
+		}
+	}
+
+	channel, _ := parseStreamPath("perf/job1/GLOBAL", p.settings)
+	packets := &streamPacketSender{}
+	sender := backend.NewStreamSender(packets)
+
+	last, err := p.pushStreamData(context.Background(), "key", channel, time.Now().Add(-time.Hour), sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets.packets) != 1 || last.Unix() != now {
+		t.Fatalf("expected a packet up to %d, got %d packets up to %d", now, len(packets.packets), last.Unix())
+	}
+
+	// Only the data after the last point sent is requested, and the points
+	// already sent aren't sent again.
+	if _, err = p.pushStreamData(context.Background(), "key", channel, last, sender); err != nil {
+		t.Fatal(err)
+	}
+	if start != fmt.Sprint(now+1) {
+		t.Errorf("expected the data since %d to be requested, got %s", now+1, start)
+	}
+	if len(packets.packets) != 1 {
+		t.Errorf("expected no new packet, got %d", len(packets.packets)-1)
+	}
+}
+
+func TestAvailabilityEvents(t *testing.T) {
+	now := time.Now().Unix()
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			fmt.Fprintf(w, `[{"timestamp": %d, "job1": 100}, {"timestamp": %d, "job1": 99},
+				{"timestamp": %d, "job1": 50}, {"timestamp": %d, "job1": 98}]`, now-180, now-120, now-60, now)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{
+		settings:     defaultSettings(),
+		pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL)),
+	}
+	channel, _ := parseStreamPath("events/job1/GLOBAL", p.settings)
+	packets := &streamPacketSender{}
+	tracker := &availabilityTracker{threshold: channel.threshold, last: time.Now().Add(-time.Hour)}
+
+	if err := p.pushAvailabilityEvents(context.Background(), "key", channel, tracker, backend.NewStreamSender(packets)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(packets.packets) != 1 {
+		t.Fatalf("expected a packet, got %d", len(packets.packets))
+	}
+	// The initial state, then down and up again.
+	body := string(packets.packets[0].Data)
+	if !strings.Contains(body, `["up","down","up"]`) || tracker.state != availabilityUp {
+		t.Errorf("unexpected events %s", body)
+	}
+}
+
+func TestSubscribeStreamAllowedApps(t *testing.T) {
+	server := testutil.NewServer()
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.DeniedApps = []string{"app1"}
+	settings.EnableDecisions = true
+	settings.EnableStreaming = true
+	p := &PulsarDatasource{
+		settings:     settings,
+		pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL)),
+	}
+
+	tests := []struct {
+		path    string
+		secrets map[string]string
+	}{
+		{"perf/job1/GLOBAL", map[string]string{APIKey: "key"}},
+		{"events/job2/GLOBAL", map[string]string{APIKey: "key"}},
+		{"decisions/app1/GLOBAL", map[string]string{APIKey: "key"}},
+		{"perf/job1/GLOBAL", map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		res, err := p.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				DecryptedSecureJSONData: tt.secrets,
+			}},
+			Path: tt.path,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != backend.SubscribeStreamStatusPermissionDenied {
+			t.Errorf("%s: expected the subscription to be denied, got %v", tt.path, res.Status)
+		}
+	}
+}
+
+func TestLastValueStream(t *testing.T) {
+	now := time.Now().Unix()
+	value := 99
+	handler := testutil.NewNS1()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/pulsar/query/") {
+			fmt.Fprintf(w, `[{"timestamp": %d, "job1": 100}, {"timestamp": %d, "job1": %d}]`, now-60, now, value)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{
+		settings:     defaultSettings(),
+		pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL)),
+	}
+	channel, _ := parseStreamPath("last/avail/job1/GLOBAL", p.settings)
+	packets := &streamPacketSender{}
+	sender := backend.NewStreamSender(packets)
+	tracker := &lastValueTracker{last: time.Now().Add(-time.Hour)}
+
+	// The unchanged value isn't sent again, the new one is.
+	for i, expected := range []int{1, 1, 2} {
+		if i == 2 {
+			value = 98
+		}
+		tracker.last = time.Now().Add(-time.Hour)
+		if err := p.pushLastValue(context.Background(), "key", channel, tracker, sender); err != nil {
+			t.Fatal(err)
+		}
+		if len(packets.packets) != expected {
+			t.Fatalf("push %d: expected %d packets, got %d", i, expected, len(packets.packets))
+		}
+	}
+	if !strings.Contains(string(packets.packets[1].Data), "[98]") {
+		t.Errorf("expected the latest value only, got %s", packets.packets[1].Data)
+	}
+}
@@ -0,0 +1,186 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// streamShutdownTimeout is how long close waits for the pollers to return.
+const streamShutdownTimeout = 5 * time.Second
+
+var errStreamsClosed = errors.New("the datasource was disposed, the stream has to be restarted")
+
+// streamHub runs a single poller per channel, whatever the number of streams
+// subscribed to it, and fans its packets out to every stream.
+type streamHub struct {
+	lock    sync.Mutex
+	pollers map[string]*streamPoller
+	wg      sync.WaitGroup
+	closed  bool
+	done    chan struct{}
+	logger  log.Logger
+}
+
+// init makes the zero value usable, the lock must be held.
+func (h *streamHub) init() {
+	if h.pollers == nil {
+		h.pollers = make(map[string]*streamPoller)
+		h.done = make(chan struct{})
+	}
+}
+
+// closing returns a channel closed once the hub is closed.
+func (h *streamHub) closing() <-chan struct{} {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.init()
+	return h.done
+}
+
+// streamPoller forwards the packets of a poller to the streams of the channel.
+// The last packet is replayed to the streams joining later, so they don't
+// start empty.
+type streamPoller struct {
+	lock        sync.Mutex
+	subscribers map[*backend.StreamSender]struct{}
+	lastPacket  []byte
+	cancel      context.CancelFunc
+	logger      log.Logger
+}
+
+// Send implements backend.StreamPacketSender. The packet is sent without the
+// lock, so a slow stream doesn't block the streams joining or leaving, and the
+// streams failing to receive it are dropped.
+func (sp *streamPoller) Send(packet *backend.StreamPacket) error {
+	sp.lock.Lock()
+	sp.lastPacket = packet.Data
+	senders := make([]*backend.StreamSender, 0, len(sp.subscribers))
+	for sender := range sp.subscribers {
+		senders = append(senders, sender)
+	}
+	sp.lock.Unlock()
+
+	var failed []*backend.StreamSender
+	for _, sender := range senders {
+		if err := sender.SendJSON(packet.Data); err != nil {
+			sp.logger.Warn("Failed to send a stream packet, dropping the stream", "error", err)
+			failed = append(failed, sender)
+		}
+	}
+
+	if len(failed) > 0 {
+		sp.lock.Lock()
+		for _, sender := range failed {
+			delete(sp.subscribers, sender)
+		}
+		sp.lock.Unlock()
+	}
+	return nil
+}
+
+func (sp *streamPoller) subscribe(sender *backend.StreamSender) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	sp.subscribers[sender] = struct{}{}
+	if sp.lastPacket != nil {
+		if err := sender.SendJSON(sp.lastPacket); err != nil {
+			sp.logger.Warn("Failed to send a stream packet", "error", err)
+		}
+	}
+}
+
+// unsubscribe returns the number of streams left.
+func (sp *streamPoller) unsubscribe(sender *backend.StreamSender) int {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	delete(sp.subscribers, sender)
+	return len(sp.subscribers)
+}
+
+// join subscribes the stream to the poller of the channel, starting it with
+// poll when the stream is the first one. It fails once the hub is closed.
+func (h *streamHub) join(channel string, sender *backend.StreamSender, poll func(context.Context, *backend.StreamSender)) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.init()
+	if h.closed {
+		return errStreamsClosed
+	}
+
+	poller, found := h.pollers[channel]
+	if !found {
+		ctx, cancel := context.WithCancel(context.Background())
+		poller = &streamPoller{
+			subscribers: make(map[*backend.StreamSender]struct{}),
+			cancel:      cancel,
+			logger:      loggerOrDefault(h.logger),
+		}
+		h.pollers[channel] = poller
+
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			poll(ctx, backend.NewStreamSender(poller))
+		}()
+	}
+
+	poller.subscribe(sender)
+	return nil
+}
+
+// leave unsubscribes the stream, stopping the poller once no stream is left.
+func (h *streamHub) leave(channel string, sender *backend.StreamSender) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	poller, found := h.pollers[channel]
+	if !found {
+		return
+	}
+	if poller.unsubscribe(sender) == 0 {
+		poller.cancel()
+		delete(h.pollers, channel)
+	}
+}
+
+// close stops every poller and waits for them to return, so no request to
+// NS1 is left running once the datasource is disposed. The streams are
+// notified through closing.
+func (h *streamHub) close() {
+	h.lock.Lock()
+	h.init()
+	if h.closed {
+		h.lock.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.done)
+	for channel, poller := range h.pollers {
+		poller.cancel()
+		delete(h.pollers, channel)
+	}
+	h.lock.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(streamShutdownTimeout):
+		loggerOrDefault(h.logger).Warn("Stream pollers still running after the datasource was disposed")
+	}
+}
@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestStreamHub(t *testing.T) {
+	var (
+		hub     streamHub
+		starts  int
+		started = make(chan *backend.StreamSender, 1)
+		stopped = make(chan struct{})
+	)
+	poll := func(ctx context.Context, sender *backend.StreamSender) {
+		started <- sender
+		<-ctx.Done()
+		close(stopped)
+	}
+
+	first, second := &streamPacketSender{}, &streamPacketSender{}
+	firstSender, secondSender := backend.NewStreamSender(first), backend.NewStreamSender(second)
+
+	_ = hub.join("1/perf/job1/GLOBAL", firstSender, func(ctx context.Context, sender *backend.StreamSender) {
+		starts++
+		poll(ctx, sender)
+	})
+	pollerSender := <-started
+	if err := pollerSender.SendJSON([]byte(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second stream shares the poller, and gets the last packet.
+	_ = hub.join("1/perf/job1/GLOBAL", secondSender, func(ctx context.Context, sender *backend.StreamSender) {
+		t.Error("a second poller was started")
+	})
+	if err := pollerSender.SendJSON([]byte(`{"a": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if starts != 1 || len(first.packets) != 2 || len(second.packets) != 2 {
+		t.Errorf("expected a poller sending 2 packets to both streams, got %d pollers, %d and %d packets",
+			starts, len(first.packets), len(second.packets))
+	}
+
+	hub.leave("1/perf/job1/GLOBAL", firstSender)
+	select {
+	case <-stopped:
+		t.Fatal("the poller stopped while a stream is left")
+	default:
+	}
+
+	hub.leave("1/perf/job1/GLOBAL", secondSender)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the poller wasn't stopped")
+	}
+}
+
+func TestStreamHubClose(t *testing.T) {
+	var hub streamHub
+	stopped := make(chan struct{})
+	err := hub.join("1/perf/job1/GLOBAL", backend.NewStreamSender(&streamPacketSender{}),
+		func(ctx context.Context, _ *backend.StreamSender) {
+			<-ctx.Done()
+			close(stopped)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub.close()
+
+	// close waits for the pollers to return.
+	select {
+	case <-stopped:
+	default:
+		t.Error("the poller is still running")
+	}
+	select {
+	case <-hub.closing():
+	default:
+		t.Error("the streams weren't notified")
+	}
+	if err = hub.join("1/perf/job1/GLOBAL", nil, nil); !errors.Is(err, errStreamsClosed) {
+		t.Errorf("expected the hub to reject new streams, got %v", err)
+	}
+}
+
+// leavingPacketSender leaves the poller while receiving a packet.
+type leavingPacketSender struct {
+	streamPacketSender
+	poller *streamPoller
+	sender *backend.StreamSender
+}
+
+func (s *leavingPacketSender) Send(packet *backend.StreamPacket) error {
+	s.poller.unsubscribe(s.sender)
+	return s.streamPacketSender.Send(packet)
+}
+
+// failingPacketSender fails every packet, like a stream gone.
+type failingPacketSender struct{}
+
+func (failingPacketSender) Send(*backend.StreamPacket) error {
+	return errors.New("stream closed")
+}
+
+func TestStreamPollerSend(t *testing.T) {
+	poller := &streamPoller{subscribers: make(map[*backend.StreamSender]struct{}), logger: Logger}
+	leaving := &leavingPacketSender{poller: poller}
+	leaving.sender = backend.NewStreamSender(leaving)
+	failing := backend.NewStreamSender(failingPacketSender{})
+	poller.subscribe(leaving.sender)
+	poller.subscribe(failing)
+
+	// The packet is sent without the lock, or the leaving stream deadlocks.
+	sent := make(chan error, 1)
+	go func() {
+		sent <- backend.NewStreamSender(poller).SendJSON([]byte(`{"a": 1}`))
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the packet was sent with the lock held")
+	}
+
+	if len(leaving.packets) != 1 || len(poller.subscribers) != 0 {
+		t.Errorf("expected the packet sent and the failed stream dropped, got %d packets and %d streams",
+			len(leaving.packets), len(poller.subscribers))
+	}
+}
@@ -0,0 +1,106 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// JobThresholds are the thresholds NS1 applies to a job, for the alert rules
+// and the panels to default to: the latency, in milliseconds, above which a
+// measurement fails, and the availability, in percent, below which the job is
+// down.
+type JobThresholds struct {
+	AppID        string   `json:"appid"`
+	JobID        string   `json:"jobid"`
+	Name         string   `json:"name"`
+	Type         string   `json:"type,omitempty"`
+	Latency      *float64 `json:"latency,omitempty"`
+	Availability float64  `json:"availability"`
+}
+
+// jobThresholds returns the thresholds of the job. The custom jobs have no
+// latency threshold, their values not being latencies. NS1 doesn't define an
+// availability threshold per job, availabilityThreshold is used instead.
+func (s *Settings) jobThresholds(app App, job Job) JobThresholds {
+	thresholds := JobThresholds{
+		AppID:        app.AppID,
+		JobID:        job.JobID,
+		Name:         job.Name,
+		Type:         job.Type,
+		Availability: s.availabilityThreshold(),
+	}
+	if job.RequestTimeout > 0 && job.Type != jobTypeCustom {
+		latency := float64(job.RequestTimeout)
+		thresholds.Latency = &latency
+	}
+	return thresholds
+}
+
+// config returns the thresholds of the field of the metric type, nil if the
+// job has none for it.
+func (t JobThresholds) config(metricType string) *data.ThresholdsConfig {
+	switch {
+	case metricType == metricTypePerformance && t.Latency != nil:
+		return &data.ThresholdsConfig{Mode: data.ThresholdsModeAbsolute, Steps: []data.Threshold{
+			data.NewThreshold(math.Inf(-1), "green", ""),
+			data.NewThreshold(*t.Latency, "red", ""),
+		}}
+	case metricType == metricTypeAvailability:
+		return &data.ThresholdsConfig{Mode: data.ThresholdsModeAbsolute, Steps: []data.Threshold{
+			data.NewThreshold(math.Inf(-1), "red", ""),
+			data.NewThreshold(t.Availability, "green", ""),
+		}}
+	default:
+		return nil
+	}
+}
+
+// handleThresholds lists the thresholds of the jobs visible to the user, so
+// the alert rules can be created with the values NS1 uses. The app and job
+// parameters keep the jobs of an app, or a single job.
+func (p *PulsarDatasource) handleThresholds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := httpadapter.PluginConfigFromContext(r.Context())
+	keys, err := p.apiKeys(config)
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	ctx := withUser(r.Context(), config.User)
+	appsResponse, err := p.pulsarAPI().GetApps(ctx, keys.pick(&p.keyFallback), p.settings.appParameters()...)
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	if allowed := p.appFilter(ctx); allowed != nil {
+		appsResponse = appsResponse.filter(allowed)
+	}
+
+	query := r.URL.Query()
+	thresholds := make([]JobThresholds, 0, len(appsResponse.JobsMap))
+	for _, app := range appsResponse.Apps {
+		if appID := query.Get("app"); appID != "" && app.AppID != appID {
+			continue
+		}
+		for _, job := range app.Jobs {
+			if jobID := query.Get("job"); jobID != "" && job.JobID != jobID {
+				continue
+			}
+			thresholds = append(thresholds, p.settings.jobThresholds(app, job))
+		}
+	}
+	setCacheControl(w, time.Duration(p.settings.AppsTTL))
+	writeJSON(w, http.StatusOK, thresholds)
+}
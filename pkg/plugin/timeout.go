@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var errInvalidTimeout = newInvalidQueryError("invalid timeout")
+
+type queryTimeoutContextKey struct{}
+
+// queryTimeout returns the timeout of the NS1 requests of the query, zero when
+// it keeps the one of the datasource, rejecting the invalid durations and the
+// ones over the maximum of the datasource.
+func (s *Settings) queryTimeout(qm *queryModel) (time.Duration, error) {
+	if qm.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := parseDuration(qm.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%w: expected a duration such as 30s, got %q", errInvalidTimeout, qm.Timeout)
+	}
+	if max := time.Duration(s.MaxQueryTimeout); timeout > max {
+		return 0, fmt.Errorf("%w: %s is over the maximum of the datasource, %s", errInvalidTimeout, timeout, max)
+	}
+	return timeout, nil
+}
+
+// withQueryTimeout returns a copy of the context overriding the timeout of the
+// NS1 requests.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, queryTimeoutContextKey{}, timeout)
+}
+
+// requestTimeout returns the timeout of the NS1 requests made with the
+// context: the one of the query, or the one of the client.
+func (pc *PulsarClient) requestTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(queryTimeoutContextKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return pc.timeout
+}
+
+// httpClientFor returns the HTTP client of the requests made with the
+// context, a copy of the shared one when the query overrides the timeout, so
+// the requests still share the connections.
+func (pc *PulsarClient) httpClientFor(ctx context.Context) *http.Client {
+	timeout := pc.requestTimeout(ctx)
+	if timeout == pc.httpClient.Timeout {
+		return pc.httpClient
+	}
+	client := *pc.httpClient
+	client.Timeout = timeout
+	return &client
+}
@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	ns1 := testutil.NewNS1(testutil.OptionValue("job1", 5))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/query/") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		ns1.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.MaxQueryTimeout = Duration(time.Minute)
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:     "A",
+			JSON:      []byte(json),
+			TimeRange: backend.TimeRange{From: to.Add(-time.Hour), To: to},
+		})
+	}
+
+	if res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`); res.Error != nil {
+		t.Fatalf("expected the datasource timeout to wait for the data, got %v", res.Error)
+	}
+	if res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p99", "timeout": "50ms"}`); res.Error == nil {
+		t.Error("expected the query timeout to cut the request short")
+	}
+	if res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p95", "timeout": "30s"}`); res.Error != nil {
+		t.Errorf("expected a longer timeout up to the maximum, got %v", res.Error)
+	}
+
+	for _, json := range []string{
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "timeout": "2m"}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "timeout": "soon"}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "timeout": "-5s"}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidTimeout) {
+			t.Errorf("%s: expected errInvalidTimeout, got %v", json, res.Error)
+		}
+	}
+}
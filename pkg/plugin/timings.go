@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// The phases of a query, timed to tell whether a slow query waits on NS1 or
+// on the plugin.
+const (
+	phaseCatalog = "catalog"
+	phaseRequest = "request"
+	phaseDecode  = "decode"
+	phaseFrame   = "frame"
+)
+
+// queryPhases lists the phases in the order they run, with the name shown in
+// the query inspector.
+var queryPhases = []struct {
+	name        string
+	displayName string
+}{
+	{phaseCatalog, "Catalog lookup"},
+	{phaseRequest, "NS1 round trip"},
+	{phaseDecode, "JSON decoding"},
+	{phaseFrame, "Frame construction"},
+}
+
+type timingsContextKey struct{}
+
+// queryTimings sums the time spent by a query in each phase. A nil
+// queryTimings records nothing.
+type queryTimings struct {
+	lock      sync.Mutex
+	durations map[string]time.Duration
+}
+
+func newQueryTimings() *queryTimings {
+	return &queryTimings{durations: make(map[string]time.Duration)}
+}
+
+func withTimings(ctx context.Context, timings *queryTimings) context.Context {
+	return context.WithValue(ctx, timingsContextKey{}, timings)
+}
+
+func timingsFromContext(ctx context.Context) *queryTimings {
+	timings, _ := ctx.Value(timingsContextKey{}).(*queryTimings)
+	return timings
+}
+
+// since adds the time elapsed since start to the phase.
+func (qt *queryTimings) since(phase string, start time.Time) {
+	if qt == nil {
+		return
+	}
+	qt.lock.Lock()
+	defer qt.lock.Unlock()
+	qt.durations[phase] += time.Since(start)
+}
+
+// add adds the durations of other, the phases of a request shared with other
+// queries.
+func (qt *queryTimings) add(other *queryTimings) {
+	if qt == nil || other == nil {
+		return
+	}
+	other.lock.Lock()
+	durations := make(map[string]time.Duration, len(other.durations))
+	for phase, duration := range other.durations {
+		durations[phase] = duration
+	}
+	other.lock.Unlock()
+
+	qt.lock.Lock()
+	defer qt.lock.Unlock()
+	for phase, duration := range durations {
+		qt.durations[phase] += duration
+	}
+}
+
+// logArgs returns the durations as key value pairs for the logs.
+func (qt *queryTimings) logArgs() []interface{} {
+	if qt == nil {
+		return nil
+	}
+	qt.lock.Lock()
+	defer qt.lock.Unlock()
+
+	args := make([]interface{}, 0, 2*len(queryPhases))
+	for _, phase := range queryPhases {
+		args = append(args, phase.name, qt.durations[phase.name])
+	}
+	return args
+}
+
+// stats returns the durations, in milliseconds, as the stats of a frame.
+func (qt *queryTimings) stats() []data.QueryStat {
+	if qt == nil {
+		return nil
+	}
+	qt.lock.Lock()
+	defer qt.lock.Unlock()
+
+	stats := make([]data.QueryStat, 0, len(queryPhases))
+	for _, phase := range queryPhases {
+		stats = append(stats, data.QueryStat{
+			FieldConfig: data.FieldConfig{DisplayName: phase.displayName, Unit: "ms"},
+			Value:       float64(qt.durations[phase.name]) / float64(time.Millisecond),
+		})
+	}
+	return stats
+}
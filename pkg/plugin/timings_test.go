@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, `[{"timestamp": 1640001600, "job1": 42}]`)
+	}))
+	defer server.Close()
+
+	timings := newQueryTimings()
+	ctx := withTimings(context.Background(), timings)
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL))
+	now := time.Now()
+	_, _, err := pc.GetData(ctx, "key", &queryModel{
+		JobID:         "job1",
+		MetricType:    metricTypePerformance,
+		Aggregation:   "avg",
+		Geo:           "*",
+		ASN:           "*",
+		From:          now.Add(-time.Hour),
+		To:            now,
+		MaxDataPoints: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := timings.stats()
+	if len(stats) != len(queryPhases) {
+		t.Fatalf("expected a stat per phase, got %d", len(stats))
+	}
+	for i, stat := range stats {
+		if stat.DisplayName != queryPhases[i].displayName || stat.Unit != "ms" {
+			t.Errorf("unexpected stat %+v", stat)
+		}
+	}
+	if request := stats[1].Value; request < 20 {
+		t.Errorf("expected the NS1 round trip to take 20ms at least, got %vms", request)
+	}
+	if catalog := stats[0].Value; catalog != 0 {
+		t.Errorf("expected no catalog lookup, got %vms", catalog)
+	}
+
+	// The queries without timings record nothing.
+	var untimed *queryTimings
+	untimed.since(phaseRequest, now)
+	if untimed.stats() != nil || untimed.logArgs() != nil {
+		t.Error("expected no stats without timings")
+	}
+}
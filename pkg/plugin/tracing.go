@@ -0,0 +1,178 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// traceparentHeader carries the W3C trace context, see
+// https://www.w3.org/TR/trace-context/.
+const traceparentHeader = "traceparent"
+
+// requestIDHeader carries the ID of a request, so NS1 support can find the
+// requests of a failing panel in their logs.
+const requestIDHeader = "X-Request-ID"
+
+type spanContextKey struct{}
+
+type requestIDContextKey struct{}
+
+// requestID returns the request ID sent by Grafana, if any, or a new one.
+func requestID(headers map[string]string) string {
+	for name, value := range headers {
+		if strings.EqualFold(name, requestIDHeader) && value != "" {
+			return value
+		}
+	}
+	return randomID(16)
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// span times an operation of a traced query. The spans are logged when they
+// end, with the IDs of the W3C trace context so they can be matched with the
+// trace of the Grafana request. A nil span does nothing, so the operations
+// of the untraced requests don't have to check whether tracing is enabled.
+type span struct {
+	name     string
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+	attrs    []interface{}
+	logger   log.Logger
+}
+
+// startTrace starts the root span of a request, continuing the trace of the
+// traceparent header when valid.
+func startTrace(ctx context.Context, traceparent, name string, attrs ...interface{}) (context.Context, *span) {
+	s := &span{name: name, start: time.Now(), attrs: attrs, logger: loggerFromContext(ctx)}
+	if traceID, parentID, ok := parseTraceparent(traceparent); ok {
+		s.traceID, s.parentID = traceID, parentID
+	} else {
+		s.traceID = randomID(16)
+	}
+	s.spanID = randomID(8)
+
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// startSpan starts a child of the span of the context. The span is nil when
+// the context isn't traced.
+func startSpan(ctx context.Context, name string, attrs ...interface{}) (context.Context, *span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	s := &span{
+		name:     name,
+		traceID:  parent.traceID,
+		spanID:   randomID(8),
+		parentID: parent.spanID,
+		start:    time.Now(),
+		attrs:    attrs,
+		logger:   loggerFromContext(ctx),
+	}
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// setAttributes adds key value pairs to the span.
+func (s *span) setAttributes(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// traceparent returns the header propagating the span to the NS1 API.
+func (s *span) traceparent() string {
+	return "00-" + s.traceID + "-" + s.spanID + "-01"
+}
+
+// end logs the span, with an error status when err isn't nil.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+
+	args := []interface{}{
+		"name", s.name,
+		"traceId", s.traceID,
+		"spanId", s.spanID,
+		"parentId", s.parentID,
+		"duration", time.Since(s.start),
+	}
+	if err != nil {
+		args = append(args, "status", "error", "error", err)
+	} else {
+		args = append(args, "status", "ok")
+	}
+	s.logger.Info("Span", append(args, s.attrs...)...)
+}
+
+// parseTraceparent returns the trace and parent span IDs of a traceparent
+// header, and whether the header is valid.
+func parseTraceparent(header string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || !isHexID(parts[0], 1) || parts[0] == "ff" {
+		return "", "", false
+	}
+	// Version 00 has exactly 4 fields, later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+	if !isHexID(parts[1], 16) || !isHexID(parts[2], 8) || !isHexID(parts[3], 1) {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+// isHexID tells whether s is the lowercase hex encoding of a size bytes ID,
+// the all zeros IDs being invalid.
+func isHexID(s string, size int) bool {
+	if len(s) != 2*size || strings.ToLower(s) != s {
+		return false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	// A zero version or flags byte is valid.
+	return size == 1
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	// crypto/rand only fails when the OS has no entropy source, the ID is
+	// then left zero rather than failing the query.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
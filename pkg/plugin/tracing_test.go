@@ -0,0 +1,104 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		traceID, parentID, ok := parseTraceparent(tt.header)
+		if ok != tt.ok {
+			t.Errorf("%q: expected valid %v, got %v", tt.header, tt.ok, ok)
+			continue
+		}
+		if ok && (traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID != "00f067aa0ba902b7") {
+			t.Errorf("%q: unexpected IDs %s %s", tt.header, traceID, parentID)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	ctx, span := startSpan(context.Background(), "untraced")
+	if span != nil || spanFromContext(ctx) != nil {
+		t.Fatal("expected no span without a trace")
+	}
+	// The methods of the nil span do nothing.
+	span.setAttributes("job", "job1")
+	span.end(nil)
+
+	ctx, root := startTrace(context.Background(),
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "QueryData")
+	if root.traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root.parentID != "00f067aa0ba902b7" {
+		t.Fatalf("expected the trace of the header, got %s %s", root.traceID, root.parentID)
+	}
+
+	_, child := startSpan(ctx, "GetData", "job", "job1")
+	if child.traceID != root.traceID || child.parentID != root.spanID {
+		t.Fatalf("expected a child of %s, got parent %s", root.spanID, child.parentID)
+	}
+	if tp := child.traceparent(); tp != "00-"+root.traceID+"-"+child.spanID+"-01" {
+		t.Fatalf("unexpected traceparent %s", tp)
+	}
+
+	_, fresh := startTrace(context.Background(), "invalid", "QueryData")
+	if len(fresh.traceID) != 32 || fresh.parentID != "" {
+		t.Fatalf("expected a new trace, got %s %s", fresh.traceID, fresh.parentID)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := requestID(map[string]string{"x-request-id": "grafana-1"}); id != "grafana-1" {
+		t.Errorf("expected the request ID of Grafana, got %q", id)
+	}
+	if id := requestID(nil); len(id) != 32 {
+		t.Errorf("expected a new request ID, got %q", id)
+	}
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(requestIDHeader)
+		fmt.Fprint(w, `[{"timestamp": 1640001600, "job1": 42}]`)
+	}))
+	defer server.Close()
+
+	pc := NewPulsarClient(OptionClientEndpoint(server.URL))
+	now := time.Now()
+	_, _, err := pc.GetData(withRequestID(context.Background(), "grafana-1"), "key", &queryModel{
+		JobID:         "job1",
+		MetricType:    metricTypePerformance,
+		Geo:           "*",
+		ASN:           "*",
+		From:          now.Add(-time.Hour),
+		To:            now,
+		MaxDataPoints: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != "grafana-1" {
+		t.Errorf("expected the request ID to be sent to NS1, got %q", received)
+	}
+}
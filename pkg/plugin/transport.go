@@ -0,0 +1,259 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	apiKeyHeader = "X-NSONE-Key"
+	// failoverThreshold is the number of consecutive failures of the primary
+	// endpoint before switching to the secondary one.
+	failoverThreshold = 3
+	failoverCooldown  = 5 * time.Minute
+)
+
+// grafanaTransport returns the transport of the HTTP options of the
+// datasource set in Grafana: TLS client certificate, CA, skipped verification,
+// custom headers, and the proxy of the environment. The response timeout is
+// left to the client, the queries being able to extend it. Without options,
+// the default transport is used.
+func grafanaTransport(instanceSettings backend.DataSourceInstanceSettings) (http.RoundTripper, error) {
+	if len(instanceSettings.JSONData) == 0 {
+		return nil, nil
+	}
+	opts, err := instanceSettings.HTTPClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.ConfigureTransport = func(_ httpclient.Options, transport *http.Transport) {
+		transport.ResponseHeaderTimeout = 0
+	}
+	return httpclient.GetTransport(opts)
+}
+
+// debugTransport logs every request sent to the NS1 API along with the status
+// code and the time it took. The API key never makes it to the logs.
+type debugTransport struct {
+	next   http.RoundTripper
+	logger log.Logger
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	apiKey := req.Header.Get(apiKeyHeader)
+
+	resp, err := t.next.RoundTrip(req)
+
+	// The requests of the queries are logged with the fields of the query.
+	logger := t.logger
+	if ctxLogger, ok := req.Context().Value(loggerContextKey{}).(log.Logger); ok {
+		logger = ctxLogger
+	}
+	args := []interface{}{
+		"method", req.Method,
+		"url", redactAPIKey(req.URL.String(), apiKey),
+		"duration", time.Since(start).String(),
+	}
+	if err != nil {
+		logger.Info("NS1 request failed", append(args, "error", redactAPIKey(err.Error(), apiKey))...)
+		return nil, err
+	}
+	logger.Info("NS1 request", append(args, "status", resp.StatusCode)...)
+
+	return resp, nil
+}
+
+// newHTTPClient builds the client used for the NS1 requests out of the
+// client configuration: requests are measured, logged when debug is enabled,
+// sent to the fallback endpoint when the primary one keeps failing, and
+// authenticated the way the endpoint expects.
+func (pc *PulsarClient) newHTTPClient() *http.Client {
+	var transport http.RoundTripper = http.DefaultTransport
+	if pc.transport != nil {
+		transport = pc.transport
+	}
+	switch pc.fixtureMode {
+	case fixtureModeRecord:
+		transport = &recordTransport{next: transport, dir: pc.fixtureDir, aead: newFixtureCipher(pc.fixtureKey),
+			redactor: pc.redactor, limitBody: pc.limitBody}
+	case fixtureModeReplay:
+		transport = &replayTransport{dir: pc.fixtureDir, aead: newFixtureCipher(pc.fixtureKey)}
+	}
+	if pc.authHeader != "" || pc.authScheme != "" {
+		transport = &authTransport{next: transport, header: pc.authHeader, scheme: pc.authScheme}
+	}
+	transport = &metricsTransport{next: transport}
+	transport = &queryDebugTransport{next: transport, redactor: pc.redactor}
+	if pc.debug {
+		transport = &debugTransport{next: transport, logger: pc.logger}
+	}
+	if pc.fallbackEndpoint != "" {
+		pc.failover = &failoverTransport{
+			next:      transport,
+			logger:    pc.logger,
+			primary:   pc.endpoint,
+			secondary: pc.fallbackEndpoint,
+		}
+		transport = pc.failover
+	}
+	transport = &redactingTransport{next: transport, redactor: pc.redactor}
+
+	return &http.Client{Timeout: pc.timeout, Transport: transport}
+}
+
+// authTransport sends the API key in the header, and with the scheme, expected
+// by the private NS1 installations (DDI) when they differ from the NS1 API
+// ones. It is the innermost transport, so the others only know of the
+// X-NSONE-Key header.
+type authTransport struct {
+	next   http.RoundTripper
+	header string
+	scheme string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	apiKey := req.Header.Get(apiKeyHeader)
+	if apiKey == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	header := apiKeyHeader
+	if t.header != "" {
+		header = t.header
+	}
+	if t.scheme != "" {
+		apiKey = t.scheme + " " + apiKey
+	}
+	req.Header.Del(apiKeyHeader)
+	req.Header.Set(header, apiKey)
+
+	return t.next.RoundTrip(req)
+}
+
+// failoverTransport sends the requests to the primary NS1 endpoint until it
+// fails failoverThreshold times in a row. From then on, requests go to the
+// secondary endpoint, and the primary is given another chance once
+// failoverCooldown has passed.
+type failoverTransport struct {
+	next      http.RoundTripper
+	logger    log.Logger
+	primary   string
+	secondary string
+
+	lock       sync.Mutex
+	failures   int
+	failedOver bool
+	since      time.Time
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.String(), t.primary) {
+		return t.next.RoundTrip(req)
+	}
+
+	if t.usingSecondary() {
+		return t.roundTripSecondary(req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if !isEndpointFailure(resp, err) {
+		t.recordSuccess()
+		return resp, err
+	}
+	if !t.recordFailure() || !canRetry(req) {
+		return resp, err
+	}
+
+	// The primary just got marked as failed, retry on the secondary.
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return t.roundTripSecondary(req.WithContext(withRetry(req.Context())))
+}
+
+func (t *failoverTransport) roundTripSecondary(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.secondary + strings.TrimPrefix(req.URL.String(), t.primary))
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.URL = target
+	retry.Host = target.Host
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(retry)
+}
+
+func (t *failoverTransport) usingSecondary() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.failedOver && time.Since(t.since) >= failoverCooldown {
+		loggerOrDefault(t.logger).Info("Retrying the primary NS1 endpoint", "endpoint", t.primary)
+		t.failedOver = false
+		t.failures = 0
+	}
+	return t.failedOver
+}
+
+func (t *failoverTransport) recordSuccess() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.failures = 0
+}
+
+// recordFailure returns true when the failure made the transport switch to
+// the secondary endpoint.
+func (t *failoverTransport) recordFailure() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.failures++
+	if t.failedOver || t.failures < failoverThreshold {
+		return false
+	}
+
+	t.failedOver = true
+	t.since = time.Now()
+	loggerOrDefault(t.logger).Warn("NS1 primary endpoint is failing, switching to the secondary",
+		"primary", t.primary, "secondary", t.secondary, "failures", t.failures)
+
+	return true
+}
+
+// status reports whether the secondary endpoint is in use, and since when.
+func (t *failoverTransport) status() (bool, time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.failedOver, t.since
+}
+
+// isEndpointFailure tells whether the endpoint itself failed, as opposed to
+// the API rejecting the request.
+func isEndpointFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// canRetry tells whether the request body, if any, can be sent again.
+func canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
@@ -0,0 +1,147 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingLogger keeps the messages logged, with their arguments.
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) log(level, msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(append([]interface{}{level, msg}, args...)...))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args...) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args...) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args...) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args...) }
+
+func TestDebugTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	defaultLogger := Logger
+	defer func() { Logger = defaultLogger }()
+
+	for _, debug := range []bool{false, true} {
+		recorder := &recordingLogger{}
+		Logger = recorder
+		pc := NewPulsarClient(OptionClientEndpoint(server.URL+"/v1"), OptionClientDebug(debug))
+		req, err := http.NewRequest(http.MethodGet, pc.endpoint+"pulsar/apps?key=secret-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(apiKeyHeader, "secret-key")
+		resp, err := pc.httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if !debug {
+			if len(recorder.lines) != 0 {
+				t.Errorf("expected no request logged without debug, got %q", recorder.lines)
+			}
+			continue
+		}
+		if len(recorder.lines) != 1 {
+			t.Fatalf("expected the request logged, got %q", recorder.lines)
+		}
+		line := recorder.lines[0]
+		if !strings.Contains(line, "NS1 request") || !strings.Contains(line, "/v1/pulsar/apps") ||
+			!strings.Contains(line, "status204") || !strings.Contains(line, "duration") {
+			t.Errorf("expected the URL, status code and duration logged, got %q", line)
+		}
+		if strings.Contains(line, "secret-key") {
+			t.Errorf("expected the API key redacted, got %q", line)
+		}
+	}
+}
+
+func TestFailoverTransport(t *testing.T) {
+	var primaryHits, secondaryHits int
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryHits++
+		if r.URL.Path != "/v1/pulsar/apps" {
+			t.Errorf("unexpected path on the secondary %q", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secondary.Close()
+
+	pc := NewPulsarClient(
+		OptionClientEndpoint(primary.URL+"/v1"),
+		OptionClientFallbackEndpoint(secondary.URL+"/v1"),
+	)
+
+	for i := 1; i <= failoverThreshold+1; i++ {
+		resp, err := pc.httpClient.Get(pc.endpoint + "pulsar/apps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		failedOver, _ := pc.FailoverStatus()
+		if i < failoverThreshold {
+			if resp.StatusCode != http.StatusServiceUnavailable || failedOver {
+				t.Fatalf("request %d: failover before reaching the threshold", i)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK || !failedOver {
+			t.Fatalf("request %d: expected the secondary endpoint to answer", i)
+		}
+	}
+
+	if primaryHits != failoverThreshold || secondaryHits != 2 {
+		t.Errorf("unexpected hits: primary %d, secondary %d", primaryHits, secondaryHits)
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	settings := defaultSettings()
+	settings.Endpoint = server.URL + "/ddi/v1"
+	settings.AuthHeader = "Authorization"
+	settings.AuthScheme = "Bearer"
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	pc := NewPulsarClient(settings.clientOptions()...)
+	if _, err := pc.ping("ddi-key"); err != nil {
+		t.Fatal(err)
+	}
+	if received.Get("Authorization") != "Bearer ddi-key" || received.Get(apiKeyHeader) != "" {
+		t.Errorf("expected the key in the Authorization header only, got %v", received)
+	}
+
+	settings.AuthHeader = "X-Auth Token"
+	if err := settings.validate(); err == nil {
+		t.Error("expected an invalid header name to be rejected")
+	}
+}
@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// The units of the values. NS1 returns the performance in milliseconds and the
+// availability in percent.
+const (
+	unitMilliseconds = "ms"
+	unitSeconds      = "s"
+	unitPercent      = "percent"
+	unitRatio        = "ratio"
+)
+
+var errInvalidUnit = newInvalidQueryError("invalid unit, expected ms or s for the performance, percent or ratio for the availability")
+
+// isValidUnit tells whether the values of the metric can be converted to the
+// unit.
+func isValidUnit(metricType, unit string) bool {
+	switch metricType {
+	case metricTypePerformance:
+		return unit == unitMilliseconds || unit == unitSeconds
+	case metricTypeAvailability:
+		return unit == unitPercent || unit == unitRatio
+	default:
+		return false
+	}
+}
+
+// checkUnit rejects the units of the query that don't match its metric.
+func checkUnit(qm *queryModel) error {
+	if qm.Unit != "" && !isValidUnit(qm.MetricType, qm.Unit) {
+		return fmt.Errorf("%w: %q for the %s", errInvalidUnit, qm.Unit, qm.MetricType)
+	}
+	return nil
+}
+
+// unit returns the unit of the values of the query: its own, or the one of the
+// datasource for its metric, empty when neither is set.
+func (s *Settings) unit(qm *queryModel) string {
+	if qm.Unit != "" {
+		return qm.Unit
+	}
+	switch qm.MetricType {
+	case metricTypePerformance:
+		return s.PerformanceUnit
+	case metricTypeAvailability:
+		return s.AvailabilityUnit
+	default:
+		return ""
+	}
+}
+
+// unitDivisor returns the divisor converting the values from the unit of NS1
+// to the unit. Dividing keeps the round values round, e.g. 95% is 0.95.
+func unitDivisor(unit string) float64 {
+	switch unit {
+	case unitSeconds:
+		return 1000
+	case unitRatio:
+		return 100
+	default:
+		return 1
+	}
+}
+
+// grafanaUnit returns the unit of the fields in Grafana.
+func grafanaUnit(unit string) string {
+	switch unit {
+	case unitRatio:
+		return "percentunit"
+	default:
+		return unit
+	}
+}
+
+// setUnit sets the unit of the value fields of the frame, if any.
+func setUnit(frame *data.Frame, unit string) {
+	if frame == nil {
+		return
+	}
+	for _, field := range frame.Fields {
+		if field.Config != nil {
+			field.Config.Unit = grafanaUnit(unit)
+		}
+	}
+}
+
+// scaleValues converts the values in place.
+func scaleValues(values []float64, divisor float64) {
+	for i := range values {
+		values[i] /= divisor
+	}
+}
+
+// scaleNullableValues converts the values in place, the nulls kept.
+func scaleNullableValues(values []*float64, divisor float64) {
+	for _, value := range values {
+		if value != nil {
+			*value /= divisor
+		}
+	}
+}
+
+// scaleThresholds converts the thresholds of the field, the base one kept.
+func scaleThresholds(thresholds *data.ThresholdsConfig, divisor float64) {
+	if thresholds == nil {
+		return
+	}
+	for i, step := range thresholds.Steps {
+		if !math.IsInf(float64(step.Value), -1) {
+			thresholds.Steps[i].Value = data.ConfFloat64(float64(step.Value) / divisor)
+		}
+	}
+}
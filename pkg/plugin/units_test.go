@@ -0,0 +1,73 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestQueryUnit(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 250))
+	defer server.Close()
+
+	settings := defaultSettings()
+	p := &PulsarDatasource{settings: settings, pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		})
+	}
+
+	res := query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`)
+	if res.Error != nil || res.Frames[0].Fields[1].At(0) != 250.0 || res.Frames[0].Fields[1].Config.Unit != "" {
+		t.Errorf("expected the milliseconds of NS1, got %v", res.Error)
+	}
+
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "p50", "unit": "s"}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if field := res.Frames[0].Fields[1]; field.At(0) != 0.25 || field.Config.Unit != "s" {
+		t.Errorf("expected the seconds, got %v %s", field.At(0), field.Config.Unit)
+	}
+
+	// The unit of the datasource applies to the queries without their own.
+	settings.AvailabilityUnit = unitRatio
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "availability", "agg": "avg"}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	field := res.Frames[0].Fields[1]
+	if field.At(0) != 2.5 || field.Config.Unit != "percentunit" {
+		t.Errorf("expected the ratio, got %v %s", field.At(0), field.Config.Unit)
+	}
+	if steps := field.Config.Thresholds.Steps; float64(steps[1].Value) != 0.95 {
+		t.Errorf("expected the thresholds in ratio, got %v", steps[1].Value)
+	}
+	res = query(`{"appid": "app1", "jobid": "job1", "metricType": "availability", "agg": "max", "unit": "percent"}`)
+	if res.Error != nil || res.Frames[0].Fields[1].At(0) != 250.0 {
+		t.Errorf("expected the unit of the query to win, got %v", res.Error)
+	}
+
+	for _, json := range []string{
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "unit": "percent"}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "availability", "unit": "ms"}`,
+		`{"appid": "app1", "jobid": "job1", "metricType": "performance", "unit": "minutes"}`,
+	} {
+		if res := query(json); !errors.Is(res.Error, errInvalidUnit) {
+			t.Errorf("%s: expected errInvalidUnit, got %v", json, res.Error)
+		}
+	}
+}
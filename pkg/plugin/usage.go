@@ -0,0 +1,197 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeUsage is the type of the queries of the DNS queries billed to the
+// NS1 account.
+const queryTypeUsage = "usage"
+
+var (
+	errUsageNotFound     = errors.New("NS1 account usage not found")
+	errInvalidUsageQuery = newInvalidQueryError("invalid usage query, the networks are a comma separated list of network IDs")
+)
+
+// Network is a NS1 network of the account, as listed for the template
+// variables.
+type Network struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// usagePeriods are the periods of the usage served by NS1, shortest first.
+// The usage is hourly over a day, and daily over 30 days.
+var usagePeriods = []statsPeriod{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// UsageSeries is the number of DNS queries of the account, or of one of its
+// networks, over time.
+type UsageSeries struct {
+	// Network is the ID of the network, nil for the whole account.
+	Network *int
+	Times   []time.Time
+	Queries []float64
+}
+
+// GetNetworks lists the NS1 networks of the account, sorted by ID.
+func (pc *PulsarClient) GetNetworks(ctx context.Context, apiKey string) (networks []Network, err error) {
+	ctx, span := startSpan(ctx, "GetNetworks")
+	defer func() { span.end(err) }()
+
+	var body []struct {
+		NetworkID int    `json:"network_id"`
+		Name      string `json:"name"`
+	}
+	if err = pc.getJSON(ctx, apiKey, "networks", errUsageNotFound, &body); err != nil {
+		return nil, err
+	}
+
+	networks = make([]Network, 0, len(body))
+	for _, network := range body {
+		networks = append(networks, Network{ID: network.NetworkID, Name: network.Name})
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].ID < networks[j].ID })
+
+	return networks, nil
+}
+
+// usageNetworks parses the networks of the usage query, a comma separated
+// list of network IDs.
+func usageNetworks(qm *queryModel) ([]string, error) {
+	if qm.Networks == "" {
+		return nil, nil
+	}
+	networks := strings.Split(qm.Networks, ",")
+	for i, network := range networks {
+		networks[i] = strings.TrimSpace(network)
+		if _, err := strconv.Atoi(networks[i]); err != nil {
+			return nil, fmt.Errorf("%w: %q", errInvalidUsageQuery, network)
+		}
+	}
+	return networks, nil
+}
+
+// GetUsage returns the number of DNS queries of the account over the time
+// range of the query, a series per network when UsageByNetwork is set or
+// when the query selects networks.
+func (pc *PulsarClient) GetUsage(ctx context.Context, apiKey string, qm *queryModel) (series []UsageSeries, err error) {
+	ctx, span := startSpan(ctx, "GetUsage", "networks", qm.Networks)
+	defer func() { span.end(err) }()
+
+	networks, err := usageNetworks(qm)
+	if err != nil {
+		return nil, err
+	}
+	byNetwork := qm.UsageByNetwork || len(networks) > 0
+
+	var body []struct {
+		NetworkID *int          `json:"networkid"`
+		Graph     [][2]*float64 `json:"graph"`
+	}
+	query := url.Values{}
+	query.Set("period", shortestPeriod(usagePeriods, qm.From, time.Now()))
+	query.Set("aggregate", strconv.FormatBool(!byNetwork))
+	if len(networks) > 0 {
+		query.Set("networks", strings.Join(networks, ","))
+	}
+	if err = pc.getJSON(ctx, apiKey, "stats/usage?"+query.Encode(), errUsageNotFound, &body); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range body {
+		s := UsageSeries{}
+		if byNetwork {
+			s.Network = entry.NetworkID
+		}
+		for _, point := range entry.Graph {
+			if point[0] == nil || point[1] == nil {
+				continue
+			}
+			t := time.Unix(int64(*point[0]), 0)
+			if t.Before(qm.From) || t.After(qm.To) {
+				continue
+			}
+			s.Times = append(s.Times, t)
+			s.Queries = append(s.Queries, *point[1])
+		}
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Network != nil && (series[j].Network == nil || *series[i].Network < *series[j].Network)
+	})
+
+	return series, nil
+}
+
+// usageLabel returns the name of the usage series: the alias if any, followed
+// by the network.
+func usageLabel(qm *queryModel, s *UsageSeries) string {
+	label := "queries"
+	if qm.Alias != "" {
+		label = qm.Alias
+	}
+	if s.Network != nil {
+		label = fmt.Sprintf("%s network %d", label, *s.Network)
+	}
+	return label
+}
+
+// queryUsage answers the usage queries with a time series of the DNS queries
+// of the account, or one per network.
+func (p *PulsarDatasource) queryUsage(ctx context.Context, apiKey string, qm *queryModel) backend.DataResponse {
+	series, err := p.pulsarAPI().GetUsage(ctx, apiKey, qm)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	res := backend.DataResponse{}
+	for i := range series {
+		name := usageLabel(qm, &series[i])
+		res.Frames = append(res.Frames, data.NewFrame(name,
+			data.NewField("time", nil, series[i].Times),
+			data.NewField(name, nil, series[i].Queries),
+		))
+	}
+	return res
+}
+
+// handleNetworks lists the NS1 networks visible to the API key of the user,
+// for the template variables of the usage queries.
+func (p *PulsarDatasource) handleNetworks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := p.apiKeys(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	networks, err := p.pulsarAPI().GetNetworks(r.Context(), keys.pick(&p.keyFallback))
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, networks)
+}
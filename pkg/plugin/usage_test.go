@@ -0,0 +1,70 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryUsage(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if r.URL.Query().Get("aggregate") == "true" {
+			fmt.Fprintf(w, `[{"graph": [[%d, 100], [%d, 200]]}]`, now.Add(-48*time.Hour).Unix(), now.Add(-time.Hour).Unix())
+			return
+		}
+		fmt.Fprintf(w, `[{"networkid": 1, "graph": [[%d, 50]]}, {"networkid": 0, "graph": [[%d, 150]]}]`,
+			now.Add(-time.Hour).Unix(), now.Add(-time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	qm := &queryModel{From: now.Add(-12 * time.Hour), To: now}
+	res := p.queryUsage(context.Background(), "key", qm)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if query != "aggregate=true&period=24h" {
+		t.Errorf("expected the usage of the account over a day, got %s", query)
+	}
+	if len(res.Frames) != 1 || res.Frames[0].Name != "queries" {
+		t.Fatalf("expected a single series, got %v", res.Frames)
+	}
+	if values := res.Frames[0].Fields[1]; values.Len() != 1 || values.At(0).(float64) != 200 {
+		t.Errorf("expected the points outside of the range to be dropped, got %v", values)
+	}
+
+	qm.UsageByNetwork = true
+	qm.Alias = "DNS"
+	res = p.queryUsage(context.Background(), "key", qm)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(res.Frames) != 2 || res.Frames[0].Name != "DNS network 0" || res.Frames[1].Name != "DNS network 1" {
+		t.Errorf("expected a series per network, got %v", res.Frames)
+	}
+
+	qm.UsageByNetwork = false
+	qm.Networks = "0, 1"
+	if res = p.queryUsage(context.Background(), "key", qm); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if query != "aggregate=false&networks=0%2C1&period=24h" || len(res.Frames) != 2 {
+		t.Errorf("expected a series per selected network, got %s and %d series", query, len(res.Frames))
+	}
+
+	qm.Networks = "0,managed"
+	if res = p.queryUsage(context.Background(), "key", qm); !errors.Is(res.Error, errInvalidUsageQuery) {
+		t.Errorf("expected errInvalidUsageQuery, got %v", res.Error)
+	}
+}
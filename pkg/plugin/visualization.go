@@ -0,0 +1,57 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeVisualizations are the visualizations Explore shows the results of
+// the query types with by default: the series as graphs, and the inventories,
+// the events and the reports as tables. The Pulsar queries are graphs.
+var queryTypeVisualizations = map[string]data.VisType{
+	queryTypeDNSQPS:            data.VisTypeGraph,
+	queryTypeMonitoringMetrics: data.VisTypeGraph,
+	queryTypeUsage:             data.VisTypeGraph,
+	queryTypeDecisionAnswers:   data.VisTypeGraph,
+	queryTypeHealthScore:       data.VisTypeGraph,
+	queryTypeMonitoringStatus:  data.VisTypeTable,
+	queryTypeNotifications:     data.VisTypeTable,
+	queryTypeActivity:          data.VisTypeTable,
+	queryTypeJobChanges:        data.VisTypeTable,
+	queryTypeShedLoad:          data.VisTypeTable,
+	queryTypeDHCPScopes:        data.VisTypeTable,
+	queryTypeReport:            data.VisTypeTable,
+	queryTypeAnnotation:        data.VisTypeTable,
+	queryTypeOutages:           data.VisTypeTable,
+	queryTypeRouteMapChanges:   data.VisTypeTable,
+	queryTypeStatusBoard:       data.VisTypeTable,
+}
+
+// preferredVisualization returns the visualization of the results of the
+// query. The reduced Pulsar queries are single numbers, shown as tables.
+func preferredVisualization(qm *queryModel) data.VisType {
+	if visualization, found := queryTypeVisualizations[qm.QueryType]; found {
+		return visualization
+	}
+	if qm.Reduce != "" {
+		return data.VisTypeTable
+	}
+	return data.VisTypeGraph
+}
+
+// setPreferredVisualization sets the visualization of the frames of the
+// response that don't have one yet.
+func setPreferredVisualization(response *backend.DataResponse, visualization data.VisType) {
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		if frame.Meta.PreferredVisualization == "" {
+			frame.Meta.PreferredVisualization = visualization
+		}
+	}
+}
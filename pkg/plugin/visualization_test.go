@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/ns1labs/grafana-pulsar-datasource/pkg/testutil"
+)
+
+func TestPreferredVisualization(t *testing.T) {
+	server := testutil.NewServer(testutil.OptionValue("job1", 5))
+	defer server.Close()
+
+	p := &PulsarDatasource{settings: defaultSettings(), pulsarClient: NewPulsarClient(OptionClientEndpoint(server.URL))}
+	to := time.Unix(1600003600, 0)
+	query := func(json string) backend.DataResponse {
+		return p.queryWithKey(context.Background(), "key", backend.DataQuery{
+			RefID:         "A",
+			JSON:          []byte(json),
+			TimeRange:     backend.TimeRange{From: to.Add(-time.Hour), To: to},
+			MaxDataPoints: 1000,
+		})
+	}
+
+	tests := []struct {
+		json          string
+		visualization data.VisType
+	}{
+		{`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg"}`, data.VisTypeGraph},
+		{`{"appid": "app1", "jobid": "job1", "metricType": "performance", "agg": "avg", "reduce": "mean"}`, data.VisTypeTable},
+		// The catalog of the query editor is a frame too.
+		{`{"queryType": "initialAppsJobsFetch"}`, data.VisTypeGraph},
+	}
+	for _, tt := range tests {
+		res := query(tt.json)
+		if res.Error != nil {
+			t.Fatalf("%s: %v", tt.json, res.Error)
+		}
+		if got := res.Frames[0].Meta.PreferredVisualization; got != tt.visualization {
+			t.Errorf("%s: expected %s, got %s", tt.json, tt.visualization, got)
+		}
+	}
+
+	if got := preferredVisualization(&queryModel{QueryType: queryTypeActivity}); got != data.VisTypeTable {
+		t.Errorf("expected the activity as a table, got %s", got)
+	}
+}
@@ -0,0 +1,125 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+)
+
+// DNSRecord is a record of a zone, as listed for the template variables.
+type DNSRecord struct {
+	Domain string `json:"domain"`
+	Type   string `json:"type"`
+}
+
+// GetZones lists the names of the zones visible to the API key.
+func (pc *PulsarClient) GetZones(ctx context.Context, apiKey string) (zones []string, err error) {
+	_, span := startSpan(ctx, "GetZones")
+	defer func() { span.end(err) }()
+
+	nsZones, _, err := pc.getAPIClient(apiKey).Zones.List()
+	if err != nil {
+		return nil, convertZoneError(err)
+	}
+
+	zones = make([]string, 0, len(nsZones))
+	for _, zone := range nsZones {
+		zones = append(zones, zone.Zone)
+	}
+	sort.Strings(zones)
+
+	return zones, nil
+}
+
+// GetRecords lists the records of the zone.
+func (pc *PulsarClient) GetRecords(ctx context.Context, apiKey, zone string) (records []DNSRecord, err error) {
+	_, span := startSpan(ctx, "GetRecords", "zone", zone)
+	defer func() { span.end(err) }()
+
+	nsZone, _, err := pc.getAPIClient(apiKey).Zones.Get(zone)
+	if err != nil {
+		return nil, convertZoneError(err)
+	}
+
+	records = make([]DNSRecord, 0, len(nsZone.Records))
+	for _, record := range nsZone.Records {
+		records = append(records, DNSRecord{Domain: record.Domain, Type: record.Type})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Domain != records[j].Domain {
+			return records[i].Domain < records[j].Domain
+		}
+		return records[i].Type < records[j].Type
+	})
+
+	return records, nil
+}
+
+// convertZoneError converts the errors of the zones and records requests.
+// The ns1-go library reports the unknown zones and records with its own
+// errors.
+func convertZoneError(err error) error {
+	if errors.Is(err, ns1api.ErrZoneMissing) || errors.Is(err, ns1api.ErrRecordMissing) {
+		return newAPIError(http.StatusNotFound, "", errZoneNotFound)
+	}
+	return convertClientError(err, errZoneNotFound)
+}
+
+// handleZones lists the zones visible to the API key of the user, for the
+// template variables of the DNS QPS queries: /zones lists the zones, and
+// /zones/<zone>/records the records of a zone.
+func (p *PulsarDatasource) handleZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	zone := ""
+	if path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/zones"), "/"); path != "" {
+		if !strings.HasSuffix(path, "/records") || strings.Count(path, "/") != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		zone = strings.TrimSuffix(path, "/records")
+	}
+
+	keys, err := p.apiKeys(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	apiKey := keys.pick(&p.keyFallback)
+
+	if zone == "" {
+		zones, err := p.pulsarAPI().GetZones(r.Context(), apiKey)
+		if err != nil {
+			p.writeResourceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, zones)
+		return
+	}
+
+	records, err := p.pulsarAPI().GetRecords(r.Context(), apiKey, zone)
+	if err != nil {
+		p.writeResourceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// writeResourceError answers with the status and the redacted message of the
+// error.
+func (p *PulsarDatasource) writeResourceError(w http.ResponseWriter, err error) {
+	queryErr := classifyError(err).redact(p.pulsarClient.redactor)
+	http.Error(w, queryErr.Error(), queryErr.Status)
+}
@@ -0,0 +1,198 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Package testutil holds the fakes shared by the tests of the datasource.
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// App is a Pulsar app of the fake NS1 API.
+type App struct {
+	AppID string
+	Name  string
+	Jobs  []Job
+}
+
+// Job is a Pulsar job of the fake NS1 API.
+type Job struct {
+	JobID  string
+	Name   string
+	TypeID string
+}
+
+// NS1 fakes the Pulsar endpoints of the NS1 API: the apps, their jobs and the
+// data of the jobs. The data endpoints answer with a point per minute of the
+// value of every job queried, none for the jobs without value.
+type NS1 struct {
+	apps       []App
+	apiKey     string
+	dataStatus int
+
+	mu       sync.Mutex
+	values   map[string]float64
+	requests map[string]int
+}
+
+// Option configures the fake NS1 API.
+type Option func(*NS1)
+
+// OptionApps replaces the apps of the fake API, a single app1 holding job1 and
+// job2 by default.
+func OptionApps(apps ...App) Option {
+	return func(s *NS1) {
+		s.apps = apps
+	}
+}
+
+// OptionAPIKey rejects the requests without the API key.
+func OptionAPIKey(apiKey string) Option {
+	return func(s *NS1) {
+		s.apiKey = apiKey
+	}
+}
+
+// OptionDataStatus answers the data requests with the status, without data
+// unless 200.
+func OptionDataStatus(status int) Option {
+	return func(s *NS1) {
+		s.dataStatus = status
+	}
+}
+
+// OptionValue sets the value of the data points of the job.
+func OptionValue(jobID string, value float64) Option {
+	return func(s *NS1) {
+		s.values[jobID] = value
+	}
+}
+
+// NewNS1 returns the handler of a fake NS1 API, to be served by NewServer or
+// wrapped by the tests overriding some endpoints.
+func NewNS1(options ...Option) *NS1 {
+	s := &NS1{
+		apps: []App{{AppID: "app1", Name: "App 1", Jobs: []Job{
+			{JobID: "job1", Name: "Job 1", TypeID: "latency"},
+			{JobID: "job2", Name: "Job 2", TypeID: "latency"},
+		}}},
+		dataStatus: http.StatusOK,
+		values:     make(map[string]float64),
+		requests:   make(map[string]int),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Server serves a fake NS1 API, closed by Close.
+type Server struct {
+	*httptest.Server
+	NS1 *NS1
+}
+
+// NewServer starts a server of a fake NS1 API.
+func NewServer(options ...Option) *Server {
+	ns1 := NewNS1(options...)
+	return &Server{Server: httptest.NewServer(ns1), NS1: ns1}
+}
+
+// SetValue changes the value of the data points of the job.
+func (s *NS1) SetValue(jobID string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[jobID] = value
+}
+
+// Requests returns the number of requests received on the path.
+func (s *NS1) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *NS1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	s.mu.Unlock()
+
+	if s.apiKey != "" && r.Header.Get("X-NSONE-Key") != s.apiKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "pulsar" && parts[1] == "apps":
+		apps := make([]map[string]interface{}, 0, len(s.apps))
+		for _, app := range s.apps {
+			apps = append(apps, map[string]interface{}{"appid": app.AppID, "name": app.Name, "active": true})
+		}
+		writeJSON(w, http.StatusOK, apps)
+		return
+	case len(parts) == 4 && parts[0] == "pulsar" && parts[1] == "apps" && parts[3] == "jobs":
+		for _, app := range s.apps {
+			if app.AppID != parts[2] {
+				continue
+			}
+			jobs := make([]map[string]interface{}, 0, len(app.Jobs))
+			for _, job := range app.Jobs {
+				jobs = append(jobs, map[string]interface{}{"jobid": job.JobID, "name": job.Name, "typeid": job.TypeID,
+					"appid": app.AppID, "active": true})
+			}
+			writeJSON(w, http.StatusOK, jobs)
+			return
+		}
+	case len(parts) == 4 && parts[0] == "pulsar" && parts[1] == "query":
+		if s.dataStatus != http.StatusOK {
+			writeJSON(w, s.dataStatus, []interface{}{})
+			return
+		}
+		writeJSON(w, http.StatusOK, s.points(r))
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "not found"})
+}
+
+// points returns a point per minute over the range of the request, of every
+// job of the request with a value.
+func (s *NS1) points(r *http.Request) []map[string]float64 {
+	query := r.URL.Query()
+	start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]float64)
+	for _, job := range strings.Split(query.Get("jobs"), ",") {
+		if value, found := s.values[job]; found {
+			values[job] = value
+		}
+	}
+
+	points := []map[string]float64{}
+	if len(values) == 0 {
+		return points
+	}
+	for t := (start + 59) / 60 * 60; t <= end; t += 60 {
+		point := map[string]float64{"timestamp": float64(t)}
+		for job, value := range values {
+			point[job] = value
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -9,5 +9,7 @@ import { PulsarQuery } from './types';
 export class DataSource extends DataSourceWithBackend<PulsarQuery> {
   constructor(instanceSettings: DataSourceInstanceSettings) {
     super(instanceSettings);
+    // The activity queries are run as annotations, by the backend.
+    this.annotations = {};
   }
 }
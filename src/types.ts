@@ -23,26 +23,125 @@ export enum AggType {
 export enum QueryType {
   INITIAL_APPS_JOBS_FETCH = 'initialAppsJobsFetch',
   REGULAR = 'regular',
+  DNS_QPS = 'dnsQps',
+  MONITORING_STATUS = 'monitoringStatus',
+  MONITORING_METRICS = 'monitoringMetrics',
+  NOTIFICATIONS = 'notifications',
+  ACTIVITY = 'activity',
+  JOB_CHANGES = 'jobChanges',
+  USAGE = 'usage',
+  DECISION_ANSWERS = 'decisionAnswers',
+  SHED_LOAD = 'shedLoad',
+  DHCP_SCOPES = 'dhcpScopes',
+  REPORT = 'report',
+  ANNOTATION = 'annotation',
+  ROUTE_MAP_CHANGES = 'routeMapChanges',
+  OUTAGES = 'outages',
+  HEALTH_SCORE = 'healthScore',
+  STATUS_BOARD = 'statusBoard',
 }
 
 export interface PulsarApp {
   name: string;
   appid: string;
   jobs?: PulsarJob[];
+  category?: string;
+  tags?: Record<string, string>;
+}
+
+export enum JobType {
+  COMMUNITY = 'community',
+  HTTP = 'http',
+  CUSTOM = 'custom',
 }
 
 export interface PulsarJob {
   name: string;
   jobid: string;
+  type?: JobType;
+  requestTimeoutMillis?: number;
+}
+
+export interface PulsarJobThresholds {
+  appid: string;
+  jobid: string;
+  name: string;
+  type?: JobType;
+  latency?: number;
+  availability: number;
+}
+
+export enum NoDataPolicy {
+  ERROR = 'error',
+  EMPTY = 'empty',
+  ZERO = 'zero',
+}
+
+export enum Reducer {
+  LAST = 'last',
+  MEAN = 'mean',
+  MAX = 'max',
+}
+
+export interface PulsarCondition {
+  operator: '>' | '>=' | '<' | '<=';
+  value: number;
+  for?: string;
+}
+
+export enum Resample {
+  LINEAR = 'linear',
+  NEAREST = 'nearest',
+  PREVIOUS = 'previous',
+}
+
+export interface PulsarAnomalyBand {
+  window: string;
+  deviations?: number;
+}
+
+export interface PulsarHealthScore {
+  availabilityWeight?: number;
+  performanceWeight?: number;
+  maxLatency?: string;
 }
 
 export interface PulsarQuery extends DataQuery {
   appid?: string;
   jobid?: string;
   metricType?: MetricType;
-  agg?: string;
+  agg?: string | string[];
   geo?: string;
   asn?: string;
+  alias?: string;
+  zone?: string;
+  domain?: string;
+  recordType?: string;
+  monitoringJobId?: string;
+  monitoringMetric?: string;
+  usageByNetwork?: boolean;
+  networks?: string;
+  jobType?: JobType;
+  reportType?: string;
+  reportScope?: string;
+  threshold?: number;
+  noData?: NoDataPolicy;
+  reduce?: Reducer;
+  condition?: PulsarCondition;
+  quantile?: number;
+  quantileInterval?: string;
+  band?: PulsarAnomalyBand;
+  healthScore?: PulsarHealthScore;
+  forecast?: string;
+  baselineWeeks?: number;
+  envelope?: boolean;
+  resample?: Resample;
+  cumulative?: boolean;
+  timeout?: string;
+  lastPoints?: number;
+  unit?: string;
+  version?: number;
+  debug?: boolean;
 }
 
 export interface Geo {
@@ -56,4 +155,6 @@ export interface Geo {
  */
 export interface SecureJsonData {
   apiKey?: string;
+  secondaryApiKey?: string;
+  dataApiKey?: string;
 }